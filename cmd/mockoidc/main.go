@@ -0,0 +1,29 @@
+// Command mockoidc runs a local OpenID Connect provider for trying out
+// external sign-in. Point the forum at it with a provider entry such as
+//
+//	{"type": "oidc", "name": "mock", "label": "Mock SSO",
+//	 "issuer": "http://localhost:9000", "client_id": "forum", "client_secret": "secret"}
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"forum/internal/identity/mockoidc"
+)
+
+func main() {
+	addr := flag.String("addr", ":9000", "listen address")
+	issuer := flag.String("issuer", "http://localhost:9000", "issuer URL as seen by clients")
+	clientID := flag.String("client-id", "forum", "accepted client_id")
+	clientSecret := flag.String("client-secret", "secret", "accepted client_secret")
+	flag.Parse()
+
+	srv, err := mockoidc.New(*issuer, *clientID, *clientSecret)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("mock OIDC provider %s listening on %s", *issuer, *addr)
+	log.Fatal(http.ListenAndServe(*addr, srv))
+}
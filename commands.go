@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"forum/internal/backup"
+	internaldb "forum/internal/db"
+	"forum/internal/repo"
+)
+
+const commandUsage = `usage: forum [command]
+
+Without a command the forum serves HTTP on :8080. Commands work on the
+SQLite database named by FORUM_DATABASE_URL:
+
+  backup    write a snapshot of the database, safe while the server runs
+  restore   replace the database with a snapshot; stop the server first
+  vacuum    compact the database and refresh query planner statistics
+
+Run forum <command> -h for the command's flags.
+`
+
+// runCommand runs the command-line tool named by args[0].
+func runCommand(args []string) {
+	switch args[0] {
+	case "backup":
+		backupCommand(args[1:])
+	case "restore":
+		restoreCommand(args[1:])
+	case "vacuum":
+		vacuumCommand(args[1:])
+	case "help", "-h", "-help", "--help":
+		fmt.Print(commandUsage)
+	default:
+		fmt.Fprintf(os.Stderr, "forum: unknown command %q\n\n%s", args[0], commandUsage)
+		os.Exit(2)
+	}
+}
+
+func backupCommand(args []string) {
+	opts, err := backupOptions()
+	if err != nil {
+		fatal("read backup settings", err)
+	}
+	flags := flag.NewFlagSet("backup", flag.ExitOnError)
+	flags.StringVar(&opts.Dir, "dir", opts.Dir, "directory for snapshots (FORUM_BACKUP_DIR)")
+	flags.BoolVar(&opts.Gzip, "gzip", opts.Gzip, "gzip the snapshot (FORUM_BACKUP_GZIP=1)")
+	flags.IntVar(&opts.Keep, "keep", opts.Keep, "number of snapshots to keep, 0 for all (FORUM_BACKUP_KEEP)")
+	flags.DurationVar(&opts.MaxAge, "max-age", opts.MaxAge, "remove snapshots older than this, 0 to keep them (FORUM_BACKUP_MAX_AGE)")
+	_ = flags.Parse(args)
+
+	path := sqlitePath()
+	if _, err := os.Stat(path); err != nil {
+		fatal("open database", err)
+	}
+	// A read-only connection cannot migrate or otherwise change the file the
+	// running server uses.
+	db, err := sql.Open(repo.SQLite.Name(), "file:"+path+"?mode=ro&_busy_timeout=5000")
+	if err != nil {
+		fatal("open database", err)
+	}
+	defer db.Close()
+
+	snapshot, err := backup.Create(context.Background(), db, opts)
+	if err != nil {
+		fatal("back up database", err)
+	}
+	fmt.Printf("wrote %s (%d bytes)\n", filepath.Join(opts.Dir, snapshot.Name), snapshot.Size)
+}
+
+func restoreCommand(args []string) {
+	opts, err := backupOptions()
+	if err != nil {
+		fatal("read backup settings", err)
+	}
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	flags.StringVar(&opts.Dir, "dir", opts.Dir, "directory to look for a snapshot given by name (FORUM_BACKUP_DIR)")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: forum restore [-dir dir] <snapshot>")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	snapshot := flags.Arg(0)
+	if _, err := os.Stat(snapshot); err != nil && filepath.Base(snapshot) == snapshot {
+		snapshot = filepath.Join(opts.Dir, snapshot)
+	}
+	kept, err := backup.Restore(context.Background(), snapshot, sqlitePath())
+	if err != nil {
+		fatal("restore database", err)
+	}
+	fmt.Printf("restored %s\n", snapshot)
+	if kept != "" {
+		fmt.Printf("the previous database was moved to %s\n", kept)
+	}
+}
+
+func vacuumCommand(args []string) {
+	flags := flag.NewFlagSet("vacuum", flag.ExitOnError)
+	_ = flags.Parse(args)
+
+	db, err := internaldb.Open(sqlitePath())
+	if err != nil {
+		fatal("open database", err)
+	}
+	defer db.Pool().Close()
+
+	if err := backup.Vacuum(context.Background(), db.Pool()); err != nil {
+		fatal("vacuum database", err)
+	}
+	fmt.Println("vacuumed")
+}
+
+// sqlitePath is the database file the commands work on. They exit when
+// FORUM_DATABASE_URL names a PostgreSQL server, which has its own tools.
+func sqlitePath() string {
+	path, ok := internaldb.SQLitePath(os.Getenv("FORUM_DATABASE_URL"))
+	if !ok {
+		fatal("open database", fmt.Errorf("backup commands only support SQLite; use pg_dump and pg_restore for PostgreSQL"))
+	}
+	return path
+}
+
+// backupOptions reads the snapshot settings shared by forum backup and the
+// server's backup job.
+func backupOptions() (backup.Options, error) {
+	opts := backup.Options{
+		Dir:  envOr("FORUM_BACKUP_DIR", "backups"),
+		Gzip: os.Getenv("FORUM_BACKUP_GZIP") == "1",
+		Keep: 7,
+	}
+	if v := os.Getenv("FORUM_BACKUP_KEEP"); v != "" {
+		keep, err := strconv.Atoi(v)
+		if err != nil {
+			return opts, fmt.Errorf("FORUM_BACKUP_KEEP: %w", err)
+		}
+		opts.Keep = keep
+	}
+	maxAge, err := envDuration("FORUM_BACKUP_MAX_AGE")
+	if err != nil {
+		return opts, err
+	}
+	opts.MaxAge = maxAge
+	return opts, nil
+}
+
+// envDuration parses a duration such as 24h from the environment; unset is
+// zero.
+func envDuration(key string) (time.Duration, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", key, err)
+	}
+	return d, nil
+}
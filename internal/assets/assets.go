@@ -0,0 +1,131 @@
+// Package assets serves static files under content-hashed names, so they can
+// be cached for a year and still change the moment a file does.
+package assets
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const hashLength = 10
+
+type file struct {
+	name   string
+	hashed string
+	hash   string
+	data   []byte
+}
+
+// Static serves the files of fsys below Prefix. A file is reachable both as
+// "app.js" and as "app.<hash>.js"; only the second form is cached forever.
+type Static struct {
+	Prefix string
+
+	fsys fs.FS
+	live bool
+
+	mu     sync.RWMutex
+	byName map[string]*file
+	byHash map[string]*file
+}
+
+// New reads and hashes every file in fsys. With live set, files are read
+// again whenever their URL is built, which suits editing them on disk.
+func New(fsys fs.FS, prefix string, live bool) (*Static, error) {
+	s := &Static{Prefix: prefix, fsys: fsys, live: live}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Static) load() error {
+	byName := map[string]*file{}
+	byHash := map[string]*file{}
+	err := fs.WalkDir(s.fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := fs.ReadFile(s.fsys, name)
+		if err != nil {
+			return err
+		}
+		f := newFile(name, data)
+		byName[f.name] = f
+		byHash[f.hashed] = f
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.byName = byName
+	s.byHash = byHash
+	s.mu.Unlock()
+	return nil
+}
+
+func newFile(name string, data []byte) *file {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])[:hashLength]
+	ext := path.Ext(name)
+	return &file{
+		name:   name,
+		hashed: strings.TrimSuffix(name, ext) + "." + hash + ext,
+		hash:   hash,
+		data:   data,
+	}
+}
+
+// URL returns the cache-busting URL of a file, or the plain one when the
+// file is unknown.
+func (s *Static) URL(name string) string {
+	if s.live {
+		if err := s.load(); err != nil {
+			return s.Prefix + name
+		}
+	}
+	s.mu.RLock()
+	f, ok := s.byName[name]
+	s.mu.RUnlock()
+	if !ok {
+		return s.Prefix + name
+	}
+	return s.Prefix + f.hashed
+}
+
+func (s *Static) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, s.Prefix)
+
+	s.mu.RLock()
+	f, immutable := s.byHash[name]
+	if !immutable {
+		f = s.byName[name]
+	}
+	s.mu.RUnlock()
+	if f == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if immutable {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	w.Header().Set("ETag", `"`+f.hash+`"`)
+	http.ServeContent(w, r, f.name, time.Time{}, bytes.NewReader(f.data))
+}
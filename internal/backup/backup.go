@@ -0,0 +1,395 @@
+// Package backup takes consistent snapshots of the SQLite database while the
+// forum keeps serving, prunes old ones and puts a snapshot back in place.
+// Copying forum.db by hand while the server runs can catch it halfway
+// through a write; VACUUM INTO reads it inside one transaction instead.
+package backup
+
+import (
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	internaldb "forum/internal/db"
+	"forum/internal/models"
+	"forum/internal/repo"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+const (
+	namePrefix = "forum-"
+	nameLayout = "20060102T150405Z"
+	dbSuffix   = ".db"
+	gzSuffix   = ".db.gz"
+)
+
+// Options says where snapshots go and how many of them to keep.
+type Options struct {
+	Dir  string
+	Gzip bool
+
+	// Keep is how many snapshots to keep, MaxAge how old they may get. Zero
+	// turns either rule off. The newest snapshot is never removed.
+	Keep   int
+	MaxAge time.Duration
+}
+
+// Create writes a snapshot of db into opts.Dir and prunes the directory. db
+// may be the pool the server runs on, or a read-only connection to the file.
+func Create(ctx context.Context, db *sql.DB, opts Options) (models.BackupSnapshot, error) {
+	if err := os.MkdirAll(opts.Dir, 0o700); err != nil {
+		return models.BackupSnapshot{}, err
+	}
+	dir, err := filepath.Abs(opts.Dir)
+	if err != nil {
+		return models.BackupSnapshot{}, err
+	}
+
+	now := time.Now().UTC()
+	name := namePrefix + now.Format(nameLayout) + dbSuffix
+	if opts.Gzip {
+		name = namePrefix + now.Format(nameLayout) + gzSuffix
+	}
+	// Snapshots are written under a dot name and renamed once complete, so a
+	// half-written file is never taken for a snapshot.
+	tmp := filepath.Join(dir, "."+name+".tmp")
+	defer os.Remove(tmp)
+
+	if _, err := db.ExecContext(ctx, `VACUUM INTO ?`, tmp); err != nil {
+		return models.BackupSnapshot{}, fmt.Errorf("vacuum into: %w", err)
+	}
+	// Snapshots hold password hashes and sessions.
+	if err := os.Chmod(tmp, 0o600); err != nil {
+		return models.BackupSnapshot{}, err
+	}
+	if opts.Gzip {
+		if err := compress(tmp); err != nil {
+			return models.BackupSnapshot{}, fmt.Errorf("compress: %w", err)
+		}
+	}
+	if err := syncFile(tmp); err != nil {
+		return models.BackupSnapshot{}, err
+	}
+	path := filepath.Join(dir, name)
+	if err := os.Rename(tmp, path); err != nil {
+		return models.BackupSnapshot{}, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return models.BackupSnapshot{}, err
+	}
+	snapshot := models.BackupSnapshot{Name: name, Size: info.Size(), CreatedAt: now.Truncate(time.Second)}
+	if err := Prune(opts); err != nil {
+		return snapshot, fmt.Errorf("prune: %w", err)
+	}
+	return snapshot, nil
+}
+
+// compress replaces the file at path with its gzipped contents.
+func compress(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	gzPath := path + ".gz"
+	dst, err := os.OpenFile(gzPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(gzPath)
+	zw := gzip.NewWriter(dst)
+	if _, err := io.Copy(zw, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	return os.Rename(gzPath, path)
+}
+
+func syncFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+// List returns the snapshots in dir, newest first. A missing directory has
+// none.
+func List(dir string) ([]models.BackupSnapshot, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshots []models.BackupSnapshot
+	for _, entry := range entries {
+		createdAt, ok := parseName(entry.Name())
+		if !ok || !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, models.BackupSnapshot{Name: entry.Name(), Size: info.Size(), CreatedAt: createdAt})
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].CreatedAt.After(snapshots[j].CreatedAt)
+	})
+	return snapshots, nil
+}
+
+// parseName reads the time out of a snapshot file name.
+func parseName(name string) (time.Time, bool) {
+	stamp, ok := strings.CutPrefix(name, namePrefix)
+	if !ok {
+		return time.Time{}, false
+	}
+	if s, ok := strings.CutSuffix(stamp, gzSuffix); ok {
+		stamp = s
+	} else if s, ok := strings.CutSuffix(stamp, dbSuffix); ok {
+		stamp = s
+	} else {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(nameLayout, stamp)
+	return t, err == nil
+}
+
+// Prune removes the snapshots that opts.Keep and opts.MaxAge no longer
+// allow.
+func Prune(opts Options) error {
+	snapshots, err := List(opts.Dir)
+	if err != nil {
+		return err
+	}
+	cutoff := time.Now().Add(-opts.MaxAge)
+	for i, s := range snapshots {
+		if i == 0 {
+			continue
+		}
+		tooMany := opts.Keep > 0 && i >= opts.Keep
+		tooOld := opts.MaxAge > 0 && s.CreatedAt.Before(cutoff)
+		if !tooMany && !tooOld {
+			continue
+		}
+		if err := os.Remove(filepath.Join(opts.Dir, s.Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ErrInUse is returned by Restore when another process holds the database
+// it would replace.
+var ErrInUse = errors.New("the database is in use; stop the server before restoring")
+
+// lockTimeout is how long Restore waits for the database's write lock.
+const lockTimeout = time.Second
+
+// rename is os.Rename; tests replace it to make the swap fail.
+var rename = os.Rename
+
+// Restore replaces the database at dbPath with snapshot. The snapshot is
+// unpacked next to the database and checked before anything is touched; the
+// database it replaces is kept as <dbPath>.pre-restore-<time>, whose path is
+// returned. The server must be stopped while this runs: Restore takes the
+// database's exclusive lock first and returns ErrInUse when it cannot.
+func Restore(ctx context.Context, snapshot string, dbPath string) (string, error) {
+	tmp := dbPath + ".restore"
+	if err := unpack(snapshot, tmp); err != nil {
+		removeDB(tmp)
+		return "", fmt.Errorf("unpack %s: %w", snapshot, err)
+	}
+	if err := Validate(ctx, tmp); err != nil {
+		removeDB(tmp)
+		return "", fmt.Errorf("check %s: %w", snapshot, err)
+	}
+
+	unlock, err := lock(ctx, dbPath)
+	if err != nil {
+		removeDB(tmp)
+		return "", err
+	}
+	defer unlock()
+	return swap(tmp, dbPath)
+}
+
+// lock takes the exclusive lock on the database at path, so that nothing
+// writes to it while it is swapped, and returns the function that lets it
+// go. A missing database needs no lock.
+func lock(ctx context.Context, path string) (func(), error) {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return func() {}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open(repo.SQLite.Name(), fmt.Sprintf("file:%s?_busy_timeout=%d", path, lockTimeout.Milliseconds()))
+	if err != nil {
+		return nil, err
+	}
+	conn, err := db.Conn(ctx)
+	if err == nil {
+		_, err = conn.ExecContext(ctx, `BEGIN EXCLUSIVE`)
+		if err != nil {
+			conn.Close()
+		}
+	}
+	if err != nil {
+		db.Close()
+		var e sqlite3.Error
+		if errors.As(err, &e) && (e.Code == sqlite3.ErrBusy || e.Code == sqlite3.ErrLocked) {
+			return nil, ErrInUse
+		}
+		return nil, fmt.Errorf("lock %s: %w", path, err)
+	}
+	return func() {
+		_, _ = conn.ExecContext(context.Background(), `ROLLBACK`)
+		conn.Close()
+		db.Close()
+	}, nil
+}
+
+// swap moves the database at dbPath aside and tmp into its place. When tmp
+// cannot be moved, the database is put back; tmp is only removed once
+// either has worked, so a failure never leaves both out of reach.
+func swap(tmp string, dbPath string) (string, error) {
+	// The -wal file may hold commits that are not in the main file yet, so
+	// it moves along with it.
+	suffixes := []string{"", "-wal", "-shm"}
+	kept := ""
+	var moved []string
+	putBack := func() error {
+		for _, suffix := range moved {
+			if err := rename(kept+suffix, dbPath+suffix); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if _, err := os.Stat(dbPath); err == nil {
+		kept = dbPath + ".pre-restore-" + time.Now().UTC().Format(nameLayout)
+		for _, suffix := range suffixes {
+			err := rename(dbPath+suffix, kept+suffix)
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				if putBackErr := putBack(); putBackErr != nil {
+					return "", fmt.Errorf("move the database aside: %w; moving it back failed too, it is at %s: %v", err, kept, putBackErr)
+				}
+				removeDB(tmp)
+				return "", fmt.Errorf("move the database aside: %w", err)
+			}
+			moved = append(moved, suffix)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		removeDB(tmp)
+		return "", err
+	}
+
+	if err := rename(tmp, dbPath); err != nil {
+		if putBackErr := putBack(); putBackErr != nil {
+			return "", fmt.Errorf("move the snapshot in: %w; moving the database back failed too, it is at %s and the snapshot at %s: %v", err, kept, tmp, putBackErr)
+		}
+		removeDB(tmp)
+		return "", fmt.Errorf("move the snapshot in: %w", err)
+	}
+	removeDB(tmp)
+	return kept, nil
+}
+
+// unpack copies snapshot to path, decompressing it if it is gzipped.
+func unpack(snapshot string, path string) error {
+	src, err := os.Open(snapshot)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	var r io.Reader = src
+	if strings.HasSuffix(snapshot, ".gz") {
+		zr, err := gzip.NewReader(src)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	dst, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, r); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Sync(); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// Validate opens the SQLite file at path the way the server would, which
+// brings a snapshot from an older release up to the current schema, and
+// checks that it is intact and complete.
+func Validate(ctx context.Context, path string) error {
+	db, err := internaldb.Open(path)
+	if err != nil {
+		return err
+	}
+	defer db.Pool().Close()
+
+	var result string
+	if err := db.QueryRowContext(ctx, `PRAGMA integrity_check`).Scan(&result); err != nil {
+		return err
+	}
+	if result != "ok" {
+		return fmt.Errorf("integrity check: %s", result)
+	}
+	return internaldb.Ready(ctx, db)
+}
+
+func removeDB(path string) {
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		_ = os.Remove(path + suffix)
+	}
+}
+
+// Vacuum rebuilds the database to give the space of deleted rows back to the
+// file system and refreshes the query planner's statistics. It holds the
+// write lock while it runs, so writers wait for it.
+func Vacuum(ctx context.Context, db *sql.DB) error {
+	if _, err := db.ExecContext(ctx, `VACUUM`); err != nil {
+		return fmt.Errorf("vacuum: %w", err)
+	}
+	if _, err := db.ExecContext(ctx, `PRAGMA optimize`); err != nil {
+		return fmt.Errorf("optimize: %w", err)
+	}
+	return nil
+}
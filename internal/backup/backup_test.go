@@ -0,0 +1,239 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	internaldb "forum/internal/db"
+	"forum/internal/repo"
+)
+
+// openDB creates a database at path holding the categories in names.
+func openDB(t *testing.T, path string, names ...string) *repo.DB {
+	t.Helper()
+	db, err := internaldb.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Pool().Close() })
+	for _, name := range names {
+		if _, err := db.ExecContext(context.Background(), `INSERT INTO categories (name) VALUES (?)`, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+// hasCategory reports whether the database at path holds a category called
+// name.
+func hasCategory(t *testing.T, path string, name string) bool {
+	t.Helper()
+	db, err := sql.Open(repo.SQLite.Name(), "file:"+path+"?mode=ro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM categories WHERE name = ?`, name).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n > 0
+}
+
+func TestCreateValidateRestore(t *testing.T) {
+	for _, gz := range []bool{false, true} {
+		dir := t.TempDir()
+		dbPath := filepath.Join(dir, "forum.db")
+		db := openDB(t, dbPath, "Before")
+		ctx := context.Background()
+
+		snapshot, err := Create(ctx, db.Pool(), Options{Dir: filepath.Join(dir, "backups"), Gzip: gz})
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, "backups", snapshot.Name)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if isGzip := bytes.HasPrefix(data, []byte{0x1f, 0x8b}); isGzip != gz {
+			t.Errorf("gzip %v: snapshot %s gzipped: %v", gz, snapshot.Name, isGzip)
+		}
+		if _, ok := parseName(snapshot.Name); !ok {
+			t.Errorf("snapshot name %q is not one List reads", snapshot.Name)
+		}
+		if gz {
+			// Validate opens plain files; Restore unpacks first.
+			tmp := filepath.Join(dir, "unpacked.db")
+			if err := unpack(path, tmp); err != nil {
+				t.Fatal(err)
+			}
+			if err := Validate(ctx, tmp); err != nil {
+				t.Errorf("gzip: Validate: %v", err)
+			}
+		} else if err := Validate(ctx, path); err != nil {
+			t.Errorf("Validate: %v", err)
+		}
+
+		if _, err := db.ExecContext(ctx, `INSERT INTO categories (name) VALUES ('After')`); err != nil {
+			t.Fatal(err)
+		}
+		db.Pool().Close()
+
+		kept, err := Restore(ctx, path, dbPath)
+		if err != nil {
+			t.Fatalf("gzip %v: Restore: %v", gz, err)
+		}
+		if !hasCategory(t, dbPath, "Before") || hasCategory(t, dbPath, "After") {
+			t.Errorf("gzip %v: the restored database is not the snapshot", gz)
+		}
+		if kept == "" || !hasCategory(t, kept, "After") {
+			t.Errorf("gzip %v: the replaced database was not kept at %q", gz, kept)
+		}
+		if _, err := os.Stat(dbPath + ".restore"); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("gzip %v: the unpacked snapshot was left behind: %v", gz, err)
+		}
+	}
+}
+
+func TestValidateRejectsGarbage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "garbage.db")
+	if err := os.WriteFile(path, bytes.Repeat([]byte("not a database "), 512), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := Validate(context.Background(), path); err == nil {
+		t.Error("Validate accepted a file that is not a database")
+	}
+	if _, err := Restore(context.Background(), path, filepath.Join(t.TempDir(), "forum.db")); err == nil {
+		t.Error("Restore accepted a file that is not a database")
+	}
+}
+
+func TestRestoreRefusesDatabaseInUse(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "forum.db")
+	db := openDB(t, dbPath, "Live")
+	ctx := context.Background()
+	snapshot, err := Create(ctx, db.Pool(), Options{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A writer in the middle of a transaction holds the lock Restore needs.
+	tx, err := db.BeginTx(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `INSERT INTO categories (name) VALUES ('Writing')`); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Restore(ctx, filepath.Join(dir, snapshot.Name), dbPath); !errors.Is(err, ErrInUse) {
+		t.Fatalf("Restore = %v, want ErrInUse", err)
+	}
+	if _, err := os.Stat(dbPath); err != nil {
+		t.Errorf("the database was moved: %v", err)
+	}
+	if _, err := os.Stat(dbPath + ".restore"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("the unpacked snapshot was left behind: %v", err)
+	}
+}
+
+func TestRestorePutsDatabaseBack(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "forum.db")
+	db := openDB(t, dbPath, "Original")
+	ctx := context.Background()
+	snapshot, err := Create(ctx, db.Pool(), Options{Dir: filepath.Join(dir, "backups")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Pool().Close()
+
+	tmp := dbPath + ".restore"
+	rename = func(from, to string) error {
+		if from == tmp {
+			return errors.New("disk gone")
+		}
+		return os.Rename(from, to)
+	}
+	defer func() { rename = os.Rename }()
+
+	kept, err := Restore(ctx, filepath.Join(dir, "backups", snapshot.Name), dbPath)
+	if err == nil {
+		t.Fatal("Restore succeeded with a failing swap")
+	}
+	if kept != "" {
+		t.Errorf("Restore reported the database kept at %q", kept)
+	}
+	if !hasCategory(t, dbPath, "Original") {
+		t.Error("the database was not put back")
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if name := e.Name(); name != "forum.db" && name != "backups" && filepath.Ext(name) != ".db-shm" && filepath.Ext(name) != ".db-wal" {
+			t.Errorf("Restore left %s behind", name)
+		}
+	}
+}
+
+func TestPrune(t *testing.T) {
+	now := time.Now().UTC()
+	ages := []time.Duration{time.Minute, time.Hour, 25 * time.Hour, 49 * time.Hour, 100 * time.Hour}
+	names := make([]string, len(ages))
+	for i, age := range ages {
+		names[i] = namePrefix + now.Add(-age).Format(nameLayout) + dbSuffix
+	}
+	// One snapshot gzipped, to check both kinds are counted.
+	names[2] = namePrefix + now.Add(-ages[2]).Format(nameLayout) + gzSuffix
+
+	tests := []struct {
+		name   string
+		keep   int
+		maxAge time.Duration
+		want   []string
+	}{
+		{"no rules", 0, 0, names},
+		{"keep three", 3, 0, names[:3]},
+		{"keep one", 1, 0, names[:1]},
+		{"max age two days", 0, 48 * time.Hour, names[:3]},
+		{"both rules", 2, 48 * time.Hour, names[:2]},
+		{"newest is kept however old", 0, time.Second, names[:1]},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		for _, name := range append(slices.Clone(names), "notes.txt", "."+names[0]+".tmp") {
+			if err := os.WriteFile(filepath.Join(dir, name), nil, 0o600); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := Prune(Options{Dir: dir, Keep: tt.keep, MaxAge: tt.maxAge}); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		snapshots, err := List(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, s := range snapshots {
+			got = append(got, s.Name)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: kept %v, want %v", tt.name, got, tt.want)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "notes.txt")); err != nil {
+			t.Errorf("%s: Prune removed a file that is not a snapshot", tt.name)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package backup
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"sync"
+	"time"
+
+	"forum/internal/models"
+)
+
+// Job takes snapshots in the background of the running server: every
+// Interval when it is set, and whenever an admin asks for one.
+type Job struct {
+	DB       *sql.DB
+	Options  Options
+	Interval time.Duration
+
+	trigger chan struct{}
+
+	mu     sync.Mutex
+	status models.BackupStatus
+}
+
+func NewJob(db *sql.DB, opts Options, interval time.Duration) *Job {
+	return &Job{
+		DB:       db,
+		Options:  opts,
+		Interval: interval,
+		trigger:  make(chan struct{}, 1),
+		status:   models.BackupStatus{Dir: opts.Dir, Interval: interval},
+	}
+}
+
+// Run takes snapshots until ctx is cancelled.
+func (j *Job) Run(ctx context.Context) {
+	var tick <-chan time.Time
+	if j.Interval > 0 {
+		ticker := time.NewTicker(j.Interval)
+		defer ticker.Stop()
+		tick = ticker.C
+		j.setNextRun(time.Now().Add(j.Interval))
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick:
+			j.setNextRun(time.Now().Add(j.Interval))
+		case <-j.trigger:
+		}
+		j.run(ctx)
+	}
+}
+
+// Trigger asks Run for a snapshot now. It reports false when one is already
+// being taken or waiting to be.
+func (j *Job) Trigger() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.status.Running {
+		return false
+	}
+	select {
+	case j.trigger <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (j *Job) Status() models.BackupStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.status
+}
+
+// Snapshots lists the snapshots in the job's directory, newest first.
+func (j *Job) Snapshots() ([]models.BackupSnapshot, error) {
+	return List(j.Options.Dir)
+}
+
+func (j *Job) run(ctx context.Context) {
+	j.mu.Lock()
+	j.status.Running = true
+	j.status.LastStarted = time.Now()
+	j.mu.Unlock()
+
+	snapshot, err := Create(ctx, j.DB, j.Options)
+	if err != nil {
+		slog.Error("database backup", "err", err)
+	} else {
+		slog.Info("database backup", "snapshot", snapshot.Name, "size", snapshot.Size)
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status.Running = false
+	j.status.LastFinished = time.Now()
+	j.status.LastError = ""
+	if err != nil {
+		j.status.LastError = err.Error()
+	}
+	if snapshot.Name != "" {
+		j.status.LastSnapshot = snapshot.Name
+	}
+}
+
+func (j *Job) setNextRun(t time.Time) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status.NextRun = t
+}
@@ -0,0 +1,43 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"forum/internal/repo"
+)
+
+// SchemaVersion is the schema this build creates and migrates to. Open
+// records it once the migrations have finished; bump it with every change to
+// the schema, so an instance never takes traffic on a database that is
+// behind it.
+const SchemaVersion = 5
+
+// Ready reports whether the database answers and carries the schema this
+// build expects.
+func Ready(ctx context.Context, db *repo.DB) error {
+	if err := db.Pool().PingContext(ctx); err != nil {
+		return fmt.Errorf("ping: %w", err)
+	}
+	version, err := schemaVersion(ctx, db)
+	if err != nil {
+		return fmt.Errorf("schema version: %w", err)
+	}
+	if version != SchemaVersion {
+		return fmt.Errorf("schema version is %d, want %d", version, SchemaVersion)
+	}
+	return nil
+}
+
+// schemaVersion reads the version the last migration recorded: SQLite's
+// user_version, which is 0 in a database no migration has finished on, and
+// the schema_version table on PostgreSQL.
+func schemaVersion(ctx context.Context, db *repo.DB) (int, error) {
+	query := `PRAGMA user_version`
+	if db.Dialect == repo.Postgres {
+		query = `SELECT version FROM schema_version WHERE id = 1`
+	}
+	var version int
+	err := db.QueryRowContext(ctx, query).Scan(&version)
+	return version, err
+}
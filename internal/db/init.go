@@ -2,27 +2,63 @@ package db
 
 import (
 	"database/sql"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"forum/internal/repo"
 )
 
-func InitDB() *sql.DB {
-	db, err := sql.Open("sqlite3", "forum.db")
-	if err != nil {
-		panic("База не открылась")
-	}
-
+// initSQLite creates the schema in a SQLite database and migrates one
+// written by an older version.
+func initSQLite(db *sql.DB) error {
 	createUsers := `
         CREATE TABLE IF NOT EXISTS users (
             id INTEGER PRIMARY KEY AUTOINCREMENT,
             email TEXT NOT NULL UNIQUE,
+            email_verified INTEGER NOT NULL DEFAULT 0,
             username TEXT NOT NULL,
-            password TEXT NOT NULL
+            username_folded TEXT NOT NULL DEFAULT '',
+            password TEXT NOT NULL,
+            created_at DATETIME,
+            bio TEXT NOT NULL DEFAULT '',
+            avatar_key TEXT NOT NULL DEFAULT '',
+            avatar_type TEXT NOT NULL DEFAULT '',
+            profile_visibility TEXT NOT NULL DEFAULT 'public',
+            show_liked INTEGER NOT NULL DEFAULT 0,
+            role TEXT NOT NULL DEFAULT 'user',
+            totp_secret TEXT NOT NULL DEFAULT '',
+            totp_enabled INTEGER NOT NULL DEFAULT 0,
+            totp_last_step INTEGER NOT NULL DEFAULT 0,
+            locale TEXT NOT NULL DEFAULT '',
+            timezone TEXT NOT NULL DEFAULT ''
         );
     `
-	_, err = db.Exec(createUsers)
+	_, err := db.Exec(createUsers)
+	if err != nil {
+		return err
+	}
+
+	err = ensureUserColumns(db)
 	if err != nil {
-		panic(err)
+		return err
+	}
+
+	err = foldUsernames(db, repo.SQLite.Rebind)
+	if err != nil {
+		return err
+	}
+	for _, stmt := range []string{
+		`DROP INDEX IF EXISTS idx_users_username`,
+		`DROP INDEX IF EXISTS idx_users_username_lower`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_users_username_folded ON users (username_folded)`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
 	}
 
 	createCategories := `
@@ -33,98 +69,743 @@ func InitDB() *sql.DB {
 	`
 	_, err = db.Exec(createCategories)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
 	createSessions := `
 		CREATE TABLE IF NOT EXISTS sessions (
 			id TEXT PRIMARY KEY,
-			user_id INTEGER NOT NULL,
-			expires_at DATETIME NOT NULL
+			user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+			expires_at DATETIME NOT NULL,
+			confirmed_at DATETIME
 		);
 	`
 
 	_, err = db.Exec(createSessions)
 	if err != nil {
-		panic(err)
+		return err
+	}
+	if err := ensureColumn(db, "sessions", "confirmed_at", "DATETIME"); err != nil {
+		return err
 	}
 
 	createPosts := `
 	CREATE TABLE IF NOT EXISTS posts (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
-    user_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
     title TEXT NOT NULL,
     content TEXT NOT NULL,
-    category_id INTEGER NOT NULL,
+    category_id INTEGER NOT NULL REFERENCES categories (id),
     created_at DATETIME NOT NULL,
+    updated_at DATETIME,
     count_likes INTEGER NOT NULL DEFAULT 0
 	);
 	`
 
 	_, err = db.Exec(createPosts)
 	if err != nil {
-		panic(err)
+		return err
+	}
+
+	err = ensureUpdatedAt(db, "posts")
+	if err != nil {
+		return err
 	}
 
 	createPostCategories := `
 	CREATE TABLE IF NOT EXISTS post_categories (
-		post_id INTEGER NOT NULL,
-		category_id INTEGER NOT NULL,
+		post_id INTEGER NOT NULL REFERENCES posts (id) ON DELETE CASCADE,
+		category_id INTEGER NOT NULL REFERENCES categories (id) ON DELETE CASCADE,
 		PRIMARY KEY (post_id, category_id)
 	);
 	`
 	_, err = db.Exec(createPostCategories)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
 	_, err = db.Exec(`INSERT OR IGNORE INTO post_categories (post_id, category_id) SELECT id, category_id FROM posts`)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
 	createComments := `
     CREATE TABLE IF NOT EXISTS comments (
         id INTEGER PRIMARY KEY AUTOINCREMENT,
-        post_id INTEGER NOT NULL,
-        user_id INTEGER NOT NULL,
+        post_id INTEGER NOT NULL REFERENCES posts (id) ON DELETE CASCADE,
+        user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
         content TEXT NOT NULL,
         created_at DATETIME NOT NULL,
+        updated_at DATETIME,
         likes INTEGER NOT NULL DEFAULT 0,
         dislikes INTEGER NOT NULL DEFAULT 0
     );
 `
 	_, err = db.Exec(createComments)
 	if err != nil {
-		panic(err)
+		return err
+	}
+
+	err = ensureUpdatedAt(db, "comments")
+	if err != nil {
+		return err
+	}
+
+	// reactions and mentions point at a post or a comment by type and id, so
+	// no foreign key covers them and deleting a post or comment leaves them
+	// behind. Code that deletes either removes them itself; see
+	// repo.DeleteAccount, the only such path.
+	createReactions := `
+	CREATE TABLE IF NOT EXISTS reactions (
+		user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		target_type TEXT NOT NULL,
+		target_id INTEGER NOT NULL,
+		kind TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		PRIMARY KEY (user_id, target_type, target_id, kind)
+	);
+	`
+	_, err = db.Exec(createReactions)
+	if err != nil {
+		return err
+	}
+
+	err = migrateLegacyReactions(db, "post_reactions", "post_id", "post")
+	if err != nil {
+		return err
+	}
+	err = migrateLegacyReactions(db, "comment_reactions", "comment_id", "comment")
+	if err != nil {
+		return err
+	}
+
+	createNotifications := `
+	CREATE TABLE IF NOT EXISTS notifications (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		actor_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		type TEXT NOT NULL,
+		post_id INTEGER NOT NULL REFERENCES posts (id) ON DELETE CASCADE,
+		comment_id INTEGER REFERENCES comments (id) ON DELETE CASCADE,
+		detail TEXT NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL,
+		read_at DATETIME
+	);
+	`
+	_, err = db.Exec(createNotifications)
+	if err != nil {
+		return err
+	}
+
+	createNotificationPreferences := `
+	CREATE TABLE IF NOT EXISTS notification_preferences (
+		user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		type TEXT NOT NULL,
+		enabled INTEGER NOT NULL,
+		PRIMARY KEY (user_id, type)
+	);
+	`
+	_, err = db.Exec(createNotificationPreferences)
+	if err != nil {
+		return err
+	}
+
+	createMentions := `
+	CREATE TABLE IF NOT EXISTS mentions (
+		source_type TEXT NOT NULL,
+		source_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		PRIMARY KEY (source_type, source_id, user_id)
+	);
+	`
+	_, err = db.Exec(createMentions)
+	if err != nil {
+		return err
+	}
+
+	createAttachments := `
+	CREATE TABLE IF NOT EXISTS attachments (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		post_id INTEGER NOT NULL REFERENCES posts (id) ON DELETE CASCADE,
+		user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		storage_key TEXT NOT NULL,
+		content_type TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		width INTEGER NOT NULL,
+		height INTEGER NOT NULL,
+		original_name TEXT NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL
+	);
+	`
+	_, err = db.Exec(createAttachments)
+	if err != nil {
+		return err
+	}
+
+	createEmailChanges := `
+	CREATE TABLE IF NOT EXISTS email_changes (
+		user_id INTEGER PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+		email TEXT NOT NULL,
+		token_hash TEXT NOT NULL UNIQUE,
+		expires_at DATETIME NOT NULL
+	);
+	`
+	_, err = db.Exec(createEmailChanges)
+	if err != nil {
+		return err
 	}
 
-	createCommentReactions := `
-	CREATE TABLE IF NOT EXISTS comment_reactions (
-		user_id INTEGER NOT NULL,
-		comment_id INTEGER NOT NULL,
-		value INTEGER NOT NULL,
-		PRIMARY KEY (user_id, comment_id)
+	createDataExports := `
+	CREATE TABLE IF NOT EXISTS data_exports (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		status TEXT NOT NULL,
+		storage_key TEXT NOT NULL DEFAULT '',
+		size INTEGER NOT NULL DEFAULT 0,
+		error TEXT NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL,
+		completed_at DATETIME,
+		expires_at DATETIME
 	);
 	`
-	_, err = db.Exec(createCommentReactions)
+	_, err = db.Exec(createDataExports)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	postReactions := `
-	CREATE TABLE IF NOT EXISTS post_reactions (
-        user_id INTEGER NOT NULL,
-    	post_id INTEGER NOT NULL,
-    	value INTEGER NOT NULL,
-    	PRIMARY KEY (user_id, post_id)
-    )
+	createExternalIdentities := `
+	CREATE TABLE IF NOT EXISTS external_identities (
+		provider TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		email TEXT NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL,
+		PRIMARY KEY (provider, subject)
+	);
 	`
-	_, err = db.Exec(postReactions)
+	_, err = db.Exec(createExternalIdentities)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	return db
+	createAuthStates := `
+	CREATE TABLE IF NOT EXISTS auth_states (
+		state TEXT PRIMARY KEY,
+		provider TEXT NOT NULL,
+		nonce TEXT NOT NULL,
+		verifier TEXT NOT NULL,
+		redirect_uri TEXT NOT NULL,
+		link_user_id INTEGER NOT NULL DEFAULT 0,
+		confirm INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME NOT NULL
+	);
+	`
+	_, err = db.Exec(createAuthStates)
+	if err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "auth_states", "confirm", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+
+	createRecoveryCodes := `
+	CREATE TABLE IF NOT EXISTS recovery_codes (
+		user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		code_hash TEXT NOT NULL,
+		used_at DATETIME,
+		PRIMARY KEY (user_id, code_hash)
+	);
+	`
+	_, err = db.Exec(createRecoveryCodes)
+	if err != nil {
+		return err
+	}
+
+	createLoginChallenges := `
+	CREATE TABLE IF NOT EXISTS login_challenges (
+		token_hash TEXT PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME NOT NULL
+	);
+	`
+	_, err = db.Exec(createLoginChallenges)
+	if err != nil {
+		return err
+	}
+
+	createSiteSettings := `
+	CREATE TABLE IF NOT EXISTS site_settings (
+		key TEXT PRIMARY KEY,
+		value TEXT NOT NULL
+	);
+	`
+	_, err = db.Exec(createSiteSettings)
+	if err != nil {
+		return err
+	}
+
+	err = ensureForeignKeys(db, []tableSchema{
+		{"sessions", createSessions},
+		{"posts", createPosts},
+		{"post_categories", createPostCategories},
+		{"comments", createComments},
+		{"reactions", createReactions},
+		{"notifications", createNotifications},
+		{"notification_preferences", createNotificationPreferences},
+		{"mentions", createMentions},
+		{"attachments", createAttachments},
+		{"email_changes", createEmailChanges},
+		{"data_exports", createDataExports},
+		{"external_identities", createExternalIdentities},
+		{"recovery_codes", createRecoveryCodes},
+		{"login_challenges", createLoginChallenges},
+	})
+	if err != nil {
+		return err
+	}
+
+	// The placeholder for anonymised content used to be found by its
+	// address, which an account registered first could hold instead.
+	_, err = db.Exec(`UPDATE users SET role = 'deleted'
+		WHERE email = 'deleted-user@invalid' AND username = '[deleted user]' AND password = ''
+		AND NOT EXISTS (SELECT 1 FROM users WHERE role = 'deleted')`)
+	if err != nil {
+		return err
+	}
+
+	for _, index := range sqliteIndexes {
+		if _, err := db.Exec(index); err != nil {
+			return err
+		}
+	}
+
+	if err := normalizeTimestamps(db); err != nil {
+		return err
+	}
+
+	_, err = db.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, SchemaVersion))
+	return err
+}
+
+// sqliteIndexes cover the lookups the store makes on every page: comments
+// and attachments of a post, reactions on a target, a user's sessions,
+// notifications and activity, and posts by date or category. Blobs are
+// looked up by key when they are served or released, and a partial unique
+// index keeps the deleted-user placeholder to one account.
+var sqliteIndexes = []string{
+	`CREATE UNIQUE INDEX IF NOT EXISTS idx_users_deleted ON users (role) WHERE role = 'deleted'`,
+	`CREATE INDEX IF NOT EXISTS idx_users_avatar_key ON users (avatar_key)`,
+	`CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions (user_id)`,
+	`CREATE INDEX IF NOT EXISTS idx_posts_created_at ON posts (created_at, id)`,
+	`CREATE INDEX IF NOT EXISTS idx_posts_user_id ON posts (user_id)`,
+	`CREATE INDEX IF NOT EXISTS idx_post_categories_category_id ON post_categories (category_id, post_id)`,
+	`CREATE INDEX IF NOT EXISTS idx_comments_post_id ON comments (post_id, created_at)`,
+	`CREATE INDEX IF NOT EXISTS idx_comments_user_id ON comments (user_id)`,
+	`CREATE INDEX IF NOT EXISTS idx_reactions_target ON reactions (target_type, target_id)`,
+	`CREATE INDEX IF NOT EXISTS idx_notifications_user_id ON notifications (user_id, created_at)`,
+	`CREATE INDEX IF NOT EXISTS idx_notifications_post_id ON notifications (post_id)`,
+	`CREATE INDEX IF NOT EXISTS idx_notifications_comment_id ON notifications (comment_id)`,
+	`CREATE INDEX IF NOT EXISTS idx_mentions_user_id ON mentions (user_id)`,
+	`CREATE INDEX IF NOT EXISTS idx_attachments_post_id ON attachments (post_id)`,
+	`CREATE INDEX IF NOT EXISTS idx_attachments_storage_key ON attachments (storage_key)`,
+	`CREATE INDEX IF NOT EXISTS idx_data_exports_user_id ON data_exports (user_id)`,
+	`CREATE INDEX IF NOT EXISTS idx_external_identities_user_id ON external_identities (user_id)`,
+}
 
+type tableSchema struct {
+	name   string
+	create string
+}
+
+// ensureForeignKeys rebuilds tables created before their foreign keys were
+// declared; SQLite cannot add a constraint to an existing table. Rows whose
+// parent is already gone are dropped afterwards, since the constraints
+// would reject them, so the database is copied aside first and the number
+// of rows dropped from each table is logged. It must run with foreign_keys
+// off.
+func ensureForeignKeys(db *sql.DB, tables []tableSchema) error {
+	var stale []tableSchema
+	for _, t := range tables {
+		var n int
+		err := db.QueryRow(`SELECT COUNT(*) FROM pragma_foreign_key_list(?)`, t.name).Scan(&n)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			stale = append(stale, t)
+		}
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+
+	copyPath, err := copyBeforeRebuild(db)
+	if err != nil {
+		return fmt.Errorf("copy the database before adding foreign keys: %w", err)
+	}
+	if copyPath != "" {
+		slog.Info("adding foreign keys; the database before the change is kept", "copy", copyPath)
+	}
+	for _, t := range stale {
+		if err := rebuildTable(db, t); err != nil {
+			return fmt.Errorf("add foreign keys to %s: %w", t.name, err)
+		}
+	}
+	removed, err := deleteOrphans(db)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(removed))
+	for table := range removed {
+		names = append(names, table)
+	}
+	sort.Strings(names)
+	for _, table := range names {
+		slog.Warn("removed rows whose parent no longer exists", "table", table, "rows", removed[table], "copy", copyPath)
+	}
+	return nil
+}
+
+// copyBeforeRebuild writes a copy of the database next to its file as
+// <file>.pre-foreign-keys-<time> and returns its path. An in-memory
+// database has no file and is not copied.
+func copyBeforeRebuild(db *sql.DB) (string, error) {
+	var file string
+	if err := db.QueryRow(`SELECT file FROM pragma_database_list WHERE name = 'main'`).Scan(&file); err != nil {
+		return "", err
+	}
+	if file == "" {
+		return "", nil
+	}
+	path := file + ".pre-foreign-keys-" + time.Now().UTC().Format("20060102T150405Z")
+	if _, err := db.Exec(`VACUUM INTO ?`, path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// rebuildTable follows SQLite's recipe for altering a table: create the new
+// shape under a temporary name, copy the rows, drop the old table and
+// rename the new one into place.
+func rebuildTable(db *sql.DB, t tableSchema) error {
+	prefix := "CREATE TABLE IF NOT EXISTS " + t.name + " ("
+	start := strings.Index(t.create, prefix)
+	if start < 0 {
+		return fmt.Errorf("unexpected schema %q", t.create)
+	}
+	create := "CREATE TABLE " + t.name + "_new (" + t.create[start+len(prefix):]
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.Exec(create); err != nil {
+		return err
+	}
+	rows, err := tx.Query(`SELECT name FROM pragma_table_info(?) WHERE name IN (SELECT name FROM pragma_table_info(?))`, t.name+"_new", t.name)
+	if err != nil {
+		return err
+	}
+	var columns []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		columns = append(columns, name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	list := strings.Join(columns, ", ")
+	stmts := []string{
+		`INSERT INTO ` + t.name + `_new (` + list + `) SELECT ` + list + ` FROM ` + t.name,
+		`DROP TABLE ` + t.name,
+		`ALTER TABLE ` + t.name + `_new RENAME TO ` + t.name,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// deleteOrphans removes the rows PRAGMA foreign_key_check reports until
+// none are left; removing an orphaned post can orphan its comments. It
+// returns how many rows it removed from each table.
+func deleteOrphans(db *sql.DB) (map[string]int, error) {
+	removed := map[string]int{}
+	for {
+		rows, err := db.Query(`SELECT "table", rowid FROM pragma_foreign_key_check`)
+		if err != nil {
+			return removed, err
+		}
+		orphans := map[string][]int64{}
+		for rows.Next() {
+			var table string
+			var rowid int64
+			if err := rows.Scan(&table, &rowid); err != nil {
+				rows.Close()
+				return removed, err
+			}
+			orphans[table] = append(orphans[table], rowid)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return removed, err
+		}
+		if len(orphans) == 0 {
+			return removed, nil
+		}
+		tx, err := db.Begin()
+		if err != nil {
+			return removed, err
+		}
+		for table, ids := range orphans {
+			for _, id := range ids {
+				if _, err := tx.Exec(`DELETE FROM `+table+` WHERE rowid = ?`, id); err != nil {
+					_ = tx.Rollback()
+					return removed, err
+				}
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return removed, err
+		}
+		for table, ids := range orphans {
+			removed[table] += len(ids)
+		}
+	}
+}
+
+// foldUsernames fills users.username_folded in the rows written before it
+// existed; see repo.FoldUsername. Names that fold the same are the same
+// name, so all but the oldest account holding one are renamed to
+// <name>_<id>. It must run before the unique index on the column is
+// created, and rebind adapts its placeholders to the dialect.
+func foldUsernames(db *sql.DB, rebind func(string) string) error {
+	var stale int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM users WHERE username_folded = ''`).Scan(&stale); err != nil {
+		return err
+	}
+	if stale == 0 {
+		return nil
+	}
+
+	type user struct {
+		id               int
+		username, folded string
+	}
+	rows, err := db.Query(`SELECT id, username, username_folded FROM users ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	taken := map[string]bool{}
+	var changed []user
+	for rows.Next() {
+		var u user
+		if err := rows.Scan(&u.id, &u.username, &u.folded); err != nil {
+			rows.Close()
+			return err
+		}
+		name := u.username
+		folded := repo.FoldUsername(name)
+		for taken[folded] {
+			name += "_" + strconv.Itoa(u.id)
+			folded = repo.FoldUsername(name)
+		}
+		taken[folded] = true
+		if name != u.username || folded != u.folded {
+			changed = append(changed, user{u.id, name, folded})
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	update := rebind(`UPDATE users SET username = ?, username_folded = ? WHERE id = ?`)
+	for _, u := range changed {
+		if _, err := tx.Exec(update, u.username, u.folded, u.id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func columnExists(db *sql.DB, table string, column string) (bool, error) {
+	rows, err := db.Query(`SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+func ensureColumn(db *sql.DB, table string, column string, definition string) error {
+	exists, err := columnExists(db, table, column)
+	if err != nil || exists {
+		return err
+	}
+	_, err = db.Exec(`ALTER TABLE ` + table + ` ADD COLUMN ` + column + ` ` + definition)
+	return err
+}
+
+func ensureUserColumns(db *sql.DB) error {
+	columns := []struct {
+		name       string
+		definition string
+	}{
+		{"created_at", "DATETIME"},
+		{"email_verified", "INTEGER NOT NULL DEFAULT 0"},
+		{"username_folded", "TEXT NOT NULL DEFAULT ''"},
+		{"bio", "TEXT NOT NULL DEFAULT ''"},
+		{"avatar_key", "TEXT NOT NULL DEFAULT ''"},
+		{"avatar_type", "TEXT NOT NULL DEFAULT ''"},
+		{"profile_visibility", "TEXT NOT NULL DEFAULT 'public'"},
+		{"show_liked", "INTEGER NOT NULL DEFAULT 0"},
+		{"role", "TEXT NOT NULL DEFAULT 'user'"},
+		{"totp_secret", "TEXT NOT NULL DEFAULT ''"},
+		{"totp_enabled", "INTEGER NOT NULL DEFAULT 0"},
+		{"totp_last_step", "INTEGER NOT NULL DEFAULT 0"},
+		{"locale", "TEXT NOT NULL DEFAULT ''"},
+		{"timezone", "TEXT NOT NULL DEFAULT ''"},
+	}
+	for _, c := range columns {
+		if err := ensureColumn(db, "users", c.name, c.definition); err != nil {
+			return err
+		}
+	}
+
+	// Accounts created before join dates were recorded get the date of their
+	// first post or comment; posts and comments may not exist yet on a fresh
+	// database, so fall back to now in that case.
+	postsExist, err := tableExists(db, "posts")
+	if err != nil {
+		return err
+	}
+	commentsExist, err := tableExists(db, "comments")
+	if err != nil {
+		return err
+	}
+	if !postsExist || !commentsExist {
+		_, err = db.Exec(`UPDATE users SET created_at = CURRENT_TIMESTAMP WHERE created_at IS NULL`)
+		return err
+	}
+	_, err = db.Exec(`
+		UPDATE users SET created_at = COALESCE((
+			SELECT MIN(t) FROM (
+				SELECT created_at AS t FROM posts WHERE user_id = users.id
+				UNION ALL
+				SELECT created_at FROM comments WHERE user_id = users.id
+			)
+		), CURRENT_TIMESTAMP)
+		WHERE created_at IS NULL
+	`)
+	return err
+}
+
+// ensureUpdatedAt adds updated_at to a table that predates it; rows that
+// were never edited count as updated when they were created.
+func ensureUpdatedAt(db *sql.DB, table string) error {
+	if err := ensureColumn(db, table, "updated_at", "DATETIME"); err != nil {
+		return err
+	}
+	_, err := db.Exec(`UPDATE ` + table + ` SET updated_at = created_at WHERE updated_at IS NULL`)
+	return err
+}
+
+// timestampColumns lists every DATETIME column. They are all kept in UTC.
+var timestampColumns = []struct {
+	table  string
+	column string
+}{
+	{"users", "created_at"},
+	{"sessions", "expires_at"},
+	{"posts", "created_at"},
+	{"posts", "updated_at"},
+	{"comments", "created_at"},
+	{"comments", "updated_at"},
+	{"reactions", "created_at"},
+	{"notifications", "created_at"},
+	{"notifications", "read_at"},
+	{"attachments", "created_at"},
+	{"email_changes", "expires_at"},
+	{"data_exports", "created_at"},
+	{"data_exports", "completed_at"},
+	{"data_exports", "expires_at"},
+	{"external_identities", "created_at"},
+	{"auth_states", "created_at"},
+	{"recovery_codes", "used_at"},
+	{"login_challenges", "created_at"},
+}
+
+// normalizeTimestamps rewrites times written in the server's local zone by
+// older versions to UTC. SQLite compares them as text, so a mix of offsets
+// sorts and expires rows wrongly. Values already in UTC are left alone,
+// which makes this a no-op after the first run.
+func normalizeTimestamps(db *sql.DB) error {
+	for _, c := range timestampColumns {
+		utc := `strftime('%Y-%m-%d %H:%M:%f+00:00', ` + c.column + `)`
+		_, err := db.Exec(`UPDATE ` + c.table + ` SET ` + c.column + ` = ` + utc + `
+			WHERE ` + c.column + ` IS NOT NULL AND ` + c.column + ` NOT LIKE '%+00:00' AND ` + utc + ` IS NOT NULL`)
+		if err != nil {
+			return fmt.Errorf("normalize %s.%s: %w", c.table, c.column, err)
+		}
+	}
+	return nil
+}
+
+func tableExists(db *sql.DB, name string) (bool, error) {
+	var n int
+	err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, name).Scan(&n)
+	return n > 0, err
+}
+
+func migrateLegacyReactions(db *sql.DB, table string, column string, targetType string) error {
+	exists, err := tableExists(db, table)
+	if err != nil || !exists {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		INSERT OR IGNORE INTO reactions (user_id, target_type, target_id, kind, created_at)
+		SELECT user_id, ?, `+column+`, CASE WHEN value > 0 THEN 'like' ELSE 'dislike' END, CURRENT_TIMESTAMP
+		FROM `+table, targetType)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec(`DROP TABLE ` + table)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
 }
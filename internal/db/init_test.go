@@ -0,0 +1,233 @@
+package db
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"forum/internal/repo"
+)
+
+// legacySchema is the shape of a database from before foreign keys were
+// declared and usernames were folded, holding the placeholder for deleted
+// users as it was then found, by its address, two pairs of usernames that
+// differ only in case, a post by a user who is gone, a comment on that post
+// and a comment on a post that is gone.
+const legacySchema = `
+	CREATE TABLE users (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		email TEXT NOT NULL UNIQUE,
+		username TEXT NOT NULL,
+		password TEXT NOT NULL
+	);
+	CREATE TABLE categories (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL UNIQUE
+	);
+	CREATE TABLE posts (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		title TEXT NOT NULL,
+		content TEXT NOT NULL,
+		category_id INTEGER NOT NULL,
+		created_at DATETIME NOT NULL
+	);
+	CREATE TABLE comments (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		post_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		content TEXT NOT NULL,
+		created_at DATETIME NOT NULL
+	);
+	INSERT INTO users (id, email, username, password) VALUES
+		(1, 'ann@example.com', 'ann', 'x'),
+		(2, 'ann2@example.com', 'ANN', 'x'),
+		(3, 'ivan@example.com', 'Иван', 'x'),
+		(4, 'ivan2@example.com', 'иван', 'x'),
+		(5, 'deleted-user@invalid', '[deleted user]', '');
+	INSERT INTO categories (id, name) VALUES (1, 'General');
+	INSERT INTO posts (id, user_id, title, content, category_id, created_at) VALUES
+		(1, 1, 'Kept', 'Body', 1, '2023-01-02 03:04:05'),
+		(2, 9, 'Orphan', 'Body', 1, '2023-01-02 03:04:05');
+	INSERT INTO comments (id, post_id, user_id, content, created_at) VALUES
+		(1, 1, 1, 'Kept', '2023-01-02 03:04:05'),
+		(2, 2, 1, 'On the orphan', '2023-01-02 03:04:05'),
+		(3, 7, 1, 'On a missing post', '2023-01-02 03:04:05');
+`
+
+// openLegacy writes legacySchema into a new file and returns its path.
+func openLegacy(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "forum.db")
+	db, err := sql.Open(repo.SQLite.Name(), "file:"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(legacySchema); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func ids(t *testing.T, db *sql.DB, table string) string {
+	t.Helper()
+	var list sql.NullString
+	if err := db.QueryRow(`SELECT group_concat(id) FROM (SELECT id FROM ` + table + ` ORDER BY id)`).Scan(&list); err != nil {
+		t.Fatal(err)
+	}
+	return list.String
+}
+
+func TestRebuildTableAndDeleteOrphans(t *testing.T) {
+	db, err := sql.Open(repo.SQLite.Name(), sqliteDSN(openLegacy(t), false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	posts := tableSchema{"posts", `
+		CREATE TABLE IF NOT EXISTS posts (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+			title TEXT NOT NULL,
+			content TEXT NOT NULL,
+			created_at DATETIME NOT NULL,
+			updated_at DATETIME
+		);`}
+	comments := tableSchema{"comments", `
+		CREATE TABLE IF NOT EXISTS comments (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			post_id INTEGER NOT NULL REFERENCES posts (id) ON DELETE CASCADE,
+			user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+			content TEXT NOT NULL,
+			created_at DATETIME NOT NULL
+		);`}
+	for _, table := range []tableSchema{posts, comments} {
+		if err := rebuildTable(db, table); err != nil {
+			t.Fatalf("rebuild %s: %v", table.name, err)
+		}
+		var n int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM pragma_foreign_key_list(?)`, table.name).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		if n == 0 {
+			t.Errorf("%s has no foreign keys after the rebuild", table.name)
+		}
+	}
+	// The rebuild keeps every row and drops the columns the new shape lacks.
+	if got := ids(t, db, "posts"); got != "1,2" {
+		t.Errorf("posts after the rebuild = %s, want 1,2", got)
+	}
+	if ok, err := columnExists(db, "posts", "category_id"); err != nil || ok {
+		t.Errorf("posts.category_id exists: %v, %v", ok, err)
+	}
+
+	removed, err := deleteOrphans(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Comment 2 is only orphaned once post 2 is gone.
+	if removed["posts"] != 1 || removed["comments"] != 2 || len(removed) != 2 {
+		t.Errorf("removed %v, want 1 post and 2 comments", removed)
+	}
+	if got := ids(t, db, "posts"); got != "1" {
+		t.Errorf("posts = %s, want 1", got)
+	}
+	if got := ids(t, db, "comments"); got != "1" {
+		t.Errorf("comments = %s, want 1", got)
+	}
+
+	if removed, err := deleteOrphans(db); err != nil || len(removed) != 0 {
+		t.Errorf("a second pass removed %v, %v", removed, err)
+	}
+}
+
+func TestOpenMigratesLegacyDatabase(t *testing.T) {
+	path := openLegacy(t)
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := db.Pool()
+	defer pool.Close()
+
+	if got := ids(t, pool, "posts"); got != "1" {
+		t.Errorf("posts = %s, want 1", got)
+	}
+	if got := ids(t, pool, "comments"); got != "1" {
+		t.Errorf("comments = %s, want 1", got)
+	}
+	var violations int
+	if err := pool.QueryRow(`SELECT COUNT(*) FROM pragma_foreign_key_check`).Scan(&violations); err != nil {
+		t.Fatal(err)
+	}
+	if violations != 0 {
+		t.Errorf("%d foreign key violations after the migration", violations)
+	}
+
+	var role string
+	if err := pool.QueryRow(`SELECT role FROM users WHERE id = 5`).Scan(&role); err != nil {
+		t.Fatal(err)
+	}
+	if role != "deleted" {
+		t.Errorf("the placeholder for deleted users has role %q, want deleted", role)
+	}
+
+	// The orphans are still in the copy taken before the rebuild.
+	copies, err := filepath.Glob(path + ".pre-foreign-keys-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(copies) != 1 {
+		t.Fatalf("copies before the rebuild = %v, want one", copies)
+	}
+	old, err := sql.Open(repo.SQLite.Name(), "file:"+copies[0]+"?mode=ro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer old.Close()
+	if got := ids(t, old, "comments"); got != "1,2,3" {
+		t.Errorf("comments in the copy = %s, want 1,2,3", got)
+	}
+
+	// Opening it again has nothing to rebuild and takes no second copy.
+	pool.Close()
+	db, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Pool().Close()
+	if again, _ := filepath.Glob(path + ".pre-foreign-keys-*"); len(again) != 1 {
+		t.Errorf("copies after opening again = %v, want one", again)
+	}
+}
+
+func TestOpenFoldsUsernames(t *testing.T) {
+	db, err := Open(openLegacy(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := db.Pool()
+	defer pool.Close()
+
+	want := map[int][2]string{
+		1: {"ann", "ann"},
+		2: {"ANN_2", "ann_2"},
+		3: {"Иван", "иван"},
+		4: {"иван_4", "иван_4"},
+	}
+	for id, names := range want {
+		var username, folded string
+		if err := pool.QueryRow(`SELECT username, username_folded FROM users WHERE id = ?`, id).Scan(&username, &folded); err != nil {
+			t.Fatal(err)
+		}
+		if username != names[0] || folded != names[1] {
+			t.Errorf("user %d = %q folded %q, want %q folded %q", id, username, folded, names[0], names[1])
+		}
+	}
+	if _, err := pool.Exec(`INSERT INTO users (email, username, username_folded, password) VALUES ('x@example.com', 'ИВАН', 'иван', '')`); err == nil {
+		t.Error("a username differing only in case was inserted")
+	}
+}
@@ -0,0 +1,90 @@
+package db
+
+import (
+	"database/sql"
+	"runtime"
+	"strings"
+
+	"forum/internal/repo"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// DefaultSQLitePath is the database used when no URL is configured.
+const DefaultSQLitePath = "forum.db"
+
+// Open connects to the database at url and brings its schema up to date. A
+// postgres:// or postgresql:// URL is a PostgreSQL server; anything else is
+// the path of a SQLite file, forum.db when url is empty.
+func Open(url string) (*repo.DB, error) {
+	if path, ok := SQLitePath(url); ok {
+		return openSQLite(path)
+	}
+	return openPostgres(url)
+}
+
+// SQLitePath returns the file a database URL given to Open points at, and
+// false when the URL is a PostgreSQL server.
+func SQLitePath(url string) (string, bool) {
+	if strings.HasPrefix(url, "postgres://") || strings.HasPrefix(url, "postgresql://") {
+		return "", false
+	}
+	if url == "" {
+		return DefaultSQLitePath, true
+	}
+	return url, true
+}
+
+func openPostgres(url string) (*repo.DB, error) {
+	pool, err := sql.Open(repo.Postgres.Name(), url)
+	if err != nil {
+		return nil, err
+	}
+	if err := initPostgres(pool); err != nil {
+		_ = pool.Close()
+		return nil, err
+	}
+	return repo.NewDB(pool, repo.Postgres), nil
+}
+
+// openSQLite migrates the file over a single connection with foreign keys
+// off, which rebuilding tables requires, and then opens the pool the app
+// runs on.
+func openSQLite(path string) (*repo.DB, error) {
+	migrate, err := sql.Open(repo.SQLite.Name(), sqliteDSN(path, false))
+	if err != nil {
+		return nil, err
+	}
+	migrate.SetMaxOpenConns(1)
+	err = initSQLite(migrate)
+	if closeErr := migrate.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := sql.Open(repo.SQLite.Name(), sqliteDSN(path, true))
+	if err != nil {
+		return nil, err
+	}
+	// WAL lets any number of readers run beside the one writer, so the pool
+	// is sized for reads. Idle connections are kept, as opening one means
+	// running the pragmas and parsing the schema again.
+	conns := max(4, runtime.NumCPU())
+	pool.SetMaxOpenConns(conns)
+	pool.SetMaxIdleConns(conns)
+	return repo.NewDB(pool, repo.SQLite), nil
+}
+
+// sqliteDSN sets up every connection: WAL journaling, a busy timeout so a
+// writer waits for the lock instead of failing with SQLITE_BUSY, and
+// transactions that take the write lock when they begin, so two of them
+// cannot deadlock upgrading from a read lock.
+func sqliteDSN(path string, foreignKeys bool) string {
+	fk := "off"
+	if foreignKeys {
+		fk = "on"
+	}
+	return "file:" + path + "?_journal_mode=WAL&_synchronous=NORMAL&_busy_timeout=5000&_txlock=immediate&_foreign_keys=" + fk
+}
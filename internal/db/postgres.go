@@ -0,0 +1,222 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+
+	"forum/internal/repo"
+)
+
+// postgresSchema is the PostgreSQL counterpart of the SQLite tables in
+// initSQLite. A PostgreSQL database starts out on the current schema, so it
+// carries none of the SQLite migrations; columns added since PostgreSQL
+// support arrived are added with ADD COLUMN IF NOT EXISTS. Unique constraints are named
+// <table>_<column>_key, which repo.Postgres relies on to tell which one a
+// duplicate broke.
+var postgresSchema = []string{
+	`CREATE TABLE IF NOT EXISTS users (
+		id BIGSERIAL PRIMARY KEY,
+		email TEXT NOT NULL CONSTRAINT users_email_key UNIQUE,
+		email_verified BOOLEAN NOT NULL DEFAULT FALSE,
+		username TEXT NOT NULL,
+		username_folded TEXT NOT NULL DEFAULT '',
+		password TEXT NOT NULL,
+		created_at TIMESTAMPTZ,
+		bio TEXT NOT NULL DEFAULT '',
+		avatar_key TEXT NOT NULL DEFAULT '',
+		avatar_type TEXT NOT NULL DEFAULT '',
+		profile_visibility TEXT NOT NULL DEFAULT 'public',
+		show_liked BOOLEAN NOT NULL DEFAULT FALSE,
+		role TEXT NOT NULL DEFAULT 'user',
+		totp_secret TEXT NOT NULL DEFAULT '',
+		totp_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+		totp_last_step BIGINT NOT NULL DEFAULT 0,
+		locale TEXT NOT NULL DEFAULT '',
+		timezone TEXT NOT NULL DEFAULT ''
+	)`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS username_folded TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified BOOLEAN NOT NULL DEFAULT FALSE`,
+	`CREATE TABLE IF NOT EXISTS categories (
+		id BIGSERIAL PRIMARY KEY,
+		name TEXT NOT NULL CONSTRAINT categories_name_key UNIQUE
+	)`,
+	`CREATE TABLE IF NOT EXISTS sessions (
+		id TEXT PRIMARY KEY,
+		user_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		expires_at TIMESTAMPTZ NOT NULL,
+		confirmed_at TIMESTAMPTZ
+	)`,
+	`ALTER TABLE sessions ADD COLUMN IF NOT EXISTS confirmed_at TIMESTAMPTZ`,
+	`CREATE TABLE IF NOT EXISTS posts (
+		id BIGSERIAL PRIMARY KEY,
+		user_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		title TEXT NOT NULL,
+		content TEXT NOT NULL,
+		category_id BIGINT NOT NULL REFERENCES categories (id),
+		created_at TIMESTAMPTZ NOT NULL,
+		updated_at TIMESTAMPTZ,
+		count_likes INTEGER NOT NULL DEFAULT 0
+	)`,
+	`CREATE TABLE IF NOT EXISTS post_categories (
+		post_id BIGINT NOT NULL REFERENCES posts (id) ON DELETE CASCADE,
+		category_id BIGINT NOT NULL REFERENCES categories (id) ON DELETE CASCADE,
+		PRIMARY KEY (post_id, category_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS comments (
+		id BIGSERIAL PRIMARY KEY,
+		post_id BIGINT NOT NULL REFERENCES posts (id) ON DELETE CASCADE,
+		user_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		content TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL,
+		updated_at TIMESTAMPTZ,
+		likes INTEGER NOT NULL DEFAULT 0,
+		dislikes INTEGER NOT NULL DEFAULT 0
+	)`,
+	// See initSQLite: reactions and mentions have no foreign key to their
+	// target and are cleaned up by the code that deletes it.
+	`CREATE TABLE IF NOT EXISTS reactions (
+		user_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		target_type TEXT NOT NULL,
+		target_id BIGINT NOT NULL,
+		kind TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL,
+		PRIMARY KEY (user_id, target_type, target_id, kind)
+	)`,
+	`CREATE TABLE IF NOT EXISTS notifications (
+		id BIGSERIAL PRIMARY KEY,
+		user_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		actor_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		type TEXT NOT NULL,
+		post_id BIGINT NOT NULL REFERENCES posts (id) ON DELETE CASCADE,
+		comment_id BIGINT REFERENCES comments (id) ON DELETE CASCADE,
+		detail TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ NOT NULL,
+		read_at TIMESTAMPTZ
+	)`,
+	`CREATE TABLE IF NOT EXISTS notification_preferences (
+		user_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		type TEXT NOT NULL,
+		enabled BOOLEAN NOT NULL,
+		PRIMARY KEY (user_id, type)
+	)`,
+	`CREATE TABLE IF NOT EXISTS mentions (
+		source_type TEXT NOT NULL,
+		source_id BIGINT NOT NULL,
+		user_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		PRIMARY KEY (source_type, source_id, user_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS attachments (
+		id BIGSERIAL PRIMARY KEY,
+		post_id BIGINT NOT NULL REFERENCES posts (id) ON DELETE CASCADE,
+		user_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		storage_key TEXT NOT NULL,
+		content_type TEXT NOT NULL,
+		size BIGINT NOT NULL,
+		width INTEGER NOT NULL,
+		height INTEGER NOT NULL,
+		original_name TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS email_changes (
+		user_id BIGINT PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+		email TEXT NOT NULL,
+		token_hash TEXT NOT NULL CONSTRAINT email_changes_token_hash_key UNIQUE,
+		expires_at TIMESTAMPTZ NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS data_exports (
+		id BIGSERIAL PRIMARY KEY,
+		user_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		status TEXT NOT NULL,
+		storage_key TEXT NOT NULL DEFAULT '',
+		size BIGINT NOT NULL DEFAULT 0,
+		error TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ NOT NULL,
+		completed_at TIMESTAMPTZ,
+		expires_at TIMESTAMPTZ
+	)`,
+	`CREATE TABLE IF NOT EXISTS external_identities (
+		provider TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		user_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		email TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ NOT NULL,
+		PRIMARY KEY (provider, subject)
+	)`,
+	`CREATE TABLE IF NOT EXISTS auth_states (
+		state TEXT PRIMARY KEY,
+		provider TEXT NOT NULL,
+		nonce TEXT NOT NULL,
+		verifier TEXT NOT NULL,
+		redirect_uri TEXT NOT NULL,
+		link_user_id BIGINT NOT NULL DEFAULT 0,
+		confirm BOOLEAN NOT NULL DEFAULT FALSE,
+		created_at TIMESTAMPTZ NOT NULL
+	)`,
+	`ALTER TABLE auth_states ADD COLUMN IF NOT EXISTS confirm BOOLEAN NOT NULL DEFAULT FALSE`,
+	`CREATE TABLE IF NOT EXISTS recovery_codes (
+		user_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		code_hash TEXT NOT NULL,
+		used_at TIMESTAMPTZ,
+		PRIMARY KEY (user_id, code_hash)
+	)`,
+	`CREATE TABLE IF NOT EXISTS login_challenges (
+		token_hash TEXT PRIMARY KEY,
+		user_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		created_at TIMESTAMPTZ NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS site_settings (
+		key TEXT PRIMARY KEY,
+		value TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS schema_version (
+		id INTEGER PRIMARY KEY CHECK (id = 1),
+		version INTEGER NOT NULL
+	)`,
+	`UPDATE users SET role = 'deleted'
+		WHERE email = 'deleted-user@invalid' AND username = '[deleted user]' AND password = ''
+		AND NOT EXISTS (SELECT 1 FROM users WHERE role = 'deleted')`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS users_role_deleted_key ON users (role) WHERE role = 'deleted'`,
+	`CREATE INDEX IF NOT EXISTS idx_users_avatar_key ON users (avatar_key)`,
+	`CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions (user_id)`,
+	`CREATE INDEX IF NOT EXISTS idx_posts_created_at ON posts (created_at, id)`,
+	`CREATE INDEX IF NOT EXISTS idx_posts_user_id ON posts (user_id)`,
+	`CREATE INDEX IF NOT EXISTS idx_post_categories_category_id ON post_categories (category_id, post_id)`,
+	`CREATE INDEX IF NOT EXISTS idx_comments_post_id ON comments (post_id, created_at)`,
+	`CREATE INDEX IF NOT EXISTS idx_comments_user_id ON comments (user_id)`,
+	`CREATE INDEX IF NOT EXISTS idx_reactions_target ON reactions (target_type, target_id)`,
+	`CREATE INDEX IF NOT EXISTS idx_notifications_user_id ON notifications (user_id, created_at)`,
+	`CREATE INDEX IF NOT EXISTS idx_notifications_post_id ON notifications (post_id)`,
+	`CREATE INDEX IF NOT EXISTS idx_notifications_comment_id ON notifications (comment_id)`,
+	`CREATE INDEX IF NOT EXISTS idx_mentions_user_id ON mentions (user_id)`,
+	`CREATE INDEX IF NOT EXISTS idx_attachments_post_id ON attachments (post_id)`,
+	`CREATE INDEX IF NOT EXISTS idx_attachments_storage_key ON attachments (storage_key)`,
+	`CREATE INDEX IF NOT EXISTS idx_data_exports_user_id ON data_exports (user_id)`,
+	`CREATE INDEX IF NOT EXISTS idx_external_identities_user_id ON external_identities (user_id)`,
+}
+
+// initPostgres creates the schema in a PostgreSQL database and records its
+// version.
+func initPostgres(db *sql.DB) error {
+	for _, stmt := range postgresSchema {
+		if _, err := db.Exec(stmt); err != nil {
+			return fmt.Errorf("postgres schema: %w", err)
+		}
+	}
+	// The unique index on usernames moved from lower(username) to the
+	// column filled in Go, which only exists once foldUsernames has run.
+	if err := foldUsernames(db, repo.Postgres.Rebind); err != nil {
+		return fmt.Errorf("fold usernames: %w", err)
+	}
+	for _, stmt := range []string{
+		`DROP INDEX IF EXISTS users_username_key`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS users_username_folded_key ON users (username_folded)`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			return fmt.Errorf("postgres schema: %w", err)
+		}
+	}
+	_, err := db.Exec(`INSERT INTO schema_version (id, version) VALUES (1, $1)
+		ON CONFLICT (id) DO UPDATE SET version = excluded.version`, SchemaVersion)
+	return err
+}
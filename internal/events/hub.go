@@ -0,0 +1,130 @@
+package events
+
+import (
+	"strconv"
+	"sync"
+)
+
+const (
+	TopicFeed = "feed"
+
+	TypePostCreated     = "post.created"
+	TypeCommentCreated  = "comment.created"
+	TypeReactionToggled = "reaction.toggled"
+)
+
+func PostTopic(postID int) string {
+	return "post:" + strconv.Itoa(postID)
+}
+
+func CategoryTopic(categoryID int) string {
+	return "category:" + strconv.Itoa(categoryID)
+}
+
+type Event struct {
+	ID     uint64
+	Type   string
+	Topics []string
+	Data   any
+}
+
+type Subscription interface {
+	Events() <-chan Event
+	Close()
+}
+
+type Broker interface {
+	Publish(e Event)
+	Subscribe(topics ...string) Subscription
+}
+
+type Hub struct {
+	mu     sync.Mutex
+	seq    uint64
+	buffer int
+	topics map[string]map[*subscriber]struct{}
+}
+
+func NewHub(buffer int) *Hub {
+	if buffer <= 0 {
+		buffer = 16
+	}
+	return &Hub{
+		buffer: buffer,
+		topics: make(map[string]map[*subscriber]struct{}),
+	}
+}
+
+func (h *Hub) Publish(e Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.seq++
+	e.ID = h.seq
+
+	delivered := make(map[*subscriber]struct{})
+	for _, topic := range e.Topics {
+		for sub := range h.topics[topic] {
+			if _, ok := delivered[sub]; ok {
+				continue
+			}
+			delivered[sub] = struct{}{}
+			select {
+			case sub.ch <- e:
+			default:
+				h.removeLocked(sub)
+			}
+		}
+	}
+}
+
+func (h *Hub) Subscribe(topics ...string) Subscription {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sub := &subscriber{
+		hub:    h,
+		topics: topics,
+		ch:     make(chan Event, h.buffer),
+	}
+	for _, topic := range topics {
+		subs, ok := h.topics[topic]
+		if !ok {
+			subs = make(map[*subscriber]struct{})
+			h.topics[topic] = subs
+		}
+		subs[sub] = struct{}{}
+	}
+	return sub
+}
+
+func (h *Hub) removeLocked(sub *subscriber) {
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	for _, topic := range sub.topics {
+		delete(h.topics[topic], sub)
+		if len(h.topics[topic]) == 0 {
+			delete(h.topics, topic)
+		}
+	}
+	close(sub.ch)
+}
+
+type subscriber struct {
+	hub    *Hub
+	topics []string
+	ch     chan Event
+	closed bool
+}
+
+func (s *subscriber) Events() <-chan Event {
+	return s.ch
+}
+
+func (s *subscriber) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.hub.removeLocked(s)
+}
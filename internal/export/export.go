@@ -0,0 +1,193 @@
+// Package export builds personal data archives in the background.
+package export
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"path"
+	"sync"
+	"time"
+
+	"forum/internal/models"
+	"forum/internal/storage"
+)
+
+// Retention is how long a finished archive can be downloaded.
+const Retention = 7 * 24 * time.Hour
+
+type Store interface {
+	GetUserExportData(ctx context.Context, userID int) (*models.UserExport, error)
+	MarkExportReady(ctx context.Context, exportID int, storageKey string, size int64, expiresAt time.Time) error
+	MarkExportFailed(ctx context.Context, exportID int, message string) error
+	DeleteOldExports(ctx context.Context, userID int, keepID int) ([]string, error)
+}
+
+// Runner builds archives on background goroutines, at most Concurrency at a
+// time; further jobs wait for a free slot.
+type Runner struct {
+	Store Store
+	Blobs storage.BlobStore
+
+	slots chan struct{}
+	wg    sync.WaitGroup
+}
+
+func NewRunner(store Store, blobs storage.BlobStore, concurrency int) *Runner {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	return &Runner{
+		Store: store,
+		Blobs: blobs,
+		slots: make(chan struct{}, concurrency),
+	}
+}
+
+// Start schedules the archive for a pending export row and returns at once.
+func (r *Runner) Start(exportID int, userID int) {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		r.slots <- struct{}{}
+		defer func() { <-r.slots }()
+
+		ctx := context.Background()
+		if err := r.build(ctx, exportID, userID); err != nil {
+			slog.Error("data export", "export_id", exportID, "err", err)
+			if err := r.Store.MarkExportFailed(ctx, exportID, err.Error()); err != nil {
+				slog.Error("mark data export failed", "export_id", exportID, "err", err)
+			}
+		}
+	}()
+}
+
+// Wait blocks until all scheduled archives are finished.
+func (r *Runner) Wait() {
+	r.wg.Wait()
+}
+
+func (r *Runner) build(ctx context.Context, exportID int, userID int) error {
+	data, err := r.Store.GetUserExportData(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("collect data: %w", err)
+	}
+
+	suffix := make([]byte, 16)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	key := fmt.Sprintf("exports/%d/%s.zip", userID, hex.EncodeToString(suffix))
+
+	pr, pw := io.Pipe()
+	counter := &countingWriter{w: pw}
+	go func() {
+		pw.CloseWithError(r.writeArchive(ctx, counter, data))
+	}()
+	if err := r.Blobs.Put(ctx, key, pr); err != nil {
+		pr.CloseWithError(err)
+		return fmt.Errorf("store archive: %w", err)
+	}
+
+	if err := r.Store.MarkExportReady(ctx, exportID, key, counter.n, time.Now().Add(Retention)); err != nil {
+		_ = r.Blobs.Delete(ctx, key)
+		return err
+	}
+
+	oldKeys, err := r.Store.DeleteOldExports(ctx, userID, exportID)
+	if err != nil {
+		slog.Error("delete old data exports", "user_id", userID, "err", err)
+	}
+	for _, old := range oldKeys {
+		if err := r.Blobs.Delete(ctx, old); err != nil {
+			slog.Error("delete data export", "key", old, "err", err)
+		}
+	}
+	return nil
+}
+
+func (r *Runner) writeArchive(ctx context.Context, w io.Writer, data *models.UserExport) error {
+	zw := zip.NewWriter(w)
+
+	// Files are named after their content hash, so the JSON can point at them
+	// before they are copied in.
+	files := make(map[string]string)
+	if data.Profile.AvatarKey != "" {
+		name := "files/" + path.Base(data.Profile.AvatarKey)
+		data.Profile.Avatar = name
+		files[name] = data.Profile.AvatarKey
+	}
+	for i := range data.Posts {
+		for j := range data.Posts[i].Attachments {
+			a := &data.Posts[i].Attachments[j]
+			a.File = "files/" + path.Base(a.StorageKey)
+			files[a.File] = a.StorageKey
+		}
+	}
+
+	documents := []struct {
+		name  string
+		value any
+	}{
+		{"profile.json", data.Profile},
+		{"posts.json", data.Posts},
+		{"comments.json", data.Comments},
+		{"reactions.json", data.Reactions},
+		{"sessions.json", data.Sessions},
+		{"notifications.json", data.Notifications},
+		{"notification_preferences.json", data.Preferences},
+		{"linked_accounts.json", data.Identities},
+	}
+	for _, doc := range documents {
+		f, err := zw.Create(doc.name)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(doc.value); err != nil {
+			return err
+		}
+	}
+
+	for name, key := range files {
+		if err := copyBlob(ctx, zw, r.Blobs, name, key); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+func copyBlob(ctx context.Context, zw *zip.Writer, blobs storage.BlobStore, name string, key string) error {
+	blob, err := blobs.Open(ctx, key)
+	if err != nil {
+		// A missing file should not cost the user the rest of the archive.
+		slog.Warn("data export: open blob", "key", key, "err", err)
+		return nil
+	}
+	defer blob.Close()
+
+	f, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: blob.ModTime()})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, blob)
+	return err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -1,83 +1,276 @@
 package handlers
 
 import (
-	"database/sql"
+	"context"
+	"fmt"
 	"html/template"
-	"log"
 	"net/http"
+	"time"
 
+	"forum/internal/events"
+	"forum/internal/i18n"
+	"forum/internal/identity"
+	"forum/internal/mail"
+	"forum/internal/markup"
+	"forum/internal/middleware"
 	"forum/internal/models"
 	"forum/internal/repo"
+	"forum/internal/storage"
+	"forum/internal/view"
 )
 
 type App struct {
-	DB       *sql.DB
-	Tpl      *template.Template
-	Posts    PostRepo
-	Users    UserRepo
-	Comments CommentRepo
+	Views      *view.Set
+	I18n       *i18n.Bundle
+	Posts      PostRepo
+	Users      UserRepo
+	Comments   CommentRepo
+	Reactions  ReactionRepo
+	Sessions   SessionRepo
+	Categories CategoryRepo
+	Events     events.Broker
+
+	Notifications NotificationRepo
+	Mentions      MentionRepo
+	Attachments   AttachmentRepo
+	Blobs         storage.BlobStore
+	Profiles      ProfileRepo
+	Mailer        mail.Mailer
+	Exports       ExportRepo
+	Exporter      ExportRunner
+	Identities    IdentityRepo
+	Providers     []identity.Provider
+	TwoFactor     TwoFactorRepo
+	Site          SiteSettingsRepo
+
+	// Backups runs database snapshots. It is nil when the database has no
+	// backup support, as on PostgreSQL.
+	Backups BackupJob
+
+	// Ready reports whether the database can serve traffic.
+	Ready func(ctx context.Context) error
+
+	// Location is the zone times are shown in when neither the user nor
+	// their browser picked one.
+	Location *time.Location
+
+	// Dev shows panic stack traces on error pages. Never set it in
+	// production.
+	Dev bool
 }
 
-func (a *App) render(w http.ResponseWriter, page string, data any) {
-	a.renderWithStatus(w, http.StatusOK, page, data)
+// Pages lists every page template the handlers render; main checks that
+// they all exist before serving.
+var Pages = []string{
+	"admin_backups.html",
+	"admin_security.html",
+	"create_post.html",
+	"error.html",
+	"home.html",
+	"login.html",
+	"login_2fa.html",
+	"notifications.html",
+	"post.html",
+	"profile.html",
+	"profile_edit.html",
+	"register.html",
+	"settings.html",
+	"twofactor.html",
 }
 
-func (a *App) renderWithStatus(w http.ResponseWriter, status int, page string, data any) {
-	tmpl, err := a.Tpl.Clone()
-	if err != nil {
-		log.Printf("template clone error: %v", err)
-		http.Error(w, "template error", http.StatusInternalServerError)
-		return
-	}
+// TemplateFuncs adds the app's own helpers to the shared ones. assetURL
+// turns a static file name into its cache-busting URL; tr translates into
+// the language the templates are parsed for, loc reports the zone times are
+// shown in by the execution in progress, and langs feeds the language
+// switcher.
+func TemplateFuncs(assetURL func(name string) string, tr *i18n.Translator, loc func() *time.Location, langs []string) template.FuncMap {
+	funcs := view.Funcs()
+	funcs["markdown"] = markup.Render
+	funcs["asset"] = assetURL
+	funcs["t"] = tr.T
+	funcs["tn"] = tr.N
+	funcs["lang"] = tr.Lang
+	funcs["languages"] = func() []string { return langs }
+	funcs["formatDate"] = func(t time.Time) string { return tr.Date(t, loc()) }
+	funcs["formatDateTime"] = func(t time.Time) string { return tr.DateTime(t, loc()) }
+	funcs["timeAgo"] = func(t time.Time) string { return tr.Ago(t, time.Now(), loc()) }
+	funcs["timestamp"] = func(t time.Time) template.HTML { return timestamp(tr, loc(), t) }
+	return funcs
+}
 
-	if _, err := tmpl.ParseFiles("templates/" + page); err != nil {
-		log.Printf("template parse error: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+// timestamp renders t as a <time> element reading "5 minutes ago" with the
+// exact time in the tooltip.
+func timestamp(tr *i18n.Translator, loc *time.Location, t time.Time) template.HTML {
+	if t.IsZero() {
+		return ""
 	}
+	return template.HTML(fmt.Sprintf(`<time datetime="%s" title="%s">%s</time>`,
+		t.UTC().Format(time.RFC3339),
+		template.HTMLEscapeString(tr.DateTime(t, loc)),
+		template.HTMLEscapeString(tr.Ago(t, time.Now(), loc)),
+	))
+}
 
-	if status != http.StatusOK {
-		w.WriteHeader(status)
-	}
+func (a *App) render(w http.ResponseWriter, r *http.Request, page string, data any) {
+	a.renderWithStatus(w, r, http.StatusOK, page, data)
+}
 
-	if err := tmpl.ExecuteTemplate(w, "layout.html", data); err != nil {
-		log.Printf("template execute error: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+func (a *App) renderWithStatus(w http.ResponseWriter, r *http.Request, status int, page string, data any) {
+	body, err := a.Views.Page(a.lang(r), a.location(r), page, data)
+	if err != nil {
+		a.logError(r, err, "template execute", "page", page)
+		http.Error(w, a.T(r, "error.internal"), http.StatusInternalServerError)
 		return
 	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
+
+// renderError shows the error page with the message for key in the
+// request's language.
+func (a *App) renderError(w http.ResponseWriter, r *http.Request, status int, key string, user *models.User) {
+	a.renderErrorText(w, r, status, a.T(r, key), user)
 }
 
-func (a *App) renderError(w http.ResponseWriter, status int, message string, user *models.User) {
+// renderErrorText shows the error page with a message that is already
+// translated.
+func (a *App) renderErrorText(w http.ResponseWriter, r *http.Request, status int, message string, user *models.User) {
 	data := models.ErrorPageData{
 		CurrentUser: user,
 		Status:      status,
 		Message:     message,
+		RequestID:   w.Header().Get(middleware.RequestIDHeader),
 	}
-	a.renderWithStatus(w, status, "error.html", data)
+	a.renderWithStatus(w, r, status, "error.html", data)
 }
 
-func (a *App) logError(err error, message string) {
+// logError records err with the ID of the request it happened in, so a user
+// quoting the ID from the error page leads straight to the log line. args
+// are further key-value pairs for the log line.
+func (a *App) logError(r *http.Request, err error, message string, args ...any) {
 	if err == nil {
 		return
 	}
-	log.Printf("%s: %v", message, err)
+	middleware.Logger(r).Error(message, append(args, "err", err)...)
 }
 
 type PostRepo interface {
-	CreatePost(userID int, title string, content string, categoryIDs []int) (int, error)
-	GetPostCards(filter repo.PostCardsFilter) ([]models.PostCard, error)
-	GetPostCardWithComments(postID int) (*models.PostCardWithComments, error)
-	PostExists(postID int) (bool, error)
+	CreatePost(ctx context.Context, userID int, title string, content string, categoryIDs []int, attachments []models.Attachment) (int, error)
+	GetPostCards(ctx context.Context, filter repo.PostCardsFilter) ([]models.PostCard, error)
+	GetPostCardWithComments(ctx context.Context, postID int, viewerID int) (*models.PostCardWithComments, error)
+	PostExists(ctx context.Context, postID int) (bool, error)
+	GetPostAuthorID(ctx context.Context, postID int) (int, error)
 }
 
 type UserRepo interface {
-	CreateUser(email string, username string, password string) error
-	GetUserByEmail(email string) (*models.User, error)
-	GetUserByID(id int) (*models.User, error)
+	CreateUser(ctx context.Context, email string, username string, password string) error
+	GetUserByEmail(ctx context.Context, email string) (*models.User, error)
+	GetUserByID(ctx context.Context, id int) (*models.User, error)
+	GetUserByUsername(ctx context.Context, username string) (*models.User, error)
+	SearchUsernames(ctx context.Context, prefix string, limit int) ([]string, error)
+	UpdateUsername(ctx context.Context, userID int, username string) error
+	UpdatePassword(ctx context.Context, userID int, password string) error
+	UpdateLocale(ctx context.Context, userID int, locale string) error
+	UpdateTimezone(ctx context.Context, userID int, timezone string) error
+	CreateEmailChange(ctx context.Context, userID int, email string, tokenHash string, expiresAt time.Time) error
+	GetPendingEmail(ctx context.Context, userID int) (string, error)
+	ConfirmEmailChange(ctx context.Context, tokenHash string) (int, error)
+	DeleteAccount(ctx context.Context, userID int, mode string) ([]string, error)
 }
 
 type CommentRepo interface {
-	CreateComment(postID int, userID int, content string) error
-	GetCommentsByPostID(postID int) ([]models.CommentView, error)
-	CommentExists(commentID int) (bool, error)
+	CreateComment(ctx context.Context, postID int, userID int, content string) (int, error)
+	GetCommentByID(ctx context.Context, commentID int, viewerID int) (*models.CommentView, error)
+	GetCommentsByPostID(ctx context.Context, postID int) ([]models.CommentView, error)
+	CommentExists(ctx context.Context, commentID int) (bool, error)
+}
+
+type ReactionRepo interface {
+	Toggle(ctx context.Context, userID int, targetType string, targetID int, kind string) (*models.ReactionState, error)
+}
+
+type SessionRepo interface {
+	CreateSession(ctx context.Context, userID int) (string, error)
+	DeleteSession(ctx context.Context, sessionID string) error
+	DeleteOtherSessions(ctx context.Context, userID int, keepSessionID string) error
+	ConfirmSession(ctx context.Context, sessionID string) error
+}
+
+type CategoryRepo interface {
+	GetAllCategories(ctx context.Context) ([]models.Category, error)
+	CategoryExists(ctx context.Context, categoryID int) (bool, error)
+}
+
+type NotificationRepo interface {
+	CreateNotification(ctx context.Context, n models.Notification) error
+	GetNotifications(ctx context.Context, userID int, limit int) ([]models.Notification, error)
+	GetNotificationByID(ctx context.Context, userID int, notificationID int) (*models.Notification, error)
+	MarkNotificationRead(ctx context.Context, userID int, notificationID int) error
+	MarkAllNotificationsRead(ctx context.Context, userID int) error
+	GetNotificationPreferences(ctx context.Context, userID int) ([]models.NotificationPreference, error)
+	SetNotificationPreferences(ctx context.Context, userID int, enabled map[string]bool) error
+}
+
+type MentionRepo interface {
+	SaveMentions(ctx context.Context, sourceType string, sourceID int, usernames []string) ([]models.User, error)
+}
+
+type AttachmentRepo interface {
+	GetAttachmentByKey(ctx context.Context, storageKey string) (*models.Attachment, error)
+	ReleaseBlobs(ctx context.Context, keys []string, remove func(ctx context.Context, key string) error) error
+}
+
+type ProfileRepo interface {
+	GetProfileByUsername(ctx context.Context, username string) (*models.Profile, error)
+	GetCommentsByUserID(ctx context.Context, userID int, limit int, offset int) ([]models.ProfileComment, error)
+	UpdateProfile(ctx context.Context, userID int, bio string, visibility string, showLiked bool) error
+	SetAvatar(ctx context.Context, userID int, key string, contentType string) error
+	GetAvatarContentType(ctx context.Context, key string) (string, error)
+}
+
+type ExportRepo interface {
+	CreateExport(ctx context.Context, userID int) (int, error)
+	GetLatestExport(ctx context.Context, userID int) (*models.DataExport, error)
+	GetExportByID(ctx context.Context, userID int, exportID int) (*models.DataExport, error)
+	DeleteOldExports(ctx context.Context, userID int, keepID int) ([]string, error)
+}
+
+type ExportRunner interface {
+	Start(exportID int, userID int)
+}
+
+type IdentityRepo interface {
+	SaveAuthState(ctx context.Context, st models.AuthState) error
+	TakeAuthState(ctx context.Context, state string, maxAge time.Duration) (*models.AuthState, error)
+	GetUserIDByIdentity(ctx context.Context, provider string, subject string) (int, error)
+	LinkIdentity(ctx context.Context, userID int, provider string, subject string, email string, emailVerified bool) error
+	GetIdentitiesByUserID(ctx context.Context, userID int) ([]models.ExternalIdentity, error)
+}
+
+type TwoFactorRepo interface {
+	GetTOTP(ctx context.Context, userID int) (secret string, enabled bool, lastStep int64, err error)
+	SetPendingTOTPSecret(ctx context.Context, userID int, secret string) error
+	EnableTOTP(ctx context.Context, userID int, step int64, codeHashes []string) error
+	DisableTOTP(ctx context.Context, userID int) error
+	UseTOTPStep(ctx context.Context, userID int, step int64) (bool, error)
+	ReplaceRecoveryCodes(ctx context.Context, userID int, codeHashes []string) error
+	UseRecoveryCode(ctx context.Context, userID int, codeHash string) (bool, error)
+	CountRecoveryCodes(ctx context.Context, userID int) (int, error)
+	CreateLoginChallenge(ctx context.Context, userID int, tokenHash string) error
+	GetLoginChallenge(ctx context.Context, tokenHash string, maxAge time.Duration) (userID int, attempts int, err error)
+	FailLoginChallenge(ctx context.Context, tokenHash string) error
+	DeleteLoginChallenge(ctx context.Context, tokenHash string) error
+}
+
+type SiteSettingsRepo interface {
+	GetSiteSetting(ctx context.Context, key string) (string, error)
+	SetSiteSetting(ctx context.Context, key string, value string) error
+}
+
+type BackupJob interface {
+	Trigger() bool
+	Status() models.BackupStatus
+	Snapshots() ([]models.BackupSnapshot, error)
 }
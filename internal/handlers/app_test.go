@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"context"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"forum/internal/events"
+	"forum/internal/i18n"
+	"forum/internal/middleware"
+	"forum/internal/models"
+	"forum/internal/repo/memory"
+	"forum/internal/storage"
+	"forum/internal/view"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// newTestApp returns an App with the real templates and message catalogs
+// and no repositories; each test sets the ones its handlers use.
+func newTestApp(t *testing.T) *App {
+	t.Helper()
+	bundle, err := i18n.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	views, err := view.Load(os.DirFS("../../templates"), bundle.Languages(), func(lang string, loc func() *time.Location) template.FuncMap {
+		assetURL := func(name string) string { return "/static/" + name }
+		return TemplateFuncs(assetURL, bundle.Translator(lang), loc, bundle.Languages())
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := views.Require(Pages...); err != nil {
+		t.Fatal(err)
+	}
+	return &App{
+		Views:    views,
+		I18n:     bundle,
+		Events:   events.NewHub(16),
+		Location: time.UTC,
+	}
+}
+
+// routes serves the pages the tests visit the way main does, behind the
+// authentication middleware.
+func routes(a *App, sessions middleware.Sessions) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", a.HomeHandler)
+	mux.HandleFunc("/post", a.PostPageHandler)
+	mux.HandleFunc("/register", a.RegisterHandler)
+	mux.HandleFunc("/login", a.LoginHandler)
+	mux.HandleFunc("/login/2fa", a.LoginTwoFactorHandler)
+	mux.HandleFunc("/logout", a.LogoutHandler)
+	mux.HandleFunc("/create-post", a.CreatePostHandler)
+	mux.HandleFunc("/addcomment", a.CommentHandler)
+	mux.HandleFunc("/react-post", a.ReactPosts)
+	mux.HandleFunc("/react-comment", a.ReactComment)
+	mux.HandleFunc("/attachments/", a.AttachmentHandler)
+	mux.HandleFunc("/avatars/", a.AvatarHandler)
+	mux.HandleFunc("/user/", a.ProfileHandler)
+	mux.HandleFunc("/settings/profile", a.ProfileSettingsHandler)
+	mux.HandleFunc("/settings", a.SettingsHandler)
+	mux.HandleFunc("/settings/username", a.SettingsUsernameHandler)
+	mux.HandleFunc("/settings/email", a.SettingsEmailHandler)
+	mux.HandleFunc("/settings/delete", a.SettingsDeleteHandler)
+	mux.HandleFunc("/settings/export", a.SettingsExportHandler)
+	mux.HandleFunc("/settings/export/download", a.ExportDownloadHandler)
+	mux.HandleFunc("/settings/2fa", a.TwoFactorSettingsHandler)
+	mux.HandleFunc("/admin/security", a.AdminSecurityHandler)
+	mux.HandleFunc("/admin/backups", a.AdminBackupsHandler)
+	mux.HandleFunc("/auth/login", a.ExternalLoginHandler)
+	mux.HandleFunc("/auth/callback", a.ExternalCallbackHandler)
+	return middleware.Authenticate(sessions, mux)
+}
+
+// memoryForum is an App over the in-memory repositories, with ann and bob
+// signed in and blobs kept in a temporary directory. Both have the password
+// "pw".
+type memoryForum struct {
+	app        *App
+	handler    http.Handler
+	sessions   *memory.Sessions
+	posts      *memory.Posts
+	reactions  *memory.Reactions
+	twoFactor  *memory.TwoFactor
+	site       *memory.Site
+	identities *memory.Identities
+	exports    *memory.Exports
+	profiles   *memory.Profiles
+	exporter   *startedExports
+	blobDir    string
+	// ann and bob are the users' IDs and annSession and bobSession their
+	// session cookies.
+	ann, bob               int
+	annSession, bobSession string
+}
+
+func newMemoryForum(t *testing.T) *memoryForum {
+	t.Helper()
+	f := &memoryForum{ann: 1, bob: 2, sessions: memory.NewSessions(), blobDir: t.TempDir()}
+	names := map[int]string{f.ann: "ann", f.bob: "bob"}
+	categories := &memory.Categories{List: []models.Category{{ID: 1, Name: "Fruit"}, {ID: 2, Name: "Stones"}}}
+	f.reactions = &memory.Reactions{Names: names}
+	f.posts = &memory.Posts{Names: names, Categories: categories, Reactions: f.reactions}
+	blobs, err := storage.NewFSStore(f.blobDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	password, err := bcrypt.GenerateFromPassword([]byte("pw"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	for id, name := range names {
+		f.sessions.AddUser(models.User{ID: id, Username: name, Email: name + "@example.com", Password: string(password), Role: models.RoleUser})
+	}
+	if f.annSession, err = f.sessions.CreateSession(ctx, f.ann); err != nil {
+		t.Fatal(err)
+	}
+	if f.bobSession, err = f.sessions.CreateSession(ctx, f.bob); err != nil {
+		t.Fatal(err)
+	}
+
+	f.app = newTestApp(t)
+	f.app.Posts = f.posts
+	f.app.Attachments = f.posts
+	f.app.Reactions = f.reactions
+	f.app.Sessions = f.sessions
+	f.app.Categories = categories
+	f.app.Mentions = noMentions{}
+	f.app.Blobs = blobs
+	f.app.Users = f.sessions
+	f.twoFactor = &memory.TwoFactor{Users: f.sessions}
+	f.app.TwoFactor = f.twoFactor
+	f.site = &memory.Site{}
+	f.app.Site = f.site
+	f.identities = &memory.Identities{Users: f.sessions}
+	f.app.Identities = f.identities
+	f.exports = &memory.Exports{}
+	f.app.Exports = f.exports
+	f.exporter = &startedExports{}
+	f.app.Exporter = f.exporter
+	f.profiles = &memory.Profiles{Users: f.sessions}
+	f.app.Profiles = f.profiles
+	f.handler = routes(f.app, f.sessions)
+	return f
+}
+
+// serve runs r through the app, signed in with session when it is not
+// empty.
+func (f *memoryForum) serve(r *http.Request, session string) *httptest.ResponseRecorder {
+	if session != "" {
+		r.AddCookie(&http.Cookie{Name: middleware.SessionCookie, Value: session})
+	}
+	r.Header.Set("Accept-Language", "en")
+	w := httptest.NewRecorder()
+	f.handler.ServeHTTP(w, r)
+	return w
+}
+
+// setRole changes the role of a memoryForum user.
+func (f *memoryForum) setRole(t *testing.T, userID int, role string) {
+	t.Helper()
+	user, err := f.sessions.GetUserByID(context.Background(), userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.Role = role
+	f.sessions.AddUser(*user)
+}
+
+// startedExports records the exports handed to it instead of building them.
+type startedExports struct {
+	mu  sync.Mutex
+	ids []int
+}
+
+func (s *startedExports) Start(exportID int, userID int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ids = append(s.ids, exportID)
+}
+
+// noMentions finds nobody to mention.
+type noMentions struct{}
+
+func (noMentions) SaveMentions(ctx context.Context, sourceType string, sourceID int, usernames []string) ([]models.User, error) {
+	return nil, nil
+}
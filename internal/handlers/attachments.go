@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strings"
+
+	"forum/internal/media"
+	"forum/internal/models"
+	"forum/internal/storage"
+)
+
+const (
+	maxAttachments       = 4
+	maxUploadBodyBytes   = maxAttachments*(5<<20) + (1 << 20)
+	multipartMemoryLimit = 8 << 20
+)
+
+type uploadError struct {
+	message string
+}
+
+func (e *uploadError) Error() string {
+	return e.message
+}
+
+func (a *App) parsePostForm(w http.ResponseWriter, r *http.Request) error {
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		return r.ParseForm()
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadBodyBytes)
+	return r.ParseMultipartForm(multipartMemoryLimit)
+}
+
+// storeAttachments stores the images uploaded with a post. It returns the
+// attachments to save with the post and the images by key, which keepBlobs
+// needs once the post is saved.
+func (a *App) storeAttachments(r *http.Request) ([]models.Attachment, map[string][]byte, error) {
+	if r.MultipartForm == nil {
+		return nil, nil, nil
+	}
+
+	var files []*multipart.FileHeader
+	for _, fh := range r.MultipartForm.File["attachments"] {
+		if fh.Filename == "" && fh.Size == 0 {
+			continue
+		}
+		files = append(files, fh)
+	}
+	if len(files) > maxAttachments {
+		return nil, nil, &uploadError{a.T(r, "upload.too_many", maxAttachments)}
+	}
+
+	attachments := make([]models.Attachment, 0, len(files))
+	images := make(map[string][]byte, len(files))
+	for _, fh := range files {
+		att, data, err := a.storeAttachment(r, fh)
+		if err != nil {
+			a.discardAttachments(r, attachments)
+			return nil, nil, err
+		}
+		attachments = append(attachments, *att)
+		images[att.StorageKey] = data
+	}
+	return attachments, images, nil
+}
+
+// storeAttachment processes an uploaded image and stores it. It returns the
+// attachment and the stored bytes.
+func (a *App) storeAttachment(r *http.Request, fh *multipart.FileHeader) (*models.Attachment, []byte, error) {
+	limits := media.DefaultLimits
+	if fh.Size > limits.MaxBytes {
+		return nil, nil, &uploadError{a.T(r, "upload.file_too_big", fh.Filename, limits.MaxBytes>>20)}
+	}
+
+	f, err := fh.Open()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, limits.MaxBytes+1))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	img, err := media.Process(data, limits)
+	switch {
+	case errors.Is(err, media.ErrTooLarge):
+		return nil, nil, &uploadError{a.T(r, "upload.file_too_big", fh.Filename, limits.MaxBytes>>20)}
+	case errors.Is(err, media.ErrUnsupportedType):
+		return nil, nil, &uploadError{a.T(r, "upload.bad_type", fh.Filename)}
+	case errors.Is(err, media.ErrDimensions):
+		return nil, nil, &uploadError{a.T(r, "upload.too_many_pixels", fh.Filename)}
+	case errors.Is(err, media.ErrCorrupt):
+		return nil, nil, &uploadError{a.T(r, "upload.unreadable", fh.Filename)}
+	case err != nil:
+		return nil, nil, err
+	}
+
+	sum := sha256.Sum256(img.Data)
+	hash := hex.EncodeToString(sum[:])
+	key := hash[:2] + "/" + hash[2:4] + "/" + hash + img.Ext
+
+	if err := a.Blobs.Put(r.Context(), key, bytes.NewReader(img.Data)); err != nil {
+		return nil, nil, err
+	}
+
+	return &models.Attachment{
+		StorageKey:   key,
+		ContentType:  img.ContentType,
+		Size:         int64(len(img.Data)),
+		Width:        img.Width,
+		Height:       img.Height,
+		OriginalName: fh.Filename,
+	}, img.Data, nil
+}
+
+// discardAttachments deletes the blobs of attachments that were stored for a
+// post that was then not created. Blobs are named by their content, so one
+// that another post uses, or starts to use meanwhile, is kept; see
+// repo.ReleaseBlobs.
+func (a *App) discardAttachments(r *http.Request, attachments []models.Attachment) {
+	// The request may have failed because the client went away; the cleanup
+	// still has to run.
+	ctx := context.WithoutCancel(r.Context())
+	keys := make([]string, len(attachments))
+	for i, att := range attachments {
+		keys[i] = att.StorageKey
+	}
+	if err := a.Attachments.ReleaseBlobs(ctx, keys, a.Blobs.Delete); err != nil {
+		a.logError(r, err, "discard attachment blobs")
+	}
+}
+
+// keepBlobs runs once the rows referencing images are committed, and stores
+// again any of them that were removed in between: a request discarding the
+// same picture may have found no row using it after this one's Put. Once
+// the rows are committed, no release can remove the blobs any more.
+func (a *App) keepBlobs(r *http.Request, images map[string][]byte) {
+	ctx := context.WithoutCancel(r.Context())
+	for key, data := range images {
+		blob, err := a.Blobs.Open(ctx, key)
+		if err == nil {
+			blob.Close()
+			continue
+		}
+		if !errors.Is(err, storage.ErrNotFound) {
+			a.logError(r, err, "check blob", "key", key)
+			continue
+		}
+		if err := a.Blobs.Put(ctx, key, bytes.NewReader(data)); err != nil {
+			a.logError(r, err, "store blob again", "key", key)
+		}
+	}
+}
+
+func (a *App) AttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, a.T(r, "error.method_not_allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, "/attachments/")
+	att, err := a.Attachments.GetAttachmentByKey(r.Context(), key)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		a.logError(r, err, "get attachment")
+		http.Error(w, a.T(r, "upload.load_failed"), http.StatusInternalServerError)
+		return
+	}
+
+	a.serveBlob(w, r, att.StorageKey, att.ContentType)
+}
+
+// serveBlob streams a content-addressed image from the blob store. Keys never
+// change content, so responses are cacheable forever.
+func (a *App) serveBlob(w http.ResponseWriter, r *http.Request, key string, contentType string) {
+	blob, err := a.Blobs.Open(r.Context(), key)
+	if errors.Is(err, storage.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		a.logError(r, err, "open blob")
+		http.Error(w, a.T(r, "upload.load_failed"), http.StatusInternalServerError)
+		return
+	}
+	defer blob.Close()
+
+	name := path.Base(key)
+	hash := strings.TrimSuffix(name, path.Ext(name))
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("ETag", `"`+hash+`"`)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'")
+	http.ServeContent(w, r, "", blob.ModTime(), blob)
+}
@@ -1,10 +1,13 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"strings"
 	"time"
 
+	"forum/internal/markup"
 	"forum/internal/middleware"
 	"forum/internal/models"
 	"forum/internal/repo"
@@ -13,36 +16,47 @@ import (
 
 func (a *App) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
-		user, _ := middleware.CurrentUser(a.DB, r)
+		user, _ := middleware.CurrentUser(r)
 		data := models.BasePageData{CurrentUser: user}
-		a.render(w, "register.html", data)
+		a.render(w, r, "register.html", data)
 		return
 	}
 	if r.Method == http.MethodPost {
 		if err := r.ParseForm(); err != nil {
-			data := models.BasePageData{Error: "Некорректная форма"}
-			a.renderWithStatus(w, http.StatusBadRequest, "register.html", data)
+			data := models.BasePageData{Error: a.T(r, "error.bad_form")}
+			a.renderWithStatus(w, r, http.StatusBadRequest, "register.html", data)
 			return
 		}
 		email := strings.TrimSpace(r.FormValue("email"))
 		username := strings.TrimSpace(r.FormValue("username"))
 		password := strings.TrimSpace(r.FormValue("password"))
 		if email == "" || username == "" || password == "" {
-			data := models.BasePageData{Error: "Заполните email, username и password"}
-			a.renderWithStatus(w, http.StatusBadRequest, "register.html", data)
+			data := models.BasePageData{Error: a.T(r, "auth.fill_register")}
+			a.renderWithStatus(w, r, http.StatusBadRequest, "register.html", data)
 			return
 		}
 
-		err := a.Users.CreateUser(email, username, password)
+		if !markup.ValidUsername(username) {
+			data := models.BasePageData{Error: a.T(r, "user.username_rules")}
+			a.renderWithStatus(w, r, http.StatusBadRequest, "register.html", data)
+			return
+		}
+
+		err := a.Users.CreateUser(r.Context(), email, username, password)
 		if err != nil {
-			if strings.Contains(err.Error(), "UNIQUE constraint failed: users.email") {
-				data := models.BasePageData{Error: "Пользователь с таким email уже существует"}
-				a.renderWithStatus(w, http.StatusBadRequest, "register.html", data)
+			if errors.Is(err, repo.ErrEmailTaken) {
+				data := models.BasePageData{Error: a.T(r, "user.email_taken")}
+				a.renderWithStatus(w, r, http.StatusBadRequest, "register.html", data)
+				return
+			}
+			if errors.Is(err, repo.ErrUsernameTaken) {
+				data := models.BasePageData{Error: a.T(r, "user.username_taken")}
+				a.renderWithStatus(w, r, http.StatusBadRequest, "register.html", data)
 				return
 			}
-			a.logError(err, "create user")
-			data := models.BasePageData{Error: "Ошибка регистрации"}
-			a.renderWithStatus(w, http.StatusInternalServerError, "register.html", data)
+			a.logError(r, err, "create user")
+			data := models.BasePageData{Error: a.T(r, "auth.register_failed")}
+			a.renderWithStatus(w, r, http.StatusInternalServerError, "register.html", data)
 			return
 		}
 
@@ -50,77 +64,83 @@ func (a *App) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	a.renderError(w, http.StatusMethodNotAllowed, "Метод не поддерживается", nil)
+	a.renderError(w, r, http.StatusMethodNotAllowed, "error.method_not_allowed", nil)
 }
 
 func (a *App) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
-		user, _ := middleware.CurrentUser(a.DB, r)
-		data := models.BasePageData{CurrentUser: user}
-		a.render(w, "login.html", data)
+		user, _ := middleware.CurrentUser(r)
+		a.renderLogin(w, r, http.StatusOK, user, "")
 		return
 	}
 	if r.Method == http.MethodPost {
 		if err := r.ParseForm(); err != nil {
-			data := models.BasePageData{Error: "Некорректная форма"}
-			a.renderWithStatus(w, http.StatusBadRequest, "login.html", data)
+			a.renderLogin(w, r, http.StatusBadRequest, nil, a.T(r, "error.bad_form"))
 			return
 		}
 		email := strings.TrimSpace(r.FormValue("email"))
 		password := strings.TrimSpace(r.FormValue("password"))
 		if email == "" || password == "" {
-			data := models.BasePageData{Error: "Введите email и password"}
-			a.renderWithStatus(w, http.StatusBadRequest, "login.html", data)
+			a.renderLogin(w, r, http.StatusBadRequest, nil, a.T(r, "auth.fill_login"))
 			return
 		}
 
-		user, err := a.Users.GetUserByEmail(email)
+		user, err := a.Users.GetUserByEmail(r.Context(), email)
 		if err != nil {
-			a.logError(err, "get user by email")
-			data := models.BasePageData{Error: "Пользователь не найден"}
-			a.renderWithStatus(w, http.StatusNotFound, "login.html", data)
+			a.logError(r, err, "get user by email")
+			a.renderLogin(w, r, http.StatusNotFound, nil, a.T(r, "user.not_found"))
 			return
 		}
 
 		err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
 		if err != nil {
-			data := models.BasePageData{Error: "Пароль неверный"}
-			a.renderWithStatus(w, http.StatusUnauthorized, "login.html", data)
-			return
-		}
-
-		sessionID, err := repo.CreateSessions(a.DB, user.ID)
-		if err != nil {
-			a.logError(err, "create session")
-			a.renderError(w, http.StatusInternalServerError, "Ошибка сессии", nil)
+			a.renderLogin(w, r, http.StatusUnauthorized, nil, a.T(r, "auth.wrong_password"))
 			return
 		}
-		http.SetCookie(w, &http.Cookie{
-			Name:    "session",
-			Value:   sessionID,
-			Expires: time.Now().Add(20 * time.Minute),
-			Path:    "/",
-		})
 
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+		a.completeLogin(w, r, user)
 		return
 	}
 
-	a.renderError(w, http.StatusMethodNotAllowed, "Метод не поддерживается", nil)
+	a.renderError(w, r, http.StatusMethodNotAllowed, "error.method_not_allowed", nil)
+}
+
+// renderLogin shows the login form with errMsg, which is already translated.
+func (a *App) renderLogin(w http.ResponseWriter, r *http.Request, status int, user *models.User, errMsg string) {
+	data := models.LoginPageData{
+		CurrentUser: user,
+		Providers:   a.loginProviders(),
+		Error:       errMsg,
+	}
+	a.renderWithStatus(w, r, status, "login.html", data)
+}
+
+func (a *App) startSession(ctx context.Context, w http.ResponseWriter, userID int) error {
+	sessionID, err := a.Sessions.CreateSession(ctx, userID)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:    middleware.SessionCookie,
+		Value:   sessionID,
+		Expires: time.Now().Add(20 * time.Minute),
+		Path:    "/",
+	})
+	return nil
 }
 
 func (a *App) LogoutHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		a.renderError(w, http.StatusMethodNotAllowed, "Метод не поддерживается", nil)
+		a.renderError(w, r, http.StatusMethodNotAllowed, "error.method_not_allowed", nil)
 		return
 	}
-	c, err := r.Cookie("session")
+	c, err := r.Cookie(middleware.SessionCookie)
 	if err == nil {
-		_ = repo.DeleteSession(a.DB, c.Value)
+		_ = a.Sessions.DeleteSession(r.Context(), c.Value)
 	}
 
 	http.SetCookie(w, &http.Cookie{
-		Name:    "session",
+		Name:    middleware.SessionCookie,
 		Value:   "",
 		Expires: time.Unix(0, 0),
 		MaxAge:  -1,
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"net/http"
+
+	"forum/internal/middleware"
+	"forum/internal/models"
+)
+
+// AdminBackupsHandler shows the state of the database backup job and lets
+// admins take a snapshot now.
+func (a *App) AdminBackupsHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := middleware.CurrentUser(r)
+	if err != nil {
+		a.renderError(w, r, http.StatusUnauthorized, "error.unauthorized", nil)
+		return
+	}
+	if user.Role != models.RoleAdmin {
+		a.renderError(w, r, http.StatusForbidden, "admin.only", user)
+		return
+	}
+
+	data := models.AdminBackupsPageData{CurrentUser: user, Available: a.Backups != nil}
+	status := http.StatusOK
+
+	if r.Method == http.MethodPost {
+		switch {
+		case !data.Available:
+			data.Error = a.T(r, "admin.backups_unavailable")
+			status = http.StatusConflict
+		case r.FormValue("action") != "run":
+			data.Error = a.T(r, "error.unknown_action")
+			status = http.StatusBadRequest
+		case a.Backups.Trigger():
+			data.Success = a.T(r, "admin.backups_started")
+		default:
+			data.Error = a.T(r, "admin.backups_busy")
+			status = http.StatusConflict
+		}
+	} else if r.Method != http.MethodGet {
+		a.renderError(w, r, http.StatusMethodNotAllowed, "error.method_not_allowed", user)
+		return
+	}
+
+	if data.Available {
+		data.Status = a.Backups.Status()
+		data.Snapshots, err = a.Backups.Snapshots()
+		if err != nil {
+			a.logError(r, err, "list backups")
+		}
+	}
+	a.renderWithStatus(w, r, status, "admin_backups.html", data)
+}
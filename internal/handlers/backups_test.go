@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"forum/internal/models"
+)
+
+// fakeBackups is a backup job that is busy when told to be and counts the
+// runs it starts.
+type fakeBackups struct {
+	busy    bool
+	started int
+}
+
+func (b *fakeBackups) Trigger() bool {
+	if b.busy {
+		return false
+	}
+	b.started++
+	return true
+}
+
+func (b *fakeBackups) Status() models.BackupStatus {
+	return models.BackupStatus{Dir: "backups", Running: b.busy}
+}
+
+func (b *fakeBackups) Snapshots() ([]models.BackupSnapshot, error) {
+	return []models.BackupSnapshot{{Name: "forum-20260101-000000.db", Size: 2048, CreatedAt: time.Now()}}, nil
+}
+
+func TestAdminBackups(t *testing.T) {
+	f := newMemoryForum(t)
+	f.setRole(t, f.ann, models.RoleAdmin)
+	run := url.Values{"action": {"run"}}
+
+	// Without a job, as with PostgreSQL, the page only says so.
+	if w := f.serve(form("/admin/backups", run), f.annSession); w.Code != http.StatusConflict {
+		t.Errorf("no job: status %d, want %d", w.Code, http.StatusConflict)
+	}
+
+	job := &fakeBackups{}
+	f.app.Backups = job
+	if w := f.serve(httptest.NewRequest(http.MethodGet, "/admin/backups", nil), f.bobSession); w.Code != http.StatusForbidden {
+		t.Errorf("bob: status %d, want %d", w.Code, http.StatusForbidden)
+	}
+	w := f.serve(httptest.NewRequest(http.MethodGet, "/admin/backups", nil), f.annSession)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "forum-20260101-000000.db") {
+		t.Errorf("list: status %d, want the snapshot listed\n%s", w.Code, w.Body)
+	}
+
+	tests := []struct {
+		name   string
+		busy   bool
+		values url.Values
+		status int
+	}{
+		{"run", false, run, http.StatusOK},
+		{"run while running", true, run, http.StatusConflict},
+		{"unknown action", false, url.Values{"action": {"restore"}}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		job.busy = tt.busy
+		if w := f.serve(form("/admin/backups", tt.values), f.annSession); w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.status)
+		}
+	}
+	if job.started != 1 {
+		t.Errorf("%d backups started, want 1", job.started)
+	}
+}
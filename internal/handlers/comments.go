@@ -5,23 +5,26 @@ import (
 	"strconv"
 	"strings"
 
+	"forum/internal/metrics"
 	"forum/internal/middleware"
+	"forum/internal/models"
 )
 
 func (a *App) CommentHandler(w http.ResponseWriter, r *http.Request) {
+	varyByFormat(w)
 	if r.Method != http.MethodPost {
-		a.renderError(w, http.StatusMethodNotAllowed, "Метод не поддерживается", nil)
+		a.respondError(w, r, http.StatusMethodNotAllowed, "error.method_not_allowed", nil)
 		return
 	}
 
-	user, err := middleware.CurrentUser(a.DB, r)
+	user, err := middleware.CurrentUser(r)
 	if err != nil {
-		a.renderError(w, http.StatusUnauthorized, "Вы должны авторизоваться, чтобы комментировать", nil)
+		a.respondError(w, r, http.StatusUnauthorized, "comment.login_required", nil)
 		return
 	}
 
 	if err := r.ParseForm(); err != nil {
-		a.renderError(w, http.StatusBadRequest, "Некорректная форма", user)
+		a.respondError(w, r, http.StatusBadRequest, "error.bad_form", user)
 		return
 	}
 	next := r.FormValue("next")
@@ -31,29 +34,57 @@ func (a *App) CommentHandler(w http.ResponseWriter, r *http.Request) {
 	postIDStr := r.FormValue("post_id")
 	content := strings.TrimSpace(r.FormValue("content"))
 	if content == "" {
-		a.renderError(w, http.StatusBadRequest, "Комментарий не может быть пустым", user)
+		a.respondError(w, r, http.StatusBadRequest, "comment.empty", user)
 		return
 	}
 
 	postID, err := strconv.Atoi(postIDStr)
 	if err != nil {
-		a.renderError(w, http.StatusBadRequest, "Неверный post_id", user)
+		a.respondError(w, r, http.StatusBadRequest, "post.invalid_post_id", user)
 		return
 	}
-	exists, err := a.Posts.PostExists(postID)
+	exists, err := a.Posts.PostExists(r.Context(), postID)
 	if err != nil {
-		a.logError(err, "post exists")
-		a.renderError(w, http.StatusInternalServerError, "Ошибка проверки поста", user)
+		a.logError(r, err, "post exists")
+		a.respondError(w, r, http.StatusInternalServerError, "post.check_failed", user)
 		return
 	}
 	if !exists {
-		a.renderError(w, http.StatusNotFound, "Пост не найден", user)
+		a.respondError(w, r, http.StatusNotFound, "post.not_found", user)
 		return
 	}
 
-	if err := a.Comments.CreateComment(postID, user.ID, content); err != nil {
-		a.logError(err, "create comment")
-		a.renderError(w, http.StatusInternalServerError, "Ошибка при создании комментария", user)
+	commentID, err := a.Comments.CreateComment(r.Context(), postID, user.ID, content)
+	if err != nil {
+		a.logError(r, err, "create comment")
+		a.respondError(w, r, http.StatusInternalServerError, "comment.create_failed", user)
+		return
+	}
+	metrics.CommentsCreated.Inc()
+
+	comment, err := a.Comments.GetCommentByID(r.Context(), commentID, user.ID)
+	if err != nil {
+		a.logError(r, err, "get comment")
+		a.respondError(w, r, http.StatusInternalServerError, "comment.load_failed", user)
+		return
+	}
+	a.publishCommentCreated(comment)
+	a.notifyPostAuthor(r, user, postID, models.Notification{
+		Type:      models.NotificationComment,
+		CommentID: commentID,
+	})
+	a.notifyMentions(r, user, postID, commentID, content)
+
+	if wantsJSON(r) {
+		a.writeJSON(w, r, http.StatusCreated, comment)
+		return
+	}
+	if wantsPartial(r) {
+		partial := "comment"
+		if r.FormValue("partial") == "comment-card" {
+			partial = "comment-card"
+		}
+		a.renderPartial(w, r, http.StatusCreated, partial, comment)
 		return
 	}
 
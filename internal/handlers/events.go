@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"forum/internal/events"
+	"forum/internal/middleware"
+	"forum/internal/models"
+)
+
+const heartbeatInterval = 15 * time.Second
+
+func (a *App) FeedEventsHandler(w http.ResponseWriter, r *http.Request) {
+	a.streamEvents(w, r, events.TopicFeed)
+}
+
+func (a *App) PostEventsHandler(w http.ResponseWriter, r *http.Request) {
+	postID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, a.T(r, "post.bad_id"), http.StatusBadRequest)
+		return
+	}
+	exists, err := a.Posts.PostExists(r.Context(), postID)
+	if err != nil {
+		a.logError(r, err, "post exists")
+		http.Error(w, a.T(r, "post.check_failed"), http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, a.T(r, "post.not_found"), http.StatusNotFound)
+		return
+	}
+	a.streamEvents(w, r, events.PostTopic(postID))
+}
+
+func (a *App) CategoryEventsHandler(w http.ResponseWriter, r *http.Request) {
+	categoryID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, a.T(r, "category.invalid"), http.StatusBadRequest)
+		return
+	}
+	exists, err := a.Categories.CategoryExists(r.Context(), categoryID)
+	if err != nil {
+		a.logError(r, err, "category exists")
+		http.Error(w, a.T(r, "category.load_failed"), http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, a.T(r, "category.not_found"), http.StatusNotFound)
+		return
+	}
+	a.streamEvents(w, r, events.CategoryTopic(categoryID))
+}
+
+func (a *App) streamEvents(w http.ResponseWriter, r *http.Request, topic string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, a.T(r, "error.method_not_allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok || a.Events == nil {
+		http.Error(w, a.T(r, "events.unsupported"), http.StatusNotImplemented)
+		return
+	}
+
+	// Loading the user makes their saved language and zone apply to the
+	// comments rendered for this stream.
+	_, _ = middleware.CurrentUser(r)
+
+	sub := a.Events.Subscribe(topic)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 3000\n\n")
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case e, ok := <-sub.Events():
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(a.eventData(r, e))
+			if err != nil {
+				a.logError(r, err, "encode event")
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func (a *App) publish(e events.Event) {
+	if a.Events == nil {
+		return
+	}
+	a.Events.Publish(e)
+}
+
+func (a *App) publishPostCreated(postID int, title string, author string, categoryIDs []int) {
+	topics := []string{events.TopicFeed, events.PostTopic(postID)}
+	for _, id := range categoryIDs {
+		topics = append(topics, events.CategoryTopic(id))
+	}
+	a.publish(events.Event{
+		Type:   events.TypePostCreated,
+		Topics: topics,
+		Data: map[string]any{
+			"post_id": postID,
+			"title":   title,
+			"author":  author,
+		},
+	})
+}
+
+// commentCreated is the data of a comment.created event. The comment is
+// rendered by eventData for each subscriber, in their language and zone.
+type commentCreated struct {
+	Comment models.CommentView
+}
+
+func (a *App) publishCommentCreated(comment *models.CommentView) {
+	c := *comment
+	c.Reactions = withoutMine(c.Reactions)
+	a.publish(events.Event{
+		Type:   events.TypeCommentCreated,
+		Topics: []string{events.TopicFeed, events.PostTopic(comment.PostID)},
+		Data:   commentCreated{Comment: c},
+	})
+}
+
+// eventData returns what the subscriber behind r is sent for e.
+func (a *App) eventData(r *http.Request, e events.Event) any {
+	c, ok := e.Data.(commentCreated)
+	if !ok {
+		return e.Data
+	}
+	html, err := a.renderPartialString(r, "comment", c.Comment)
+	if err != nil {
+		a.logError(r, err, "render comment event")
+	}
+	return map[string]any{
+		"post_id":    c.Comment.PostID,
+		"comment_id": c.Comment.ID,
+		"author":     c.Comment.AuthorName,
+		"content":    c.Comment.Content,
+		"html":       html,
+	}
+}
+
+func (a *App) publishReaction(postID int, targetType string, targetID int, state *models.ReactionState) {
+	reactions := withoutMine(state.Reactions)
+	a.publish(events.Event{
+		Type:   events.TypeReactionToggled,
+		Topics: []string{events.TopicFeed, events.PostTopic(postID)},
+		Data: map[string]any{
+			"post_id":     postID,
+			"target_type": targetType,
+			"target_id":   targetID,
+			"likes":       state.Likes,
+			"dislikes":    state.Dislikes,
+			"reactions":   reactions,
+		},
+	})
+}
+
+// withoutMine copies reaction summaries for broadcast, dropping the flags
+// that describe the reacting user.
+func withoutMine(summaries []models.ReactionSummary) []models.ReactionSummary {
+	out := make([]models.ReactionSummary, len(summaries))
+	copy(out, summaries)
+	for i := range out {
+		out[i].Mine = false
+	}
+	return out
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"forum/internal/middleware"
+	"forum/internal/models"
+	"forum/internal/storage"
+)
+
+// exportCooldown limits how often a user may request a new archive.
+const exportCooldown = 24 * time.Hour
+
+func (a *App) SettingsExportHandler(w http.ResponseWriter, r *http.Request) {
+	user := a.settingsUser(w, r)
+	if user == nil {
+		return
+	}
+
+	latest, err := a.Exports.GetLatestExport(r.Context(), user.ID)
+	if err != nil && err != sql.ErrNoRows {
+		a.logError(r, err, "get latest export")
+		a.renderSettings(w, r, http.StatusInternalServerError, user, a.T(r, "export.failed"), "")
+		return
+	}
+	if latest != nil {
+		if latest.Status == models.ExportPending {
+			a.renderSettings(w, r, http.StatusConflict, user, a.T(r, "export.pending"), "")
+			return
+		}
+		next := latest.CreatedAt.Add(exportCooldown)
+		if latest.Status == models.ExportReady && time.Now().Before(next) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(next).Seconds())+1))
+			a.renderSettings(w, r, http.StatusTooManyRequests, user,
+				a.T(r, "export.cooldown", a.translator(r).DateTime(next, a.location(r))), "")
+			return
+		}
+	}
+
+	exportID, err := a.Exports.CreateExport(r.Context(), user.ID)
+	if err != nil {
+		a.logError(r, err, "create export")
+		a.renderSettings(w, r, http.StatusInternalServerError, user, a.T(r, "export.failed"), "")
+		return
+	}
+	a.Exporter.Start(exportID, user.ID)
+
+	http.Redirect(w, r, "/settings?done=export", http.StatusSeeOther)
+}
+
+func (a *App) ExportDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		a.renderError(w, r, http.StatusMethodNotAllowed, "error.method_not_allowed", nil)
+		return
+	}
+	user, err := middleware.CurrentUser(r)
+	if err != nil {
+		a.renderError(w, r, http.StatusUnauthorized, "error.unauthorized", nil)
+		return
+	}
+
+	exportID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		a.renderError(w, r, http.StatusBadRequest, "error.bad_id", user)
+		return
+	}
+	export, err := a.Exports.GetExportByID(r.Context(), user.ID, exportID)
+	if err == sql.ErrNoRows {
+		a.renderError(w, r, http.StatusNotFound, "export.not_found", user)
+		return
+	}
+	if err != nil {
+		a.logError(r, err, "get export")
+		a.renderError(w, r, http.StatusInternalServerError, "export.failed", user)
+		return
+	}
+	if export.Status != models.ExportReady {
+		a.renderError(w, r, http.StatusNotFound, "export.not_ready", user)
+		return
+	}
+	if export.Expired() {
+		a.renderError(w, r, http.StatusGone, "export.expired", user)
+		return
+	}
+
+	blob, err := a.Blobs.Open(r.Context(), export.StorageKey)
+	if errors.Is(err, storage.ErrNotFound) {
+		a.renderError(w, r, http.StatusGone, "export.deleted", user)
+		return
+	}
+	if err != nil {
+		a.logError(r, err, "open export")
+		a.renderError(w, r, http.StatusInternalServerError, "export.failed", user)
+		return
+	}
+	defer blob.Close()
+
+	filename := fmt.Sprintf("forum-%s-%s.zip", user.Username, export.CreatedAt.Format("2006-01-02"))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Header().Set("Cache-Control", "private, no-store")
+	http.ServeContent(w, r, "", blob.ModTime(), blob)
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestExport(t *testing.T) {
+	f := newMemoryForum(t)
+	ctx := context.Background()
+	request := func() *httptest.ResponseRecorder {
+		return f.serve(form("/settings/export", nil), f.annSession)
+	}
+
+	if w := request(); w.Code != http.StatusSeeOther {
+		t.Fatalf("request: status %d\n%s", w.Code, w.Body)
+	}
+	if !slices.Equal(f.exporter.ids, []int{1}) {
+		t.Fatalf("started exports %v, want [1]", f.exporter.ids)
+	}
+	if w := request(); w.Code != http.StatusConflict {
+		t.Errorf("while pending: status %d, want %d", w.Code, http.StatusConflict)
+	}
+
+	// A failed export can be retried straight away, a ready one only after
+	// the cooldown.
+	if err := f.exports.MarkExportFailed(ctx, 1, "disk full"); err != nil {
+		t.Fatal(err)
+	}
+	if w := request(); w.Code != http.StatusSeeOther {
+		t.Errorf("after a failure: status %d, want %d", w.Code, http.StatusSeeOther)
+	}
+	if err := f.exports.MarkExportReady(ctx, 2, "exports/2.zip", 10, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	w := request()
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("after a ready export: status %d, Retry-After %q; want %d with a delay", w.Code, w.Header().Get("Retry-After"), http.StatusTooManyRequests)
+	}
+	if !slices.Equal(f.exporter.ids, []int{1, 2}) {
+		t.Errorf("started exports %v, want [1 2]", f.exporter.ids)
+	}
+
+	if w := f.serve(httptest.NewRequest(http.MethodGet, "/settings/export", nil), f.annSession); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: status %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestDownloadExport(t *testing.T) {
+	f := newMemoryForum(t)
+	ctx := context.Background()
+	newExport := func(settle func(id int) error) string {
+		t.Helper()
+		id, err := f.exports.CreateExport(ctx, f.ann)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if settle != nil {
+			if err := settle(id); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return strconv.Itoa(id)
+	}
+	ready := newExport(func(id int) error {
+		return f.exports.MarkExportReady(ctx, id, "exports/ready.zip", 7, time.Now().Add(time.Hour))
+	})
+	if err := f.app.Blobs.Put(ctx, "exports/ready.zip", strings.NewReader("archive")); err != nil {
+		t.Fatal(err)
+	}
+	pending := newExport(nil)
+	expired := newExport(func(id int) error {
+		return f.exports.MarkExportReady(ctx, id, "exports/expired.zip", 7, time.Now().Add(-time.Hour))
+	})
+	deleted := newExport(func(id int) error {
+		return f.exports.MarkExportReady(ctx, id, "exports/deleted.zip", 7, time.Now().Add(time.Hour))
+	})
+
+	tests := []struct {
+		name    string
+		id      string
+		session string
+		status  int
+	}{
+		{"signed out", ready, "", http.StatusUnauthorized},
+		{"bad id", "x", f.annSession, http.StatusBadRequest},
+		{"another user's", ready, f.bobSession, http.StatusNotFound},
+		{"pending", pending, f.annSession, http.StatusNotFound},
+		{"expired", expired, f.annSession, http.StatusGone},
+		{"archive deleted", deleted, f.annSession, http.StatusGone},
+		{"ready", ready, f.annSession, http.StatusOK},
+	}
+	for _, tt := range tests {
+		w := f.serve(httptest.NewRequest(http.MethodGet, "/settings/export/download?id="+tt.id, nil), tt.session)
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.status)
+			continue
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		if w.Body.String() != "archive" || w.Header().Get("Content-Type") != "application/zip" {
+			t.Errorf("%s: got %q as %q", tt.name, w.Body, w.Header().Get("Content-Type"))
+		}
+		if cd := w.Header().Get("Content-Disposition"); !strings.Contains(cd, "forum-ann-") {
+			t.Errorf("%s: Content-Disposition %q does not name ann's archive", tt.name, cd)
+		}
+	}
+}
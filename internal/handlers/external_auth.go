@@ -0,0 +1,326 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"forum/internal/identity"
+	"forum/internal/markup"
+	"forum/internal/middleware"
+	"forum/internal/models"
+	"forum/internal/repo"
+)
+
+const (
+	authStateTTL    = 10 * time.Minute
+	authStateCookie = "auth_state"
+)
+
+// externalAuthError is a sign-in failure that can be shown to the user; key
+// names its message.
+type externalAuthError struct {
+	key string
+}
+
+func (e *externalAuthError) Error() string {
+	return e.key
+}
+
+func (a *App) loginProviders() []models.LoginProvider {
+	providers := make([]models.LoginProvider, 0, len(a.Providers))
+	for _, p := range a.Providers {
+		providers = append(providers, models.LoginProvider{Name: p.Name(), Label: p.Label()})
+	}
+	return providers
+}
+
+func (a *App) findProvider(name string) identity.Provider {
+	for _, p := range a.Providers {
+		if p.Name() == name {
+			return p
+		}
+	}
+	return nil
+}
+
+func (a *App) ExternalLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.renderError(w, r, http.StatusMethodNotAllowed, "error.method_not_allowed", nil)
+		return
+	}
+	user, _ := middleware.CurrentUser(r)
+
+	provider := a.findProvider(r.URL.Query().Get("provider"))
+	if provider == nil {
+		a.renderError(w, r, http.StatusNotFound, "auth.provider_not_found", user)
+		return
+	}
+
+	req, err := identity.NewAuthRequest(requestBaseURL(r) + "/auth/callback")
+	if err != nil {
+		a.logError(r, err, "new auth request")
+		a.renderError(w, r, http.StatusInternalServerError, "auth.login_failed", user)
+		return
+	}
+	authURL, err := provider.AuthCodeURL(r.Context(), req)
+	if err != nil {
+		a.logError(r, err, "auth code url")
+		a.renderErrorText(w, r, http.StatusBadGateway, a.T(r, "auth.provider_unavailable", provider.Label()), user)
+		return
+	}
+
+	st := models.AuthState{
+		State:       req.State,
+		Provider:    provider.Name(),
+		Nonce:       req.Nonce,
+		Verifier:    req.Verifier,
+		RedirectURI: req.RedirectURI,
+	}
+	if user != nil && r.URL.Query().Get("link") == "1" {
+		st.LinkUserID = user.ID
+	}
+	if user != nil && r.URL.Query().Get("confirm") == "1" {
+		st.LinkUserID = user.ID
+		st.Confirm = true
+	}
+	if err := a.Identities.SaveAuthState(r.Context(), st); err != nil {
+		a.logError(r, err, "save auth state")
+		a.renderError(w, r, http.StatusInternalServerError, "auth.login_failed", user)
+		return
+	}
+
+	// The state is also bound to this browser, so a callback URL started by
+	// someone else cannot sign the visitor into the wrong account.
+	http.SetCookie(w, &http.Cookie{
+		Name:     authStateCookie,
+		Value:    req.State,
+		Path:     "/auth/",
+		MaxAge:   int(authStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+func (a *App) ExternalCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.renderError(w, r, http.StatusMethodNotAllowed, "error.method_not_allowed", nil)
+		return
+	}
+	user, _ := middleware.CurrentUser(r)
+	q := r.URL.Query()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     authStateCookie,
+		Value:    "",
+		Path:     "/auth/",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+
+	state := q.Get("state")
+	c, err := r.Cookie(authStateCookie)
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(c.Value), []byte(state)) != 1 {
+		a.renderLogin(w, r, http.StatusBadRequest, user, a.T(r, "auth.state_expired"))
+		return
+	}
+	st, err := a.Identities.TakeAuthState(r.Context(), state, authStateTTL)
+	if err == sql.ErrNoRows {
+		a.renderLogin(w, r, http.StatusBadRequest, user, a.T(r, "auth.state_expired"))
+		return
+	}
+	if err != nil {
+		a.logError(r, err, "take auth state")
+		a.renderError(w, r, http.StatusInternalServerError, "auth.login_failed", user)
+		return
+	}
+
+	provider := a.findProvider(st.Provider)
+	if provider == nil {
+		a.renderLogin(w, r, http.StatusBadRequest, user, a.T(r, "auth.provider_gone"))
+		return
+	}
+	if q.Get("error") != "" {
+		a.renderLogin(w, r, http.StatusUnauthorized, user, a.T(r, "auth.cancelled", provider.Label()))
+		return
+	}
+
+	id, err := provider.Exchange(r.Context(), q.Get("code"), identity.AuthRequest{
+		State:       st.State,
+		Nonce:       st.Nonce,
+		Verifier:    st.Verifier,
+		RedirectURI: st.RedirectURI,
+	})
+	if errors.Is(err, identity.ErrNoEmail) {
+		a.renderLogin(w, r, http.StatusUnauthorized, user, a.T(r, "auth.no_email", provider.Label()))
+		return
+	}
+	if err != nil {
+		a.logError(r, err, "exchange "+provider.Name()+" code")
+		a.renderLogin(w, r, http.StatusBadGateway, user, a.T(r, "auth.provider_failed", provider.Label()))
+		return
+	}
+
+	if st.Confirm {
+		a.confirmSession(w, r, user, st, id)
+		return
+	}
+
+	userID, err := a.resolveExternalUser(r.Context(), st, id)
+	var authErr *externalAuthError
+	if errors.As(err, &authErr) {
+		a.renderLogin(w, r, http.StatusConflict, user, a.T(r, authErr.key))
+		return
+	}
+	if err != nil {
+		a.logError(r, err, "resolve external user")
+		a.renderError(w, r, http.StatusInternalServerError, "auth.login_failed", user)
+		return
+	}
+
+	if st.LinkUserID != 0 {
+		http.Redirect(w, r, "/settings?done=linked", http.StatusSeeOther)
+		return
+	}
+	account, err := a.Users.GetUserByID(r.Context(), userID)
+	if err != nil {
+		a.logError(r, err, "get user by id")
+		a.renderError(w, r, http.StatusInternalServerError, "auth.login_failed", nil)
+		return
+	}
+	a.completeLogin(w, r, account)
+}
+
+// confirmSession finishes a confirmation started from the settings page. It
+// only counts when the same user is still signed in and the provider vouched
+// for an identity already linked to them.
+func (a *App) confirmSession(w http.ResponseWriter, r *http.Request, user *models.User, st *models.AuthState, id *identity.Identity) {
+	if user == nil || user.ID != st.LinkUserID {
+		a.renderLogin(w, r, http.StatusUnauthorized, user, a.T(r, "auth.state_expired"))
+		return
+	}
+	linkedID, err := a.Identities.GetUserIDByIdentity(r.Context(), id.Provider, id.Subject)
+	if err != nil && err != sql.ErrNoRows {
+		a.logError(r, err, "get user by identity")
+		a.renderError(w, r, http.StatusInternalServerError, "auth.login_failed", user)
+		return
+	}
+	if err == sql.ErrNoRows || linkedID != user.ID {
+		a.renderSettings(w, r, http.StatusForbidden, user, a.T(r, "auth.reauth_not_linked"), "")
+		return
+	}
+	c, err := r.Cookie(middleware.SessionCookie)
+	if err == nil {
+		err = a.Sessions.ConfirmSession(r.Context(), c.Value)
+	}
+	if err != nil {
+		a.logError(r, err, "confirm session")
+		a.renderError(w, r, http.StatusInternalServerError, "auth.login_failed", user)
+		return
+	}
+	http.Redirect(w, r, "/settings?done=reauth", http.StatusSeeOther)
+}
+
+// resolveExternalUser maps a provider identity to a forum account: an
+// already linked account, the signed-in user who asked to link, the account
+// with the identity's email, or else a new account. Linking by email needs
+// both the provider and the account to have verified the address: an
+// unverified one could have been registered by someone else ahead of its
+// owner, waiting for them to sign in through the provider. Such an account
+// is refused instead; its owner links it from the settings page after
+// signing in.
+func (a *App) resolveExternalUser(ctx context.Context, st *models.AuthState, id *identity.Identity) (int, error) {
+	linkedID, err := a.Identities.GetUserIDByIdentity(ctx, id.Provider, id.Subject)
+	if err == nil {
+		if st.LinkUserID != 0 && st.LinkUserID != linkedID {
+			return 0, &externalAuthError{"auth.identity_taken"}
+		}
+		return linkedID, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, err
+	}
+
+	if st.LinkUserID != 0 {
+		return st.LinkUserID, a.Identities.LinkIdentity(ctx, st.LinkUserID, id.Provider, id.Subject, id.Email, id.EmailVerified)
+	}
+
+	if id.Email == "" || !id.EmailVerified {
+		return 0, &externalAuthError{"auth.email_unverified"}
+	}
+
+	existing, err := a.Users.GetUserByEmail(ctx, id.Email)
+	if err == nil {
+		if !existing.EmailVerified {
+			return 0, &externalAuthError{"auth.email_registered"}
+		}
+		return existing.ID, a.Identities.LinkIdentity(ctx, existing.ID, id.Provider, id.Subject, id.Email, true)
+	}
+	if err != sql.ErrNoRows {
+		return 0, err
+	}
+
+	userID, err := a.createExternalUser(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+	return userID, a.Identities.LinkIdentity(ctx, userID, id.Provider, id.Subject, id.Email, true)
+}
+
+// createExternalUser registers an account for a new identity. It has no
+// password; the user signs in through the provider and confirms protected
+// changes through it too, or sets a password in the settings.
+func (a *App) createExternalUser(ctx context.Context, id *identity.Identity) (int, error) {
+	base := usernameFromIdentity(id)
+	for i := 0; i < 20; i++ {
+		username := base
+		if i > 0 {
+			username = base + strconv.Itoa(i+1)
+		}
+		err := a.Users.CreateUser(ctx, id.Email, username, "")
+		if err != nil && errors.Is(err, repo.ErrUsernameTaken) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		user, err := a.Users.GetUserByEmail(ctx, id.Email)
+		if err != nil {
+			return 0, err
+		}
+		return user.ID, nil
+	}
+	return 0, &externalAuthError{"auth.no_free_username"}
+}
+
+func usernameFromIdentity(id *identity.Identity) string {
+	candidates := []string{id.Username, strings.SplitN(id.Email, "@", 2)[0], id.Name}
+	for _, c := range candidates {
+		runes := make([]rune, 0, markup.MaxUsernameLength)
+		for _, r := range c {
+			switch {
+			case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '.' || r == '-':
+				runes = append(runes, r)
+			case unicode.IsSpace(r):
+				runes = append(runes, '_')
+			}
+		}
+		// Leave room for the numeric suffix added on collisions.
+		if len(runes) > markup.MaxUsernameLength-2 {
+			runes = runes[:markup.MaxUsernameLength-2]
+		}
+		name := strings.Trim(string(runes), ".-")
+		if markup.ValidUsername(name) {
+			return name
+		}
+	}
+	return "user"
+}
@@ -0,0 +1,303 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"forum/internal/identity"
+	"forum/internal/identity/mockoidc"
+	"forum/internal/middleware"
+	"forum/internal/repo"
+)
+
+// newSSOForum is newForum with a mock OpenID Connect provider called "mock"
+// configured.
+func newSSOForum(t *testing.T) (*httptest.Server, *repo.Store) {
+	t.Helper()
+	var mock *mockoidc.Server
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mock.ServeHTTP(w, r)
+	}))
+	t.Cleanup(idp.Close)
+	var err error
+	mock, err = mockoidc.New(idp.URL, "forum", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider, err := identity.New(identity.Config{Type: "oidc", Name: "mock", Issuer: idp.URL, ClientID: "forum", ClientSecret: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app, srv, store := newForumApp(t)
+	app.Providers = []identity.Provider{provider}
+	return srv, store
+}
+
+// startSSO follows the sign-in from the forum through the mock provider,
+// which vouches for claims, and returns the callback URL it sends the
+// browser back to. query is added to the forum's login URL.
+func (b *browser) startSSO(query string, claims url.Values) string {
+	b.t.Helper()
+	resp, body := b.get("/auth/login?provider=mock" + query)
+	if resp.StatusCode != http.StatusFound {
+		b.t.Fatalf("start sign-in: status %d\n%s", resp.StatusCode, body)
+	}
+	req, err := http.NewRequest(http.MethodPost, resp.Header.Get("Location"), strings.NewReader(claims.Encode()))
+	if err != nil {
+		b.t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, body = b.do(req)
+	if resp.StatusCode != http.StatusFound {
+		b.t.Fatalf("sign in at the provider: status %d\n%s", resp.StatusCode, body)
+	}
+	return resp.Header.Get("Location")
+}
+
+// sso signs in through the mock provider and returns the forum's answer to
+// the callback.
+func (b *browser) sso(query string, claims url.Values) (*http.Response, string) {
+	b.t.Helper()
+	req, err := http.NewRequest(http.MethodGet, b.startSSO(query, claims), nil)
+	if err != nil {
+		b.t.Fatal(err)
+	}
+	return b.do(req)
+}
+
+// claims are what the mock provider vouches for.
+func claims(subject, email string, verified bool) url.Values {
+	v := url.Values{"sub": {subject}, "email": {email}, "preferred_username": {subject}}
+	if verified {
+		v.Set("email_verified", "1")
+	}
+	return v
+}
+
+func TestExternalLoginCreatesThenFindsAccount(t *testing.T) {
+	srv, store := newSSOForum(t)
+	ctx := context.Background()
+
+	b := newBrowser(t, srv)
+	if resp, body := b.sso("", claims("dana", "dana@example.com", true)); resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("first sign-in: status %d\n%s", resp.StatusCode, body)
+	}
+	user, err := store.GetUserByEmail(ctx, "dana@example.com")
+	if err != nil {
+		t.Fatalf("no account was created: %v", err)
+	}
+	if !user.EmailVerified || user.HasPassword() {
+		t.Errorf("created account: verified %v, password %v; want a verified address and no password", user.EmailVerified, user.HasPassword())
+	}
+	if resp, body := b.get("/settings"); resp.StatusCode != http.StatusOK || !strings.Contains(body, user.Username) {
+		t.Errorf("settings after the first sign-in: status %d", resp.StatusCode)
+	}
+
+	// Signing in again finds the linked account, whatever the address now.
+	again := newBrowser(t, srv)
+	if resp, body := again.sso("", claims("dana", "dana@elsewhere.example", false)); resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("second sign-in: status %d\n%s", resp.StatusCode, body)
+	}
+	if _, err := store.GetUserByEmail(ctx, "dana@elsewhere.example"); err == nil {
+		t.Error("the second sign-in created another account")
+	}
+	if id, err := store.GetUserIDByIdentity(ctx, "mock", "dana"); err != nil || id != user.ID {
+		t.Errorf("identity linked to %d, %v, want %d", id, err, user.ID)
+	}
+}
+
+func TestExternalLoginChecksState(t *testing.T) {
+	srv, store := newSSOForum(t)
+
+	tests := []struct {
+		name string
+		// deliver hands the callback URL to the forum.
+		deliver func(b *browser, callback string) (*http.Response, string)
+	}{
+		{"another browser", func(b *browser, callback string) (*http.Response, string) {
+			req, _ := http.NewRequest(http.MethodGet, callback, nil)
+			return newBrowser(t, srv).do(req)
+		}},
+		{"altered state", func(b *browser, callback string) (*http.Response, string) {
+			u, _ := url.Parse(callback)
+			q := u.Query()
+			q.Set("state", q.Get("state")+"x")
+			u.RawQuery = q.Encode()
+			req, _ := http.NewRequest(http.MethodGet, u.String(), nil)
+			return b.do(req)
+		}},
+		{"replayed", func(b *browser, callback string) (*http.Response, string) {
+			req, _ := http.NewRequest(http.MethodGet, callback, nil)
+			if resp, body := b.do(req); resp.StatusCode != http.StatusSeeOther {
+				t.Fatalf("replayed: first use: status %d\n%s", resp.StatusCode, body)
+			}
+			// The cookie is gone after the first use; send it anyway, so
+			// that only the stored state is left to refuse the replay.
+			u, _ := url.Parse(callback)
+			req, _ = http.NewRequest(http.MethodGet, callback, nil)
+			req.AddCookie(&http.Cookie{Name: authStateCookie, Value: u.Query().Get("state")})
+			return newBrowser(t, srv).do(req)
+		}},
+	}
+	for i, tt := range tests {
+		subject := "eve" + string(rune('a'+i))
+		b := newBrowser(t, srv)
+		callback := b.startSSO("", claims(subject, subject+"@example.com", true))
+		resp, body := tt.deliver(b, callback)
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want %d", tt.name, resp.StatusCode, http.StatusBadRequest)
+		}
+		if !strings.Contains(body, "sign-in session has expired") {
+			t.Errorf("%s: the page does not explain the failure", tt.name)
+		}
+		if tt.name != "replayed" {
+			if _, err := store.GetUserByEmail(context.Background(), subject+"@example.com"); err == nil {
+				t.Errorf("%s: an account was created", tt.name)
+			}
+		}
+	}
+}
+
+func TestExternalLoginLinksByVerifiedEmail(t *testing.T) {
+	srv, store := newSSOForum(t)
+	ctx := context.Background()
+	signUp(t, srv, "ann")
+	carol := signUp(t, srv, "carol")
+
+	// A registered address is not verified, so the provider's word for it
+	// does not reach the account: whoever registered it may not own it.
+	if resp, body := newBrowser(t, srv).sso("", claims("ann-work", "ann@example.com", true)); resp.StatusCode != http.StatusConflict || !strings.Contains(body, "already exists") {
+		t.Errorf("sign-in to an unverified account: status %d, want %d", resp.StatusCode, http.StatusConflict)
+	}
+	if _, err := store.GetUserIDByIdentity(ctx, "mock", "ann-work"); err == nil {
+		t.Error("an identity was linked to an account with an unverified address")
+	}
+
+	// Carol links a provider from the settings; it vouches for her address,
+	// which verifies it.
+	if resp, body := carol.sso("&link=1", claims("carol-home", "carol@example.com", true)); resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("link from the settings: status %d\n%s", resp.StatusCode, body)
+	}
+	user, err := store.GetUserByEmail(ctx, "carol@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !user.EmailVerified {
+		t.Fatal("linking an identity with the same verified address left it unverified")
+	}
+
+	tests := []struct {
+		name     string
+		subject  string
+		verified bool
+		status   int
+		linked   bool
+	}{
+		{"provider did not verify", "carol-unverified", false, http.StatusConflict, false},
+		{"both verified", "carol-work", true, http.StatusSeeOther, true},
+	}
+	for _, tt := range tests {
+		resp, body := newBrowser(t, srv).sso("", claims(tt.subject, "carol@example.com", tt.verified))
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: status %d, want %d\n%s", tt.name, resp.StatusCode, tt.status, body)
+		}
+		id, err := store.GetUserIDByIdentity(ctx, "mock", tt.subject)
+		if linked := err == nil && id == user.ID; linked != tt.linked {
+			t.Errorf("%s: linked to carol %v, want %v", tt.name, linked, tt.linked)
+		}
+	}
+}
+
+// fixedProvider hands back its identity for any code, so the sign-in flow
+// can run against the in-memory repositories without a provider server.
+type fixedProvider struct {
+	id identity.Identity
+}
+
+func (p *fixedProvider) Name() string  { return "fixed" }
+func (p *fixedProvider) Label() string { return "Fixed" }
+
+func (p *fixedProvider) AuthCodeURL(ctx context.Context, req identity.AuthRequest) (string, error) {
+	return "https://idp.test/authorize?state=" + url.QueryEscape(req.State), nil
+}
+
+func (p *fixedProvider) Exchange(ctx context.Context, code string, req identity.AuthRequest) (*identity.Identity, error) {
+	id := p.id
+	id.Provider = p.Name()
+	return &id, nil
+}
+
+// external runs the sign-in through the fixed provider, signed in with session when it is
+// not empty, and returns the forum's answer to the callback. query is added
+// to the forum's login URL.
+func (f *memoryForum) external(t *testing.T, session string, query string) *httptest.ResponseRecorder {
+	t.Helper()
+	w := f.serve(httptest.NewRequest(http.MethodGet, "/auth/login?provider=fixed"+query, nil), session)
+	authURL, err := url.Parse(w.Header().Get("Location"))
+	if w.Code != http.StatusFound || err != nil {
+		t.Fatalf("start sign-in: status %d\n%s", w.Code, w.Body)
+	}
+	state := authURL.Query().Get("state")
+	r := httptest.NewRequest(http.MethodGet, "/auth/callback?code=c&state="+url.QueryEscape(state), nil)
+	r.AddCookie(&http.Cookie{Name: authStateCookie, Value: cookie(w, authStateCookie)})
+	return f.serve(r, session)
+}
+
+func TestLinkIdentityFromSettings(t *testing.T) {
+	f := newMemoryForum(t)
+	ctx := context.Background()
+	provider := &fixedProvider{identity.Identity{Subject: "bob-1", Email: "bob@example.com", EmailVerified: true}}
+	f.app.Providers = []identity.Provider{provider}
+
+	w := f.external(t, f.bobSession, "&link=1")
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/settings?done=linked" {
+		t.Fatalf("link: status %d to %q\n%s", w.Code, w.Header().Get("Location"), w.Body)
+	}
+	if bob, _ := f.sessions.GetUserByID(ctx, f.bob); !bob.EmailVerified {
+		t.Error("bob's address is not verified after linking an identity that vouches for it")
+	}
+
+	settings := func(session string) string {
+		t.Helper()
+		w := f.serve(httptest.NewRequest(http.MethodGet, "/settings", nil), session)
+		if w.Code != http.StatusOK {
+			t.Fatalf("settings: status %d", w.Code)
+		}
+		return w.Body.String()
+	}
+	if body := settings(f.bobSession); !strings.Contains(body, "fixed: bob@example.com") {
+		t.Error("bob's settings do not list the linked identity")
+	}
+	if body := settings(f.annSession); strings.Contains(body, "fixed: bob@example.com") {
+		t.Error("ann's settings list bob's identity")
+	}
+
+	// Signed out, the identity signs bob in.
+	w = f.external(t, "", "")
+	if w.Code != http.StatusSeeOther || cookie(w, middleware.SessionCookie) == "" {
+		t.Fatalf("sign-in: status %d, want a session\n%s", w.Code, w.Body)
+	}
+	if user, err := f.sessions.GetUserBySessionID(ctx, cookie(w, middleware.SessionCookie)); err != nil || user.ID != f.bob {
+		t.Errorf("signed in as %+v, %v; want bob", user, err)
+	}
+
+	// Linking it to ann as well fails, and 2FA still applies to her sign-in
+	// through her own identity.
+	if w := f.external(t, f.annSession, "&link=1"); w.Code != http.StatusConflict {
+		t.Errorf("linking bob's identity to ann: status %d, want %d", w.Code, http.StatusConflict)
+	}
+	f.enrol(t, f.ann)
+	provider.id = identity.Identity{Subject: "ann-1", Email: "ann@example.com", EmailVerified: true}
+	if w := f.external(t, f.annSession, "&link=1"); w.Code != http.StatusSeeOther {
+		t.Fatalf("link ann: status %d", w.Code)
+	}
+	w = f.external(t, "", "")
+	if w.Header().Get("Location") != "/login/2fa" || cookie(w, middleware.SessionCookie) != "" {
+		t.Errorf("ann with 2FA: status %d to %q; want the second step", w.Code, w.Header().Get("Location"))
+	}
+}
@@ -0,0 +1,293 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	internaldb "forum/internal/db"
+	"forum/internal/mail"
+	"forum/internal/models"
+	"forum/internal/repo"
+	"forum/internal/storage"
+)
+
+// newForum serves the app over a SQLite store in a temporary directory.
+func newForum(t *testing.T) (*httptest.Server, *repo.Store) {
+	t.Helper()
+	_, srv, store := newForumApp(t)
+	return srv, store
+}
+
+// newForumApp is newForum that also returns the App, for tests that set up
+// more of it or look into its blob store.
+func newForumApp(t *testing.T) (*App, *httptest.Server, *repo.Store) {
+	t.Helper()
+	dir := t.TempDir()
+	db, err := internaldb.Open(filepath.Join(dir, "forum.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Pool().Close() })
+	if err := repo.SeedCategories(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+	blobs, err := storage.NewFSStore(filepath.Join(dir, "uploads"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := repo.NewStore(db)
+	app := newTestApp(t)
+	app.Posts = store
+	app.Users = store
+	app.Comments = store
+	app.Reactions = store
+	app.Sessions = store
+	app.Categories = store
+	app.Notifications = store
+	app.Mentions = store
+	app.Attachments = store
+	app.Blobs = blobs
+	app.Profiles = store
+	app.Mailer = mail.LogMailer{}
+	app.Exports = store
+	app.Identities = store
+	app.TwoFactor = store
+	app.Site = store
+
+	srv := httptest.NewServer(routes(app, store))
+	t.Cleanup(srv.Close)
+	return app, srv, store
+}
+
+// browser is a visitor with its own cookies. It does not follow redirects,
+// so tests see where a handler sends it.
+type browser struct {
+	t      *testing.T
+	srv    *httptest.Server
+	client *http.Client
+}
+
+func newBrowser(t *testing.T, srv *httptest.Server) *browser {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{
+		Jar: jar,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return &browser{t: t, srv: srv, client: client}
+}
+
+func (b *browser) do(req *http.Request) (*http.Response, string) {
+	b.t.Helper()
+	req.Header.Set("Accept-Language", "en")
+	resp, err := b.client.Do(req)
+	if err != nil {
+		b.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		b.t.Fatal(err)
+	}
+	return resp, string(body)
+}
+
+func (b *browser) get(path string) (*http.Response, string) {
+	b.t.Helper()
+	req, err := http.NewRequest(http.MethodGet, b.srv.URL+path, nil)
+	if err != nil {
+		b.t.Fatal(err)
+	}
+	return b.do(req)
+}
+
+func (b *browser) post(path string, form url.Values, header ...string) (*http.Response, string) {
+	b.t.Helper()
+	req, err := http.NewRequest(http.MethodPost, b.srv.URL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		b.t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	return b.do(req)
+}
+
+// signUp registers username and returns a browser signed in as them.
+func signUp(t *testing.T, srv *httptest.Server, username string) *browser {
+	t.Helper()
+	b := newBrowser(t, srv)
+	email := username + "@example.com"
+	if resp, body := b.post("/register", url.Values{"email": {email}, "username": {username}, "password": {"pw"}}); resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("register %s: status %d\n%s", username, resp.StatusCode, body)
+	}
+	if resp, body := b.post("/login", url.Values{"email": {email}, "password": {"pw"}}); resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("login %s: status %d\n%s", username, resp.StatusCode, body)
+	}
+	return b
+}
+
+func (b *browser) createPost(title string, categoryID int) {
+	b.t.Helper()
+	form := url.Values{"title": {title}, "content": {"About " + title}, "category_id": {strconv.Itoa(categoryID)}}
+	if resp, body := b.post("/create-post", form); resp.StatusCode != http.StatusSeeOther {
+		b.t.Fatalf("create %q: status %d\n%s", title, resp.StatusCode, body)
+	}
+}
+
+// postID finds the post titled title through the store.
+func postID(t *testing.T, store *repo.Store, title string) int {
+	t.Helper()
+	cards, err := store.GetPostCards(context.Background(), repo.PostCardsFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range cards {
+		if c.Title == title {
+			return c.ID
+		}
+	}
+	t.Fatalf("no post titled %q", title)
+	return 0
+}
+
+// checkFeed asserts which of titles the page at path lists.
+func checkFeed(t *testing.T, b *browser, path string, titles map[string]bool) {
+	t.Helper()
+	resp, body := b.get(path)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s: status %d", path, resp.StatusCode)
+	}
+	for title, want := range titles {
+		if got := strings.Contains(body, title); got != want {
+			t.Errorf("GET %s lists %q: %v, want %v", path, title, got, want)
+		}
+	}
+}
+
+func TestForumFeeds(t *testing.T) {
+	srv, store := newForum(t)
+	ann := signUp(t, srv, "ann")
+	bob := signUp(t, srv, "bob")
+
+	ann.createPost("Apples", 1)
+	ann.createPost("Pears", 2)
+	bob.createPost("Plums", 1)
+
+	resp, body := ann.post("/react-post", url.Values{"post_id": {strconv.Itoa(postID(t, store, "Plums"))}, "kind": {"like"}}, "Accept", "application/json")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("react: status %d\n%s", resp.StatusCode, body)
+	}
+	var state models.ReactionState
+	if err := json.Unmarshal([]byte(body), &state); err != nil {
+		t.Fatal(err)
+	}
+	if !state.Active || state.Likes != 1 {
+		t.Errorf("reaction state = %+v, want an active like", state)
+	}
+
+	all := map[string]bool{"Apples": true, "Pears": true, "Plums": true}
+	checkFeed(t, ann, "/", all)
+	checkFeed(t, newBrowser(t, srv), "/", all)
+	checkFeed(t, ann, "/?category_id=1", map[string]bool{"Apples": true, "Pears": false, "Plums": true})
+	checkFeed(t, ann, "/?mine=1", map[string]bool{"Apples": true, "Pears": true, "Plums": false})
+	checkFeed(t, bob, "/?mine=1", map[string]bool{"Apples": false, "Pears": false, "Plums": true})
+	checkFeed(t, ann, "/?liked=1", map[string]bool{"Apples": false, "Pears": false, "Plums": true})
+	checkFeed(t, bob, "/?liked=1", map[string]bool{"Apples": false, "Pears": false, "Plums": false})
+}
+
+func TestForumHomeErrors(t *testing.T) {
+	srv, _ := newForum(t)
+	guest := newBrowser(t, srv)
+
+	for _, tt := range []struct {
+		path   string
+		status int
+	}{
+		{"/?liked=1", http.StatusSeeOther},
+		{"/?mine=1", http.StatusSeeOther},
+		{"/?category_id=x", http.StatusBadRequest},
+		{"/?category_id=999", http.StatusNotFound},
+	} {
+		if resp, _ := guest.get(tt.path); resp.StatusCode != tt.status {
+			t.Errorf("GET %s: status %d, want %d", tt.path, resp.StatusCode, tt.status)
+		}
+	}
+	if resp, _ := guest.post("/", nil); resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("POST /: status %d, want %d", resp.StatusCode, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestForumAuth(t *testing.T) {
+	srv, store := newForum(t)
+	ann := signUp(t, srv, "ann")
+
+	guest := newBrowser(t, srv)
+	if _, body := ann.get("/"); !strings.Contains(body, `href="/user/ann"`) {
+		t.Error("home page does not show the signed-in user")
+	}
+	if _, body := guest.get("/"); strings.Contains(body, `href="/user/ann"`) {
+		t.Error("home page shows a guest as signed in")
+	}
+
+	if resp, _ := guest.post("/login", url.Values{"email": {"ann@example.com"}, "password": {"wrong"}}); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("wrong password: status %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+	if resp, _ := guest.post("/register", url.Values{"email": {"ann@example.com"}, "username": {"ann2"}, "password": {"pw"}}); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("taken email: status %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+	if resp, _ := guest.post("/create-post", url.Values{"title": {"t"}, "content": {"c"}, "category_id": {"1"}}); resp.StatusCode == http.StatusSeeOther {
+		t.Error("a guest created a post")
+	}
+
+	if resp, _ := ann.get("/logout"); resp.StatusCode != http.StatusSeeOther {
+		t.Errorf("logout: status %d", resp.StatusCode)
+	}
+	if resp, _ := ann.get("/?mine=1"); resp.StatusCode != http.StatusSeeOther {
+		t.Errorf("signed out user sees their posts: status %d", resp.StatusCode)
+	}
+	if n, err := store.CountActiveSessions(context.Background()); err != nil || n != 0 {
+		t.Errorf("CountActiveSessions = %d, %v, want 0", n, err)
+	}
+}
+
+func TestForumComments(t *testing.T) {
+	srv, store := newForum(t)
+	ann := signUp(t, srv, "ann")
+	bob := signUp(t, srv, "bob")
+	ann.createPost("Apples", 1)
+	id := strconv.Itoa(postID(t, store, "Apples"))
+
+	if resp, body := bob.post("/addcomment", url.Values{"post_id": {id}, "content": {"Crunchy ones"}}); resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("comment: status %d\n%s", resp.StatusCode, body)
+	}
+	if resp, _ := bob.post("/addcomment", url.Values{"post_id": {id}, "content": {"  "}}); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("empty comment: status %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+
+	resp, body := ann.get("/post?id=" + id)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("post page: status %d", resp.StatusCode)
+	}
+	if !strings.Contains(body, "Crunchy ones") {
+		t.Error("post page does not show the comment")
+	}
+	if resp, _ := ann.get("/post?id=999"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("missing post: status %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+}
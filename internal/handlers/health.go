@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// HealthzHandler answers as long as the process is up.
+func (a *App) HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+// ReadyzHandler tells the load balancer whether this instance can serve
+// traffic: the database must answer and carry the full schema.
+func (a *App) ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if err := a.Ready(ctx); err != nil {
+		a.logError(r, err, "readiness check")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte("not ready\n"))
+		return
+	}
+	_, _ = w.Write([]byte("ok\n"))
+}
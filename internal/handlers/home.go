@@ -11,15 +11,15 @@ import (
 
 func (a *App) HomeHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		a.renderError(w, http.StatusMethodNotAllowed, "Метод не поддерживается", nil)
+		a.renderError(w, r, http.StatusMethodNotAllowed, "error.method_not_allowed", nil)
 		return
 	}
-	user, _ := middleware.CurrentUser(a.DB, r)
+	user, _ := middleware.CurrentUser(r)
 
-	cats, err := repo.GetAllCategories(a.DB)
+	cats, err := a.Categories.GetAllCategories(r.Context())
 	if err != nil {
-		a.logError(err, "get categories")
-		a.renderError(w, http.StatusInternalServerError, "Ошибка категорий", user)
+		a.logError(r, err, "get categories")
+		a.renderError(w, r, http.StatusInternalServerError, "category.load_failed", user)
 		return
 	}
 
@@ -33,6 +33,9 @@ func (a *App) HomeHandler(w http.ResponseWriter, r *http.Request) {
 	filter := repo.PostCardsFilter{
 		CommentLimit: 3,
 	}
+	if user != nil {
+		filter.ViewerID = user.ID
+	}
 	if liked == "1" {
 		likedActive = true
 		if user == nil {
@@ -52,7 +55,7 @@ func (a *App) HomeHandler(w http.ResponseWriter, r *http.Request) {
 	} else if categoryIDStr != "" {
 		categoryID, convErr := strconv.Atoi(categoryIDStr)
 		if convErr != nil {
-			a.renderError(w, http.StatusBadRequest, "Неверная категория", user)
+			a.renderError(w, r, http.StatusBadRequest, "category.invalid", user)
 			return
 		}
 		categoryFound := false
@@ -63,7 +66,7 @@ func (a *App) HomeHandler(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 		if !categoryFound {
-			a.renderError(w, http.StatusNotFound, "Категория не найдена", user)
+			a.renderError(w, r, http.StatusNotFound, "category.not_found", user)
 			return
 		}
 		selectedCategoryID = categoryID
@@ -72,10 +75,10 @@ func (a *App) HomeHandler(w http.ResponseWriter, r *http.Request) {
 		allActive = true
 	}
 
-	cards, err := a.Posts.GetPostCards(filter)
+	cards, err := a.Posts.GetPostCards(r.Context(), filter)
 	if err != nil {
-		a.logError(err, "get post cards")
-		a.renderError(w, http.StatusInternalServerError, "Ошибка получения постов", user)
+		a.logError(r, err, "get post cards")
+		a.renderError(w, r, http.StatusInternalServerError, "post.list_failed", user)
 		return
 	}
 
@@ -89,5 +92,5 @@ func (a *App) HomeHandler(w http.ResponseWriter, r *http.Request) {
 		SelectedCategoryID: selectedCategoryID,
 	}
 
-	a.render(w, "home.html", data)
+	a.render(w, r, "home.html", data)
 }
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"forum/internal/i18n"
+	"forum/internal/middleware"
+)
+
+const langCookie = "lang"
+
+// lang picks the UI language: the signed-in user's choice, then the lang
+// cookie, then Accept-Language.
+func (a *App) lang(r *http.Request) string {
+	if lang := middleware.UserLocale(r); a.I18n.Has(lang) {
+		return lang
+	}
+	if c, err := r.Cookie(langCookie); err == nil && a.I18n.Has(c.Value) {
+		return c.Value
+	}
+	return a.I18n.Match(r.Header.Get("Accept-Language"))
+}
+
+func (a *App) translator(r *http.Request) *i18n.Translator {
+	return a.I18n.Translator(a.lang(r))
+}
+
+// T translates key into the request's language.
+func (a *App) T(r *http.Request, key string, args ...any) string {
+	return a.translator(r).T(key, args...)
+}
+
+// LanguageHandler switches the UI language. It is remembered in a cookie and,
+// for signed-in users, in their account so it follows them across devices.
+func (a *App) LanguageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		a.renderError(w, r, http.StatusMethodNotAllowed, "error.method_not_allowed", nil)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		a.renderError(w, r, http.StatusBadRequest, "error.bad_form", nil)
+		return
+	}
+	lang := r.FormValue("lang")
+	if !a.I18n.Has(lang) {
+		a.renderError(w, r, http.StatusBadRequest, "language.unknown", nil)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     langCookie,
+		Value:    lang,
+		Path:     "/",
+		Expires:  time.Now().AddDate(1, 0, 0),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	if user, err := middleware.CurrentUser(r); err == nil {
+		if err := a.Users.UpdateLocale(r.Context(), user.ID, lang); err != nil {
+			a.logError(r, err, "update locale")
+		}
+	}
+
+	http.Redirect(w, r, languageReturnPath(r), http.StatusSeeOther)
+}
+
+// languageReturnPath sends the user back to the page they switched language
+// on: the explicit next field, or the Referer when it points at this site.
+func languageReturnPath(r *http.Request) string {
+	next := r.FormValue("next")
+	if next == "" {
+		if ref, err := url.Parse(r.Referer()); err == nil && ref.Host == r.Host {
+			next = ref.RequestURI()
+		}
+	}
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
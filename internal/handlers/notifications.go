@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"forum/internal/markup"
+	"forum/internal/middleware"
+	"forum/internal/models"
+	"forum/internal/repo"
+)
+
+func (a *App) NotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.renderError(w, r, http.StatusMethodNotAllowed, "error.method_not_allowed", nil)
+		return
+	}
+
+	user, err := middleware.CurrentUser(r)
+	if err != nil {
+		a.renderError(w, r, http.StatusUnauthorized, "error.unauthorized", nil)
+		return
+	}
+
+	notifications, err := a.Notifications.GetNotifications(r.Context(), user.ID, 100)
+	if err != nil {
+		a.logError(r, err, "get notifications")
+		a.renderError(w, r, http.StatusInternalServerError, "notifications.load_failed", user)
+		return
+	}
+	prefs, err := a.Notifications.GetNotificationPreferences(r.Context(), user.ID)
+	if err != nil {
+		a.logError(r, err, "get notification preferences")
+		a.renderError(w, r, http.StatusInternalServerError, "settings.load_failed", user)
+		return
+	}
+
+	data := models.NotificationsPageData{
+		CurrentUser:   user,
+		Notifications: notifications,
+		Preferences:   prefs,
+	}
+	a.render(w, r, "notifications.html", data)
+}
+
+func (a *App) NotificationsReadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		a.renderError(w, r, http.StatusMethodNotAllowed, "error.method_not_allowed", nil)
+		return
+	}
+
+	user, err := middleware.CurrentUser(r)
+	if err != nil {
+		a.renderError(w, r, http.StatusUnauthorized, "error.unauthorized", nil)
+		return
+	}
+
+	if err := a.Notifications.MarkAllNotificationsRead(r.Context(), user.ID); err != nil {
+		a.logError(r, err, "mark notifications read")
+		a.renderError(w, r, http.StatusInternalServerError, "notifications.update_failed", user)
+		return
+	}
+
+	http.Redirect(w, r, "/notifications", http.StatusSeeOther)
+}
+
+func (a *App) NotificationOpenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.renderError(w, r, http.StatusMethodNotAllowed, "error.method_not_allowed", nil)
+		return
+	}
+
+	user, err := middleware.CurrentUser(r)
+	if err != nil {
+		a.renderError(w, r, http.StatusUnauthorized, "error.unauthorized", nil)
+		return
+	}
+
+	notificationID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		a.renderError(w, r, http.StatusBadRequest, "notifications.bad_id", user)
+		return
+	}
+
+	n, err := a.Notifications.GetNotificationByID(r.Context(), user.ID, notificationID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			a.renderError(w, r, http.StatusNotFound, "notifications.not_found", user)
+			return
+		}
+		a.logError(r, err, "get notification")
+		a.renderError(w, r, http.StatusInternalServerError, "notifications.load_failed", user)
+		return
+	}
+
+	if err := a.Notifications.MarkNotificationRead(r.Context(), user.ID, n.ID); err != nil {
+		a.logError(r, err, "mark notification read")
+	}
+
+	target := fmt.Sprintf("/post?id=%d", n.PostID)
+	if n.CommentID != 0 {
+		target += fmt.Sprintf("#comment-%d", n.CommentID)
+	}
+	http.Redirect(w, r, target, http.StatusSeeOther)
+}
+
+func (a *App) NotificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		a.renderError(w, r, http.StatusMethodNotAllowed, "error.method_not_allowed", nil)
+		return
+	}
+
+	user, err := middleware.CurrentUser(r)
+	if err != nil {
+		a.renderError(w, r, http.StatusUnauthorized, "error.unauthorized", nil)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		a.renderError(w, r, http.StatusBadRequest, "error.bad_form", user)
+		return
+	}
+
+	enabled := make(map[string]bool, len(models.NotificationTypes))
+	for _, code := range r.Form["type"] {
+		enabled[code] = true
+	}
+
+	if err := a.Notifications.SetNotificationPreferences(r.Context(), user.ID, enabled); err != nil {
+		a.logError(r, err, "set notification preferences")
+		a.renderError(w, r, http.StatusInternalServerError, "settings.save_failed", user)
+		return
+	}
+
+	http.Redirect(w, r, "/notifications", http.StatusSeeOther)
+}
+
+func (a *App) notify(r *http.Request, n models.Notification) {
+	if a.Notifications == nil || n.UserID == 0 {
+		return
+	}
+	if err := a.Notifications.CreateNotification(r.Context(), n); err != nil {
+		a.logError(r, err, "create notification")
+	}
+}
+
+func (a *App) notifyPostAuthor(r *http.Request, actor *models.User, postID int, n models.Notification) {
+	authorID, err := a.Posts.GetPostAuthorID(r.Context(), postID)
+	if err != nil {
+		a.logError(r, err, "get post author")
+		return
+	}
+	n.UserID = authorID
+	n.ActorID = actor.ID
+	n.PostID = postID
+	a.notify(r, n)
+}
+
+func (a *App) notifyMentions(r *http.Request, actor *models.User, postID int, commentID int, content string) {
+	sourceType, sourceID := repo.MentionSourcePost, postID
+	if commentID != 0 {
+		sourceType, sourceID = repo.MentionSourceComment, commentID
+	}
+
+	mentioned, err := a.Mentions.SaveMentions(r.Context(), sourceType, sourceID, markup.ExtractMentions(content))
+	if err != nil {
+		a.logError(r, err, "save mentions")
+		return
+	}
+	for _, u := range mentioned {
+		a.notify(r, models.Notification{
+			UserID:    u.ID,
+			ActorID:   actor.ID,
+			Type:      models.NotificationMention,
+			PostID:    postID,
+			CommentID: commentID,
+		})
+	}
+}
@@ -2,76 +2,85 @@ package handlers
 
 import (
 	"database/sql"
+	"errors"
+	"io"
 	"net/http"
 	"strconv"
 	"strings"
 
+	"forum/internal/markup"
+	"forum/internal/metrics"
 	"forum/internal/middleware"
 	"forum/internal/models"
-	"forum/internal/repo"
 )
 
 func (a *App) CreatePostHandler(w http.ResponseWriter, r *http.Request) {
-	user, err := middleware.CurrentUser(a.DB, r)
+	user, err := middleware.CurrentUser(r)
 	if err != nil {
-		a.renderError(w, http.StatusUnauthorized, "Нужна авторизация для создания поста", nil)
+		a.renderError(w, r, http.StatusUnauthorized, "post.login_required", nil)
 		return
 	}
 
 	if r.Method == http.MethodGet {
-		cats, err := repo.GetAllCategories(a.DB)
+		cats, err := a.Categories.GetAllCategories(r.Context())
 		if err != nil {
-			a.logError(err, "get categories")
-			a.renderError(w, http.StatusInternalServerError, "Ошибка категорий", user)
+			a.logError(r, err, "get categories")
+			a.renderError(w, r, http.StatusInternalServerError, "category.load_failed", user)
 			return
 		}
 		data := models.CreatePostPageData{
 			CurrentUser: user,
 			Categories:  cats,
 		}
-		a.render(w, "create_post.html", data)
+		a.render(w, r, "create_post.html", data)
 		return
 	}
 
 	if r.Method == http.MethodPost {
-		if err := r.ParseForm(); err != nil {
-			cats, _ := repo.GetAllCategories(a.DB)
+		if err := a.parsePostForm(w, r); err != nil {
+			cats, _ := a.Categories.GetAllCategories(r.Context())
 			data := models.CreatePostPageData{
 				CurrentUser: user,
 				Categories:  cats,
-				Error:       "Некорректная форма",
+				Error:       a.T(r, "error.bad_form"),
 			}
-			a.renderWithStatus(w, http.StatusBadRequest, "create_post.html", data)
+			status := http.StatusBadRequest
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				data.Error = a.T(r, "upload.files_too_big")
+				status = http.StatusRequestEntityTooLarge
+			}
+			a.renderWithStatus(w, r, status, "create_post.html", data)
 			return
 		}
 		title := strings.TrimSpace(r.FormValue("title"))
 		content := strings.TrimSpace(r.FormValue("content"))
 		if title == "" || content == "" {
-			cats, _ := repo.GetAllCategories(a.DB)
+			cats, _ := a.Categories.GetAllCategories(r.Context())
 			data := models.CreatePostPageData{
 				CurrentUser: user,
 				Categories:  cats,
-				Error:       "Заполните заголовок и текст",
+				Error:       a.T(r, "post.fill_fields"),
 			}
-			a.renderWithStatus(w, http.StatusBadRequest, "create_post.html", data)
+			a.renderWithStatus(w, r, http.StatusBadRequest, "create_post.html", data)
 			return
 		}
 		catIDStrs := r.Form["category_id"]
 		if len(catIDStrs) == 0 {
-			cats, _ := repo.GetAllCategories(a.DB)
+			cats, _ := a.Categories.GetAllCategories(r.Context())
 			data := models.CreatePostPageData{
 				CurrentUser: user,
 				Categories:  cats,
-				Error:       "Выберите хотя бы одну категорию",
+				Error:       a.T(r, "post.choose_category"),
 			}
-			a.renderWithStatus(w, http.StatusBadRequest, "create_post.html", data)
+			a.renderWithStatus(w, r, http.StatusBadRequest, "create_post.html", data)
 			return
 		}
 
-		cats, err := repo.GetAllCategories(a.DB)
+		cats, err := a.Categories.GetAllCategories(r.Context())
 		if err != nil {
-			a.logError(err, "get categories")
-			a.renderError(w, http.StatusInternalServerError, "Ошибка категорий", user)
+			a.logError(r, err, "get categories")
+			a.renderError(w, r, http.StatusInternalServerError, "category.load_failed", user)
 			return
 		}
 		validCats := make(map[int]bool, len(cats))
@@ -86,63 +95,94 @@ func (a *App) CreatePostHandler(w http.ResponseWriter, r *http.Request) {
 				data := models.CreatePostPageData{
 					CurrentUser: user,
 					Categories:  cats,
-					Error:       "Неверная категория",
+					Error:       a.T(r, "category.invalid"),
 				}
-				a.renderWithStatus(w, http.StatusBadRequest, "create_post.html", data)
+				a.renderWithStatus(w, r, http.StatusBadRequest, "create_post.html", data)
 				return
 			}
 			if !validCats[catID] {
 				data := models.CreatePostPageData{
 					CurrentUser: user,
 					Categories:  cats,
-					Error:       "Категория не найдена",
+					Error:       a.T(r, "category.not_found"),
 				}
-				a.renderWithStatus(w, http.StatusNotFound, "create_post.html", data)
+				a.renderWithStatus(w, r, http.StatusNotFound, "create_post.html", data)
 				return
 			}
 			categoryIDs = append(categoryIDs, catID)
 		}
 
-		if _, err := a.Posts.CreatePost(user.ID, title, content, categoryIDs); err != nil {
-			a.logError(err, "create post")
+		attachments, images, err := a.storeAttachments(r)
+		if err != nil {
+			var uploadErr *uploadError
+			if !errors.As(err, &uploadErr) {
+				a.logError(r, err, "store attachments")
+			}
+			message := a.T(r, "upload.failed")
+			status := http.StatusInternalServerError
+			if uploadErr != nil {
+				message = uploadErr.message
+				status = http.StatusBadRequest
+			}
+			data := models.CreatePostPageData{
+				CurrentUser: user,
+				Categories:  cats,
+				Error:       message,
+			}
+			a.renderWithStatus(w, r, status, "create_post.html", data)
+			return
+		}
+
+		postID, err := a.Posts.CreatePost(r.Context(), user.ID, title, content, categoryIDs, attachments)
+		if err != nil {
+			a.logError(r, err, "create post")
+			a.discardAttachments(r, attachments)
 			data := models.CreatePostPageData{
 				CurrentUser: user,
 				Categories:  cats,
-				Error:       "Ошибка создания поста",
+				Error:       a.T(r, "post.create_failed"),
 			}
-			a.renderWithStatus(w, http.StatusInternalServerError, "create_post.html", data)
+			a.renderWithStatus(w, r, http.StatusInternalServerError, "create_post.html", data)
 			return
 		}
+		a.keepBlobs(r, images)
+		metrics.PostsCreated.Inc()
+		a.publishPostCreated(postID, title, user.Username, categoryIDs)
+		a.notifyMentions(r, user, postID, 0, title+"\n"+content)
 
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
 
-	a.renderError(w, http.StatusMethodNotAllowed, "Метод не поддерживается", user)
+	a.renderError(w, r, http.StatusMethodNotAllowed, "error.method_not_allowed", user)
 }
 
 func (a *App) PostPageHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		a.renderError(w, http.StatusMethodNotAllowed, "Метод не поддерживается", nil)
+		a.renderError(w, r, http.StatusMethodNotAllowed, "error.method_not_allowed", nil)
 		return
 	}
-	user, _ := middleware.CurrentUser(a.DB, r)
+	user, _ := middleware.CurrentUser(r)
 
 	idStr := r.URL.Query().Get("id")
 	postID, err := strconv.Atoi(idStr)
 	if err != nil {
-		a.renderError(w, http.StatusBadRequest, "Некорректный id поста", user)
+		a.renderError(w, r, http.StatusBadRequest, "post.bad_id", user)
 		return
 	}
 
-	post, err := a.Posts.GetPostCardWithComments(postID)
+	viewerID := 0
+	if user != nil {
+		viewerID = user.ID
+	}
+	post, err := a.Posts.GetPostCardWithComments(r.Context(), postID, viewerID)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			a.renderError(w, http.StatusNotFound, "Пост не найден", user)
+			a.renderError(w, r, http.StatusNotFound, "post.not_found", user)
 			return
 		}
-		a.logError(err, "get post")
-		a.renderError(w, http.StatusInternalServerError, "Ошибка загрузки поста", user)
+		a.logError(r, err, "get post")
+		a.renderError(w, r, http.StatusInternalServerError, "post.load_failed", user)
 		return
 	}
 
@@ -151,5 +191,32 @@ func (a *App) PostPageHandler(w http.ResponseWriter, r *http.Request) {
 		Post:        *post,
 	}
 
-	a.render(w, "post.html", data)
+	a.render(w, r, "post.html", data)
+}
+
+func (a *App) PreviewHandler(w http.ResponseWriter, r *http.Request) {
+	varyByFormat(w)
+	if r.Method != http.MethodPost {
+		a.respondError(w, r, http.StatusMethodNotAllowed, "error.method_not_allowed", nil)
+		return
+	}
+
+	user, err := middleware.CurrentUser(r)
+	if err != nil {
+		a.respondError(w, r, http.StatusUnauthorized, "error.unauthorized", nil)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		a.respondError(w, r, http.StatusBadRequest, "error.bad_form", user)
+		return
+	}
+
+	rendered := markup.Render(r.FormValue("content"))
+	if wantsJSON(r) {
+		a.writeJSON(w, r, http.StatusOK, map[string]string{"html": string(rendered)})
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = io.WriteString(w, string(rendered))
 }
@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"io/fs"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"forum/internal/models"
+	"forum/internal/repo"
+	"forum/internal/repo/memory"
+	"forum/internal/storage"
+)
+
+func TestHomeFilters(t *testing.T) {
+	f := newMemoryForum(t)
+	ctx := context.Background()
+	if _, err := f.posts.CreatePost(ctx, f.ann, "Apples", "Crisp", []int{1}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.posts.CreatePost(ctx, f.bob, "Plums", "Ripe", []int{1, 2}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.reactions.Toggle(ctx, f.ann, repo.ReactionTargetPost, 2, "like"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path    string
+		session string
+		status  int
+		apples  bool
+		plums   bool
+	}{
+		{"/", "", http.StatusOK, true, true},
+		{"/", f.annSession, http.StatusOK, true, true},
+		{"/?category_id=2", "", http.StatusOK, false, true},
+		{"/?mine=1", f.annSession, http.StatusOK, true, false},
+		{"/?mine=1", f.bobSession, http.StatusOK, false, true},
+		{"/?liked=1", f.annSession, http.StatusOK, false, true},
+		{"/?liked=1", f.bobSession, http.StatusOK, false, false},
+		{"/?liked=1", "", http.StatusSeeOther, false, false},
+		{"/?category_id=x", "", http.StatusBadRequest, false, false},
+		{"/?category_id=9", "", http.StatusNotFound, false, false},
+	}
+	for _, tt := range tests {
+		w := f.serve(httptest.NewRequest(http.MethodGet, tt.path, nil), tt.session)
+		if w.Code != tt.status {
+			t.Errorf("GET %s: status %d, want %d", tt.path, w.Code, tt.status)
+			continue
+		}
+		body := w.Body.String()
+		if got := strings.Contains(body, "Apples"); got != tt.apples {
+			t.Errorf("GET %s lists Apples: %v, want %v", tt.path, got, tt.apples)
+		}
+		if got := strings.Contains(body, "Plums"); got != tt.plums {
+			t.Errorf("GET %s lists Plums: %v, want %v", tt.path, got, tt.plums)
+		}
+	}
+}
+
+// uploadRequest builds a new post form with one PNG attachment.
+func uploadRequest(t *testing.T, title string) *http.Request {
+	t.Helper()
+	body, contentType := uploadForm(t, title)
+	r := httptest.NewRequest(http.MethodPost, "/create-post", body)
+	r.Header.Set("Content-Type", contentType)
+	return r
+}
+
+// uploadForm encodes a new post form with one PNG attachment, always the
+// same picture, and returns it with its content type.
+func uploadForm(t *testing.T, title string) (*bytes.Buffer, string) {
+	t.Helper()
+	return multipartForm(t, map[string]string{"title": title, "content": "With a picture", "category_id": "1"}, "attachments")
+}
+
+// multipartForm encodes fields and the picture as the file field, and
+// returns the body with its content type.
+func multipartForm(t *testing.T, fields map[string]string, file string) (*bytes.Buffer, string) {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for name, value := range fields {
+		if err := mw.WriteField(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	part, err := mw.CreateFormFile(file, "pic.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := part.Write(picture(t)); err != nil {
+		t.Fatal(err)
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &body, mw.FormDataContentType()
+}
+
+// picture is a small PNG, always the same.
+func picture(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	img.Set(1, 2, color.RGBA{R: 200, A: 255})
+	var pic bytes.Buffer
+	if err := png.Encode(&pic, img); err != nil {
+		t.Fatal(err)
+	}
+	return pic.Bytes()
+}
+
+// blobs lists the files in the forum's blob store.
+func (f *memoryForum) blobs(t *testing.T) []string {
+	t.Helper()
+	var files []string
+	err := filepath.WalkDir(f.blobDir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			files = append(files, path)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestCreatePostDiscardsAttachments(t *testing.T) {
+	f := newMemoryForum(t)
+
+	f.posts.Err = errors.New("disk full")
+	if w := f.serve(uploadRequest(t, "Lost"), f.annSession); w.Code != http.StatusInternalServerError {
+		t.Fatalf("failed post: status %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+	if blobs := f.blobs(t); len(blobs) != 0 {
+		t.Fatalf("failed post left blobs %v", blobs)
+	}
+
+	f.posts.Err = nil
+	if w := f.serve(uploadRequest(t, "Kept"), f.annSession); w.Code != http.StatusSeeOther {
+		t.Fatalf("post: status %d, want %d\n%s", w.Code, http.StatusSeeOther, w.Body)
+	}
+	blobs := f.blobs(t)
+	if len(blobs) != 1 {
+		t.Fatalf("post stored blobs %v, want one", blobs)
+	}
+	card, err := f.posts.GetPostCardWithComments(context.Background(), 1, f.ann)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(card.Attachments) != 1 || card.Attachments[0].OriginalName != "pic.png" {
+		t.Fatalf("attachments = %+v, want pic.png", card.Attachments)
+	}
+
+	// The same picture failing again must not take the stored post's blob.
+	f.posts.Err = errors.New("disk full")
+	if w := f.serve(uploadRequest(t, "Lost again"), f.bobSession); w.Code != http.StatusInternalServerError {
+		t.Fatalf("failed post: status %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+	if after := f.blobs(t); len(after) != 1 || after[0] != blobs[0] {
+		t.Errorf("blobs = %v, want %v kept", after, blobs)
+	}
+}
+
+// racingPosts removes the blobs of a post's attachments just before saving
+// it, as a request discarding the same picture does when it finds no row
+// using it between this request's Put and its commit.
+type racingPosts struct {
+	*memory.Posts
+	blobs storage.BlobStore
+}
+
+func (p racingPosts) CreatePost(ctx context.Context, userID int, title string, content string, categoryIDs []int, attachments []models.Attachment) (int, error) {
+	for _, att := range attachments {
+		if err := p.blobs.Delete(ctx, att.StorageKey); err != nil {
+			return 0, err
+		}
+	}
+	return p.Posts.CreatePost(ctx, userID, title, content, categoryIDs, attachments)
+}
+
+func TestCreatePostKeepsBlobRemovedBeforeCommit(t *testing.T) {
+	f := newMemoryForum(t)
+	f.app.Posts = racingPosts{f.posts, f.app.Blobs}
+
+	if w := f.serve(uploadRequest(t, "Raced"), f.annSession); w.Code != http.StatusSeeOther {
+		t.Fatalf("post: status %d, want %d\n%s", w.Code, http.StatusSeeOther, w.Body)
+	}
+	if blobs := f.blobs(t); len(blobs) != 1 {
+		t.Errorf("blobs = %v, want the post's picture stored again", blobs)
+	}
+}
+
+func TestCreatePostRequiresSignIn(t *testing.T) {
+	f := newMemoryForum(t)
+	w := f.serve(uploadRequest(t, "Anonymous"), "")
+	if w.Code == http.StatusSeeOther && w.Header().Get("Location") == "/" {
+		t.Fatal("a guest created a post")
+	}
+	if ok, _ := f.posts.PostExists(context.Background(), 1); ok {
+		t.Error("a guest's post was stored")
+	}
+	if blobs := f.blobs(t); len(blobs) != 0 {
+		t.Errorf("a guest's upload stored blobs %v", blobs)
+	}
+}
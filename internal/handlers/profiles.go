@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"forum/internal/middleware"
+	"forum/internal/models"
+	"forum/internal/repo"
+)
+
+const (
+	profilePageSize = 10
+	maxBioLength    = 500
+)
+
+func (a *App) ProfileHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.renderError(w, r, http.StatusMethodNotAllowed, "error.method_not_allowed", nil)
+		return
+	}
+	viewer, _ := middleware.CurrentUser(r)
+
+	username := strings.TrimPrefix(r.URL.Path, "/user/")
+	if username == "" || strings.Contains(username, "/") {
+		a.renderError(w, r, http.StatusNotFound, "user.not_found", viewer)
+		return
+	}
+
+	profile, err := a.Profiles.GetProfileByUsername(r.Context(), username)
+	if err == sql.ErrNoRows {
+		a.renderError(w, r, http.StatusNotFound, "user.not_found", viewer)
+		return
+	}
+	if err != nil {
+		a.logError(r, err, "get profile")
+		a.renderError(w, r, http.StatusInternalServerError, "profile.load_failed", viewer)
+		return
+	}
+	if profile.Username != username {
+		target := "/user/" + url.PathEscape(profile.Username)
+		if r.URL.RawQuery != "" {
+			target += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+		return
+	}
+
+	data := models.ProfilePageData{
+		CurrentUser: viewer,
+		Profile:     *profile,
+		IsOwner:     viewer != nil && viewer.ID == profile.UserID,
+		Tab:         r.URL.Query().Get("tab"),
+		Page:        1,
+	}
+	if !profile.VisibleTo(viewer) {
+		data.Hidden = true
+		a.render(w, r, "profile.html", data)
+		return
+	}
+
+	if data.Tab == "" {
+		data.Tab = "posts"
+	}
+	if pageStr := r.URL.Query().Get("page"); pageStr != "" {
+		page, convErr := strconv.Atoi(pageStr)
+		if convErr != nil || page < 1 {
+			a.renderError(w, r, http.StatusBadRequest, "error.bad_page", viewer)
+			return
+		}
+		data.Page = page
+	}
+	offset := (data.Page - 1) * profilePageSize
+
+	switch data.Tab {
+	case "posts", "liked":
+		filter := repo.PostCardsFilter{
+			UserID:   profile.UserID,
+			MineOnly: data.Tab == "posts",
+			Limit:    profilePageSize + 1,
+			Offset:   offset,
+		}
+		if data.Tab == "liked" {
+			if !profile.ShowLiked && !data.IsOwner {
+				a.renderError(w, r, http.StatusForbidden, "profile.liked_hidden", viewer)
+				return
+			}
+			filter.LikedOnly = true
+		}
+		if viewer != nil {
+			filter.ViewerID = viewer.ID
+		}
+		cards, err := a.Posts.GetPostCards(r.Context(), filter)
+		if err != nil {
+			a.logError(r, err, "get profile posts")
+			a.renderError(w, r, http.StatusInternalServerError, "post.list_failed", viewer)
+			return
+		}
+		if len(cards) > profilePageSize {
+			cards = cards[:profilePageSize]
+			data.HasNext = true
+		}
+		data.Posts = cards
+	case "comments":
+		comments, err := a.Profiles.GetCommentsByUserID(r.Context(), profile.UserID, profilePageSize+1, offset)
+		if err != nil {
+			a.logError(r, err, "get profile comments")
+			a.renderError(w, r, http.StatusInternalServerError, "comment.list_failed", viewer)
+			return
+		}
+		if len(comments) > profilePageSize {
+			comments = comments[:profilePageSize]
+			data.HasNext = true
+		}
+		data.Comments = comments
+	default:
+		a.renderError(w, r, http.StatusNotFound, "profile.tab_not_found", viewer)
+		return
+	}
+
+	a.render(w, r, "profile.html", data)
+}
+
+func (a *App) ProfileSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := middleware.CurrentUser(r)
+	if err != nil {
+		a.renderError(w, r, http.StatusUnauthorized, "error.unauthorized", nil)
+		return
+	}
+
+	profile, err := a.Profiles.GetProfileByUsername(r.Context(), user.Username)
+	if err != nil {
+		a.logError(r, err, "get profile")
+		a.renderError(w, r, http.StatusInternalServerError, "profile.load_failed", user)
+		return
+	}
+	data := models.ProfileEditPageData{
+		CurrentUser:  user,
+		Profile:      *profile,
+		Visibilities: models.ProfileVisibilities,
+	}
+
+	if r.Method == http.MethodGet {
+		a.render(w, r, "profile_edit.html", data)
+		return
+	}
+	if r.Method != http.MethodPost {
+		a.renderError(w, r, http.StatusMethodNotAllowed, "error.method_not_allowed", user)
+		return
+	}
+
+	if err := a.parsePostForm(w, r); err != nil {
+		data.Error = a.T(r, "error.bad_form")
+		status := http.StatusBadRequest
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			data.Error = a.T(r, "upload.file_too_large")
+			status = http.StatusRequestEntityTooLarge
+		}
+		a.renderWithStatus(w, r, status, "profile_edit.html", data)
+		return
+	}
+
+	bio := strings.TrimSpace(r.FormValue("bio"))
+	visibility := r.FormValue("visibility")
+	showLiked := r.FormValue("show_liked") == "1"
+	data.Profile.Bio = bio
+	data.Profile.Visibility = visibility
+	data.Profile.ShowLiked = showLiked
+
+	if utf8.RuneCountInString(bio) > maxBioLength {
+		data.Error = a.T(r, "profile.bio_too_long")
+		a.renderWithStatus(w, r, http.StatusBadRequest, "profile_edit.html", data)
+		return
+	}
+	if !models.ValidProfileVisibility(visibility) {
+		data.Error = a.T(r, "profile.bad_visibility")
+		a.renderWithStatus(w, r, http.StatusBadRequest, "profile_edit.html", data)
+		return
+	}
+
+	if err := a.Profiles.UpdateProfile(r.Context(), user.ID, bio, visibility, showLiked); err != nil {
+		a.logError(r, err, "update profile")
+		a.renderError(w, r, http.StatusInternalServerError, "profile.save_failed", user)
+		return
+	}
+
+	if err := a.updateAvatar(r, user.ID); err != nil {
+		var uploadErr *uploadError
+		if errors.As(err, &uploadErr) {
+			data.Error = uploadErr.message
+			a.renderWithStatus(w, r, http.StatusBadRequest, "profile_edit.html", data)
+			return
+		}
+		a.logError(r, err, "update avatar")
+		a.renderError(w, r, http.StatusInternalServerError, "profile.avatar_failed", user)
+		return
+	}
+
+	http.Redirect(w, r, "/user/"+url.PathEscape(user.Username), http.StatusSeeOther)
+}
+
+// updateAvatar applies the avatar part of the settings form. Old avatar blobs
+// are left in place: keys are content-addressed and may be shared with post
+// attachments.
+func (a *App) updateAvatar(r *http.Request, userID int) error {
+	if r.FormValue("remove_avatar") == "1" {
+		return a.Profiles.SetAvatar(r.Context(), userID, "", "")
+	}
+	if r.MultipartForm == nil {
+		return nil
+	}
+	for _, fh := range r.MultipartForm.File["avatar"] {
+		if fh.Filename == "" && fh.Size == 0 {
+			continue
+		}
+		att, data, err := a.storeAttachment(r, fh)
+		if err != nil {
+			return err
+		}
+		if err := a.Profiles.SetAvatar(r.Context(), userID, att.StorageKey, att.ContentType); err != nil {
+			return err
+		}
+		a.keepBlobs(r, map[string][]byte{att.StorageKey: data})
+		return nil
+	}
+	return nil
+}
+
+func (a *App) AvatarHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, a.T(r, "error.method_not_allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, "/avatars/")
+	contentType, err := a.Profiles.GetAvatarContentType(r.Context(), key)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		a.logError(r, err, "get avatar")
+		http.Error(w, a.T(r, "upload.load_failed"), http.StatusInternalServerError)
+		return
+	}
+
+	a.serveBlob(w, r, key, contentType)
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"forum/internal/models"
+)
+
+func TestProfileVisibility(t *testing.T) {
+	f := newMemoryForum(t)
+	ctx := context.Background()
+	hidden := "This user has hidden their profile"
+
+	tests := []struct {
+		visibility string
+		session    string
+		status     int
+		hidden     bool
+	}{
+		{models.ProfilePublic, "", http.StatusOK, false},
+		{models.ProfileMembers, "", http.StatusOK, true},
+		{models.ProfileMembers, f.bobSession, http.StatusOK, false},
+		{models.ProfilePrivate, f.bobSession, http.StatusOK, true},
+		{models.ProfilePrivate, f.annSession, http.StatusOK, false},
+	}
+	for _, tt := range tests {
+		if err := f.profiles.UpdateProfile(ctx, f.ann, "Hi", tt.visibility, false); err != nil {
+			t.Fatal(err)
+		}
+		w := f.serve(httptest.NewRequest(http.MethodGet, "/user/ann", nil), tt.session)
+		if w.Code != tt.status {
+			t.Errorf("%s, session %q: status %d, want %d", tt.visibility, tt.session, w.Code, tt.status)
+			continue
+		}
+		if got := strings.Contains(w.Body.String(), hidden); got != tt.hidden {
+			t.Errorf("%s, session %q: hidden %v, want %v", tt.visibility, tt.session, got, tt.hidden)
+		}
+	}
+}
+
+func TestProfilePages(t *testing.T) {
+	f := newMemoryForum(t)
+
+	tests := []struct {
+		name    string
+		target  string
+		session string
+		status  int
+	}{
+		{"posts", "/user/ann", "", http.StatusOK},
+		{"comments", "/user/ann?tab=comments", "", http.StatusOK},
+		{"liked, hidden from others", "/user/ann?tab=liked", f.bobSession, http.StatusForbidden},
+		{"liked, by the owner", "/user/ann?tab=liked", f.annSession, http.StatusOK},
+		{"unknown tab", "/user/ann?tab=drafts", "", http.StatusNotFound},
+		{"bad page", "/user/ann?page=0", "", http.StatusBadRequest},
+		{"unknown user", "/user/carol", "", http.StatusNotFound},
+		{"other case", "/user/ANN?tab=comments", "", http.StatusMovedPermanently},
+	}
+	for _, tt := range tests {
+		w := f.serve(httptest.NewRequest(http.MethodGet, tt.target, nil), tt.session)
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.status)
+		}
+	}
+	w := f.serve(httptest.NewRequest(http.MethodGet, "/user/ANN?tab=comments", nil), "")
+	if loc := w.Header().Get("Location"); loc != "/user/ann?tab=comments" {
+		t.Errorf("other case redirects to %q", loc)
+	}
+}
+
+func TestProfileSettings(t *testing.T) {
+	f := newMemoryForum(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		values url.Values
+		status int
+	}{
+		{"bio too long", url.Values{"bio": {strings.Repeat("я", maxBioLength+1)}, "visibility": {models.ProfilePublic}}, http.StatusBadRequest},
+		{"unknown visibility", url.Values{"bio": {"Hi"}, "visibility": {"friends"}}, http.StatusBadRequest},
+		{"saved", url.Values{"bio": {" Hi "}, "visibility": {models.ProfileMembers}, "show_liked": {"1"}}, http.StatusSeeOther},
+	}
+	for _, tt := range tests {
+		if w := f.serve(form("/settings/profile", tt.values), f.annSession); w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.status)
+		}
+	}
+	p, err := f.profiles.GetProfileByUsername(ctx, "ann")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Bio != "Hi" || p.Visibility != models.ProfileMembers || !p.ShowLiked {
+		t.Errorf("profile = %+v", p)
+	}
+
+	if w := f.serve(form("/settings/profile", url.Values{"visibility": {models.ProfilePublic}}), ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("signed out: status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestAvatar(t *testing.T) {
+	f := newMemoryForum(t)
+	ctx := context.Background()
+
+	body, contentType := multipartForm(t, map[string]string{"visibility": models.ProfilePublic}, "avatar")
+	r := httptest.NewRequest(http.MethodPost, "/settings/profile", body)
+	r.Header.Set("Content-Type", contentType)
+	if w := f.serve(r, f.annSession); w.Code != http.StatusSeeOther {
+		t.Fatalf("upload: status %d\n%s", w.Code, w.Body)
+	}
+	p, err := f.profiles.GetProfileByUsername(ctx, "ann")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.AvatarKey == "" || p.AvatarType != "image/png" {
+		t.Fatalf("avatar %q of type %q, want a PNG", p.AvatarKey, p.AvatarType)
+	}
+
+	w := f.serve(httptest.NewRequest(http.MethodGet, p.AvatarURL(), nil), "")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/png" || !bytes.Equal(w.Body.Bytes(), picture(t)) {
+		t.Errorf("avatar: status %d, type %q, %d bytes", w.Code, w.Header().Get("Content-Type"), w.Body.Len())
+	}
+
+	removed := url.Values{"visibility": {models.ProfilePublic}, "remove_avatar": {"1"}}
+	if w := f.serve(form("/settings/profile", removed), f.annSession); w.Code != http.StatusSeeOther {
+		t.Fatalf("remove: status %d", w.Code)
+	}
+	// The blob may be shared, but no user points at it any more.
+	if w := f.serve(httptest.NewRequest(http.MethodGet, p.AvatarURL(), nil), ""); w.Code != http.StatusNotFound {
+		t.Errorf("removed avatar: status %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
@@ -5,99 +5,80 @@ import (
 	"net/http"
 	"strconv"
 
+	"forum/internal/metrics"
 	"forum/internal/middleware"
+	"forum/internal/models"
+	"forum/internal/repo"
 )
 
 func (a *App) ReactPosts(w http.ResponseWriter, r *http.Request) {
+	varyByFormat(w)
 	if r.Method != http.MethodPost {
-		a.renderError(w, http.StatusMethodNotAllowed, "Метод не поддерживается", nil)
+		a.respondError(w, r, http.StatusMethodNotAllowed, "error.method_not_allowed", nil)
 		return
 	}
 
-	user, err := middleware.CurrentUser(a.DB, r)
+	user, err := middleware.CurrentUser(r)
 	if err != nil {
-		a.renderError(w, http.StatusUnauthorized, "Вы должны авторизоваться, чтобы ставить лайки", nil)
+		a.respondError(w, r, http.StatusUnauthorized, "reaction.login_required", nil)
 		return
 	}
 
 	if err := r.ParseForm(); err != nil {
-		a.renderError(w, http.StatusBadRequest, "Некорректная форма", user)
+		a.respondError(w, r, http.StatusBadRequest, "error.bad_form", user)
 		return
 	}
 	next := r.FormValue("next")
 	if next == "" {
 		next = "/"
 	}
-	postIDStr := r.FormValue("post_id")
-	valueStr := r.FormValue("value")
 
-	postID, err := strconv.Atoi(postIDStr)
+	postID, err := strconv.Atoi(r.FormValue("post_id"))
 	if err != nil {
-		a.renderError(w, http.StatusBadRequest, "Некорректный post_id", user)
+		a.respondError(w, r, http.StatusBadRequest, "post.bad_post_id", user)
 		return
 	}
-	exists, err := a.Posts.PostExists(postID)
+	exists, err := a.Posts.PostExists(r.Context(), postID)
 	if err != nil {
-		a.logError(err, "post exists")
-		a.renderError(w, http.StatusInternalServerError, "Ошибка проверки поста", user)
+		a.logError(r, err, "post exists")
+		a.respondError(w, r, http.StatusInternalServerError, "post.check_failed", user)
 		return
 	}
 	if !exists {
-		a.renderError(w, http.StatusNotFound, "Пост не найден", user)
+		a.respondError(w, r, http.StatusNotFound, "post.not_found", user)
 		return
 	}
 
-	value, err := strconv.Atoi(valueStr)
-	if err != nil || (value != 1 && value != -1) {
-		a.renderError(w, http.StatusBadRequest, "Некорректное значение реакции", user)
-		return
-	}
-
-	var existing int
-	row := a.DB.QueryRow(`SELECT value FROM post_reactions WHERE user_id = ? AND post_id = ?`, user.ID, postID)
-
-	err = row.Scan(&existing)
-
-	if err == sql.ErrNoRows {
-		_, err = a.DB.Exec(`INSERT INTO post_reactions (user_id, post_id, value) VALUES (?, ?, ?)`,
-			user.ID, postID, value)
-
-		if err != nil {
-			a.logError(err, "insert post reaction")
-			a.renderError(w, http.StatusInternalServerError, "Ошибка сохранения реакции", user)
-			return
-		}
-		http.Redirect(w, r, next, http.StatusSeeOther)
+	kind, ok := reactionKindFromForm(r)
+	if !ok {
+		a.respondError(w, r, http.StatusBadRequest, "reaction.invalid", user)
 		return
 	}
 
+	state, err := a.Reactions.Toggle(r.Context(), user.ID, repo.ReactionTargetPost, postID, kind)
 	if err != nil {
-		a.logError(err, "select post reaction")
-		a.renderError(w, http.StatusInternalServerError, "Ошибка чтения реакции", user)
+		a.logError(r, err, "toggle post reaction")
+		a.respondError(w, r, http.StatusInternalServerError, "reaction.save_failed", user)
 		return
 	}
+	if state.Added {
+		metrics.ReactionsCreated.With(repo.ReactionTargetPost).Inc()
+	}
+	a.publishReaction(postID, repo.ReactionTargetPost, postID, state)
+	if state.Added {
+		a.notifyPostAuthor(r, user, postID, models.Notification{
+			Type:   models.NotificationReaction,
+			Detail: kind,
+		})
+	}
 
-	if existing == value {
-		_, err = a.DB.Exec(
-			`DELETE FROM post_reactions WHERE user_id = ? AND post_id = ?`,
-			user.ID, postID,
-		)
-		if err != nil {
-			a.logError(err, "delete post reaction")
-			a.renderError(w, http.StatusInternalServerError, "Ошибка удаления реакции", user)
-			return
-		}
-		http.Redirect(w, r, next, http.StatusSeeOther)
+	if wantsJSON(r) {
+		a.writeJSON(w, r, http.StatusOK, state)
 		return
 	}
-
-	_, err = a.DB.Exec(
-		`UPDATE post_reactions SET value = ? WHERE user_id = ? AND post_id = ?`,
-		value, user.ID, postID,
-	)
-	if err != nil {
-		a.logError(err, "update post reaction")
-		a.renderError(w, http.StatusInternalServerError, "Ошибка обновления реакции", user)
+	if wantsPartial(r) {
+		post := models.PostCard{ID: postID, Reactions: state.Reactions}
+		a.renderPartial(w, r, http.StatusOK, "reactions", post.ReactionBar(r.FormValue("next")))
 		return
 	}
 
@@ -105,101 +86,96 @@ func (a *App) ReactPosts(w http.ResponseWriter, r *http.Request) {
 }
 
 func (a *App) ReactComment(w http.ResponseWriter, r *http.Request) {
+	varyByFormat(w)
 	if r.Method != http.MethodPost {
-		a.renderError(w, http.StatusMethodNotAllowed, "Метод не поддерживается", nil)
+		a.respondError(w, r, http.StatusMethodNotAllowed, "error.method_not_allowed", nil)
 		return
 	}
 
-	user, err := middleware.CurrentUser(a.DB, r)
+	user, err := middleware.CurrentUser(r)
 	if err != nil {
-		a.renderError(w, http.StatusUnauthorized, "Нужно войти", nil)
+		a.respondError(w, r, http.StatusUnauthorized, "error.login_required", nil)
 		return
 	}
 
 	if err := r.ParseForm(); err != nil {
-		a.renderError(w, http.StatusBadRequest, "Некорректная форма", user)
+		a.respondError(w, r, http.StatusBadRequest, "error.bad_form", user)
 		return
 	}
-
-	commentIDStr := r.FormValue("comment_id")
-	valueStr := r.FormValue("value")
 	next := r.FormValue("next")
 	if next == "" {
 		next = "/"
 	}
 
-	commentID, err := strconv.Atoi(commentIDStr)
-	if err != nil {
-		a.renderError(w, http.StatusBadRequest, "Некорректный comment_id", user)
-		return
-	}
-	exists, err := a.Comments.CommentExists(commentID)
+	commentID, err := strconv.Atoi(r.FormValue("comment_id"))
 	if err != nil {
-		a.logError(err, "comment exists")
-		a.renderError(w, http.StatusInternalServerError, "Ошибка проверки комментария", user)
+		a.respondError(w, r, http.StatusBadRequest, "comment.bad_id", user)
 		return
 	}
-	if !exists {
-		a.renderError(w, http.StatusNotFound, "Комментарий не найден", user)
+	comment, err := a.Comments.GetCommentByID(r.Context(), commentID, user.ID)
+	if err == sql.ErrNoRows {
+		a.respondError(w, r, http.StatusNotFound, "comment.not_found", user)
 		return
 	}
-
-	value, err := strconv.Atoi(valueStr)
-	if err != nil || (value != 1 && value != -1) {
-		a.renderError(w, http.StatusBadRequest, "Некорректное значение реакции", user)
+	if err != nil {
+		a.logError(r, err, "get comment")
+		a.respondError(w, r, http.StatusInternalServerError, "comment.check_failed", user)
 		return
 	}
 
-	var existing int
-	row := a.DB.QueryRow(
-		`SELECT value FROM comment_reactions WHERE user_id = ? AND comment_id = ?`,
-		user.ID, commentID,
-	)
-	err = row.Scan(&existing)
-
-	if err == sql.ErrNoRows {
-		_, err = a.DB.Exec(
-			`INSERT INTO comment_reactions (user_id, comment_id, value) VALUES (?, ?, ?)`,
-			user.ID, commentID, value,
-		)
-		if err != nil {
-			a.logError(err, "insert comment reaction")
-			a.renderError(w, http.StatusInternalServerError, "Ошибка сохранения реакции", user)
-			return
-		}
-		http.Redirect(w, r, next, http.StatusSeeOther)
+	kind, ok := reactionKindFromForm(r)
+	if !ok {
+		a.respondError(w, r, http.StatusBadRequest, "reaction.invalid", user)
 		return
 	}
 
+	state, err := a.Reactions.Toggle(r.Context(), user.ID, repo.ReactionTargetComment, commentID, kind)
 	if err != nil {
-		a.logError(err, "select comment reaction")
-		a.renderError(w, http.StatusInternalServerError, "Ошибка чтения реакции", user)
+		a.logError(r, err, "toggle comment reaction")
+		a.respondError(w, r, http.StatusInternalServerError, "reaction.save_failed", user)
 		return
 	}
+	if state.Added {
+		metrics.ReactionsCreated.With(repo.ReactionTargetComment).Inc()
+	}
+	a.publishReaction(comment.PostID, repo.ReactionTargetComment, commentID, state)
+	if state.Added {
+		a.notify(r, models.Notification{
+			UserID:    comment.UserID,
+			ActorID:   user.ID,
+			Type:      models.NotificationReaction,
+			PostID:    comment.PostID,
+			CommentID: commentID,
+			Detail:    kind,
+		})
+	}
 
-	if existing == value {
-		_, err = a.DB.Exec(
-			`DELETE FROM comment_reactions WHERE user_id = ? AND comment_id = ?`,
-			user.ID, commentID,
-		)
-		if err != nil {
-			a.logError(err, "delete comment reaction")
-			a.renderError(w, http.StatusInternalServerError, "Ошибка удаления реакции", user)
-			return
-		}
-		http.Redirect(w, r, next, http.StatusSeeOther)
+	if wantsJSON(r) {
+		a.writeJSON(w, r, http.StatusOK, state)
 		return
 	}
-
-	_, err = a.DB.Exec(
-		`UPDATE comment_reactions SET value = ? WHERE user_id = ? AND comment_id = ?`,
-		value, user.ID, commentID,
-	)
-	if err != nil {
-		a.logError(err, "update comment reaction")
-		a.renderError(w, http.StatusInternalServerError, "Ошибка обновления реакции", user)
+	if wantsPartial(r) {
+		comment.Reactions = state.Reactions
+		a.renderPartial(w, r, http.StatusOK, "reactions", comment.ReactionBar(r.FormValue("next")))
 		return
 	}
 
 	http.Redirect(w, r, next, http.StatusSeeOther)
 }
+
+func reactionKindFromForm(r *http.Request) (string, bool) {
+	kind := r.FormValue("kind")
+	if kind == "" {
+		sign := 0
+		switch r.FormValue("value") {
+		case "1":
+			sign = 1
+		case "-1":
+			sign = -1
+		}
+		k, ok := models.ReactionKindByScore(sign)
+		return k.Code, ok
+	}
+	_, ok := models.ReactionKindByCode(kind)
+	return kind, ok
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"forum/internal/models"
+	"forum/internal/repo"
+)
+
+func reactRequest(method string, form url.Values, accept string) *http.Request {
+	r := httptest.NewRequest(method, "/react-post", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if accept != "" {
+		r.Header.Set("Accept", accept)
+	}
+	return r
+}
+
+func TestReactPosts(t *testing.T) {
+	f := newMemoryForum(t)
+	if _, err := f.posts.CreatePost(context.Background(), f.bob, "Plums", "Ripe", []int{1}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		method  string
+		session string
+		form    url.Values
+		status  int
+		// active and likes are checked on JSON responses.
+		active bool
+		likes  int
+	}{
+		{"guest", http.MethodPost, "", url.Values{"post_id": {"1"}, "kind": {"like"}}, http.StatusUnauthorized, false, 0},
+		{"wrong method", http.MethodGet, f.annSession, nil, http.StatusMethodNotAllowed, false, 0},
+		{"bad post id", http.MethodPost, f.annSession, url.Values{"post_id": {"x"}, "kind": {"like"}}, http.StatusBadRequest, false, 0},
+		{"missing post", http.MethodPost, f.annSession, url.Values{"post_id": {"9"}, "kind": {"like"}}, http.StatusNotFound, false, 0},
+		{"unknown kind", http.MethodPost, f.annSession, url.Values{"post_id": {"1"}, "kind": {"nope"}}, http.StatusBadRequest, false, 0},
+		{"like", http.MethodPost, f.annSession, url.Values{"post_id": {"1"}, "kind": {"like"}}, http.StatusOK, true, 1},
+		{"like by another user", http.MethodPost, f.bobSession, url.Values{"post_id": {"1"}, "value": {"1"}}, http.StatusOK, true, 2},
+		{"like again", http.MethodPost, f.annSession, url.Values{"post_id": {"1"}, "kind": {"like"}}, http.StatusOK, false, 1},
+		{"dislike", http.MethodPost, f.bobSession, url.Values{"post_id": {"1"}, "value": {"-1"}}, http.StatusOK, true, 0},
+	}
+	for _, tt := range tests {
+		w := f.serve(reactRequest(tt.method, tt.form, "application/json"), tt.session)
+		if w.Code != tt.status {
+			t.Fatalf("%s: status %d, want %d\n%s", tt.name, w.Code, tt.status, w.Body)
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		var state models.ReactionState
+		if err := json.Unmarshal(w.Body.Bytes(), &state); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if state.Active != tt.active || state.Likes != tt.likes {
+			t.Errorf("%s: active %v with %d likes, want %v with %d", tt.name, state.Active, state.Likes, tt.active, tt.likes)
+		}
+	}
+
+	summaries, likes, dislikes := f.reactions.Summaries(repo.ReactionTargetPost, 1, f.bob)
+	if likes != 0 || dislikes != 1 {
+		t.Errorf("likes, dislikes = %d, %d, want 0, 1", likes, dislikes)
+	}
+	for _, s := range summaries {
+		if s.Kind == "dislike" && !s.Mine {
+			t.Error("bob's dislike is not marked as bob's")
+		}
+	}
+}
+
+func TestReactPostsRedirectsForms(t *testing.T) {
+	f := newMemoryForum(t)
+	if _, err := f.posts.CreatePost(context.Background(), f.bob, "Plums", "Ripe", []int{1}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	w := f.serve(reactRequest(http.MethodPost, url.Values{"post_id": {"1"}, "kind": {"heart"}, "next": {"/post?id=1"}}, ""), f.annSession)
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/post?id=1" {
+		t.Errorf("status %d to %q, want %d to /post?id=1", w.Code, w.Header().Get("Location"), http.StatusSeeOther)
+	}
+	if vary := w.Header().Get("Vary"); !strings.Contains(vary, "Accept") {
+		t.Errorf("Vary = %q, want it to name Accept", vary)
+	}
+
+	w = f.serve(reactRequest(http.MethodPost, url.Values{"post_id": {"1"}, "kind": {"heart"}}, ""), f.annSession)
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/" {
+		t.Errorf("without next: status %d to %q, want %d to /", w.Code, w.Header().Get("Location"), http.StatusSeeOther)
+	}
+	if _, likes, _ := f.reactions.Summaries(repo.ReactionTargetPost, 1, f.ann); likes != 0 {
+		t.Errorf("a heart counted as %d likes", likes)
+	}
+}
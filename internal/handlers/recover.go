@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"forum/internal/middleware"
+	"forum/internal/models"
+)
+
+// Recover turns a panic in a handler into a logged stack trace and a plain
+// 500 page. The stack only reaches the page when the App runs in dev mode.
+func (a *App) Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tw := &writeTracker{ResponseWriter: w}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			stack := debug.Stack()
+			middleware.Logger(r).Error("panic", "panic", fmt.Sprint(v), "stack", string(stack))
+			if tw.wrote {
+				// Part of the response is already out; the best we can do
+				// is let the client see a truncated body.
+				return
+			}
+			a.renderPanic(tw, r, fmt.Sprint(v), stack)
+		}()
+		next.ServeHTTP(tw, r)
+	})
+}
+
+func (a *App) renderPanic(w http.ResponseWriter, r *http.Request, value string, stack []byte) {
+	const key = "error.internal"
+	if !a.Dev {
+		a.renderError(w, r, http.StatusInternalServerError, key, nil)
+		return
+	}
+	a.renderWithStatus(w, r, http.StatusInternalServerError, "error.html", models.ErrorPageData{
+		Status:    http.StatusInternalServerError,
+		Message:   a.T(r, key),
+		RequestID: w.Header().Get(middleware.RequestIDHeader),
+		Stack:     value + "\n\n" + string(stack),
+	})
+}
+
+// writeTracker remembers whether the handler started the response.
+type writeTracker struct {
+	http.ResponseWriter
+	wrote bool
+}
+
+func (t *writeTracker) WriteHeader(status int) {
+	t.wrote = true
+	t.ResponseWriter.WriteHeader(status)
+}
+
+func (t *writeTracker) Write(p []byte) (int, error) {
+	t.wrote = true
+	return t.ResponseWriter.Write(p)
+}
+
+func (t *writeTracker) Flush() {
+	if f, ok := t.ResponseWriter.(http.Flusher); ok {
+		t.wrote = true
+		f.Flush()
+	}
+}
+
+func (t *writeTracker) Unwrap() http.ResponseWriter {
+	return t.ResponseWriter
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"forum/internal/models"
+)
+
+// varyByFormat tells caches that the response depends on the headers
+// wantsJSON and wantsPartial read. Handlers that answer in more than one
+// format call it before writing anything.
+func varyByFormat(w http.ResponseWriter) {
+	w.Header().Set("Vary", "Accept, HX-Request")
+}
+
+func wantsJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+func wantsPartial(r *http.Request) bool {
+	return r.Header.Get("HX-Request") == "true"
+}
+
+func (a *App) writeJSON(w http.ResponseWriter, r *http.Request, status int, v any) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		a.logError(r, err, "json encode")
+		http.Error(w, a.T(r, "error.internal"), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
+
+func (a *App) renderPartial(w http.ResponseWriter, r *http.Request, status int, name string, data any) {
+	html, err := a.renderPartialString(r, name, data)
+	if err != nil {
+		a.logError(r, err, "template execute", "partial", name)
+		http.Error(w, a.T(r, "error.internal"), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	_, _ = io.WriteString(w, html)
+}
+
+func (a *App) renderPartialString(r *http.Request, name string, data any) (string, error) {
+	html, err := a.Views.Partial(a.lang(r), a.location(r), name, data)
+	return string(html), err
+}
+
+func (a *App) respondError(w http.ResponseWriter, r *http.Request, status int, key string, user *models.User) {
+	varyByFormat(w)
+	if wantsJSON(r) {
+		a.writeJSON(w, r, status, map[string]string{"error": a.T(r, key)})
+		return
+	}
+	if wantsPartial(r) {
+		http.Error(w, a.T(r, key), status)
+		return
+	}
+	a.renderError(w, r, status, key, user)
+}
@@ -0,0 +1,350 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"forum/internal/markup"
+	"forum/internal/middleware"
+	"forum/internal/models"
+	"forum/internal/repo"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const emailChangeTTL = 24 * time.Hour
+
+// settingsMessages maps the done parameter of a redirect to the message key
+// shown on the settings page.
+var settingsMessages = map[string]string{
+	"username":  "settings.done.username",
+	"password":  "settings.done.password",
+	"email":     "settings.done.email",
+	"confirmed": "settings.done.confirmed",
+	"export":    "settings.done.export",
+	"linked":    "settings.done.linked",
+	"timezone":  "settings.done.timezone",
+	"reauth":    "settings.done.reauth",
+}
+
+func (a *App) SettingsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.renderError(w, r, http.StatusMethodNotAllowed, "error.method_not_allowed", nil)
+		return
+	}
+	user, err := middleware.CurrentUser(r)
+	if err != nil {
+		a.renderError(w, r, http.StatusUnauthorized, "error.unauthorized", nil)
+		return
+	}
+
+	var success string
+	if key, ok := settingsMessages[r.URL.Query().Get("done")]; ok {
+		success = a.T(r, key)
+	}
+	a.renderSettings(w, r, http.StatusOK, user, "", success)
+}
+
+func (a *App) renderSettings(w http.ResponseWriter, r *http.Request, status int, user *models.User, errMsg string, success string) {
+	pending, err := a.Users.GetPendingEmail(r.Context(), user.ID)
+	if err != nil {
+		a.logError(r, err, "get pending email")
+	}
+	latest, err := a.Exports.GetLatestExport(r.Context(), user.ID)
+	if err != nil && err != sql.ErrNoRows {
+		a.logError(r, err, "get latest export")
+	}
+	identities, err := a.Identities.GetIdentitiesByUserID(r.Context(), user.ID)
+	if err != nil {
+		a.logError(r, err, "get identities")
+	}
+	data := models.SettingsPageData{
+		CurrentUser:  user,
+		PendingEmail: pending,
+		Export:       latest,
+		Identities:   identities,
+		Providers:    a.loginProviders(),
+		Confirmed:    confirmedOwner(user, ""),
+		Confirmable:  a.linkedProviders(identities),
+		Timezone:     a.location(r).String(),
+		Timezones:    commonTimezones,
+		Error:        errMsg,
+		Success:      success,
+	}
+	a.renderWithStatus(w, r, status, "settings.html", data)
+}
+
+// linkedProviders returns the configured providers among identities, which
+// are the ones the user can confirm the account through.
+func (a *App) linkedProviders(identities []models.ExternalIdentity) []models.LoginProvider {
+	var providers []models.LoginProvider
+	for _, p := range a.loginProviders() {
+		for _, id := range identities {
+			if id.Provider == p.Name {
+				providers = append(providers, p)
+				break
+			}
+		}
+	}
+	return providers
+}
+
+// settingsUser returns the signed-in user for a settings form submission, or
+// writes the error response and returns nil.
+func (a *App) settingsUser(w http.ResponseWriter, r *http.Request) *models.User {
+	if r.Method != http.MethodPost {
+		a.renderError(w, r, http.StatusMethodNotAllowed, "error.method_not_allowed", nil)
+		return nil
+	}
+	user, err := middleware.CurrentUser(r)
+	if err != nil {
+		a.renderError(w, r, http.StatusUnauthorized, "error.unauthorized", nil)
+		return nil
+	}
+	if err := r.ParseForm(); err != nil {
+		a.renderSettings(w, r, http.StatusBadRequest, user, a.T(r, "error.bad_form"), "")
+		return nil
+	}
+	return user
+}
+
+func checkPassword(user *models.User, password string) bool {
+	return user.HasPassword() && bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) == nil
+}
+
+// confirmWindow is how long a confirmation through a sign-in provider stands
+// in for the password.
+const confirmWindow = 10 * time.Minute
+
+// confirmedOwner reports whether a request to change a protected setting
+// comes from the account owner: the password is right, or the session
+// confirmed the account through a linked provider within confirmWindow.
+// Accounts created through a provider have no password, so that is the only
+// way for them.
+func confirmedOwner(user *models.User, password string) bool {
+	if checkPassword(user, password) {
+		return true
+	}
+	return !user.ConfirmedAt.IsZero() && time.Since(user.ConfirmedAt) < confirmWindow
+}
+
+// notOwnerKey is the message for a failed confirmedOwner check: wrongPassword
+// for accounts with a password, and a request to confirm through the
+// provider for those without.
+func notOwnerKey(user *models.User, wrongPassword string) string {
+	if user.HasPassword() {
+		return wrongPassword
+	}
+	return "settings.reauth_required"
+}
+
+func (a *App) SettingsUsernameHandler(w http.ResponseWriter, r *http.Request) {
+	user := a.settingsUser(w, r)
+	if user == nil {
+		return
+	}
+
+	username := strings.TrimSpace(r.FormValue("username"))
+	if !markup.ValidUsername(username) {
+		a.renderSettings(w, r, http.StatusBadRequest, user, a.T(r, "user.username_rules"), "")
+		return
+	}
+
+	if err := a.Users.UpdateUsername(r.Context(), user.ID, username); err != nil {
+		if errors.Is(err, repo.ErrUsernameTaken) {
+			a.renderSettings(w, r, http.StatusBadRequest, user, a.T(r, "user.username_taken"), "")
+			return
+		}
+		a.logError(r, err, "update username")
+		a.renderSettings(w, r, http.StatusInternalServerError, user, a.T(r, "error.save_failed"), "")
+		return
+	}
+
+	http.Redirect(w, r, "/settings?done=username", http.StatusSeeOther)
+}
+
+func (a *App) SettingsEmailHandler(w http.ResponseWriter, r *http.Request) {
+	user := a.settingsUser(w, r)
+	if user == nil {
+		return
+	}
+
+	email := strings.TrimSpace(r.FormValue("email"))
+	if email == "" || !strings.Contains(email, "@") {
+		a.renderSettings(w, r, http.StatusBadRequest, user, a.T(r, "settings.enter_valid_email"), "")
+		return
+	}
+	if !confirmedOwner(user, r.FormValue("password")) {
+		a.renderSettings(w, r, http.StatusUnauthorized, user, a.T(r, notOwnerKey(user, "auth.wrong_password")), "")
+		return
+	}
+	if strings.EqualFold(email, user.Email) {
+		a.renderSettings(w, r, http.StatusBadRequest, user, a.T(r, "settings.email_same"), "")
+		return
+	}
+	if _, err := a.Users.GetUserByEmail(r.Context(), email); err == nil {
+		a.renderSettings(w, r, http.StatusBadRequest, user, a.T(r, "user.email_taken"), "")
+		return
+	}
+
+	token, tokenHash, err := newEmailToken()
+	if err != nil {
+		a.logError(r, err, "generate email token")
+		a.renderSettings(w, r, http.StatusInternalServerError, user, a.T(r, "error.save_failed"), "")
+		return
+	}
+	if err := a.Users.CreateEmailChange(r.Context(), user.ID, email, tokenHash, time.Now().Add(emailChangeTTL)); err != nil {
+		if errors.Is(err, repo.ErrEmailTaken) {
+			a.renderSettings(w, r, http.StatusBadRequest, user, a.T(r, "user.email_taken"), "")
+			return
+		}
+		a.logError(r, err, "create email change")
+		a.renderSettings(w, r, http.StatusInternalServerError, user, a.T(r, "error.save_failed"), "")
+		return
+	}
+
+	link := requestBaseURL(r) + "/settings/email/confirm?token=" + token
+	body := a.T(r, "settings.email_body", user.Username, link)
+	if err := a.Mailer.Send(email, a.T(r, "settings.email_subject"), body); err != nil {
+		a.logError(r, err, "send email confirmation")
+		a.renderSettings(w, r, http.StatusInternalServerError, user, a.T(r, "settings.mail_failed"), "")
+		return
+	}
+
+	http.Redirect(w, r, "/settings?done=email", http.StatusSeeOther)
+}
+
+func (a *App) EmailConfirmHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.renderError(w, r, http.StatusMethodNotAllowed, "error.method_not_allowed", nil)
+		return
+	}
+	user, _ := middleware.CurrentUser(r)
+
+	token := r.URL.Query().Get("token")
+	sum := sha256.Sum256([]byte(token))
+	_, err := a.Users.ConfirmEmailChange(r.Context(), hex.EncodeToString(sum[:]))
+	if errors.Is(err, repo.ErrEmailChangeExpired) {
+		a.renderError(w, r, http.StatusBadRequest, "settings.email_link_invalid", user)
+		return
+	}
+	if err != nil {
+		if errors.Is(err, repo.ErrEmailTaken) {
+			a.renderError(w, r, http.StatusConflict, "user.email_taken", user)
+			return
+		}
+		a.logError(r, err, "confirm email change")
+		a.renderError(w, r, http.StatusInternalServerError, "settings.confirm_failed", user)
+		return
+	}
+
+	if user == nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/settings?done=confirmed", http.StatusSeeOther)
+}
+
+func (a *App) SettingsPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	user := a.settingsUser(w, r)
+	if user == nil {
+		return
+	}
+
+	if !confirmedOwner(user, r.FormValue("current_password")) {
+		a.renderSettings(w, r, http.StatusUnauthorized, user, a.T(r, notOwnerKey(user, "settings.wrong_current_password")), "")
+		return
+	}
+	password := strings.TrimSpace(r.FormValue("password"))
+	if password == "" {
+		a.renderSettings(w, r, http.StatusBadRequest, user, a.T(r, "settings.enter_new_password"), "")
+		return
+	}
+	if password != strings.TrimSpace(r.FormValue("password_confirm")) {
+		a.renderSettings(w, r, http.StatusBadRequest, user, a.T(r, "settings.password_mismatch"), "")
+		return
+	}
+
+	if err := a.Users.UpdatePassword(r.Context(), user.ID, password); err != nil {
+		a.logError(r, err, "update password")
+		a.renderSettings(w, r, http.StatusInternalServerError, user, a.T(r, "error.save_failed"), "")
+		return
+	}
+	if c, err := r.Cookie(middleware.SessionCookie); err == nil {
+		if err := a.Sessions.DeleteOtherSessions(r.Context(), user.ID, c.Value); err != nil {
+			a.logError(r, err, "delete other sessions")
+		}
+	}
+
+	http.Redirect(w, r, "/settings?done=password", http.StatusSeeOther)
+}
+
+func (a *App) SettingsDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	user := a.settingsUser(w, r)
+	if user == nil {
+		return
+	}
+
+	if !confirmedOwner(user, r.FormValue("password")) {
+		a.renderSettings(w, r, http.StatusUnauthorized, user, a.T(r, notOwnerKey(user, "auth.wrong_password")), "")
+		return
+	}
+	mode := r.FormValue("mode")
+	if mode != repo.AccountAnonymize && mode != repo.AccountRemove {
+		a.renderSettings(w, r, http.StatusBadRequest, user, a.T(r, "settings.delete_choose_mode"), "")
+		return
+	}
+
+	exportKeys, err := a.Exports.DeleteOldExports(r.Context(), user.ID, 0)
+	if err != nil {
+		a.logError(r, err, "delete exports")
+	}
+	for _, key := range exportKeys {
+		if err := a.Blobs.Delete(r.Context(), key); err != nil {
+			a.logError(r, err, "delete export archive")
+		}
+	}
+
+	blobKeys, err := a.Users.DeleteAccount(r.Context(), user.ID, mode)
+	if err != nil {
+		a.logError(r, err, "delete account")
+		a.renderSettings(w, r, http.StatusInternalServerError, user, a.T(r, "settings.delete_failed"), "")
+		return
+	}
+	if err := a.Attachments.ReleaseBlobs(context.WithoutCancel(r.Context()), blobKeys, a.Blobs.Delete); err != nil {
+		a.logError(r, err, "release account blobs")
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:    middleware.SessionCookie,
+		Value:   "",
+		Expires: time.Unix(0, 0),
+		MaxAge:  -1,
+		Path:    "/",
+	})
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func newEmailToken() (token string, tokenHash string, err error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	token = hex.EncodeToString(buf)
+	sum := sha256.Sum256([]byte(token))
+	return token, hex.EncodeToString(sum[:]), nil
+}
+
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"forum/internal/models"
+	"forum/internal/storage"
+)
+
+// upload creates a post with the picture uploadForm attaches.
+func (b *browser) upload(title string) {
+	b.t.Helper()
+	body, contentType := uploadForm(b.t, title)
+	req, err := http.NewRequest(http.MethodPost, b.srv.URL+"/create-post", body)
+	if err != nil {
+		b.t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	if resp, body := b.do(req); resp.StatusCode != http.StatusSeeOther {
+		b.t.Fatalf("upload %q: status %d\n%s", title, resp.StatusCode, body)
+	}
+}
+
+// blobCount counts the files in the app's blob store.
+func blobCount(t *testing.T, app *App) int {
+	t.Helper()
+	n := 0
+	err := filepath.WalkDir(app.Blobs.(*storage.FSStore).Root, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			n++
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestPlaceholderAccountIsReserved(t *testing.T) {
+	srv, _ := newForum(t)
+
+	tests := []struct {
+		email, username string
+		message         string
+	}{
+		{"deleted-user@invalid", "mallory", "email already exists"},
+		{"Deleted-User@Invalid", "mallory", "email already exists"},
+		// The placeholder's name breaks the username rules as well.
+		{"mallory@example.com", models.DeletedUsername, "2 to 32 letters"},
+	}
+	for _, tt := range tests {
+		b := newBrowser(t, srv)
+		resp, body := b.post("/register", url.Values{"email": {tt.email}, "username": {tt.username}, "password": {"pw"}})
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("register %s as %q: status %d, want %d", tt.email, tt.username, resp.StatusCode, http.StatusBadRequest)
+		}
+		if !strings.Contains(body, tt.message) {
+			t.Errorf("register %s as %q: page does not say %q", tt.email, tt.username, tt.message)
+		}
+	}
+
+	ann := signUp(t, srv, "ann")
+	if resp, _ := ann.post("/settings/username", url.Values{"username": {models.DeletedUsername}}); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("rename to the placeholder: status %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+	if resp, _ := ann.post("/settings/email", url.Values{"email": {"deleted-user@invalid"}, "password": {"pw"}}); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("change to the placeholder's address: status %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestDeleteAccountReleasesBlobs(t *testing.T) {
+	tests := []struct {
+		name string
+		mode string
+		// shared has bob post the same picture, which must then stay.
+		shared bool
+		blobs  int
+	}{
+		{"remove", "remove", false, 0},
+		{"remove shared picture", "remove", true, 1},
+		{"anonymize", "anonymize", false, 1},
+	}
+	for _, tt := range tests {
+		app, srv, _ := newForumApp(t)
+		ann := signUp(t, srv, "ann")
+		ann.upload("Ann's picture")
+		if tt.shared {
+			signUp(t, srv, "bob").upload("Bob's copy")
+		}
+		if n := blobCount(t, app); n != 1 {
+			t.Fatalf("%s: %d blobs stored, want 1", tt.name, n)
+		}
+
+		resp, body := ann.post("/settings/delete", url.Values{"mode": {tt.mode}, "password": {"pw"}})
+		if resp.StatusCode != http.StatusSeeOther {
+			t.Fatalf("%s: delete: status %d\n%s", tt.name, resp.StatusCode, body)
+		}
+		if n := blobCount(t, app); n != tt.blobs {
+			t.Errorf("%s: %d blobs left, want %d", tt.name, n, tt.blobs)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"forum/internal/middleware"
+)
+
+// tzCookie holds the zone reported by the browser; app.js sets it.
+const tzCookie = "tz"
+
+// commonTimezones are suggested on the settings page. Any IANA zone name is
+// accepted.
+var commonTimezones = []string{
+	"Europe/Kaliningrad",
+	"Europe/Moscow",
+	"Europe/Samara",
+	"Asia/Yekaterinburg",
+	"Asia/Omsk",
+	"Asia/Novosibirsk",
+	"Asia/Krasnoyarsk",
+	"Asia/Irkutsk",
+	"Asia/Yakutsk",
+	"Asia/Vladivostok",
+	"Asia/Magadan",
+	"Asia/Kamchatka",
+	"Europe/Minsk",
+	"Europe/Kyiv",
+	"Asia/Tbilisi",
+	"Asia/Yerevan",
+	"Asia/Almaty",
+	"Asia/Tashkent",
+	"Europe/London",
+	"Europe/Berlin",
+	"America/New_York",
+	"America/Los_Angeles",
+	"Asia/Tokyo",
+	"UTC",
+}
+
+// zones caches loaded locations; time.LoadLocation reads the zone database
+// on every call.
+var zones sync.Map
+
+// loadZone returns the location for an IANA zone name. "Local" is refused:
+// it names the server's zone, not one a user could mean.
+func loadZone(name string) (*time.Location, bool) {
+	if name == "" || name == "Local" {
+		return nil, false
+	}
+	if loc, ok := zones.Load(name); ok {
+		return loc.(*time.Location), true
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, false
+	}
+	zones.Store(name, loc)
+	return loc, true
+}
+
+// location picks the zone times are shown in: the signed-in user's choice,
+// then the browser's zone from the tz cookie, then the site default.
+func (a *App) location(r *http.Request) *time.Location {
+	if loc, ok := loadZone(middleware.UserTimezone(r)); ok {
+		return loc
+	}
+	if c, err := r.Cookie(tzCookie); err == nil {
+		if loc, ok := loadZone(c.Value); ok {
+			return loc
+		}
+	}
+	if a.Location != nil {
+		return a.Location
+	}
+	return time.UTC
+}
+
+// SettingsTimezoneHandler stores the zone the user wants times shown in. An
+// empty value goes back to following the browser.
+func (a *App) SettingsTimezoneHandler(w http.ResponseWriter, r *http.Request) {
+	user := a.settingsUser(w, r)
+	if user == nil {
+		return
+	}
+
+	timezone := strings.TrimSpace(r.FormValue("timezone"))
+	if timezone != "" {
+		if _, ok := loadZone(timezone); !ok {
+			a.renderSettings(w, r, http.StatusBadRequest, user, a.T(r, "settings.bad_timezone"), "")
+			return
+		}
+	}
+
+	if err := a.Users.UpdateTimezone(r.Context(), user.ID, timezone); err != nil {
+		a.logError(r, err, "update timezone")
+		a.renderSettings(w, r, http.StatusInternalServerError, user, a.T(r, "error.save_failed"), "")
+		return
+	}
+
+	http.Redirect(w, r, "/settings?done=timezone", http.StatusSeeOther)
+}
@@ -0,0 +1,510 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"forum/internal/middleware"
+	"forum/internal/models"
+	"forum/internal/repo"
+	"forum/internal/totp"
+	"rsc.io/qr"
+)
+
+const (
+	totpIssuer             = "Forum"
+	loginChallengeCookie   = "login_challenge"
+	loginChallengeTTL      = 5 * time.Minute
+	maxLoginAttempts       = 5
+	recoveryCodeCount      = 10
+	recoveryCodeHalfLength = 5
+)
+
+// twoFactorRequired reports whether the user must have 2FA before signing in.
+func (a *App) twoFactorRequired(r *http.Request, user *models.User) bool {
+	if !user.Staff() {
+		return false
+	}
+	value, err := a.Site.GetSiteSetting(r.Context(), repo.SettingRequireStaff2FA)
+	if err != nil {
+		a.logError(r, err, "get site setting")
+		// Fail closed: staff accounts are worth the extra step.
+		return true
+	}
+	return value == "1"
+}
+
+// completeLogin runs after the first factor (password or an external
+// provider) has been checked. Users with 2FA, or staff who must enrol, go to
+// the second step; everyone else gets a session straight away.
+func (a *App) completeLogin(w http.ResponseWriter, r *http.Request, user *models.User) {
+	if !user.TOTPEnabled && !a.twoFactorRequired(r, user) {
+		if err := a.startSession(r.Context(), w, user.ID); err != nil {
+			a.logError(r, err, "create session")
+			a.renderError(w, r, http.StatusInternalServerError, "auth.session_failed", nil)
+			return
+		}
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	token, tokenHash, err := newEmailToken()
+	if err != nil {
+		a.logError(r, err, "generate login challenge")
+		a.renderError(w, r, http.StatusInternalServerError, "auth.login_failed", nil)
+		return
+	}
+	if err := a.TwoFactor.CreateLoginChallenge(r.Context(), user.ID, tokenHash); err != nil {
+		a.logError(r, err, "create login challenge")
+		a.renderError(w, r, http.StatusInternalServerError, "auth.login_failed", nil)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     loginChallengeCookie,
+		Value:    token,
+		Path:     "/login",
+		MaxAge:   int(loginChallengeTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, "/login/2fa", http.StatusSeeOther)
+}
+
+func clearLoginChallenge(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     loginChallengeCookie,
+		Value:    "",
+		Path:     "/login",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+}
+
+func (a *App) LoginTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		a.renderError(w, r, http.StatusMethodNotAllowed, "error.method_not_allowed", nil)
+		return
+	}
+
+	c, err := r.Cookie(loginChallengeCookie)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	sum := sha256.Sum256([]byte(c.Value))
+	tokenHash := hex.EncodeToString(sum[:])
+	userID, attempts, err := a.TwoFactor.GetLoginChallenge(r.Context(), tokenHash, loginChallengeTTL)
+	if err == sql.ErrNoRows {
+		clearLoginChallenge(w)
+		a.renderLogin(w, r, http.StatusUnauthorized, nil, a.T(r, "twofactor.expired"))
+		return
+	}
+	if err != nil {
+		a.logError(r, err, "get login challenge")
+		a.renderError(w, r, http.StatusInternalServerError, "auth.login_failed", nil)
+		return
+	}
+	user, err := a.Users.GetUserByID(r.Context(), userID)
+	if err != nil {
+		a.logError(r, err, "get user by id")
+		a.renderError(w, r, http.StatusInternalServerError, "auth.login_failed", nil)
+		return
+	}
+
+	if !user.TOTPEnabled {
+		a.enrolDuringLogin(w, r, user, tokenHash)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		a.render(w, r, "login_2fa.html", models.LoginTwoFactorPageData{})
+		return
+	}
+
+	if attempts >= maxLoginAttempts {
+		_ = a.TwoFactor.DeleteLoginChallenge(r.Context(), tokenHash)
+		clearLoginChallenge(w)
+		a.renderLogin(w, r, http.StatusTooManyRequests, nil, a.T(r, "twofactor.too_many_attempts"))
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		a.renderWithStatus(w, r, http.StatusBadRequest, "login_2fa.html", models.LoginTwoFactorPageData{Error: a.T(r, "error.bad_form")})
+		return
+	}
+
+	ok, err := a.checkSecondFactor(r.Context(), user.ID, r.FormValue("code"))
+	if err != nil {
+		a.logError(r, err, "check second factor")
+		a.renderError(w, r, http.StatusInternalServerError, "auth.login_failed", nil)
+		return
+	}
+	if !ok {
+		if err := a.TwoFactor.FailLoginChallenge(r.Context(), tokenHash); err != nil {
+			a.logError(r, err, "fail login challenge")
+		}
+		a.renderWithStatus(w, r, http.StatusUnauthorized, "login_2fa.html", models.LoginTwoFactorPageData{Error: a.T(r, "twofactor.wrong_code")})
+		return
+	}
+
+	a.finishLoginChallenge(w, r, user.ID, tokenHash)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (a *App) finishLoginChallenge(w http.ResponseWriter, r *http.Request, userID int, tokenHash string) bool {
+	if err := a.TwoFactor.DeleteLoginChallenge(r.Context(), tokenHash); err != nil {
+		a.logError(r, err, "delete login challenge")
+	}
+	clearLoginChallenge(w)
+	if err := a.startSession(r.Context(), w, userID); err != nil {
+		a.logError(r, err, "create session")
+		a.renderError(w, r, http.StatusInternalServerError, "auth.session_failed", nil)
+		return false
+	}
+	return true
+}
+
+// checkSecondFactor accepts either a current TOTP code or an unused
+// recovery code.
+func (a *App) checkSecondFactor(ctx context.Context, userID int, input string) (bool, error) {
+	secret, enabled, lastStep, err := a.TwoFactor.GetTOTP(ctx, userID)
+	if err != nil || !enabled {
+		return false, err
+	}
+	if step, ok := totp.Validate(secret, input, time.Now(), lastStep); ok {
+		return a.TwoFactor.UseTOTPStep(ctx, userID, step)
+	}
+	normalized := normalizeRecoveryCode(input)
+	if normalized == "" {
+		return false, nil
+	}
+	return a.TwoFactor.UseRecoveryCode(ctx, userID, hashRecoveryCode(normalized))
+}
+
+// enrolDuringLogin makes staff who must use 2FA set it up before their first
+// session is created.
+func (a *App) enrolDuringLogin(w http.ResponseWriter, r *http.Request, user *models.User, tokenHash string) {
+	data := models.TwoFactorPageData{Login: true, Required: true}
+
+	if r.Method == http.MethodPost {
+		codes, ok := a.confirmEnrolment(w, r, user, &data)
+		if !ok {
+			return
+		}
+		if !a.finishLoginChallenge(w, r, user.ID, tokenHash) {
+			return
+		}
+		data.Enabled = true
+		data.RecoveryCodes = codes
+		a.render(w, r, "twofactor.html", data)
+		return
+	}
+
+	if err := a.preparePendingSecret(r.Context(), user, &data); err != nil {
+		a.logError(r, err, "prepare totp secret")
+		a.renderError(w, r, http.StatusInternalServerError, "twofactor.setup_failed", nil)
+		return
+	}
+	a.render(w, r, "twofactor.html", data)
+}
+
+// preparePendingSecret returns the not yet confirmed secret, creating one if
+// needed, and fills in the QR code that encodes it.
+func (a *App) preparePendingSecret(ctx context.Context, user *models.User, data *models.TwoFactorPageData) error {
+	secret, _, _, err := a.TwoFactor.GetTOTP(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	if secret == "" {
+		secret, err = totp.GenerateSecret()
+		if err != nil {
+			return err
+		}
+		if err := a.TwoFactor.SetPendingTOTPSecret(ctx, user.ID, secret); err != nil {
+			return err
+		}
+	}
+
+	code, err := qr.Encode(totp.URI(totpIssuer, user.Username, secret), qr.M)
+	if err != nil {
+		return err
+	}
+	code.Scale = 5
+	data.QRCode = template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(code.PNG()))
+	data.Secret = secret
+	return nil
+}
+
+// confirmEnrolment checks the first code from the authenticator app and, if
+// it matches, enables 2FA and returns fresh recovery codes. On failure it
+// renders the enrolment page again and reports false.
+func (a *App) confirmEnrolment(w http.ResponseWriter, r *http.Request, user *models.User, data *models.TwoFactorPageData) ([]string, bool) {
+	fail := func(status int, msg string) ([]string, bool) {
+		data.Error = msg
+		if err := a.preparePendingSecret(r.Context(), user, data); err != nil {
+			a.logError(r, err, "prepare totp secret")
+		}
+		a.renderWithStatus(w, r, status, "twofactor.html", *data)
+		return nil, false
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return fail(http.StatusBadRequest, a.T(r, "error.bad_form"))
+	}
+	secret, enabled, _, err := a.TwoFactor.GetTOTP(r.Context(), user.ID)
+	if err != nil {
+		a.logError(r, err, "get totp")
+		return fail(http.StatusInternalServerError, a.T(r, "twofactor.setup_failed"))
+	}
+	if enabled {
+		return fail(http.StatusConflict, a.T(r, "twofactor.already_enabled"))
+	}
+	step, ok := totp.Validate(secret, r.FormValue("code"), time.Now(), 0)
+	if secret == "" || !ok {
+		return fail(http.StatusBadRequest, a.T(r, "twofactor.wrong_setup_code"))
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		a.logError(r, err, "generate recovery codes")
+		return fail(http.StatusInternalServerError, a.T(r, "twofactor.setup_failed"))
+	}
+	if err := a.TwoFactor.EnableTOTP(r.Context(), user.ID, step, hashes); err != nil {
+		a.logError(r, err, "enable totp")
+		return fail(http.StatusInternalServerError, a.T(r, "twofactor.setup_failed"))
+	}
+	return codes, true
+}
+
+func (a *App) TwoFactorSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := middleware.CurrentUser(r)
+	if err != nil {
+		a.renderError(w, r, http.StatusUnauthorized, "error.unauthorized", nil)
+		return
+	}
+	data := models.TwoFactorPageData{
+		CurrentUser: user,
+		Enabled:     user.TOTPEnabled,
+		Required:    a.twoFactorRequired(r, user),
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if err := r.ParseForm(); err != nil {
+			data.Error = a.T(r, "error.bad_form")
+			break
+		}
+		switch r.FormValue("action") {
+		case "enable":
+			codes, ok := a.confirmEnrolment(w, r, user, &data)
+			if !ok {
+				return
+			}
+			data.Enabled = true
+			data.RecoveryCodes = codes
+			data.Remaining = len(codes)
+			a.render(w, r, "twofactor.html", data)
+			return
+		case "recovery":
+			a.regenerateRecoveryCodes(w, r, user, data)
+			return
+		case "disable":
+			a.disableTwoFactor(w, r, user, data)
+			return
+		default:
+			data.Error = a.T(r, "error.unknown_action")
+		}
+	default:
+		a.renderError(w, r, http.StatusMethodNotAllowed, "error.method_not_allowed", user)
+		return
+	}
+
+	a.renderTwoFactor(w, r, http.StatusOK, user, data)
+}
+
+func (a *App) renderTwoFactor(w http.ResponseWriter, r *http.Request, status int, user *models.User, data models.TwoFactorPageData) {
+	if data.Enabled {
+		remaining, err := a.TwoFactor.CountRecoveryCodes(r.Context(), user.ID)
+		if err != nil {
+			a.logError(r, err, "count recovery codes")
+		}
+		data.Remaining = remaining
+	} else if err := a.preparePendingSecret(r.Context(), user, &data); err != nil {
+		a.logError(r, err, "prepare totp secret")
+		a.renderError(w, r, http.StatusInternalServerError, "twofactor.setup_failed", user)
+		return
+	}
+	if data.Error != "" && status == http.StatusOK {
+		status = http.StatusBadRequest
+	}
+	a.renderWithStatus(w, r, status, "twofactor.html", data)
+}
+
+func (a *App) regenerateRecoveryCodes(w http.ResponseWriter, r *http.Request, user *models.User, data models.TwoFactorPageData) {
+	ok, err := a.checkSecondFactor(r.Context(), user.ID, r.FormValue("code"))
+	if err != nil {
+		a.logError(r, err, "check second factor")
+	}
+	if !ok {
+		data.Error = a.T(r, "twofactor.wrong_code")
+		a.renderTwoFactor(w, r, http.StatusUnauthorized, user, data)
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err == nil {
+		err = a.TwoFactor.ReplaceRecoveryCodes(r.Context(), user.ID, hashes)
+	}
+	if err != nil {
+		a.logError(r, err, "replace recovery codes")
+		data.Error = a.T(r, "error.save_failed")
+		a.renderTwoFactor(w, r, http.StatusInternalServerError, user, data)
+		return
+	}
+	data.RecoveryCodes = codes
+	a.renderTwoFactor(w, r, http.StatusOK, user, data)
+}
+
+func (a *App) disableTwoFactor(w http.ResponseWriter, r *http.Request, user *models.User, data models.TwoFactorPageData) {
+	if data.Required {
+		data.Error = a.T(r, "twofactor.required_for_role")
+		a.renderTwoFactor(w, r, http.StatusForbidden, user, data)
+		return
+	}
+	if !confirmedOwner(user, r.FormValue("password")) {
+		data.Error = a.T(r, notOwnerKey(user, "auth.wrong_password"))
+		a.renderTwoFactor(w, r, http.StatusUnauthorized, user, data)
+		return
+	}
+	ok, err := a.checkSecondFactor(r.Context(), user.ID, r.FormValue("code"))
+	if err != nil {
+		a.logError(r, err, "check second factor")
+	}
+	if !ok {
+		data.Error = a.T(r, "twofactor.wrong_code")
+		a.renderTwoFactor(w, r, http.StatusUnauthorized, user, data)
+		return
+	}
+
+	if err := a.TwoFactor.DisableTOTP(r.Context(), user.ID); err != nil {
+		a.logError(r, err, "disable totp")
+		data.Error = a.T(r, "error.save_failed")
+		a.renderTwoFactor(w, r, http.StatusInternalServerError, user, data)
+		return
+	}
+	http.Redirect(w, r, "/settings/2fa", http.StatusSeeOther)
+}
+
+// AdminSecurityHandler lets admins require 2FA for staff and reset the 2FA of
+// users who lost both their device and their recovery codes.
+func (a *App) AdminSecurityHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := middleware.CurrentUser(r)
+	if err != nil {
+		a.renderError(w, r, http.StatusUnauthorized, "error.unauthorized", nil)
+		return
+	}
+	if user.Role != models.RoleAdmin {
+		a.renderError(w, r, http.StatusForbidden, "admin.only", user)
+		return
+	}
+
+	data := models.AdminSecurityPageData{CurrentUser: user}
+	status := http.StatusOK
+
+	if r.Method == http.MethodPost {
+		if err := r.ParseForm(); err != nil {
+			data.Error = a.T(r, "error.bad_form")
+			status = http.StatusBadRequest
+		} else {
+			status = a.applyAdminSecurity(r, &data)
+		}
+	} else if r.Method != http.MethodGet {
+		a.renderError(w, r, http.StatusMethodNotAllowed, "error.method_not_allowed", user)
+		return
+	}
+
+	value, err := a.Site.GetSiteSetting(r.Context(), repo.SettingRequireStaff2FA)
+	if err != nil {
+		a.logError(r, err, "get site setting")
+	}
+	data.RequireStaff = value == "1"
+	a.renderWithStatus(w, r, status, "admin_security.html", data)
+}
+
+func (a *App) applyAdminSecurity(r *http.Request, data *models.AdminSecurityPageData) int {
+	switch r.FormValue("action") {
+	case "require":
+		value := "0"
+		if r.FormValue("require_staff") == "1" {
+			value = "1"
+		}
+		if err := a.Site.SetSiteSetting(r.Context(), repo.SettingRequireStaff2FA, value); err != nil {
+			a.logError(r, err, "set site setting")
+			data.Error = a.T(r, "error.save_failed")
+			return http.StatusInternalServerError
+		}
+		data.Success = a.T(r, "admin.saved")
+	case "reset":
+		username := strings.TrimSpace(r.FormValue("username"))
+		target, err := a.Users.GetUserByUsername(r.Context(), username)
+		if err == sql.ErrNoRows {
+			data.Error = a.T(r, "user.not_found")
+			return http.StatusNotFound
+		}
+		if err == nil {
+			err = a.TwoFactor.DisableTOTP(r.Context(), target.ID)
+		}
+		if err != nil {
+			a.logError(r, err, "reset totp")
+			data.Error = a.T(r, "admin.reset_failed")
+			return http.StatusInternalServerError
+		}
+		data.Success = a.T(r, "admin.reset_done", target.Username)
+	default:
+		data.Error = a.T(r, "error.unknown_action")
+		return http.StatusBadRequest
+	}
+	return http.StatusOK
+}
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newRecoveryCodes returns codes to show once and the hashes to store.
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		buf := make([]byte, 7)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, err
+		}
+		raw := strings.ToLower(recoveryEncoding.EncodeToString(buf))[:2*recoveryCodeHalfLength]
+		codes = append(codes, raw[:recoveryCodeHalfLength]+"-"+raw[recoveryCodeHalfLength:])
+		hashes = append(hashes, hashRecoveryCode(raw))
+	}
+	return codes, hashes, nil
+}
+
+func normalizeRecoveryCode(input string) string {
+	input = strings.ToLower(strings.TrimSpace(input))
+	input = strings.NewReplacer("-", "", " ", "").Replace(input)
+	if len(input) != 2*recoveryCodeHalfLength {
+		return ""
+	}
+	return input
+}
+
+func hashRecoveryCode(normalized string) string {
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
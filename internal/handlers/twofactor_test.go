@@ -0,0 +1,306 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"forum/internal/middleware"
+	"forum/internal/models"
+	"forum/internal/repo"
+	"forum/internal/totp"
+)
+
+var recoveryCodePattern = regexp.MustCompile(`\b[a-z2-7]{5}-[a-z2-7]{5}\b`)
+
+// form builds a POST of values to target.
+func form(target string, values url.Values) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(values.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
+
+// cookie returns the value w sets for the named cookie, or "".
+func cookie(w *httptest.ResponseRecorder, name string) string {
+	for _, c := range w.Result().Cookies() {
+		if c.Name == name && c.MaxAge >= 0 {
+			return c.Value
+		}
+	}
+	return ""
+}
+
+// enrol turns on 2FA for userID and returns the secret and the recovery
+// codes.
+func (f *memoryForum) enrol(t *testing.T, userID int) (string, []string) {
+	t.Helper()
+	ctx := context.Background()
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.twoFactor.SetPendingTOTPSecret(ctx, userID, secret); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.twoFactor.EnableTOTP(ctx, userID, 0, hashes); err != nil {
+		t.Fatal(err)
+	}
+	return secret, codes
+}
+
+// signIn posts the password form for name and returns the response.
+func (f *memoryForum) signIn(name string) *httptest.ResponseRecorder {
+	return f.serve(form("/login", url.Values{"email": {name + "@example.com"}, "password": {"pw"}}), "")
+}
+
+// secondFactor posts code to the second login step under challenge.
+func (f *memoryForum) secondFactor(challenge string, code string) *httptest.ResponseRecorder {
+	r := form("/login/2fa", url.Values{"code": {code}})
+	r.AddCookie(&http.Cookie{Name: loginChallengeCookie, Value: challenge})
+	return f.serve(r, "")
+}
+
+// nextCode is the authenticator code for the step after the current one,
+// which is not yet used however recently the secret was checked.
+func nextCode(t *testing.T, secret string) string {
+	t.Helper()
+	code, err := totp.Code(secret, time.Now().Add(totp.Period*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func TestTwoFactorEnrolment(t *testing.T) {
+	f := newMemoryForum(t)
+	ctx := context.Background()
+
+	if w := f.serve(httptest.NewRequest(http.MethodGet, "/settings/2fa", nil), f.annSession); w.Code != http.StatusOK {
+		t.Fatalf("settings: status %d", w.Code)
+	}
+	secret, enabled, _, err := f.twoFactor.GetTOTP(ctx, f.ann)
+	if err != nil || secret == "" || enabled {
+		t.Fatalf("after opening the page: secret %q, enabled %v, %v; want a pending secret", secret, enabled, err)
+	}
+
+	enable := func(code string) *httptest.ResponseRecorder {
+		return f.serve(form("/settings/2fa", url.Values{"action": {"enable"}, "code": {code}}), f.annSession)
+	}
+	if w := enable("000000"); w.Code != http.StatusBadRequest {
+		t.Errorf("wrong code: status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	code, err := totp.Code(secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := enable(code)
+	if w.Code != http.StatusOK {
+		t.Fatalf("enable: status %d\n%s", w.Code, w.Body)
+	}
+	if codes := recoveryCodePattern.FindAllString(w.Body.String(), -1); len(codes) != recoveryCodeCount {
+		t.Errorf("enable showed %d recovery codes, want %d", len(codes), recoveryCodeCount)
+	}
+	if n, _ := f.twoFactor.CountRecoveryCodes(ctx, f.ann); n != recoveryCodeCount {
+		t.Errorf("%d recovery codes stored, want %d", n, recoveryCodeCount)
+	}
+	if w := enable(nextCode(t, secret)); w.Code != http.StatusConflict {
+		t.Errorf("enabling twice: status %d, want %d", w.Code, http.StatusConflict)
+	}
+
+	// New codes take a second factor and replace the old ones.
+	if w := f.serve(form("/settings/2fa", url.Values{"action": {"recovery"}, "code": {"000000"}}), f.annSession); w.Code != http.StatusUnauthorized {
+		t.Errorf("new codes with a wrong code: status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	w = f.serve(form("/settings/2fa", url.Values{"action": {"recovery"}, "code": {nextCode(t, secret)}}), f.annSession)
+	if w.Code != http.StatusOK {
+		t.Fatalf("new codes: status %d\n%s", w.Code, w.Body)
+	}
+	if codes := recoveryCodePattern.FindAllString(w.Body.String(), -1); len(codes) != recoveryCodeCount {
+		t.Errorf("new codes showed %d recovery codes, want %d", len(codes), recoveryCodeCount)
+	}
+}
+
+func TestTwoFactorSignIn(t *testing.T) {
+	f := newMemoryForum(t)
+	secret, codes := f.enrol(t, f.ann)
+
+	// Without 2FA the password is enough.
+	if w := f.signIn("bob"); w.Code != http.StatusSeeOther || cookie(w, middleware.SessionCookie) == "" {
+		t.Fatalf("bob: status %d, session %q; want a session", w.Code, cookie(w, middleware.SessionCookie))
+	}
+
+	w := f.signIn("ann")
+	challenge := cookie(w, loginChallengeCookie)
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/login/2fa" || challenge == "" {
+		t.Fatalf("ann: status %d to %q, challenge %q; want the second step", w.Code, w.Header().Get("Location"), challenge)
+	}
+	if cookie(w, middleware.SessionCookie) != "" {
+		t.Fatal("a session was created before the second factor")
+	}
+
+	if w := f.secondFactor(challenge, "000000"); w.Code != http.StatusUnauthorized {
+		t.Errorf("wrong code: status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	code := nextCode(t, secret)
+	w = f.secondFactor(challenge, code)
+	if w.Code != http.StatusSeeOther || cookie(w, middleware.SessionCookie) == "" {
+		t.Fatalf("right code: status %d, session %q; want a session", w.Code, cookie(w, middleware.SessionCookie))
+	}
+	if w := f.secondFactor(challenge, code); w.Code != http.StatusUnauthorized {
+		t.Errorf("finished challenge reused: status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	// A code is good for one sign-in, and so is a recovery code.
+	tests := []struct {
+		name   string
+		code   string
+		status int
+	}{
+		{"same app code", code, http.StatusUnauthorized},
+		{"recovery code", strings.ToUpper(codes[0]), http.StatusSeeOther},
+		{"same recovery code", codes[0], http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		challenge := cookie(f.signIn("ann"), loginChallengeCookie)
+		if w := f.secondFactor(challenge, tt.code); w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.status)
+		}
+	}
+}
+
+func TestTwoFactorSignInLimitsAttempts(t *testing.T) {
+	f := newMemoryForum(t)
+	secret, _ := f.enrol(t, f.ann)
+
+	challenge := cookie(f.signIn("ann"), loginChallengeCookie)
+	for i := 0; i < maxLoginAttempts; i++ {
+		if w := f.secondFactor(challenge, "000000"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: status %d, want %d", i+1, w.Code, http.StatusUnauthorized)
+		}
+	}
+	if w := f.secondFactor(challenge, nextCode(t, secret)); w.Code != http.StatusTooManyRequests {
+		t.Errorf("right code after too many attempts: status %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	if w := f.secondFactor(challenge, nextCode(t, secret)); w.Code != http.StatusUnauthorized {
+		t.Errorf("challenge after it was dropped: status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestStaffEnrolDuringSignIn(t *testing.T) {
+	f := newMemoryForum(t)
+	ctx := context.Background()
+	f.setRole(t, f.bob, models.RoleModerator)
+	if err := f.site.SetSiteSetting(ctx, repo.SettingRequireStaff2FA, "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// ann is not staff and signs in as before.
+	if w := f.signIn("ann"); cookie(w, middleware.SessionCookie) == "" {
+		t.Fatalf("ann: status %d, want a session", w.Code)
+	}
+
+	challenge := cookie(f.signIn("bob"), loginChallengeCookie)
+	if challenge == "" {
+		t.Fatal("bob got no login challenge")
+	}
+	r := httptest.NewRequest(http.MethodGet, "/login/2fa", nil)
+	r.AddCookie(&http.Cookie{Name: loginChallengeCookie, Value: challenge})
+	if w := f.serve(r, ""); w.Code != http.StatusOK {
+		t.Fatalf("enrolment page: status %d", w.Code)
+	}
+	secret, _, _, err := f.twoFactor.GetTOTP(ctx, f.bob)
+	if err != nil || secret == "" {
+		t.Fatalf("no pending secret for bob: %v", err)
+	}
+
+	code, err := totp.Code(secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := f.secondFactor(challenge, code)
+	if w.Code != http.StatusOK || cookie(w, middleware.SessionCookie) == "" {
+		t.Fatalf("enrol: status %d, session %q; want a session", w.Code, cookie(w, middleware.SessionCookie))
+	}
+	if _, enabled, _, _ := f.twoFactor.GetTOTP(ctx, f.bob); !enabled {
+		t.Error("2FA is not on after enrolling")
+	}
+
+	// Staff cannot turn it off while it is required.
+	w = f.serve(form("/settings/2fa", url.Values{"action": {"disable"}, "password": {"pw"}, "code": {nextCode(t, secret)}}), cookie(w, middleware.SessionCookie))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("disable while required: status %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
+
+func TestDisableTwoFactor(t *testing.T) {
+	f := newMemoryForum(t)
+	secret, codes := f.enrol(t, f.ann)
+
+	tests := []struct {
+		name     string
+		password string
+		code     string
+		status   int
+	}{
+		{"wrong password", "nope", nextCode(t, secret), http.StatusUnauthorized},
+		{"wrong code", "pw", "000000", http.StatusUnauthorized},
+		{"recovery code", "pw", codes[1], http.StatusSeeOther},
+	}
+	for _, tt := range tests {
+		w := f.serve(form("/settings/2fa", url.Values{"action": {"disable"}, "password": {tt.password}, "code": {tt.code}}), f.annSession)
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.status)
+		}
+	}
+	if _, enabled, _, _ := f.twoFactor.GetTOTP(context.Background(), f.ann); enabled {
+		t.Error("2FA is still on")
+	}
+	if w := f.signIn("ann"); cookie(w, middleware.SessionCookie) == "" {
+		t.Errorf("sign-in after turning 2FA off: status %d, want a session", w.Code)
+	}
+}
+
+func TestAdminSecurity(t *testing.T) {
+	f := newMemoryForum(t)
+	ctx := context.Background()
+	f.setRole(t, f.ann, models.RoleAdmin)
+	f.enrol(t, f.bob)
+
+	if w := f.serve(httptest.NewRequest(http.MethodGet, "/admin/security", nil), f.bobSession); w.Code != http.StatusForbidden {
+		t.Errorf("bob: status %d, want %d", w.Code, http.StatusForbidden)
+	}
+
+	tests := []struct {
+		name   string
+		values url.Values
+		status int
+	}{
+		{"require", url.Values{"action": {"require"}, "require_staff": {"1"}}, http.StatusOK},
+		{"reset unknown user", url.Values{"action": {"reset"}, "username": {"carol"}}, http.StatusNotFound},
+		{"reset", url.Values{"action": {"reset"}, "username": {"Bob"}}, http.StatusOK},
+		{"unknown action", url.Values{"action": {"promote"}}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if w := f.serve(form("/admin/security", tt.values), f.annSession); w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d\n%s", tt.name, w.Code, tt.status, w.Body)
+		}
+	}
+	if v, _ := f.site.GetSiteSetting(ctx, repo.SettingRequireStaff2FA); v != "1" {
+		t.Errorf("require_staff_2fa = %q, want 1", v)
+	}
+	if _, enabled, _, _ := f.twoFactor.GetTOTP(ctx, f.bob); enabled {
+		t.Error("bob's 2FA survived the reset")
+	}
+	if w := f.signIn("bob"); cookie(w, middleware.SessionCookie) == "" {
+		t.Errorf("bob after the reset: status %d, want a session", w.Code)
+	}
+}
@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+)
+
+func (a *App) UsernameAutocompleteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.writeJSON(w, r, http.StatusMethodNotAllowed, map[string]string{"error": a.T(r, "error.method_not_allowed")})
+		return
+	}
+
+	prefix := strings.TrimPrefix(strings.TrimSpace(r.URL.Query().Get("q")), "@")
+	if prefix == "" {
+		a.writeJSON(w, r, http.StatusOK, []string{})
+		return
+	}
+
+	usernames, err := a.Users.SearchUsernames(r.Context(), prefix, 10)
+	if err != nil {
+		a.logError(r, err, "search usernames")
+		a.writeJSON(w, r, http.StatusInternalServerError, map[string]string{"error": a.T(r, "error.search_failed")})
+		return
+	}
+	a.writeJSON(w, r, http.StatusOK, usernames)
+}
@@ -0,0 +1,258 @@
+// Package i18n holds the UI message catalogs and picks one per request.
+//
+// A catalog maps message keys to text. Messages that depend on a number map
+// to an object with one entry per plural form of the language, for example
+// {"one": "%d пост", "few": "%d поста", "many": "%d постов"}.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Default is the language used when nothing better is known, and the one
+// other catalogs fall back to.
+const Default = "ru"
+
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+// pluralRules returns the form a number takes in a language.
+var pluralRules = map[string]func(n int) string{
+	"ru": func(n int) string {
+		if n < 0 {
+			n = -n
+		}
+		switch {
+		case n%10 == 1 && n%100 != 11:
+			return "one"
+		case n%10 >= 2 && n%10 <= 4 && (n%100 < 12 || n%100 > 14):
+			return "few"
+		default:
+			return "many"
+		}
+	},
+	"en": func(n int) string {
+		if n == 1 {
+			return "one"
+		}
+		return "other"
+	},
+}
+
+// pluralForms lists the forms each language's catalog must provide.
+var pluralForms = map[string][]string{
+	"ru": {"one", "few", "many"},
+	"en": {"one", "other"},
+}
+
+type message struct {
+	text   string
+	plural map[string]string
+}
+
+type catalog struct {
+	lang     string
+	messages map[string]message
+}
+
+// Bundle is the set of loaded catalogs.
+type Bundle struct {
+	catalogs map[string]*catalog
+	langs    []string
+}
+
+// Load reads the catalogs built into the binary.
+func Load() (*Bundle, error) {
+	sub, err := fs.Sub(localeFiles, "locales")
+	if err != nil {
+		return nil, err
+	}
+	return LoadFS(sub)
+}
+
+// LoadFS reads one <lang>.json catalog per file in fsys.
+func LoadFS(fsys fs.FS) (*Bundle, error) {
+	files, err := fs.Glob(fsys, "*.json")
+	if err != nil {
+		return nil, err
+	}
+	b := &Bundle{catalogs: map[string]*catalog{}}
+	for _, file := range files {
+		lang := strings.TrimSuffix(path.Base(file), ".json")
+		if pluralRules[lang] == nil {
+			return nil, fmt.Errorf("i18n: no plural rule for %s", lang)
+		}
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+		c, err := parseCatalog(lang, data)
+		if err != nil {
+			return nil, fmt.Errorf("i18n: %s: %w", file, err)
+		}
+		b.catalogs[lang] = c
+		b.langs = append(b.langs, lang)
+	}
+	if b.catalogs[Default] == nil {
+		return nil, fmt.Errorf("i18n: missing %s catalog", Default)
+	}
+	sort.Slice(b.langs, func(i, j int) bool {
+		if (b.langs[i] == Default) != (b.langs[j] == Default) {
+			return b.langs[i] == Default
+		}
+		return b.langs[i] < b.langs[j]
+	})
+	return b, nil
+}
+
+func parseCatalog(lang string, data []byte) (*catalog, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	c := &catalog{lang: lang, messages: make(map[string]message, len(raw))}
+	for key, value := range raw {
+		var text string
+		if err := json.Unmarshal(value, &text); err == nil {
+			c.messages[key] = message{text: text}
+			continue
+		}
+		var forms map[string]string
+		if err := json.Unmarshal(value, &forms); err != nil {
+			return nil, fmt.Errorf("%s: want a string or an object of plural forms", key)
+		}
+		c.messages[key] = message{plural: forms}
+	}
+	return c, nil
+}
+
+// Check reports every key that is missing from a catalog, or that lacks a
+// plural form its language needs. Run it at startup so a forgotten
+// translation fails loudly instead of showing a raw key.
+func (b *Bundle) Check() error {
+	keys := map[string]bool{}
+	for _, c := range b.catalogs {
+		for key := range c.messages {
+			keys[key] = true
+		}
+	}
+
+	var problems []string
+	for _, lang := range b.langs {
+		c := b.catalogs[lang]
+		for key := range keys {
+			m, ok := c.messages[key]
+			if !ok {
+				problems = append(problems, lang+": missing "+key)
+				continue
+			}
+			if m.plural == nil {
+				continue
+			}
+			for _, form := range pluralForms[lang] {
+				if _, ok := m.plural[form]; !ok {
+					problems = append(problems, lang+": "+key+" lacks plural form "+form)
+				}
+			}
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("i18n: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Languages returns the codes of the loaded catalogs, Default first.
+func (b *Bundle) Languages() []string {
+	return b.langs
+}
+
+func (b *Bundle) Has(lang string) bool {
+	return b.catalogs[lang] != nil
+}
+
+// Match picks the best loaded language for an Accept-Language header.
+func (b *Bundle) Match(acceptLanguage string) string {
+	best, bestQ := Default, 0.0
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		base, _, _ := strings.Cut(strings.ToLower(tag), "-")
+		if b.Has(base) && q > bestQ {
+			best, bestQ = base, q
+		}
+	}
+	return best
+}
+
+// Translator returns the translator for lang, falling back to Default for
+// unknown languages.
+func (b *Bundle) Translator(lang string) *Translator {
+	c := b.catalogs[lang]
+	if c == nil {
+		c = b.catalogs[Default]
+	}
+	return &Translator{catalog: c, fallback: b.catalogs[Default]}
+}
+
+type Translator struct {
+	catalog  *catalog
+	fallback *catalog
+}
+
+func (t *Translator) Lang() string {
+	return t.catalog.lang
+}
+
+func (t *Translator) lookup(key string) (message, string, bool) {
+	if m, ok := t.catalog.messages[key]; ok {
+		return m, t.catalog.lang, true
+	}
+	m, ok := t.fallback.messages[key]
+	return m, t.fallback.lang, ok
+}
+
+// T translates key. With args the message is a fmt format.
+func (t *Translator) T(key string, args ...any) string {
+	m, _, ok := t.lookup(key)
+	if !ok {
+		return key
+	}
+	text := m.text
+	if m.plural != nil {
+		text = m.plural["other"]
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(text, args...)
+	}
+	return text
+}
+
+// N translates key in the plural form for n. The message is a fmt format
+// whose first verb receives n, followed by args.
+func (t *Translator) N(key string, n int, args ...any) string {
+	m, lang, ok := t.lookup(key)
+	if !ok {
+		return key
+	}
+	text := m.text
+	if m.plural != nil {
+		text = m.plural[pluralRules[lang](n)]
+	}
+	return fmt.Sprintf(text, append([]any{n}, args...)...)
+}
@@ -0,0 +1,114 @@
+package i18n
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestCatalogsHaveTheSameKeys(t *testing.T) {
+	b, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Check(); err != nil {
+		t.Fatal(err)
+	}
+
+	def := b.catalogs[Default]
+	for _, lang := range b.Languages() {
+		c := b.catalogs[lang]
+		for key := range def.messages {
+			if _, ok := c.messages[key]; !ok {
+				t.Errorf("%s: missing %s", lang, key)
+			}
+		}
+		for key := range c.messages {
+			if _, ok := def.messages[key]; !ok {
+				t.Errorf("%s: %s is not in the %s catalog", lang, key, Default)
+			}
+		}
+	}
+}
+
+func TestCheckReportsMissingKeys(t *testing.T) {
+	b, err := LoadFS(fstest.MapFS{
+		"ru.json": {Data: []byte(`{"a": "а", "n": {"one": "%d", "few": "%d"}}`)},
+		"en.json": {Data: []byte(`{"n": {"one": "%d", "other": "%d"}, "b": "b"}`)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.Check()
+	if err == nil {
+		t.Fatal("Check passed catalogs with missing keys")
+	}
+	for _, want := range []string{"en: missing a", "ru: missing b", "ru: n lacks plural form many"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Check() = %q, want it to mention %q", err, want)
+		}
+	}
+}
+
+var (
+	// templateKey matches {{t "key"}}, {{tn "key" n}} and the same calls
+	// in parentheses.
+	templateKey = regexp.MustCompile(`(?:\{\{-?|\()\s*tn? "([^"]+)"`)
+	// goKey matches the handler helpers that take a message key as a
+	// string literal.
+	goKey = regexp.MustCompile(`\b(?:T|renderError|respondError)\((?:r|w, r, [^,"]+), "([^"]+)"`)
+)
+
+// TestUsedKeysExist scans the templates and handlers for literal message
+// keys, so a key that is used but never translated fails here rather than
+// showing up raw on a page.
+func TestUsedKeysExist(t *testing.T) {
+	b, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	def := b.catalogs[Default]
+
+	used := map[string][]string{}
+	scan := func(pattern string, re *regexp.Regexp) {
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) == 0 {
+			t.Fatalf("no files match %s", pattern)
+		}
+		for _, file := range files {
+			if strings.HasSuffix(file, "_test.go") {
+				continue
+			}
+			data, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, m := range re.FindAllStringSubmatch(string(data), -1) {
+				used[m[1]] = append(used[m[1]], filepath.Base(file))
+			}
+		}
+	}
+	scan("../../templates/*.html", templateKey)
+	scan("../handlers/*.go", goKey)
+
+	if len(used) < 100 {
+		t.Fatalf("found only %d keys; did the patterns stop matching?", len(used))
+	}
+	var missing []string
+	for key, files := range used {
+		if _, ok := def.messages[key]; !ok {
+			missing = append(missing, key+" (used in "+strings.Join(files, ", ")+")")
+		}
+	}
+	sort.Strings(missing)
+	for _, m := range missing {
+		t.Errorf("missing key %s", m)
+	}
+}
@@ -0,0 +1,31 @@
+package i18n
+
+import "time"
+
+// Date formats ts as a calendar date in loc, laid out the way the language
+// writes dates.
+func (t *Translator) Date(ts time.Time, loc *time.Location) string {
+	return ts.In(loc).Format(t.T("time.date_layout"))
+}
+
+// DateTime formats ts as a date and time of day in loc.
+func (t *Translator) DateTime(ts time.Time, loc *time.Location) string {
+	return ts.In(loc).Format(t.T("time.datetime_layout"))
+}
+
+// Ago describes how long before now ts happened: "just now", "5 minutes
+// ago" and so on for up to a week, after which the date in loc is clearer.
+func (t *Translator) Ago(ts time.Time, now time.Time, loc *time.Location) string {
+	d := now.Sub(ts)
+	switch {
+	case d < time.Minute:
+		return t.T("time.just_now")
+	case d < time.Hour:
+		return t.N("time.minutes_ago", int(d/time.Minute))
+	case d < 24*time.Hour:
+		return t.N("time.hours_ago", int(d/time.Hour))
+	case d < 7*24*time.Hour:
+		return t.N("time.days_ago", int(d/(24*time.Hour)))
+	}
+	return t.Date(ts, loc)
+}
@@ -0,0 +1,217 @@
+// Package identity signs users in through external OAuth 2.0 and OpenID
+// Connect providers using the authorization code flow with PKCE.
+package identity
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNonceMismatch = errors.New("identity: nonce mismatch")
+	ErrNoEmail       = errors.New("identity: provider returned no email")
+)
+
+// Identity is the account a provider vouched for.
+type Identity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Username      string
+	Name          string
+}
+
+// AuthRequest carries the per-login secrets that the callback must match.
+type AuthRequest struct {
+	State       string
+	Nonce       string
+	Verifier    string
+	RedirectURI string
+}
+
+type Provider interface {
+	Name() string
+	Label() string
+	AuthCodeURL(ctx context.Context, req AuthRequest) (string, error)
+	Exchange(ctx context.Context, code string, req AuthRequest) (*Identity, error)
+}
+
+// Config describes one provider in the JSON file named by
+// FORUM_IDENTITY_CONFIG. Values may reference environment variables as
+// $NAME or ${NAME}, which keeps client secrets out of the file.
+type Config struct {
+	Type         string   `json:"type"`
+	Name         string   `json:"name"`
+	Label        string   `json:"label"`
+	Issuer       string   `json:"issuer"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret"`
+	Scopes       []string `json:"scopes"`
+}
+
+// LoadConfig reads provider definitions from path. A missing path means no
+// providers are configured.
+func LoadConfig(path string) ([]Provider, error) {
+	if path == "" {
+		return nil, nil
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var configs []Config
+	if err := json.Unmarshal([]byte(os.ExpandEnv(string(raw))), &configs); err != nil {
+		return nil, fmt.Errorf("identity: parse %s: %w", path, err)
+	}
+
+	providers := make([]Provider, 0, len(configs))
+	seen := make(map[string]bool)
+	for _, c := range configs {
+		p, err := New(c)
+		if err != nil {
+			return nil, err
+		}
+		if seen[p.Name()] {
+			return nil, fmt.Errorf("identity: duplicate provider %q", p.Name())
+		}
+		seen[p.Name()] = true
+		providers = append(providers, p)
+	}
+	return providers, nil
+}
+
+func New(c Config) (Provider, error) {
+	if c.ClientID == "" {
+		return nil, fmt.Errorf("identity: provider %q has no client_id", c.Name)
+	}
+	switch c.Type {
+	case "github":
+		return newGitHub(c), nil
+	case "google":
+		if c.Issuer == "" {
+			c.Issuer = "https://accounts.google.com"
+		}
+		if c.Name == "" {
+			c.Name = "google"
+		}
+		if c.Label == "" {
+			c.Label = "Google"
+		}
+		return newOIDC(c)
+	case "oidc":
+		return newOIDC(c)
+	default:
+		return nil, fmt.Errorf("identity: unknown provider type %q", c.Type)
+	}
+}
+
+// NewAuthRequest generates fresh state, nonce and PKCE verifier values.
+func NewAuthRequest(redirectURI string) (AuthRequest, error) {
+	var req AuthRequest
+	for _, v := range []*string{&req.State, &req.Nonce, &req.Verifier} {
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			return AuthRequest{}, err
+		}
+		*v = base64.RawURLEncoding.EncodeToString(buf)
+	}
+	req.RedirectURI = redirectURI
+	return req, nil
+}
+
+// Challenge returns the S256 PKCE challenge for the verifier.
+func (r AuthRequest) Challenge() string {
+	sum := sha256.Sum256([]byte(r.Verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	IDToken     string `json:"id_token"`
+	Error       string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+// exchangeCode redeems an authorization code at a token endpoint.
+func exchangeCode(ctx context.Context, endpoint string, clientID string, clientSecret string, code string, req AuthRequest) (*tokenResponse, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {req.RedirectURI},
+		"client_id":     {clientID},
+		"code_verifier": {req.Verifier},
+	}
+	if clientSecret != "" {
+		form.Set("client_secret", clientSecret)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	httpReq.Header.Set("Accept", "application/json")
+
+	var tok tokenResponse
+	status, err := doJSON(httpReq, &tok)
+	if err != nil {
+		return nil, err
+	}
+	if tok.Error != "" {
+		return nil, fmt.Errorf("identity: token endpoint: %s %s", tok.Error, tok.Description)
+	}
+	if status != http.StatusOK || tok.AccessToken == "" {
+		return nil, fmt.Errorf("identity: token endpoint returned status %d", status)
+	}
+	return &tok, nil
+}
+
+func getJSON(ctx context.Context, endpoint string, accessToken string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	status, err := doJSON(req, v)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("identity: GET %s returned status %d", endpoint, status)
+	}
+	return nil
+}
+
+func doJSON(req *http.Request, v any) (int, error) {
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if err := json.Unmarshal(body, v); err != nil && resp.StatusCode == http.StatusOK {
+		return resp.StatusCode, fmt.Errorf("identity: decode %s: %w", req.URL, err)
+	}
+	return resp.StatusCode, nil
+}
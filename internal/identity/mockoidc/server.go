@@ -0,0 +1,254 @@
+// Package mockoidc is a minimal OpenID Connect provider for local
+// development. It signs in whoever fills in its form, so it must never be
+// exposed publicly.
+package mockoidc
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"html/template"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Server struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+
+	key *rsa.PrivateKey
+	kid string
+
+	mu     sync.Mutex
+	codes  map[string]grant
+	tokens map[string]claims
+}
+
+type grant struct {
+	claims      claims
+	clientID    string
+	redirectURI string
+	challenge   string
+	expires     time.Time
+}
+
+type claims struct {
+	Issuer        string `json:"iss"`
+	Subject       string `json:"sub"`
+	Audience      string `json:"aud"`
+	Expiry        int64  `json:"exp"`
+	IssuedAt      int64  `json:"iat"`
+	Nonce         string `json:"nonce,omitempty"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name,omitempty"`
+	Username      string `json:"preferred_username,omitempty"`
+}
+
+func New(issuer string, clientID string, clientSecret string) (*Server, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	return &Server{
+		Issuer:       strings.TrimSuffix(issuer, "/"),
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		key:          key,
+		kid:          randomString(8),
+		codes:        make(map[string]grant),
+		tokens:       make(map[string]claims),
+	}, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/.well-known/openid-configuration":
+		writeJSON(w, http.StatusOK, map[string]any{
+			"issuer":                                s.Issuer,
+			"authorization_endpoint":                s.Issuer + "/authorize",
+			"token_endpoint":                        s.Issuer + "/token",
+			"userinfo_endpoint":                     s.Issuer + "/userinfo",
+			"jwks_uri":                              s.Issuer + "/jwks",
+			"response_types_supported":              []string{"code"},
+			"subject_types_supported":               []string{"public"},
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+			"code_challenge_methods_supported":      []string{"S256"},
+		})
+	case "/jwks":
+		writeJSON(w, http.StatusOK, map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": s.kid,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(s.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(s.key.E)).Bytes()),
+		}}})
+	case "/authorize":
+		s.authorize(w, r)
+	case "/token":
+		s.token(w, r)
+	case "/userinfo":
+		s.userinfo(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+var loginPage = template.Must(template.New("login").Parse(`<!doctype html>
+<title>Mock OIDC</title>
+<h1>Mock OIDC sign-in</h1>
+<form method="POST">
+  {{range $k, $v := .Query}}<input type="hidden" name="{{$k}}" value="{{index $v 0}}">{{end}}
+  <p><label>Subject <input name="sub" value="mock-user"></label></p>
+  <p><label>Email <input name="email" value="mock@example.com"></label></p>
+  <p><label><input type="checkbox" name="email_verified" value="1" checked> Email verified</label></p>
+  <p><label>Username <input name="preferred_username" value="mockuser"></label></p>
+  <p><button type="submit">Sign in</button></p>
+</form>`))
+
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "bad form", http.StatusBadRequest)
+		return
+	}
+	q := r.Form
+	if q.Get("response_type") != "code" || q.Get("client_id") != s.ClientID {
+		http.Error(w, "unsupported response_type or unknown client_id", http.StatusBadRequest)
+		return
+	}
+	if q.Get("code_challenge") == "" || q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "PKCE with S256 is required", http.StatusBadRequest)
+		return
+	}
+	redirectURI, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || !redirectURI.IsAbs() {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		_ = loginPage.Execute(w, map[string]any{"Query": r.URL.Query()})
+		return
+	}
+
+	now := time.Now()
+	code := randomString(24)
+	s.mu.Lock()
+	s.codes[code] = grant{
+		claims: claims{
+			Issuer:        s.Issuer,
+			Subject:       q.Get("sub"),
+			Audience:      s.ClientID,
+			IssuedAt:      now.Unix(),
+			Expiry:        now.Add(time.Hour).Unix(),
+			Nonce:         q.Get("nonce"),
+			Email:         q.Get("email"),
+			EmailVerified: q.Get("email_verified") == "1",
+			Username:      q.Get("preferred_username"),
+		},
+		clientID:    s.ClientID,
+		redirectURI: redirectURI.String(),
+		challenge:   q.Get("code_challenge"),
+		expires:     now.Add(time.Minute),
+	}
+	s.mu.Unlock()
+
+	back := redirectURI.Query()
+	back.Set("code", code)
+	back.Set("state", q.Get("state"))
+	redirectURI.RawQuery = back.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ParseForm() != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	s.mu.Lock()
+	g, ok := s.codes[r.PostForm.Get("code")]
+	delete(s.codes, r.PostForm.Get("code"))
+	s.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	switch {
+	case !ok || time.Now().After(g.expires):
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	case r.PostForm.Get("client_id") != g.clientID || r.PostForm.Get("client_secret") != s.ClientSecret:
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	case r.PostForm.Get("redirect_uri") != g.redirectURI:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "redirect_uri mismatch"})
+		return
+	case base64.RawURLEncoding.EncodeToString(sum[:]) != g.challenge:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "PKCE verification failed"})
+		return
+	}
+
+	idToken, err := s.sign(g.claims)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+	access := randomString(24)
+	s.mu.Lock()
+	s.tokens[access] = g.claims
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": access,
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+func (s *Server) userinfo(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	c, ok := s.tokens[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+	s.mu.Unlock()
+	if !ok {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_token"})
+		return
+	}
+	c.Nonce = ""
+	writeJSON(w, http.StatusOK, c)
+}
+
+func (s *Server) sign(c claims) (string, error) {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": s.kid})
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	signing := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signing))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signing + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func randomString(n int) string {
+	buf := make([]byte, n)
+	_, _ = rand.Read(buf)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
@@ -0,0 +1,375 @@
+package identity
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clockSkew is how far token timestamps may be off from the local clock.
+const clockSkew = 2 * time.Minute
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type oidcProvider struct {
+	config Config
+
+	mu   sync.Mutex
+	meta *discovery
+	keys map[string]crypto.PublicKey
+}
+
+func newOIDC(c Config) (*oidcProvider, error) {
+	if c.Name == "" || c.Issuer == "" {
+		return nil, fmt.Errorf("identity: oidc provider needs name and issuer")
+	}
+	if c.Label == "" {
+		c.Label = c.Name
+	}
+	if len(c.Scopes) == 0 {
+		c.Scopes = []string{"openid", "email", "profile"}
+	}
+	return &oidcProvider{config: c}, nil
+}
+
+func (p *oidcProvider) Name() string  { return p.config.Name }
+func (p *oidcProvider) Label() string { return p.config.Label }
+
+// discover fetches the issuer's metadata once and caches it.
+func (p *oidcProvider) discover(ctx context.Context) (*discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.meta != nil {
+		return p.meta, nil
+	}
+
+	var meta discovery
+	endpoint := strings.TrimSuffix(p.config.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := getJSON(ctx, endpoint, "", &meta); err != nil {
+		return nil, err
+	}
+	if meta.Issuer != p.config.Issuer {
+		return nil, fmt.Errorf("identity: issuer mismatch: configured %q, discovered %q", p.config.Issuer, meta.Issuer)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, errors.New("identity: incomplete discovery document")
+	}
+	p.meta = &meta
+	return p.meta, nil
+}
+
+func (p *oidcProvider) AuthCodeURL(ctx context.Context, req AuthRequest) (string, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {req.RedirectURI},
+		"scope":                 {strings.Join(p.config.Scopes, " ")},
+		"state":                 {req.State},
+		"nonce":                 {req.Nonce},
+		"code_challenge":        {req.Challenge()},
+		"code_challenge_method": {"S256"},
+	}
+	return appendQuery(meta.AuthorizationEndpoint, q), nil
+}
+
+type idClaims struct {
+	Issuer            string       `json:"iss"`
+	Subject           string       `json:"sub"`
+	Audience          audience     `json:"aud"`
+	AuthorizedParty   string       `json:"azp"`
+	Expiry            int64        `json:"exp"`
+	IssuedAt          int64        `json:"iat"`
+	Nonce             string       `json:"nonce"`
+	Email             string       `json:"email"`
+	EmailVerified     flexibleBool `json:"email_verified"`
+	Name              string       `json:"name"`
+	PreferredUsername string       `json:"preferred_username"`
+}
+
+func (p *oidcProvider) Exchange(ctx context.Context, code string, req AuthRequest) (*Identity, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tok, err := exchangeCode(ctx, meta.TokenEndpoint, p.config.ClientID, p.config.ClientSecret, code, req)
+	if err != nil {
+		return nil, err
+	}
+	if tok.IDToken == "" {
+		return nil, errors.New("identity: token response has no id_token")
+	}
+
+	claims, err := p.verify(ctx, tok.IDToken)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Nonce != req.Nonce {
+		return nil, ErrNonceMismatch
+	}
+
+	id := &Identity{
+		Provider:      p.config.Name,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: bool(claims.EmailVerified),
+		Username:      claims.PreferredUsername,
+		Name:          claims.Name,
+	}
+	if id.Email == "" && meta.UserinfoEndpoint != "" {
+		var info idClaims
+		if err := getJSON(ctx, meta.UserinfoEndpoint, tok.AccessToken, &info); err != nil {
+			return nil, err
+		}
+		// The userinfo subject must match the ID token or the response is
+		// about someone else.
+		if info.Subject != claims.Subject {
+			return nil, errors.New("identity: userinfo subject mismatch")
+		}
+		id.Email = info.Email
+		id.EmailVerified = bool(info.EmailVerified)
+		if id.Username == "" {
+			id.Username = info.PreferredUsername
+		}
+		if id.Name == "" {
+			id.Name = info.Name
+		}
+	}
+	return id, nil
+}
+
+// verify checks the ID token signature against the issuer's JWKS and
+// validates the standard claims.
+func (p *oidcProvider) verify(ctx context.Context, token string) (*idClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("identity: malformed id_token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("identity: malformed id_token signature")
+	}
+
+	key, err := p.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch header.Alg {
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok || rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig) != nil {
+			return nil, errors.New("identity: invalid id_token signature")
+		}
+	case "ES256":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(sig) != 64 {
+			return nil, errors.New("identity: invalid id_token signature")
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(pub, digest[:], r, s) {
+			return nil, errors.New("identity: invalid id_token signature")
+		}
+	default:
+		return nil, fmt.Errorf("identity: unsupported id_token algorithm %q", header.Alg)
+	}
+
+	var claims idClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	switch {
+	case claims.Issuer != p.config.Issuer:
+		return nil, errors.New("identity: id_token issuer mismatch")
+	case !claims.Audience.contains(p.config.ClientID):
+		return nil, errors.New("identity: id_token audience mismatch")
+	case len(claims.Audience) > 1 && claims.AuthorizedParty != p.config.ClientID:
+		return nil, errors.New("identity: id_token authorized party mismatch")
+	case claims.Subject == "":
+		return nil, errors.New("identity: id_token has no subject")
+	case now.After(time.Unix(claims.Expiry, 0).Add(clockSkew)):
+		return nil, errors.New("identity: id_token expired")
+	case claims.IssuedAt != 0 && time.Unix(claims.IssuedAt, 0).After(now.Add(clockSkew)):
+		return nil, errors.New("identity: id_token issued in the future")
+	}
+	return &claims, nil
+}
+
+// key returns the signing key with the given id, refetching the JWKS once
+// when the id is unknown so that key rotation is picked up.
+func (p *oidcProvider) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	key, ok := p.keys[kid]
+	p.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := getJSON(ctx, meta.JWKSURI, "", &set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		pub, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = pub
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+
+	key, ok = keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("identity: unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		exp := new(big.Int).SetBytes(e)
+		if !exp.IsInt64() || exp.Int64() > 1<<31-1 {
+			return nil, errors.New("identity: bad RSA exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("identity: unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errors.New("identity: EC key not on curve")
+		}
+		return pub, nil
+	default:
+		return nil, fmt.Errorf("identity: unsupported key type %q", k.Kty)
+	}
+}
+
+func decodeSegment(seg string, v any) error {
+	raw, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return errors.New("identity: malformed id_token segment")
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return errors.New("identity: malformed id_token segment")
+	}
+	return nil
+}
+
+// audience accepts both the string and array forms of the aud claim.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+func (a audience) contains(v string) bool {
+	for _, s := range a {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// flexibleBool accepts true and "true"; some providers send the latter.
+type flexibleBool bool
+
+func (b *flexibleBool) UnmarshalJSON(data []byte) error {
+	switch strings.Trim(string(data), `"`) {
+	case "true":
+		*b = true
+	default:
+		*b = false
+	}
+	return nil
+}
+
+func appendQuery(endpoint string, q url.Values) string {
+	if strings.Contains(endpoint, "?") {
+		return endpoint + "&" + q.Encode()
+	}
+	return endpoint + "?" + q.Encode()
+}
@@ -0,0 +1,166 @@
+package identity
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"forum/internal/identity/mockoidc"
+)
+
+// mockProvider serves a mock OpenID Connect provider and returns a provider
+// configured against it. tamper, when set, rewrites the ID token its token
+// endpoint hands out.
+func mockProvider(t *testing.T, tamper func(idToken string) string) (Provider, *httptest.Server) {
+	t.Helper()
+	var mock *mockoidc.Server
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/token" || tamper == nil {
+			mock.ServeHTTP(w, r)
+			return
+		}
+		rec := httptest.NewRecorder()
+		mock.ServeHTTP(rec, r)
+		var tok map[string]any
+		if err := json.Unmarshal(rec.Body.Bytes(), &tok); err != nil {
+			t.Error(err)
+		}
+		if idToken, ok := tok["id_token"].(string); ok {
+			tok["id_token"] = tamper(idToken)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(rec.Code)
+		_ = json.NewEncoder(w).Encode(tok)
+	}))
+	t.Cleanup(srv.Close)
+
+	var err error
+	mock, err = mockoidc.New(srv.URL, "forum", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := New(Config{Type: "oidc", Name: "mock", Issuer: srv.URL, ClientID: "forum", ClientSecret: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p, srv
+}
+
+// authorize signs in at the mock provider with the fields of form and
+// returns the code it redirects back with.
+func authorize(t *testing.T, p Provider, req AuthRequest, form url.Values) string {
+	t.Helper()
+	authURL, err := p.AuthCodeURL(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Post(authURL, "application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	back, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize: status %d, location %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	if back.Query().Get("state") != req.State {
+		t.Fatalf("authorize returned state %q, want %q", back.Query().Get("state"), req.State)
+	}
+	return back.Query().Get("code")
+}
+
+// forgeEmail swaps the email in an ID token's claims and keeps the
+// signature, which then no longer matches.
+func forgeEmail(idToken string) string {
+	parts := strings.Split(idToken, ".")
+	raw, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	var claims map[string]any
+	_ = json.Unmarshal(raw, &claims)
+	claims["email"] = "mallory@example.com"
+	raw, _ = json.Marshal(claims)
+	parts[1] = base64.RawURLEncoding.EncodeToString(raw)
+	return strings.Join(parts, ".")
+}
+
+func TestOIDCExchange(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(string) string
+		// alter changes the request the callback exchanges the code with.
+		alter   func(*AuthRequest)
+		wantErr string
+	}{
+		{name: "valid"},
+		{name: "nonce mismatch", alter: func(r *AuthRequest) { r.Nonce = "other" }, wantErr: ErrNonceMismatch.Error()},
+		{name: "wrong PKCE verifier", alter: func(r *AuthRequest) { r.Verifier = "other" }, wantErr: "PKCE verification failed"},
+		{name: "redirect URI mismatch", alter: func(r *AuthRequest) { r.RedirectURI += "/other" }, wantErr: "redirect_uri mismatch"},
+		{name: "bad signature", tamper: forgeEmail, wantErr: "invalid id_token signature"},
+	}
+	for _, tt := range tests {
+		p, _ := mockProvider(t, tt.tamper)
+		req, err := NewAuthRequest("http://forum.test/auth/callback")
+		if err != nil {
+			t.Fatal(err)
+		}
+		code := authorize(t, p, req, url.Values{
+			"sub":                {"ann-1"},
+			"email":              {"ann@example.com"},
+			"email_verified":     {"1"},
+			"preferred_username": {"ann"},
+		})
+		if tt.alter != nil {
+			tt.alter(&req)
+		}
+
+		id, err := p.Exchange(context.Background(), code, req)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: Exchange = %+v, %v, want an error containing %q", tt.name, id, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		want := Identity{Provider: "mock", Subject: "ann-1", Email: "ann@example.com", EmailVerified: true, Username: "ann"}
+		if *id != want {
+			t.Errorf("%s: identity = %+v, want %+v", tt.name, *id, want)
+		}
+	}
+}
+
+func TestOIDCCodeIsSingleUse(t *testing.T) {
+	p, _ := mockProvider(t, nil)
+	req, err := NewAuthRequest("http://forum.test/auth/callback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := authorize(t, p, req, url.Values{"sub": {"ann-1"}, "email": {"ann@example.com"}})
+	if _, err := p.Exchange(context.Background(), code, req); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Exchange(context.Background(), code, req); err == nil {
+		t.Error("a code was redeemed twice")
+	}
+}
+
+func TestOIDCDiscoveryChecksIssuer(t *testing.T) {
+	_, srv := mockProvider(t, nil)
+	p, err := New(Config{Type: "oidc", Name: "mock", Issuer: srv.URL + "/", ClientID: "forum"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := NewAuthRequest("http://forum.test/auth/callback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.AuthCodeURL(context.Background(), req); err == nil || !strings.Contains(err.Error(), "issuer mismatch") {
+		t.Errorf("AuthCodeURL against a different issuer = %v, want an issuer mismatch", err)
+	}
+}
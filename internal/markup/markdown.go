@@ -0,0 +1,584 @@
+package markup
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const maxBlockDepth = 8
+
+var (
+	fencePattern      = regexp.MustCompile("^ {0,3}(`{3,}|~{3,})\\s*([\\w+#.-]*)\\s*$")
+	headingPattern    = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ \t]+(.*?))?(?:[ \t]+#+)?[ \t]*$`)
+	rulePattern       = regexp.MustCompile(`^ {0,3}(?:(?:\*[ \t]*){3,}|(?:-[ \t]*){3,}|(?:_[ \t]*){3,})$`)
+	quotePattern      = regexp.MustCompile(`^ {0,3}> ?`)
+	bulletPattern     = regexp.MustCompile(`^( {0,3})([-*+])[ \t]+`)
+	orderedPattern    = regexp.MustCompile(`^( {0,3})(\d{1,9})[.)][ \t]+`)
+	languagePattern   = regexp.MustCompile(`^[\w+#-]{1,32}$`)
+	usernameAtPattern = regexp.MustCompile(`^@(` + usernamePattern + `)`)
+)
+
+func Markdown(src string) string {
+	src = strings.ReplaceAll(src, "\r\n", "\n")
+	src = strings.ReplaceAll(src, "\r", "\n")
+	var b strings.Builder
+	renderBlocks(&b, strings.Split(src, "\n"), 0)
+	return b.String()
+}
+
+func renderBlocks(b *strings.Builder, lines []string, depth int) {
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		if strings.TrimSpace(line) == "" {
+			i++
+			continue
+		}
+
+		if m := fencePattern.FindStringSubmatch(line); m != nil {
+			i = renderFence(b, lines, i, m[1], m[2])
+			continue
+		}
+
+		if m := headingPattern.FindStringSubmatch(line); m != nil {
+			level := len(m[1]) + 2
+			if level > 6 {
+				level = 6
+			}
+			tag := "h" + strconv.Itoa(level)
+			b.WriteString("<" + tag + ">")
+			renderInline(b, strings.TrimSpace(m[2]), true)
+			b.WriteString("</" + tag + ">\n")
+			i++
+			continue
+		}
+
+		if rulePattern.MatchString(line) {
+			b.WriteString("<hr>\n")
+			i++
+			continue
+		}
+
+		if quotePattern.MatchString(line) {
+			var inner []string
+			for i < len(lines) && strings.TrimSpace(lines[i]) != "" {
+				if loc := quotePattern.FindStringIndex(lines[i]); loc != nil {
+					inner = append(inner, lines[i][loc[1]:])
+				} else if len(inner) > 0 && !startsBlock(lines[i]) {
+					inner = append(inner, lines[i])
+				} else {
+					break
+				}
+				i++
+			}
+			b.WriteString("<blockquote>\n")
+			if depth < maxBlockDepth {
+				renderBlocks(b, inner, depth+1)
+			} else {
+				renderParagraph(b, inner)
+			}
+			b.WriteString("</blockquote>\n")
+			continue
+		}
+
+		if bulletPattern.MatchString(line) || orderedPattern.MatchString(line) {
+			i = renderList(b, lines, i, depth)
+			continue
+		}
+
+		start := i
+		for i < len(lines) && strings.TrimSpace(lines[i]) != "" && (i == start || !startsBlock(lines[i])) {
+			i++
+		}
+		renderParagraph(b, lines[start:i])
+	}
+}
+
+func startsBlock(line string) bool {
+	return fencePattern.MatchString(line) ||
+		headingPattern.MatchString(line) ||
+		rulePattern.MatchString(line) ||
+		quotePattern.MatchString(line) ||
+		bulletPattern.MatchString(line) ||
+		orderedPattern.MatchString(line)
+}
+
+func renderFence(b *strings.Builder, lines []string, i int, fence string, lang string) int {
+	i++
+	var code []string
+	for i < len(lines) {
+		trimmed := strings.TrimSpace(lines[i])
+		if strings.HasPrefix(trimmed, fence[:3]) && strings.Trim(trimmed, fence[:1]) == "" && len(trimmed) >= len(fence) {
+			i++
+			break
+		}
+		code = append(code, lines[i])
+		i++
+	}
+
+	b.WriteString("<pre><code")
+	if lang != "" && languagePattern.MatchString(lang) {
+		b.WriteString(` class="language-` + html.EscapeString(lang) + `"`)
+	}
+	b.WriteString(">")
+	for _, line := range code {
+		b.WriteString(html.EscapeString(line))
+		b.WriteString("\n")
+	}
+	b.WriteString("</code></pre>\n")
+	return i
+}
+
+func renderList(b *strings.Builder, lines []string, i int, depth int) int {
+	ordered := !bulletPattern.MatchString(lines[i])
+	pattern := bulletPattern
+	if ordered {
+		pattern = orderedPattern
+	}
+
+	first := pattern.FindStringSubmatch(lines[i])
+	marker := first[2]
+	if ordered {
+		start, _ := strconv.Atoi(first[2])
+		if start != 1 {
+			b.WriteString(`<ol start="` + strconv.Itoa(start) + `">` + "\n")
+		} else {
+			b.WriteString("<ol>\n")
+		}
+	} else {
+		b.WriteString("<ul>\n")
+	}
+
+	for i < len(lines) {
+		m := pattern.FindStringSubmatch(lines[i])
+		if m == nil || (!ordered && m[2] != marker) {
+			break
+		}
+		indent := len(m[0])
+		item := []string{lines[i][indent:]}
+		i++
+		for i < len(lines) {
+			line := lines[i]
+			if strings.TrimSpace(line) == "" {
+				if i+1 < len(lines) && leadingSpaces(lines[i+1]) >= 2 && strings.TrimSpace(lines[i+1]) != "" {
+					item = append(item, "")
+					i++
+					continue
+				}
+				break
+			}
+			if leadingSpaces(line) >= 2 {
+				item = append(item, stripIndent(line, indent))
+				i++
+				continue
+			}
+			if startsBlock(line) {
+				break
+			}
+			item = append(item, line)
+			i++
+		}
+
+		b.WriteString("<li>")
+		if depth < maxBlockDepth {
+			var inner strings.Builder
+			renderBlocks(&inner, item, depth+1)
+			b.WriteString(unwrapParagraph(inner.String()))
+		} else {
+			renderInline(b, strings.Join(item, "\n"), true)
+		}
+		b.WriteString("</li>\n")
+	}
+
+	if ordered {
+		b.WriteString("</ol>\n")
+	} else {
+		b.WriteString("</ul>\n")
+	}
+	return i
+}
+
+func unwrapParagraph(s string) string {
+	trimmed := strings.TrimSuffix(s, "\n")
+	if strings.HasPrefix(trimmed, "<p>") && strings.HasSuffix(trimmed, "</p>") && strings.Count(trimmed, "<p>") == 1 {
+		return trimmed[3 : len(trimmed)-4]
+	}
+	if strings.HasPrefix(trimmed, "<p>") {
+		if end := strings.Index(trimmed, "</p>\n"); end >= 0 && strings.Count(trimmed, "<p>") == 1 {
+			return trimmed[3:end] + "\n" + trimmed[end+5:]
+		}
+	}
+	return s
+}
+
+func stripIndent(line string, n int) string {
+	for n > 0 && line != "" && (line[0] == ' ' || line[0] == '\t') {
+		line = line[1:]
+		n--
+	}
+	return line
+}
+
+func leadingSpaces(line string) int {
+	n := 0
+	for _, r := range line {
+		switch r {
+		case ' ':
+			n++
+		case '\t':
+			n += 4
+		default:
+			return n
+		}
+	}
+	return n
+}
+
+func renderParagraph(b *strings.Builder, lines []string) {
+	for i := range lines {
+		lines[i] = strings.TrimSpace(lines[i])
+	}
+	b.WriteString("<p>")
+	renderInline(b, strings.Join(lines, "\n"), true)
+	b.WriteString("</p>\n")
+}
+
+func renderInline(b *strings.Builder, s string, allowLinks bool) {
+	var text strings.Builder
+	flush := func() {
+		b.WriteString(html.EscapeString(text.String()))
+		text.Reset()
+	}
+
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s) && isASCIIPunct(s[i+1]):
+			text.WriteByte(s[i+1])
+			i += 2
+			continue
+
+		case c == '\n':
+			flush()
+			b.WriteString("<br>\n")
+			i++
+			continue
+
+		case c == '`':
+			if n, ok := renderCodeSpan(b, s[i:], flush); ok {
+				i += n
+				continue
+			}
+			run := countRun(s[i:], '`')
+			text.WriteString(s[i : i+run])
+			i += run
+			continue
+
+		case c == '<' && allowLinks:
+			if end := strings.IndexByte(s[i:], '>'); end > 0 {
+				target := s[i+1 : i+end]
+				if !strings.ContainsAny(target, " \t\n<") && safeURL(target) && strings.Contains(target, ":") {
+					flush()
+					writeLink(b, target)
+					b.WriteString(html.EscapeString(target))
+					b.WriteString("</a>")
+					i += end + 1
+					continue
+				}
+			}
+
+		case c == '[' && allowLinks:
+			if label, target, n, ok := parseLink(s[i:]); ok {
+				flush()
+				if safeURL(target) {
+					writeLink(b, target)
+					renderInline(b, label, false)
+					b.WriteString("</a>")
+				} else {
+					renderInline(b, label, false)
+				}
+				i += n
+				continue
+			}
+
+		case c == '*' || c == '_' || c == '~':
+			if n, ok := renderEmphasis(b, s, i, allowLinks, flush); ok {
+				i += n
+				continue
+			}
+			run := countRun(s[i:], c)
+			text.WriteString(s[i : i+run])
+			i += run
+			continue
+
+		case c == '@' && allowLinks && wordBoundaryBefore(s, i):
+			if m := usernameAtPattern.FindStringSubmatch(s[i:]); m != nil {
+				flush()
+				b.WriteString(`<a class="mention" href="/user/` + html.EscapeString(url.PathEscape(m[1])) + `">@`)
+				b.WriteString(html.EscapeString(m[1]))
+				b.WriteString("</a>")
+				i += len(m[0])
+				continue
+			}
+
+		case (c == 'h' || c == 'w') && allowLinks && wordBoundaryBefore(s, i):
+			if n := bareURLLength(s[i:]); n > 0 {
+				flush()
+				target := s[i : i+n]
+				href := target
+				if strings.HasPrefix(target, "www.") {
+					href = "https://" + target
+				}
+				writeLink(b, href)
+				b.WriteString(html.EscapeString(target))
+				b.WriteString("</a>")
+				i += n
+				continue
+			}
+		}
+
+		_, size := utf8.DecodeRuneInString(s[i:])
+		text.WriteString(s[i : i+size])
+		i += size
+	}
+	flush()
+}
+
+func renderCodeSpan(b *strings.Builder, s string, flush func()) (int, bool) {
+	run := countRun(s, '`')
+	for j := run; j < len(s); {
+		k := strings.IndexByte(s[j:], '`')
+		if k < 0 {
+			return 0, false
+		}
+		j += k
+		closing := countRun(s[j:], '`')
+		if closing == run {
+			code := strings.ReplaceAll(s[run:j], "\n", " ")
+			if len(code) >= 2 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.TrimSpace(code) != "" {
+				code = code[1 : len(code)-1]
+			}
+			flush()
+			b.WriteString("<code>")
+			b.WriteString(html.EscapeString(code))
+			b.WriteString("</code>")
+			return j + closing, true
+		}
+		j += closing
+	}
+	return 0, false
+}
+
+func renderEmphasis(b *strings.Builder, s string, i int, allowLinks bool, flush func()) (int, bool) {
+	c := s[i]
+	run := countRun(s[i:], c)
+	var width int
+	var openTag, closeTag string
+	switch {
+	case c == '~' && run >= 2:
+		width, openTag, closeTag = 2, "<del>", "</del>"
+	case c == '~':
+		return 0, false
+	case run >= 3:
+		width, openTag, closeTag = 3, "<em><strong>", "</strong></em>"
+	case run == 2:
+		width, openTag, closeTag = 2, "<strong>", "</strong>"
+	default:
+		width, openTag, closeTag = 1, "<em>", "</em>"
+	}
+
+	delim := s[i : i+width]
+	open := i + width
+	if open >= len(s) || isSpace(s[open]) {
+		return 0, false
+	}
+	if c == '_' && !wordBoundaryBefore(s, i) {
+		return 0, false
+	}
+
+	for j := open + 1; j <= len(s)-width; j++ {
+		if s[j] == '`' {
+			if n := codeSpanEnd(s[j:]); n > 0 {
+				j += n - 1
+				continue
+			}
+		}
+		if s[j:j+width] != delim || isSpace(s[j-1]) {
+			continue
+		}
+		if width == 1 && j+1 < len(s) && s[j+1] == c {
+			j++
+			continue
+		}
+		if c == '_' && j+width < len(s) && isWordByte(s[j+width]) {
+			continue
+		}
+		flush()
+		b.WriteString(openTag)
+		renderInline(b, s[open:j], allowLinks)
+		b.WriteString(closeTag)
+		return j + width - i, true
+	}
+	return 0, false
+}
+
+func codeSpanEnd(s string) int {
+	run := countRun(s, '`')
+	for j := run; j < len(s); {
+		k := strings.IndexByte(s[j:], '`')
+		if k < 0 {
+			return 0
+		}
+		j += k
+		closing := countRun(s[j:], '`')
+		if closing == run {
+			return j + closing
+		}
+		j += closing
+	}
+	return 0
+}
+
+func parseLink(s string) (string, string, int, bool) {
+	depth := 0
+	closeBracket := -1
+	for j := 0; j < len(s); j++ {
+		switch s[j] {
+		case '\\':
+			j++
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				closeBracket = j
+			}
+		}
+		if closeBracket >= 0 {
+			break
+		}
+	}
+	if closeBracket < 0 || closeBracket+1 >= len(s) || s[closeBracket+1] != '(' {
+		return "", "", 0, false
+	}
+
+	depth = 0
+	for j := closeBracket + 1; j < len(s); j++ {
+		switch s[j] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				target := strings.TrimSpace(s[closeBracket+2 : j])
+				if k := strings.IndexAny(target, " \t"); k >= 0 {
+					target = target[:k]
+				}
+				target = strings.TrimSuffix(strings.TrimPrefix(target, "<"), ">")
+				return s[1:closeBracket], target, j + 1, true
+			}
+		case '\n':
+			return "", "", 0, false
+		}
+	}
+	return "", "", 0, false
+}
+
+func bareURLLength(s string) int {
+	var prefix int
+	switch {
+	case strings.HasPrefix(s, "https://"):
+		prefix = len("https://")
+	case strings.HasPrefix(s, "http://"):
+		prefix = len("http://")
+	case strings.HasPrefix(s, "www."):
+		prefix = len("www.")
+	default:
+		return 0
+	}
+
+	end := prefix
+	for end < len(s) {
+		r, size := utf8.DecodeRuneInString(s[end:])
+		if unicode.IsSpace(r) || r == '<' || r == '>' || r == '"' || r == '`' {
+			break
+		}
+		end += size
+	}
+
+	for end > prefix {
+		last := s[end-1]
+		if strings.IndexByte(".,:;!?'*_~", last) >= 0 {
+			end--
+			continue
+		}
+		if last == ')' && strings.Count(s[:end], "(") < strings.Count(s[:end], ")") {
+			end--
+			continue
+		}
+		break
+	}
+	if end == prefix {
+		return 0
+	}
+	return end
+}
+
+func writeLink(b *strings.Builder, href string) {
+	b.WriteString(`<a href="`)
+	b.WriteString(html.EscapeString(href))
+	b.WriteString(`">`)
+}
+
+func safeURL(raw string) bool {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return false
+	}
+	if strings.HasPrefix(raw, "/") && !strings.HasPrefix(raw, "//") || strings.HasPrefix(raw, "#") {
+		return true
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		return u.Host != ""
+	case "mailto":
+		return u.Opaque != ""
+	}
+	return false
+}
+
+func countRun(s string, c byte) int {
+	n := 0
+	for n < len(s) && s[n] == c {
+		n++
+	}
+	return n
+}
+
+func wordBoundaryBefore(s string, i int) bool {
+	if i == 0 {
+		return true
+	}
+	r, _ := utf8.DecodeLastRuneInString(s[:i])
+	return !(unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '@' || r == '/' || r == '.')
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n'
+}
+
+func isASCIIPunct(c byte) bool {
+	return strings.IndexByte("!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~", c) >= 0
+}
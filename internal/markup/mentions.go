@@ -0,0 +1,42 @@
+package markup
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	MinUsernameLength = 2
+	MaxUsernameLength = 32
+)
+
+const usernamePattern = `[\p{L}\p{N}_]+(?:[.-][\p{L}\p{N}_]+)*`
+
+var (
+	mentionPattern  = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_@])@(` + usernamePattern + `)`)
+	usernameMatcher = regexp.MustCompile(`^` + usernamePattern + `$`)
+)
+
+func ValidUsername(name string) bool {
+	n := utf8.RuneCountInString(name)
+	if n < MinUsernameLength || n > MaxUsernameLength {
+		return false
+	}
+	return usernameMatcher.MatchString(name)
+}
+
+func ExtractMentions(content string) []string {
+	seen := make(map[string]bool)
+	var usernames []string
+	for _, m := range mentionPattern.FindAllStringSubmatch(content, -1) {
+		name := m[1]
+		key := strings.ToLower(name)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		usernames = append(usernames, name)
+	}
+	return usernames
+}
@@ -0,0 +1,68 @@
+package markup
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"html/template"
+	"sync"
+)
+
+const defaultCacheSize = 4096
+
+var defaultCache = NewCache(defaultCacheSize)
+
+func Render(src string) template.HTML {
+	return defaultCache.Render(src)
+}
+
+type Cache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[[sha256.Size]byte]*list.Element
+}
+
+type cacheEntry struct {
+	key  [sha256.Size]byte
+	html template.HTML
+}
+
+func NewCache(size int) *Cache {
+	if size <= 0 {
+		size = defaultCacheSize
+	}
+	return &Cache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[[sha256.Size]byte]*list.Element, size),
+	}
+}
+
+func (c *Cache) Render(src string) template.HTML {
+	key := sha256.Sum256([]byte(src))
+
+	c.mu.Lock()
+	if el, ok := c.entries[key]; ok {
+		c.order.MoveToFront(el)
+		rendered := el.Value.(*cacheEntry).html
+		c.mu.Unlock()
+		return rendered
+	}
+	c.mu.Unlock()
+
+	rendered := template.HTML(Sanitize(Markdown(src)))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.order.MoveToFront(el)
+		return rendered
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, html: rendered})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+	return rendered
+}
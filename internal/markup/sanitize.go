@@ -0,0 +1,117 @@
+package markup
+
+import (
+	"html"
+	"regexp"
+	"strings"
+
+	nethtml "golang.org/x/net/html"
+)
+
+var allowedTags = map[string]bool{
+	"p": true, "br": true, "hr": true,
+	"h3": true, "h4": true, "h5": true, "h6": true,
+	"strong": true, "em": true, "del": true, "code": true, "pre": true,
+	"blockquote": true, "ul": true, "ol": true, "li": true, "a": true,
+}
+
+var voidTags = map[string]bool{"br": true, "hr": true}
+
+var droppedContent = map[string]bool{
+	"script": true, "style": true, "iframe": true, "object": true,
+	"embed": true, "template": true, "noscript": true, "textarea": true,
+	"title": true, "xmp": true, "noembed": true, "plaintext": true,
+}
+
+var (
+	languageClassPattern = regexp.MustCompile(`^language-[\w+#-]{1,32}$`)
+	digitsPattern        = regexp.MustCompile(`^\d{1,9}$`)
+)
+
+func Sanitize(s string) string {
+	z := nethtml.NewTokenizer(strings.NewReader(s))
+	var (
+		b    strings.Builder
+		open []string
+		skip int
+	)
+
+	for {
+		tt := z.Next()
+		switch tt {
+		case nethtml.ErrorToken:
+			for i := len(open) - 1; i >= 0; i-- {
+				b.WriteString("</" + open[i] + ">")
+			}
+			return b.String()
+
+		case nethtml.TextToken:
+			if skip == 0 {
+				b.WriteString(html.EscapeString(string(z.Text())))
+			}
+
+		case nethtml.StartTagToken, nethtml.SelfClosingTagToken:
+			tok := z.Token()
+			if droppedContent[tok.Data] {
+				if tt == nethtml.StartTagToken {
+					skip++
+				}
+				continue
+			}
+			if skip > 0 || !allowedTags[tok.Data] {
+				continue
+			}
+			b.WriteString("<" + tok.Data)
+			for _, attr := range sanitizeAttrs(tok) {
+				b.WriteString(" " + attr.Key + `="` + html.EscapeString(attr.Val) + `"`)
+			}
+			b.WriteString(">")
+			if !voidTags[tok.Data] && tt == nethtml.StartTagToken {
+				open = append(open, tok.Data)
+			}
+
+		case nethtml.EndTagToken:
+			tok := z.Token()
+			if droppedContent[tok.Data] {
+				if skip > 0 {
+					skip--
+				}
+				continue
+			}
+			if skip > 0 || !allowedTags[tok.Data] || voidTags[tok.Data] {
+				continue
+			}
+			for i := len(open) - 1; i >= 0; i-- {
+				if open[i] != tok.Data {
+					continue
+				}
+				for j := len(open) - 1; j >= i; j-- {
+					b.WriteString("</" + open[j] + ">")
+				}
+				open = open[:i]
+				break
+			}
+		}
+	}
+}
+
+func sanitizeAttrs(tok nethtml.Token) []nethtml.Attribute {
+	var attrs []nethtml.Attribute
+	for _, attr := range tok.Attr {
+		key := strings.ToLower(attr.Key)
+		switch {
+		case tok.Data == "a" && key == "href" && safeURL(attr.Val):
+			attrs = append(attrs, nethtml.Attribute{Key: "href", Val: strings.TrimSpace(attr.Val)})
+		case tok.Data == "a" && key == "class" && attr.Val == "mention":
+			attrs = append(attrs, nethtml.Attribute{Key: "class", Val: attr.Val})
+		case tok.Data == "code" && key == "class" && languageClassPattern.MatchString(attr.Val):
+			attrs = append(attrs, nethtml.Attribute{Key: "class", Val: attr.Val})
+		case tok.Data == "ol" && key == "start" && digitsPattern.MatchString(attr.Val):
+			attrs = append(attrs, nethtml.Attribute{Key: "start", Val: attr.Val})
+		}
+	}
+	if tok.Data == "a" {
+		attrs = append(attrs, nethtml.Attribute{Key: "rel", Val: "nofollow noopener ugc"})
+	}
+	return attrs
+}
@@ -0,0 +1,256 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"net/http"
+
+	_ "golang.org/x/image/webp"
+)
+
+var (
+	ErrUnsupportedType = errors.New("unsupported image type")
+	ErrTooLarge        = errors.New("image is too large")
+	ErrDimensions      = errors.New("image dimensions exceed the limit")
+	ErrCorrupt         = errors.New("image is corrupt")
+)
+
+type Limits struct {
+	MaxBytes  int64
+	MaxWidth  int
+	MaxHeight int
+	MaxPixels int
+}
+
+var DefaultLimits = Limits{
+	MaxBytes:  5 << 20,
+	MaxWidth:  8000,
+	MaxHeight: 8000,
+	MaxPixels: 40_000_000,
+}
+
+type Image struct {
+	Data        []byte
+	ContentType string
+	Ext         string
+	Width       int
+	Height      int
+}
+
+var allowedTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+func Process(data []byte, limits Limits) (*Image, error) {
+	if limits.MaxBytes > 0 && int64(len(data)) > limits.MaxBytes {
+		return nil, ErrTooLarge
+	}
+
+	contentType := http.DetectContentType(data)
+	ext, ok := allowedTypes[contentType]
+	if !ok {
+		return nil, ErrUnsupportedType
+	}
+
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	if "image/"+format != contentType {
+		return nil, ErrUnsupportedType
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return nil, ErrCorrupt
+	}
+	if (limits.MaxWidth > 0 && cfg.Width > limits.MaxWidth) ||
+		(limits.MaxHeight > 0 && cfg.Height > limits.MaxHeight) ||
+		(limits.MaxPixels > 0 && cfg.Width*cfg.Height > limits.MaxPixels) {
+		return nil, ErrDimensions
+	}
+
+	var stripped, exif []byte
+	switch contentType {
+	case "image/jpeg":
+		stripped, exif, err = stripJPEG(data)
+	case "image/png":
+		stripped, exif, err = stripPNG(data)
+	case "image/webp":
+		stripped, exif, err = stripWebP(data)
+	default:
+		stripped = data
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+
+	img := &Image{
+		Data:        stripped,
+		ContentType: contentType,
+		Ext:         ext,
+		Width:       cfg.Width,
+		Height:      cfg.Height,
+	}
+	// Viewers turn a photo by its EXIF orientation, which goes with the rest
+	// of the EXIF data, so the pixels are turned here instead.
+	if orientation := exifOrientation(exif); orientation > 1 {
+		if err := img.orient(orientation); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+		}
+	}
+	return img, nil
+}
+
+// stripJPEG drops the metadata segments from a JPEG and returns the EXIF
+// data it found, without its "Exif" header.
+func stripJPEG(data []byte) ([]byte, []byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, nil, errors.New("missing SOI marker")
+	}
+	var exif []byte
+	out := make([]byte, 0, len(data))
+	out = append(out, 0xFF, 0xD8)
+
+	i := 2
+	for i < len(data) {
+		if data[i] != 0xFF {
+			return nil, nil, errors.New("expected marker")
+		}
+		for i < len(data) && data[i] == 0xFF {
+			i++
+		}
+		if i >= len(data) {
+			return nil, nil, errors.New("truncated marker")
+		}
+		marker := data[i]
+		i++
+
+		if marker == 0xD9 || (marker >= 0xD0 && marker <= 0xD7) || marker == 0x01 {
+			out = append(out, 0xFF, marker)
+			continue
+		}
+		if i+2 > len(data) {
+			return nil, nil, errors.New("truncated segment")
+		}
+		length := int(binary.BigEndian.Uint16(data[i:]))
+		if length < 2 || i+length > len(data) {
+			return nil, nil, errors.New("bad segment length")
+		}
+		segment := data[i : i+length]
+		i += length
+
+		if marker == 0xDA {
+			out = append(out, 0xFF, marker)
+			out = append(out, segment...)
+			out = append(out, data[i:]...)
+			return out, exif, nil
+		}
+
+		if marker == 0xE1 && exif == nil && bytes.HasPrefix(segment[2:], exifHeader) {
+			exif = segment[2+len(exifHeader):]
+		}
+		// APP1 (EXIF and XMP), APP13 (IPTC) and comments are dropped. APP0
+		// (JFIF), APP2 (the ICC profile) and APP14 (Adobe) are kept, as the
+		// colors depend on them.
+		if marker == 0xE1 || marker == 0xED || marker == 0xFE ||
+			(marker >= 0xE3 && marker <= 0xEC) || marker == 0xEF {
+			continue
+		}
+		out = append(out, 0xFF, marker)
+		out = append(out, segment...)
+	}
+	return out, exif, nil
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+var pngMetadataChunks = map[string]bool{
+	"eXIf": true,
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+	"tIME": true,
+}
+
+// stripPNG drops the metadata chunks from a PNG and returns the contents of
+// its eXIf chunk.
+func stripPNG(data []byte) ([]byte, []byte, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, nil, errors.New("missing PNG signature")
+	}
+	out := make([]byte, 0, len(data))
+	out = append(out, pngSignature...)
+
+	var exif []byte
+	i := len(pngSignature)
+	for i+8 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		chunkType := string(data[i+4 : i+8])
+		end := i + 12 + length
+		if length < 0 || end > len(data) {
+			return nil, nil, errors.New("bad chunk length")
+		}
+		if chunkType == "eXIf" && exif == nil {
+			exif = data[i+8 : i+8+length]
+		}
+		if !pngMetadataChunks[chunkType] {
+			out = append(out, data[i:end]...)
+		}
+		i = end
+		if chunkType == "IEND" {
+			break
+		}
+	}
+	return out, exif, nil
+}
+
+// stripWebP drops the EXIF and XMP chunks from a WebP file and returns the
+// EXIF data.
+func stripWebP(data []byte) ([]byte, []byte, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, nil, errors.New("missing RIFF header")
+	}
+	out := make([]byte, 12, len(data))
+	copy(out, data[:12])
+
+	var exif []byte
+	i := 12
+	for i+8 <= len(data) {
+		chunkType := string(data[i : i+4])
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		end := i + 8 + size + size%2
+		if size < 0 || end > len(data) {
+			if i+8+size == len(data) {
+				end = len(data)
+			} else {
+				return nil, nil, errors.New("bad chunk length")
+			}
+		}
+		switch chunkType {
+		case "EXIF":
+			if exif == nil {
+				exif = bytes.TrimPrefix(data[i+8:min(i+8+size, len(data))], exifHeader)
+			}
+		case "XMP ":
+		case "VP8X":
+			chunk := append([]byte(nil), data[i:end]...)
+			if len(chunk) > 8 {
+				chunk[8] &^= 0x08 | 0x04
+			}
+			out = append(out, chunk...)
+		default:
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	binary.LittleEndian.PutUint32(out[4:8], uint32(len(out)-8))
+	return out, exif, nil
+}
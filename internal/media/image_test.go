@@ -0,0 +1,151 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// tiffOrientation is little-endian TIFF data with one IFD entry, the
+// orientation tag.
+func tiffOrientation(orientation int) []byte {
+	b := []byte("II*\x00\x08\x00\x00\x00\x01\x00")
+	entry := make([]byte, 12)
+	binary.LittleEndian.PutUint16(entry[0:], orientationTag)
+	binary.LittleEndian.PutUint16(entry[2:], 3)
+	binary.LittleEndian.PutUint32(entry[4:], 1)
+	binary.LittleEndian.PutUint16(entry[8:], uint16(orientation))
+	b = append(b, entry...)
+	return append(b, 0, 0, 0, 0)
+}
+
+// quadrants is a 32x16 image with a red left half and a blue right half.
+func quadrants() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 32, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 32; x++ {
+			c := color.RGBA{255, 0, 0, 255}
+			if x >= 16 {
+				c = color.RGBA{0, 0, 255, 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func jpegWithOrientation(t *testing.T, orientation int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, quadrants(), &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	payload := append(append([]byte(nil), exifHeader...), tiffOrientation(orientation)...)
+	app1 := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(app1[2:], uint16(len(payload)+2))
+	app1 = append(app1, payload...)
+	icc := []byte{0xFF, 0xE2, 0x00, 0x06, 'I', 'C', 'C', '!'}
+
+	data := buf.Bytes()
+	out := append([]byte{}, data[:2]...)
+	out = append(out, app1...)
+	out = append(out, icc...)
+	return append(out, data[2:]...)
+}
+
+func pngWithOrientation(t *testing.T, orientation int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, quadrants()); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	exif := tiffOrientation(orientation)
+	chunk := make([]byte, 8, 12+len(exif))
+	binary.BigEndian.PutUint32(chunk, uint32(len(exif)))
+	copy(chunk[4:], "eXIf")
+	chunk = append(chunk, exif...)
+	chunk = append(chunk, 0, 0, 0, 0) // the CRC is not checked by the decoder
+
+	// eXIf goes after IHDR, which is 25 bytes long.
+	at := len(pngSignature) + 25
+	out := append([]byte{}, data[:at]...)
+	out = append(out, chunk...)
+	return append(out, data[at:]...)
+}
+
+func isRed(c color.Color) bool {
+	r, g, b, _ := c.RGBA()
+	return r > 0xC000 && g < 0x4000 && b < 0x4000
+}
+
+func TestProcessAppliesOrientation(t *testing.T) {
+	tests := []struct {
+		orientation int
+		width       int
+		height      int
+		// red is a point that must be red after turning.
+		red image.Point
+	}{
+		{1, 32, 16, image.Pt(4, 8)},
+		{2, 32, 16, image.Pt(28, 8)},
+		{3, 32, 16, image.Pt(28, 8)},
+		{4, 32, 16, image.Pt(4, 8)},
+		{5, 16, 32, image.Pt(8, 4)},
+		{6, 16, 32, image.Pt(8, 4)},
+		{7, 16, 32, image.Pt(8, 28)},
+		{8, 16, 32, image.Pt(8, 28)},
+	}
+	for _, tt := range tests {
+		for name, data := range map[string][]byte{
+			"jpeg": jpegWithOrientation(t, tt.orientation),
+			"png":  pngWithOrientation(t, tt.orientation),
+		} {
+			img, err := Process(data, DefaultLimits)
+			if err != nil {
+				t.Fatalf("%s %d: %v", name, tt.orientation, err)
+			}
+			if img.Width != tt.width || img.Height != tt.height {
+				t.Errorf("%s %d: %dx%d, want %dx%d", name, tt.orientation, img.Width, img.Height, tt.width, tt.height)
+			}
+			decoded, _, err := image.Decode(bytes.NewReader(img.Data))
+			if err != nil {
+				t.Fatalf("%s %d: %v", name, tt.orientation, err)
+			}
+			if b := decoded.Bounds(); b.Dx() != tt.width || b.Dy() != tt.height {
+				t.Errorf("%s %d: decoded %v", name, tt.orientation, b)
+			}
+			if !isRed(decoded.At(tt.red.X, tt.red.Y)) {
+				t.Errorf("%s %d: %v is %v, want red", name, tt.orientation, tt.red, decoded.At(tt.red.X, tt.red.Y))
+			}
+			if bytes.Contains(img.Data, exifHeader) || bytes.Contains(img.Data, []byte("eXIf")) {
+				t.Errorf("%s %d: EXIF data was kept", name, tt.orientation)
+			}
+			if name == "jpeg" && !bytes.Contains(img.Data, []byte("ICC!")) {
+				t.Errorf("jpeg %d: the ICC segment was dropped", tt.orientation)
+			}
+		}
+	}
+}
+
+func TestExifOrientationIgnoresBadData(t *testing.T) {
+	good := tiffOrientation(6)
+	for name, data := range map[string][]byte{
+		"empty":        nil,
+		"short":        good[:6],
+		"bad order":    append([]byte("XX"), good[2:]...),
+		"cut entry":    good[:16],
+		"out of range": tiffOrientation(9),
+	} {
+		if got := exifOrientation(data); got != 0 {
+			t.Errorf("%s: orientation %d, want 0", name, got)
+		}
+	}
+	if got := exifOrientation(good); got != 6 {
+		t.Errorf("orientation %d, want 6", got)
+	}
+}
@@ -0,0 +1,156 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+)
+
+// exifHeader starts the EXIF data in a JPEG APP1 segment, and sometimes in a
+// WebP EXIF chunk.
+var exifHeader = []byte("Exif\x00\x00")
+
+const orientationTag = 0x0112
+
+// exifOrientation reads the orientation tag, 1 to 8, from TIFF-formatted EXIF
+// data. It returns 0 when there is none or the data cannot be read.
+func exifOrientation(exif []byte) int {
+	if len(exif) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(exif[:4]) {
+	case "II*\x00":
+		order = binary.LittleEndian
+	case "MM\x00*":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	ifd := int(order.Uint32(exif[4:]))
+	if ifd < 8 || ifd+2 > len(exif) {
+		return 0
+	}
+	count := int(order.Uint16(exif[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(exif) {
+			return 0
+		}
+		if order.Uint16(exif[entry:]) != orientationTag {
+			continue
+		}
+		// The value is one SHORT, stored in the entry itself.
+		if order.Uint16(exif[entry+2:]) != 3 {
+			return 0
+		}
+		if v := int(order.Uint16(exif[entry+8:])); v >= 1 && v <= 8 {
+			return v
+		}
+		return 0
+	}
+	return 0
+}
+
+// orient turns the pixels of img the way EXIF orientation 2 to 8 asks and
+// encodes them again. A JPEG keeps its ICC profile. WebP, which the standard
+// library cannot write, becomes a PNG.
+func (img *Image) orient(orientation int) error {
+	src, _, err := image.Decode(bytes.NewReader(img.Data))
+	if err != nil {
+		return err
+	}
+	turned := transform(src, orientation)
+
+	var buf bytes.Buffer
+	switch img.ContentType {
+	case "image/jpeg":
+		if err := jpeg.Encode(&buf, turned, &jpeg.Options{Quality: 90}); err != nil {
+			return err
+		}
+		img.Data = withICCProfile(buf.Bytes(), img.Data)
+	case "image/png", "image/webp":
+		if err := png.Encode(&buf, turned); err != nil {
+			return err
+		}
+		img.Data = buf.Bytes()
+		img.ContentType = "image/png"
+		img.Ext = allowedTypes["image/png"]
+	default:
+		return nil
+	}
+	img.Width, img.Height = turned.Bounds().Dx(), turned.Bounds().Dy()
+	return nil
+}
+
+// transform returns src flipped and rotated for an EXIF orientation.
+func transform(src image.Image, orientation int) *image.RGBA {
+	b := src.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), src, b.Min, draw.Src)
+
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	// from maps a pixel of the result to the pixel of src it shows.
+	var from func(x, y int) (int, int)
+	switch orientation {
+	case 2:
+		from = func(x, y int) (int, int) { return w - 1 - x, y }
+	case 3:
+		from = func(x, y int) (int, int) { return w - 1 - x, h - 1 - y }
+	case 4:
+		from = func(x, y int) (int, int) { return x, h - 1 - y }
+	case 5:
+		from = func(x, y int) (int, int) { return y, x }
+	case 6:
+		from = func(x, y int) (int, int) { return y, h - 1 - x }
+	case 7:
+		from = func(x, y int) (int, int) { return w - 1 - y, h - 1 - x }
+	case 8:
+		from = func(x, y int) (int, int) { return w - 1 - y, x }
+	default:
+		return rgba
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			sx, sy := from(x, y)
+			copy(dst.Pix[dst.PixOffset(x, y):][:4], rgba.Pix[rgba.PixOffset(sx, sy):][:4])
+		}
+	}
+	return dst
+}
+
+// withICCProfile copies the APP2 segments of orig, which hold its ICC
+// profile, into encoded right after the start marker.
+func withICCProfile(encoded []byte, orig []byte) []byte {
+	var icc []byte
+	for i := 2; i+4 <= len(orig) && orig[i] == 0xFF; {
+		marker := orig[i+1]
+		if marker == 0xDA || marker == 0xD9 {
+			break
+		}
+		end := i + 2 + int(binary.BigEndian.Uint16(orig[i+2:]))
+		if end > len(orig) {
+			break
+		}
+		if marker == 0xE2 {
+			icc = append(icc, orig[i:end]...)
+		}
+		i = end
+	}
+	if len(icc) == 0 {
+		return encoded
+	}
+	out := make([]byte, 0, len(encoded)+len(icc))
+	out = append(out, encoded[:2]...)
+	out = append(out, icc...)
+	return append(out, encoded[2:]...)
+}
@@ -0,0 +1,18 @@
+package metrics
+
+// Default holds the forum's own metrics and is what /metrics serves.
+var Default = NewRegistry()
+
+var (
+	HTTPRequests = Default.NewCounterVec("forum_http_requests_total",
+		"HTTP requests by route pattern, method and status code.", "route", "method", "status")
+	HTTPDuration = Default.NewHistogramVec("forum_http_request_duration_seconds",
+		"Time spent serving HTTP requests.", DefaultBuckets, "route", "method")
+	DBQueryDuration = Default.NewHistogramVec("forum_db_query_duration_seconds",
+		"Time spent in repository methods.", DefaultBuckets, "method")
+
+	PostsCreated     = Default.NewCounterVec("forum_posts_created_total", "Posts created.").With()
+	CommentsCreated  = Default.NewCounterVec("forum_comments_created_total", "Comments created.").With()
+	ReactionsCreated = Default.NewCounterVec("forum_reactions_created_total",
+		"Reactions added, by target.", "target")
+)
@@ -1,17 +1,78 @@
 package middleware
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"net/http"
+	"sync"
 
 	"forum/internal/models"
 	"forum/internal/repo"
 )
 
-func CurrentUser(db *sql.DB, r *http.Request) (*models.User, error) {
-	c, err := r.Cookie("session")
+// SessionCookie holds the ID of the signed-in user's session.
+const SessionCookie = "session"
+
+// ErrNotSignedIn is what CurrentUser reports for anonymous visitors.
+var ErrNotSignedIn = errors.New("not signed in")
+
+// Sessions is what Authenticate needs to find the user behind a session.
+type Sessions interface {
+	GetUserBySessionID(ctx context.Context, sessionID string) (*models.User, error)
+	CountUnreadNotifications(ctx context.Context, userID int) (int, error)
+}
+
+type authKey struct{}
+
+// auth resolves the request's user the first time a handler asks for it,
+// so requests that never look, such as static files, cost no query.
+type auth struct {
+	once sync.Once
+	load func() (*models.User, error)
+	user *models.User
+	err  error
+}
+
+// Authenticate makes the user behind the session cookie available to
+// CurrentUser.
+func Authenticate(sessions Sessions, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a := &auth{load: func() (*models.User, error) { return loadUser(sessions, r) }}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), authKey{}, a)))
+	})
+}
+
+// CurrentUser returns the signed-in user, or ErrNotSignedIn when there is
+// none.
+func CurrentUser(r *http.Request) (*models.User, error) {
+	a, ok := r.Context().Value(authKey{}).(*auth)
+	if !ok {
+		return nil, ErrNotSignedIn
+	}
+	a.once.Do(func() { a.user, a.err = a.load() })
+	return a.user, a.err
+}
+
+func loadUser(sessions Sessions, r *http.Request) (*models.User, error) {
+	c, err := r.Cookie(SessionCookie)
+	if err != nil {
+		return nil, ErrNotSignedIn
+	}
+	user, err := sessions.GetUserBySessionID(r.Context(), c.Value)
+	if err != nil {
+		// An unknown or expired session is an anonymous visitor; anything
+		// else is worth a look.
+		if !errors.Is(err, sql.ErrNoRows) && !errors.Is(err, repo.ErrSessionExpired) {
+			Logger(r).Error("get user by session", "err", err)
+		}
+		return nil, ErrNotSignedIn
+	}
+	setRequestUser(r, user)
+	count, err := sessions.CountUnreadNotifications(r.Context(), user.ID)
 	if err != nil {
-		return nil, err
+		Logger(r).Error("count unread notifications", "err", err)
 	}
-	return repo.GetUserBySessionID(db, c.Value)
+	user.UnreadNotifications = count
+	return user, nil
 }
@@ -0,0 +1,151 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"forum/internal/models"
+)
+
+// RequestIDHeader carries the request ID back to the client so users can
+// quote it in bug reports.
+const RequestIDHeader = "X-Request-ID"
+
+type requestInfoKey struct{}
+
+// requestInfo is shared between the logging middleware and the handlers of
+// one request. Handlers fill in the user once they know who is calling.
+type requestInfo struct {
+	id       string
+	userID   int
+	locale   string
+	timezone string
+}
+
+// RequestID returns the ID assigned to the request by RequestLog, or "" when
+// the request did not pass through it.
+func RequestID(r *http.Request) string {
+	return RequestIDFromContext(r.Context())
+}
+
+func RequestIDFromContext(ctx context.Context) string {
+	if info, ok := ctx.Value(requestInfoKey{}).(*requestInfo); ok {
+		return info.id
+	}
+	return ""
+}
+
+func setRequestUser(r *http.Request, user *models.User) {
+	if info, ok := r.Context().Value(requestInfoKey{}).(*requestInfo); ok {
+		info.userID = user.ID
+		info.locale = user.Locale
+		info.timezone = user.Timezone
+	}
+}
+
+// UserLocale returns the language the signed-in user chose, once
+// CurrentUser has run for the request.
+func UserLocale(r *http.Request) string {
+	if info, ok := r.Context().Value(requestInfoKey{}).(*requestInfo); ok {
+		return info.locale
+	}
+	return ""
+}
+
+// UserTimezone returns the time zone the signed-in user chose, once
+// CurrentUser has run for the request.
+func UserTimezone(r *http.Request) string {
+	if info, ok := r.Context().Value(requestInfoKey{}).(*requestInfo); ok {
+		return info.timezone
+	}
+	return ""
+}
+
+// Logger returns a logger that tags every record with the request ID and,
+// once CurrentUser has run for the request, the user's ID.
+func Logger(r *http.Request) *slog.Logger {
+	info, ok := r.Context().Value(requestInfoKey{}).(*requestInfo)
+	if !ok {
+		return slog.Default()
+	}
+	if info.userID != 0 {
+		return slog.With("request_id", info.id, "user_id", info.userID)
+	}
+	return slog.With("request_id", info.id)
+}
+
+// RequestLog assigns every request an ID and writes one access log record
+// when it finishes.
+func RequestLog(logger *slog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		info := &requestInfo{id: newRequestID()}
+		w.Header().Set(RequestIDHeader, info.id)
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info)))
+
+		attrs := []slog.Attr{
+			slog.String("request_id", info.id),
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", rec.status),
+			slog.Duration("latency", time.Since(start)),
+			slog.Int64("bytes", rec.bytes),
+			slog.String("remote", r.RemoteAddr),
+		}
+		if info.userID != 0 {
+			attrs = append(attrs, slog.Int("user_id", info.userID))
+		}
+		level := slog.LevelInfo
+		if rec.status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		logger.LogAttrs(r.Context(), level, "request", attrs...)
+	})
+}
+
+func newRequestID() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(buf)
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if !s.wroteHeader {
+		s.status = status
+		s.wroteHeader = true
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(p []byte) (int, error) {
+	s.wroteHeader = true
+	n, err := s.ResponseWriter.Write(p)
+	s.bytes += int64(n)
+	return n, err
+}
+
+// Flush keeps server-sent events working behind the recorder.
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"forum/internal/metrics"
+)
+
+// Metrics counts requests and their latency per route. It must wrap the
+// ServeMux directly: the mux records the matched pattern on the request it
+// is given, and that pattern is the route label. Middleware that passes the
+// mux a copy of the request, as Authenticate does, has to sit outside it.
+func Metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		// A panic is counted as the 500 that Recover, further out, turns it
+		// into.
+		defer func() {
+			v := recover()
+			status := rec.status
+			if v != nil {
+				status = http.StatusInternalServerError
+			}
+			route := r.Pattern
+			if route == "" {
+				route = "unmatched"
+			}
+			metrics.HTTPRequests.With(route, r.Method, strconv.Itoa(status)).Inc()
+			metrics.HTTPDuration.With(route, r.Method).ObserveDuration(start)
+			if v != nil {
+				panic(v)
+			}
+		}()
+
+		next.ServeHTTP(rec, r)
+	})
+}
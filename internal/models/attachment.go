@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+type Attachment struct {
+	ID           int
+	PostID       int
+	UserID       int
+	StorageKey   string
+	ContentType  string
+	Size         int64
+	Width        int
+	Height       int
+	OriginalName string
+	CreatedAt    time.Time
+}
+
+func (a Attachment) URL() string {
+	return "/attachments/" + a.StorageKey
+}
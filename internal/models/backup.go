@@ -0,0 +1,38 @@
+package models
+
+import "time"
+
+// BackupSnapshot is one database snapshot in the backup directory.
+type BackupSnapshot struct {
+	Name      string
+	Size      int64
+	CreatedAt time.Time
+}
+
+// SizeKB is the size rounded up to whole kilobytes.
+func (s BackupSnapshot) SizeKB() int64 {
+	return (s.Size + 1023) / 1024
+}
+
+// BackupStatus describes the backup job. The Last fields are zero until the
+// job has run since the server started.
+type BackupStatus struct {
+	Dir      string
+	Interval time.Duration
+	Running  bool
+	NextRun  time.Time
+
+	LastStarted  time.Time
+	LastFinished time.Time
+	LastError    string
+	LastSnapshot string
+}
+
+type AdminBackupsPageData struct {
+	CurrentUser *User
+	Available   bool
+	Status      BackupStatus
+	Snapshots   []BackupSnapshot
+	Error       string
+	Success     string
+}
@@ -3,11 +3,25 @@ package models
 import "time"
 
 type CommentView struct {
-	ID         int
-	UserID     int
-	AuthorName string
-	Content    string
-	CreatedAt  time.Time
-	Likes      int
-	Dislikes   int
+	ID         int               `json:"id"`
+	PostID     int               `json:"post_id"`
+	UserID     int               `json:"user_id"`
+	AuthorName string            `json:"author_name"`
+	Content    string            `json:"content"`
+	CreatedAt  time.Time         `json:"created_at"`
+	UpdatedAt  time.Time         `json:"updated_at"`
+	Likes      int               `json:"likes"`
+	Dislikes   int               `json:"dislikes"`
+	Reactions  []ReactionSummary `json:"reactions"`
+}
+
+func (c CommentView) ReactionBar(next string) ReactionBar {
+	return ReactionBar{
+		TargetType: "comment",
+		Action:     "/react-comment",
+		IDField:    "comment_id",
+		TargetID:   c.ID,
+		Next:       next,
+		Reactions:  c.Reactions,
+	}
 }
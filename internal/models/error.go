@@ -4,4 +4,6 @@ type ErrorPageData struct {
 	CurrentUser *User
 	Status      int
 	Message     string
+	RequestID   string
+	Stack       string
 }
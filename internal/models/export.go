@@ -0,0 +1,104 @@
+package models
+
+import "time"
+
+const (
+	ExportPending = "pending"
+	ExportReady   = "ready"
+	ExportFailed  = "failed"
+)
+
+type DataExport struct {
+	ID          int
+	UserID      int
+	Status      string
+	StorageKey  string
+	Size        int64
+	Error       string
+	CreatedAt   time.Time
+	CompletedAt time.Time
+	ExpiresAt   time.Time
+}
+
+func (e DataExport) Expired() bool {
+	return !e.ExpiresAt.IsZero() && time.Now().After(e.ExpiresAt)
+}
+
+// UserExport is everything stored about one user, as written to the JSON
+// files of a personal data export.
+type UserExport struct {
+	Profile       ExportProfile        `json:"profile"`
+	Posts         []ExportPost         `json:"posts"`
+	Comments      []ExportComment      `json:"comments"`
+	Reactions     []ExportReaction     `json:"reactions"`
+	Sessions      []ExportSession      `json:"sessions"`
+	Notifications []ExportNotification `json:"notifications"`
+	Preferences   []ExportPreference   `json:"notification_preferences"`
+	Identities    []ExternalIdentity   `json:"linked_accounts"`
+}
+
+type ExportProfile struct {
+	ID         int       `json:"id"`
+	Email      string    `json:"email"`
+	Username   string    `json:"username"`
+	Bio        string    `json:"bio"`
+	Avatar     string    `json:"avatar,omitempty"`
+	Visibility string    `json:"profile_visibility"`
+	ShowLiked  bool      `json:"show_liked"`
+	CreatedAt  time.Time `json:"created_at"`
+
+	AvatarKey string `json:"-"`
+}
+
+type ExportPost struct {
+	ID          int                `json:"id"`
+	Title       string             `json:"title"`
+	Content     string             `json:"content"`
+	Categories  []string           `json:"categories"`
+	CreatedAt   time.Time          `json:"created_at"`
+	Attachments []ExportAttachment `json:"attachments"`
+}
+
+type ExportAttachment struct {
+	File         string `json:"file"`
+	ContentType  string `json:"content_type"`
+	Size         int64  `json:"size"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+	OriginalName string `json:"original_name"`
+
+	StorageKey string `json:"-"`
+}
+
+type ExportComment struct {
+	ID        int       `json:"id"`
+	PostID    int       `json:"post_id"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ExportReaction struct {
+	TargetType string    `json:"target_type"`
+	TargetID   int       `json:"target_id"`
+	Kind       string    `json:"kind"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type ExportSession struct {
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type ExportNotification struct {
+	Type      string     `json:"type"`
+	Actor     string     `json:"actor"`
+	PostID    int        `json:"post_id"`
+	CommentID int        `json:"comment_id,omitempty"`
+	Detail    string     `json:"detail,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	ReadAt    *time.Time `json:"read_at,omitempty"`
+}
+
+type ExportPreference struct {
+	Type    string `json:"type"`
+	Enabled bool   `json:"enabled"`
+}
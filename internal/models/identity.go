@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+type LoginProvider struct {
+	Name  string
+	Label string
+}
+
+type ExternalIdentity struct {
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"subject"`
+	UserID    int       `json:"-"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AuthState is a started external sign-in, kept until the provider redirects
+// back. LinkUserID is set when a signed-in user is connecting an account, or
+// with Confirm when the user is proving it is them to change a protected
+// setting.
+type AuthState struct {
+	State       string
+	Provider    string
+	Nonce       string
+	Verifier    string
+	RedirectURI string
+	LinkUserID  int
+	Confirm     bool
+	CreatedAt   time.Time
+}
+
+type LoginPageData struct {
+	CurrentUser *User
+	Providers   []LoginProvider
+	Error       string
+}
@@ -0,0 +1,55 @@
+package models
+
+import "time"
+
+const (
+	NotificationComment  = "comment"
+	NotificationReaction = "reaction"
+	NotificationMention  = "mention"
+)
+
+type NotificationType struct {
+	Code     string
+	LabelKey string
+}
+
+var NotificationTypes = []NotificationType{
+	{Code: NotificationComment, LabelKey: "notifications.type.comment"},
+	{Code: NotificationReaction, LabelKey: "notifications.type.reaction"},
+	{Code: NotificationMention, LabelKey: "notifications.type.mention"},
+}
+
+type Notification struct {
+	ID        int
+	UserID    int
+	ActorID   int
+	ActorName string
+	Type      string
+	PostID    int
+	PostTitle string
+	CommentID int
+	Detail    string
+	CreatedAt time.Time
+	Read      bool
+}
+
+type NotificationPreference struct {
+	Code     string
+	LabelKey string
+	Enabled  bool
+}
+
+type NotificationsPageData struct {
+	CurrentUser   *User
+	Notifications []Notification
+	Preferences   []NotificationPreference
+	Error         string
+}
+
+func (n Notification) ReactionEmoji() string {
+	kind, ok := ReactionKindByCode(n.Detail)
+	if !ok {
+		return n.Detail
+	}
+	return kind.Emoji
+}
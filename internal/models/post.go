@@ -3,8 +3,11 @@ package models
 import "time"
 
 type CommentCard struct {
+	ID         int
 	AuthorName string
 	Content    string
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
 }
 
 type BasePageData struct {
@@ -20,6 +23,7 @@ type PostView struct {
 	CategoryID   int
 	CategoryName string
 	CreatedAt    time.Time
+	UpdatedAt    time.Time
 	CountLikes   int
 }
 
@@ -29,18 +33,22 @@ type PostCard struct {
 	Content      string
 	CategoryName string
 	AuthorName   string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
 	Likes        int
 	Dislikes     int
+	Reactions    []ReactionSummary
+	Attachments  []Attachment
 	Comments     []CommentCard
 }
 
 type HomePageData struct {
-	CurrentUser       *User
-	Categories        []Category
-	Posts             []PostCard
-	AllActive         bool
-	MineActive        bool
-	LikedActive       bool
+	CurrentUser        *User
+	Categories         []Category
+	Posts              []PostCard
+	AllActive          bool
+	MineActive         bool
+	LikedActive        bool
 	SelectedCategoryID int
 }
 
@@ -50,8 +58,12 @@ type PostCardWithComments struct {
 	Content      string
 	CategoryName string
 	AuthorName   string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
 	Likes        int
 	Dislikes     int
+	Reactions    []ReactionSummary
+	Attachments  []Attachment
 	Comments     []CommentView
 }
 
@@ -65,3 +77,22 @@ type CreatePostPageData struct {
 	Categories  []Category
 	Error       string
 }
+
+func (p PostCard) ReactionBar(next string) ReactionBar {
+	return postReactionBar(p.ID, next, p.Reactions)
+}
+
+func (p PostCardWithComments) ReactionBar(next string) ReactionBar {
+	return postReactionBar(p.ID, next, p.Reactions)
+}
+
+func postReactionBar(postID int, next string, reactions []ReactionSummary) ReactionBar {
+	return ReactionBar{
+		TargetType: "post",
+		Action:     "/react-post",
+		IDField:    "post_id",
+		TargetID:   postID,
+		Next:       next,
+		Reactions:  reactions,
+	}
+}
@@ -0,0 +1,109 @@
+package models
+
+import "time"
+
+const (
+	ProfilePublic  = "public"
+	ProfileMembers = "members"
+	ProfilePrivate = "private"
+)
+
+type ProfileVisibility struct {
+	Code     string
+	LabelKey string
+}
+
+var ProfileVisibilities = []ProfileVisibility{
+	{Code: ProfilePublic, LabelKey: "profile.visibility.public"},
+	{Code: ProfileMembers, LabelKey: "profile.visibility.members"},
+	{Code: ProfilePrivate, LabelKey: "profile.visibility.private"},
+}
+
+type Profile struct {
+	UserID       int
+	Username     string
+	Bio          string
+	AvatarKey    string
+	AvatarType   string
+	Visibility   string
+	ShowLiked    bool
+	JoinedAt     time.Time
+	PostCount    int
+	CommentCount int
+	Reputation   int
+}
+
+type ProfileComment struct {
+	ID        int
+	PostID    int
+	PostTitle string
+	Content   string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+type ProfilePageData struct {
+	CurrentUser *User
+	Profile     Profile
+	IsOwner     bool
+	Hidden      bool
+	Tab         string
+	Posts       []PostCard
+	Comments    []ProfileComment
+	Page        int
+	HasNext     bool
+}
+
+type ProfileEditPageData struct {
+	CurrentUser  *User
+	Profile      Profile
+	Visibilities []ProfileVisibility
+	Error        string
+}
+
+func ValidProfileVisibility(code string) bool {
+	for _, v := range ProfileVisibilities {
+		if v.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+func (p Profile) AvatarURL() string {
+	if p.AvatarKey == "" {
+		return ""
+	}
+	return "/avatars/" + p.AvatarKey
+}
+
+func (p Profile) Initial() string {
+	for _, r := range p.Username {
+		return string(r)
+	}
+	return "?"
+}
+
+// VisibleTo reports whether viewer (nil for guests) may see the profile's
+// activity. The owner always sees their own profile.
+func (p Profile) VisibleTo(viewer *User) bool {
+	if viewer != nil && viewer.ID == p.UserID {
+		return true
+	}
+	switch p.Visibility {
+	case ProfilePrivate:
+		return false
+	case ProfileMembers:
+		return viewer != nil
+	default:
+		return true
+	}
+}
+
+func (d ProfilePageData) PrevPage() int {
+	return d.Page - 1
+}
+
+func (d ProfilePageData) NextPage() int {
+	return d.Page + 1
+}
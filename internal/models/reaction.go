@@ -0,0 +1,147 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+type ReactionKind struct {
+	Code  string `json:"code"`
+	Emoji string `json:"emoji"`
+	Score int    `json:"score"`
+}
+
+// DefaultReactionKinds are offered when no reaction config is given.
+var DefaultReactionKinds = []ReactionKind{
+	{Code: "like", Emoji: "👍", Score: 1},
+	{Code: "dislike", Emoji: "👎", Score: -1},
+	{Code: "heart", Emoji: "❤️"},
+	{Code: "laugh", Emoji: "😂"},
+	{Code: "wow", Emoji: "😮"},
+	{Code: "party", Emoji: "🎉"},
+}
+
+// ReactionKinds are the reactions the forum offers, in display order. They
+// are set once at startup by SetReactionKinds and only read afterwards.
+var ReactionKinds = DefaultReactionKinds
+
+// reactionCode keeps codes safe to use in form values, URLs and the SQL that
+// maps kinds to scores.
+var reactionCode = regexp.MustCompile(`^[a-z0-9_]{1,32}$`)
+
+// ParseReactionKinds reads a JSON list of reaction kinds and checks it.
+func ParseReactionKinds(data []byte) ([]ReactionKind, error) {
+	var kinds []ReactionKind
+	if err := json.Unmarshal(data, &kinds); err != nil {
+		return nil, err
+	}
+	if err := ValidateReactionKinds(kinds); err != nil {
+		return nil, err
+	}
+	return kinds, nil
+}
+
+// ValidateReactionKinds reports kinds with a bad or repeated code, without an
+// emoji, or with a score other than -1, 0 or 1.
+func ValidateReactionKinds(kinds []ReactionKind) error {
+	if len(kinds) == 0 {
+		return errors.New("no reaction kinds")
+	}
+	seen := make(map[string]bool, len(kinds))
+	for _, k := range kinds {
+		switch {
+		case !reactionCode.MatchString(k.Code):
+			return fmt.Errorf("reaction kind %q: code must be 1-32 of a-z, 0-9 and _", k.Code)
+		case seen[k.Code]:
+			return fmt.Errorf("reaction kind %q is listed twice", k.Code)
+		case k.Emoji == "":
+			return fmt.Errorf("reaction kind %q has no emoji", k.Code)
+		case k.Score < -1 || k.Score > 1:
+			return fmt.Errorf("reaction kind %q: score must be -1, 0 or 1", k.Code)
+		}
+		seen[k.Code] = true
+	}
+	return nil
+}
+
+// SetReactionKinds replaces the offered kinds. It must run before the server
+// starts.
+func SetReactionKinds(kinds []ReactionKind) error {
+	if err := ValidateReactionKinds(kinds); err != nil {
+		return err
+	}
+	ReactionKinds = kinds
+	return nil
+}
+
+func ReactionKindByCode(code string) (ReactionKind, bool) {
+	for _, k := range ReactionKinds {
+		if k.Code == code {
+			return k, true
+		}
+	}
+	return ReactionKind{}, false
+}
+
+// ReactionKindByScore returns the first kind scored like sign, which is what
+// the old like and dislike buttons (value=1 and value=-1) stand for.
+func ReactionKindByScore(sign int) (ReactionKind, bool) {
+	for _, k := range ReactionKinds {
+		if sign != 0 && k.Score == sign {
+			return k, true
+		}
+	}
+	return ReactionKind{}, false
+}
+
+// ReactionCodesByScore returns the codes of the kinds scored like sign.
+func ReactionCodesByScore(sign int) []string {
+	var codes []string
+	for _, k := range ReactionKinds {
+		if k.Score == sign {
+			codes = append(codes, k.Code)
+		}
+	}
+	return codes
+}
+
+// NewReactionSummaries returns an empty summary for every kind. Users starts
+// out empty rather than nil, so JSON clients always get a list.
+func NewReactionSummaries() []ReactionSummary {
+	summaries := make([]ReactionSummary, len(ReactionKinds))
+	for i, k := range ReactionKinds {
+		summaries[i] = ReactionSummary{Kind: k.Code, Emoji: k.Emoji, Users: []string{}}
+	}
+	return summaries
+}
+
+type ReactionSummary struct {
+	Kind  string   `json:"kind"`
+	Emoji string   `json:"emoji"`
+	Count int      `json:"count"`
+	Users []string `json:"users"`
+	Mine  bool     `json:"mine"`
+}
+
+// ReactionState is the outcome of a toggle. Active says whether the reaction
+// is set afterwards; Added whether this toggle stored it, which is false when
+// a concurrent one got there first.
+type ReactionState struct {
+	Kind      string            `json:"kind"`
+	Active    bool              `json:"active"`
+	Added     bool              `json:"-"`
+	Likes     int               `json:"likes"`
+	Dislikes  int               `json:"dislikes"`
+	Reactions []ReactionSummary `json:"reactions"`
+}
+
+type ReactionBar struct {
+	TargetType string
+	Action     string
+	IDField    string
+	TargetID   int
+	Next       string
+	Reactions  []ReactionSummary
+}
@@ -0,0 +1,58 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseReactionKinds(t *testing.T) {
+	kinds, err := ParseReactionKinds([]byte(`[
+		{"code": "up", "emoji": "⬆️", "score": 1},
+		{"code": "down", "emoji": "⬇️", "score": -1},
+		{"code": "fire", "emoji": "🔥"}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kinds) != 3 || kinds[0].Code != "up" || kinds[1].Score != -1 || kinds[2].Score != 0 {
+		t.Errorf("ParseReactionKinds = %+v", kinds)
+	}
+
+	tests := []struct {
+		name string
+		json string
+		want string
+	}{
+		{"empty", `[]`, "no reaction kinds"},
+		{"quote in code", `[{"code": "x' OR 1=1", "emoji": "x"}]`, "code must be"},
+		{"upper case", `[{"code": "Like", "emoji": "x"}]`, "code must be"},
+		{"duplicate", `[{"code": "a", "emoji": "x"}, {"code": "a", "emoji": "y"}]`, "listed twice"},
+		{"no emoji", `[{"code": "a"}]`, "no emoji"},
+		{"score", `[{"code": "a", "emoji": "x", "score": 2}]`, "score must be"},
+		{"not json", `{`, "unexpected end"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseReactionKinds([]byte(tt.json))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestReactionKindByScore(t *testing.T) {
+	defer func(kinds []ReactionKind) { ReactionKinds = kinds }(ReactionKinds)
+	if err := SetReactionKinds([]ReactionKind{{Code: "fire", Emoji: "🔥"}, {Code: "up", Emoji: "⬆️", Score: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if k, ok := ReactionKindByScore(1); !ok || k.Code != "up" {
+		t.Errorf("ReactionKindByScore(1) = %v, %v; want up", k, ok)
+	}
+	if _, ok := ReactionKindByScore(-1); ok {
+		t.Error("ReactionKindByScore(-1) found a kind none is scored with")
+	}
+	if _, ok := ReactionKindByScore(0); ok {
+		t.Error("ReactionKindByScore(0) stands for no button")
+	}
+}
@@ -0,0 +1,29 @@
+package models
+
+import "html/template"
+
+// TwoFactorPageData drives both the settings page and the enrolment that
+// staff must finish during login when 2FA is required for them.
+type TwoFactorPageData struct {
+	CurrentUser   *User
+	Enabled       bool
+	Required      bool
+	Login         bool
+	QRCode        template.URL
+	Secret        string
+	RecoveryCodes []string
+	Remaining     int
+	Error         string
+}
+
+type LoginTwoFactorPageData struct {
+	CurrentUser *User
+	Error       string
+}
+
+type AdminSecurityPageData struct {
+	CurrentUser  *User
+	RequireStaff bool
+	Error        string
+	Success      string
+}
@@ -1,8 +1,68 @@
 package models
 
+import "time"
+
 type User struct {
 	ID       int
 	Email    string
 	Username string
 	Password string
+	Role     string
+	Locale   string
+	Timezone string
+
+	TOTPEnabled bool
+
+	// EmailVerified is set once the owner has shown they hold Email, by
+	// confirming a change to it or through a provider that vouched for it.
+	// Registering with an address does not verify it.
+	EmailVerified bool
+
+	// ConfirmedAt is when the current session last confirmed the account
+	// through a linked sign-in provider, which stands in for the password.
+	ConfirmedAt time.Time
+
+	UnreadNotifications int
+}
+
+// HasPassword reports whether the account has a local password. Accounts
+// created through a sign-in provider have none until the owner sets one.
+func (u User) HasPassword() bool {
+	return u.Password != ""
 }
+
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+
+	// RoleDeleted marks the one placeholder account that anonymised content
+	// is handed over to. No one can register or be given it.
+	RoleDeleted = "deleted"
+)
+
+// Staff reports whether the user moderates or administers the forum.
+func (u User) Staff() bool {
+	return u.Role == RoleModerator || u.Role == RoleAdmin
+}
+
+type SettingsPageData struct {
+	CurrentUser  *User
+	PendingEmail string
+	Export       *DataExport
+	Identities   []ExternalIdentity
+	Providers    []LoginProvider
+	Timezone     string
+	Timezones    []string
+	// Confirmed is set while a confirmation through a sign-in provider
+	// stands in for the password; Confirmable lists the providers the user
+	// can confirm through.
+	Confirmed   bool
+	Confirmable []LoginProvider
+	Error       string
+	Success     string
+}
+
+// DeletedUsername is shown in place of the author of content whose account
+// was deleted with anonymisation.
+const DeletedUsername = "[deleted user]"
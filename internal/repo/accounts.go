@@ -0,0 +1,253 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"forum/internal/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	AccountAnonymize = "anonymize"
+	AccountRemove    = "remove"
+)
+
+var (
+	ErrUnknownDeleteMode  = errors.New("unknown account delete mode")
+	ErrEmailChangeExpired = errors.New("email change expired or not found")
+)
+
+// deletedUserEmail is the address of the placeholder account. The account
+// is found by its role, never by its address; .invalid is a domain that
+// cannot receive mail, and no user can register or change to an address in
+// it, see reservedEmail.
+const deletedUserEmail = "deleted-user@invalid"
+
+// reservedEmail and reservedUsername report whether an address or name is
+// kept for the placeholder account, which users may not take.
+func reservedEmail(email string) bool {
+	email = strings.ToLower(strings.TrimSpace(email))
+	return strings.HasSuffix(email, "@invalid") || strings.HasSuffix(email, ".invalid")
+}
+
+func reservedUsername(username string) bool {
+	return FoldUsername(strings.TrimSpace(username)) == FoldUsername(models.DeletedUsername)
+}
+
+func UpdateUsername(ctx context.Context, db *DB, userID int, username string) error {
+	if reservedUsername(username) {
+		return ErrUsernameTaken
+	}
+	_, err := db.ExecContext(ctx, `UPDATE users SET username = ?, username_folded = ? WHERE id = ?`, username, FoldUsername(username), userID)
+	return uniqueUserError(db.Dialect, err)
+}
+
+func UpdatePassword(ctx context.Context, db *DB, userID int, password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, `UPDATE users SET password = ? WHERE id = ?`, string(hash), userID)
+	return err
+}
+
+// UpdateLocale stores the user's language; "" means follow the browser.
+func UpdateLocale(ctx context.Context, db *DB, userID int, locale string) error {
+	_, err := db.ExecContext(ctx, `UPDATE users SET locale = ? WHERE id = ?`, locale, userID)
+	return err
+}
+
+// UpdateTimezone stores the IANA zone the user's times are shown in; ""
+// goes back to the browser's or the site's zone.
+func UpdateTimezone(ctx context.Context, db *DB, userID int, timezone string) error {
+	_, err := db.ExecContext(ctx, `UPDATE users SET timezone = ? WHERE id = ?`, timezone, userID)
+	return err
+}
+
+// CreateEmailChange stores a pending address until it is confirmed with the
+// token whose hash is given. A new request replaces the previous one.
+func CreateEmailChange(ctx context.Context, db *DB, userID int, email string, tokenHash string, expiresAt time.Time) error {
+	if reservedEmail(email) {
+		return ErrEmailTaken
+	}
+	_, err := db.ExecContext(ctx,
+		`INSERT INTO email_changes (user_id, email, token_hash, expires_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET email = excluded.email, token_hash = excluded.token_hash, expires_at = excluded.expires_at`,
+		userID, email, tokenHash, expiresAt.UTC(),
+	)
+	return err
+}
+
+func GetPendingEmail(ctx context.Context, db *DB, userID int) (string, error) {
+	var email string
+	err := db.QueryRowContext(ctx,
+		`SELECT email FROM email_changes WHERE user_id = ? AND expires_at > ?`,
+		userID, now(),
+	).Scan(&email)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return email, err
+}
+
+func ConfirmEmailChange(ctx context.Context, db *DB, tokenHash string) (int, error) {
+	tx, err := db.BeginTx(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var userID int
+	var email string
+	var expiresAt time.Time
+	err = tx.QueryRowContext(ctx,
+		`SELECT user_id, email, expires_at FROM email_changes WHERE token_hash = ?`,
+		tokenHash,
+	).Scan(&userID, &email, &expiresAt)
+	if err == sql.ErrNoRows || (err == nil && now().After(expiresAt)) {
+		_ = tx.Rollback()
+		return 0, ErrEmailChangeExpired
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return 0, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE users SET email = ?, email_verified = TRUE WHERE id = ?`, email, userID); err != nil {
+		_ = tx.Rollback()
+		return 0, uniqueUserError(db.Dialect, err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM email_changes WHERE user_id = ?`, userID); err != nil {
+		_ = tx.Rollback()
+		return 0, err
+	}
+
+	return userID, tx.Commit()
+}
+
+// DeleteAccount removes a user in one transaction. With AccountAnonymize the
+// user's posts, comments and attachments are handed over to a shared
+// "[deleted user]" account; with AccountRemove they are deleted together with
+// everything attached to them. Reactions, sessions, notifications and
+// mentions of the user are always removed. It returns the blob keys the
+// removed rows referenced: the avatar and, with AccountRemove, the
+// attachments. Keys are content-addressed and may be shared, so the caller
+// hands them to ReleaseBlobs rather than deleting them.
+func DeleteAccount(ctx context.Context, db *DB, userID int, mode string) ([]string, error) {
+	if mode != AccountAnonymize && mode != AccountRemove {
+		return nil, ErrUnknownDeleteMode
+	}
+
+	tx, err := db.BeginTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	keysQuery := `SELECT avatar_key FROM users WHERE id = ? AND avatar_key <> ''`
+	keysArgs := []any{userID}
+	if mode == AccountRemove {
+		keysQuery += ` UNION SELECT storage_key FROM attachments WHERE post_id IN (SELECT id FROM posts WHERE user_id = ?)`
+		keysArgs = append(keysArgs, userID)
+	}
+	keys, err := queryStrings(ctx, tx, keysQuery, keysArgs...)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	var statements []string
+	var args [][]any
+	add := func(query string, a ...any) {
+		statements = append(statements, query)
+		args = append(args, a)
+	}
+
+	if mode == AccountAnonymize {
+		deletedID, err := deletedUserID(ctx, tx)
+		if err != nil {
+			_ = tx.Rollback()
+			return nil, err
+		}
+		add(`UPDATE posts SET user_id = ? WHERE user_id = ?`, deletedID, userID)
+		add(`UPDATE comments SET user_id = ? WHERE user_id = ?`, deletedID, userID)
+		add(`UPDATE attachments SET user_id = ? WHERE user_id = ?`, deletedID, userID)
+	} else {
+		userPosts := `SELECT id FROM posts WHERE user_id = ?`
+		removedComments := `SELECT id FROM comments WHERE user_id = ? OR post_id IN (` + userPosts + `)`
+
+		addTargetCleanup(add, userPosts, []any{userID}, removedComments, []any{userID, userID})
+		add(`DELETE FROM notifications WHERE comment_id IN (`+removedComments+`) OR post_id IN (`+userPosts+`)`, userID, userID, userID)
+		add(`DELETE FROM comments WHERE user_id = ? OR post_id IN (`+userPosts+`)`, userID, userID)
+		add(`DELETE FROM attachments WHERE post_id IN (`+userPosts+`)`, userID)
+		add(`DELETE FROM post_categories WHERE post_id IN (`+userPosts+`)`, userID)
+		add(`DELETE FROM posts WHERE user_id = ?`, userID)
+	}
+
+	add(`DELETE FROM reactions WHERE user_id = ?`, userID)
+	add(`DELETE FROM mentions WHERE user_id = ?`, userID)
+	add(`DELETE FROM notifications WHERE user_id = ? OR actor_id = ?`, userID, userID)
+	add(`DELETE FROM notification_preferences WHERE user_id = ?`, userID)
+	add(`DELETE FROM email_changes WHERE user_id = ?`, userID)
+	add(`DELETE FROM data_exports WHERE user_id = ?`, userID)
+	add(`DELETE FROM recovery_codes WHERE user_id = ?`, userID)
+	add(`DELETE FROM login_challenges WHERE user_id = ?`, userID)
+	add(`DELETE FROM external_identities WHERE user_id = ?`, userID)
+	add(`DELETE FROM auth_states WHERE link_user_id = ?`, userID)
+	add(`DELETE FROM sessions WHERE user_id = ?`, userID)
+	add(`DELETE FROM users WHERE id = ?`, userID)
+
+	for i, query := range statements {
+		if _, err := tx.ExecContext(ctx, query, args[i]...); err != nil {
+			_ = tx.Rollback()
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// addTargetCleanup adds the statements that delete the reactions and mentions
+// of the posts and comments the two subqueries select. Those tables name their
+// target by type and id, which no foreign key can express, so nothing
+// cascades to them: every path that deletes posts or comments has to run
+// these first, while the subqueries still find the rows.
+func addTargetCleanup(add func(query string, a ...any), posts string, postArgs []any, comments string, commentArgs []any) {
+	add(`DELETE FROM reactions WHERE target_type = 'comment' AND target_id IN (`+comments+`)`, commentArgs...)
+	add(`DELETE FROM reactions WHERE target_type = 'post' AND target_id IN (`+posts+`)`, postArgs...)
+	add(`DELETE FROM mentions WHERE source_type = 'comment' AND source_id IN (`+comments+`)`, commentArgs...)
+	add(`DELETE FROM mentions WHERE source_type = 'post' AND source_id IN (`+posts+`)`, postArgs...)
+}
+
+// deletedUserID returns the id of the placeholder account that anonymised
+// content is reassigned to, creating it on first use. It is the one account
+// with RoleDeleted, which a unique index keeps to one, and it has no usable
+// password, so nobody can sign in as it.
+func deletedUserID(ctx context.Context, tx *Tx) (int, error) {
+	// A database from before addresses were reserved may have an account
+	// on deletedUserEmail already; the placeholder then takes another one.
+	emails := []string{deletedUserEmail, "deleted-user-" + strconv.FormatInt(now().UnixNano(), 36) + "@invalid"}
+	for i := 0; ; i++ {
+		var id int
+		err := tx.QueryRowContext(ctx, `SELECT id FROM users WHERE role = ?`, models.RoleDeleted).Scan(&id)
+		if err != sql.ErrNoRows {
+			return id, err
+		}
+		if i == len(emails) {
+			return 0, errors.New("no free address for the deleted user placeholder")
+		}
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO users (email, username, username_folded, password, role, created_at) VALUES (?, ?, ?, '', ?, ?) ON CONFLICT DO NOTHING`,
+			emails[i], models.DeletedUsername, FoldUsername(models.DeletedUsername), models.RoleDeleted, now(),
+		)
+		if err != nil {
+			return 0, err
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package repo_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"forum/internal/models"
+	"forum/internal/repo"
+)
+
+// count runs a SELECT COUNT(*) query.
+func count(t *testing.T, db *repo.DB, query string, args ...any) int {
+	t.Helper()
+	var n int
+	if err := db.QueryRowContext(context.Background(), query, args...).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// account is ann's activity on a forum shared with bob: a post that bob
+// commented on and reacted to, a comment on bob's post, and mentions of bob.
+type account struct {
+	db                     *repo.DB
+	ann, bob               int
+	annPost, bobPost       int
+	bobComment, annComment int
+}
+
+func newAccount(t *testing.T) *account {
+	t.Helper()
+	db := openTestDB(t)
+	ctx := context.Background()
+	a := &account{db: db}
+	a.ann = createUser(t, db, "ann")
+	a.bob = createUser(t, db, "bob")
+	a.annPost = createPost(t, db, a.ann, "ann's post")
+	a.bobPost = createPost(t, db, a.bob, "bob's post")
+
+	var err error
+	if a.bobComment, err = repo.CreateComment(ctx, db, a.annPost, a.bob, "bob on ann's post"); err != nil {
+		t.Fatal(err)
+	}
+	if a.annComment, err = repo.CreateComment(ctx, db, a.bobPost, a.ann, "ann on bob's post"); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []struct {
+		user       int
+		targetType string
+		target     int
+	}{
+		{a.bob, repo.ReactionTargetPost, a.annPost},
+		{a.bob, repo.ReactionTargetComment, a.bobComment},
+		{a.bob, repo.ReactionTargetComment, a.annComment},
+		{a.ann, repo.ReactionTargetPost, a.bobPost},
+	} {
+		if _, err := repo.ToggleReaction(ctx, db, r.user, r.targetType, r.target, "like"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := repo.SaveMentions(ctx, db, repo.MentionSourcePost, a.annPost, []string{"bob"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.SaveMentions(ctx, db, repo.MentionSourceComment, a.annComment, []string{"bob"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.CreateSessions(ctx, db, a.ann); err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestDeleteAccountAnonymize(t *testing.T) {
+	a := newAccount(t)
+	ctx := context.Background()
+
+	if _, err := repo.DeleteAccount(ctx, a.db, a.ann, repo.AccountAnonymize); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.GetUserByID(ctx, a.db, a.ann); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("deleted user: err = %v, want sql.ErrNoRows", err)
+	}
+
+	post, err := repo.GetPostByID(ctx, a.db, a.annPost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	owner, err := repo.GetUserByID(ctx, a.db, post.UserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owner.Username != models.DeletedUsername || owner.Role != models.RoleDeleted || owner.HasPassword() {
+		t.Errorf("post owner = %q with role %q, has password %v", owner.Username, owner.Role, owner.HasPassword())
+	}
+	comment, err := repo.GetCommentByID(ctx, a.db, a.annComment, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if comment.UserID != post.UserID {
+		t.Errorf("comment owner = %d, want %d", comment.UserID, post.UserID)
+	}
+
+	// What others did to the content stays; what ann did goes.
+	if n := count(t, a.db, `SELECT COUNT(*) FROM reactions`); n != 3 {
+		t.Errorf("%d reactions left, want bob's 3", n)
+	}
+	if n := count(t, a.db, `SELECT COUNT(*) FROM reactions WHERE user_id = ?`, a.ann); n != 0 {
+		t.Errorf("%d reactions by ann left", n)
+	}
+	if n := count(t, a.db, `SELECT COUNT(*) FROM mentions`); n != 2 {
+		t.Errorf("%d mentions left, want 2", n)
+	}
+	if n := count(t, a.db, `SELECT COUNT(*) FROM sessions WHERE user_id = ?`, a.ann); n != 0 {
+		t.Errorf("%d sessions of ann left", n)
+	}
+
+	// A second anonymised account shares the placeholder.
+	carl := createUser(t, a.db, "carl")
+	createPost(t, a.db, carl, "carl's post")
+	if _, err := repo.DeleteAccount(ctx, a.db, carl, repo.AccountAnonymize); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(t, a.db, `SELECT COUNT(*) FROM users WHERE username = ?`, models.DeletedUsername); n != 1 {
+		t.Errorf("%d placeholder accounts, want 1", n)
+	}
+}
+
+func TestDeleteAccountRemove(t *testing.T) {
+	a := newAccount(t)
+	ctx := context.Background()
+
+	if _, err := repo.DeleteAccount(ctx, a.db, a.ann, repo.AccountRemove); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.GetUserByID(ctx, a.db, a.ann); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("deleted user: err = %v, want sql.ErrNoRows", err)
+	}
+	if ok, err := repo.PostExists(ctx, a.db, a.annPost); err != nil || ok {
+		t.Errorf("PostExists(ann's post) = %v, %v, want false", ok, err)
+	}
+	for _, id := range []int{a.bobComment, a.annComment} {
+		if ok, err := repo.CommentExists(ctx, a.db, id); err != nil || ok {
+			t.Errorf("CommentExists(%d) = %v, %v, want false", id, ok, err)
+		}
+	}
+	if ok, err := repo.PostExists(ctx, a.db, a.bobPost); err != nil || !ok {
+		t.Errorf("PostExists(bob's post) = %v, %v, want true", ok, err)
+	}
+
+	// Reactions and mentions have no foreign key to their target, so
+	// nothing may be left pointing at the removed posts and comments.
+	if n := count(t, a.db, `SELECT COUNT(*) FROM reactions`); n != 0 {
+		t.Errorf("%d reactions left, want 0", n)
+	}
+	if n := count(t, a.db, `SELECT COUNT(*) FROM mentions`); n != 0 {
+		t.Errorf("%d mentions left, want 0", n)
+	}
+	if n := count(t, a.db, `SELECT COUNT(*) FROM post_categories WHERE post_id = ?`, a.annPost); n != 0 {
+		t.Errorf("%d categories of ann's post left", n)
+	}
+}
+
+// TestDeleteAccountPlaceholderIgnoresSquatter checks that content is never
+// handed to an account that took the placeholder's address before it was
+// reserved.
+func TestDeleteAccountPlaceholderIgnoresSquatter(t *testing.T) {
+	a := newAccount(t)
+	ctx := context.Background()
+	if _, err := a.db.ExecContext(ctx,
+		`INSERT INTO users (email, username, username_folded, password, created_at) VALUES ('deleted-user@invalid', 'squatter', 'squatter', 'x', ?)`,
+		time.Now().UTC(),
+	); err != nil {
+		t.Fatal(err)
+	}
+	squatter, err := repo.GetUserByEmail(ctx, a.db, "deleted-user@invalid")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := repo.DeleteAccount(ctx, a.db, a.ann, repo.AccountAnonymize); err != nil {
+		t.Fatal(err)
+	}
+	post, err := repo.GetPostByID(ctx, a.db, a.annPost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if post.UserID == squatter.ID {
+		t.Fatal("ann's post was handed to the account holding the placeholder's address")
+	}
+	owner, err := repo.GetUserByID(ctx, a.db, post.UserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owner.Role != models.RoleDeleted {
+		t.Errorf("post owner %q has role %q, want %q", owner.Username, owner.Role, models.RoleDeleted)
+	}
+	if err := repo.PromoteAdmins(ctx, a.db, []string{owner.Email}); err != nil {
+		t.Fatal(err)
+	}
+	if owner, _ = repo.GetUserByID(ctx, a.db, owner.ID); owner.Role != models.RoleDeleted {
+		t.Errorf("the placeholder was promoted to %q", owner.Role)
+	}
+}
+
+func TestPlaceholderNamesAreReserved(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	ann := createUser(t, db, "ann")
+
+	for _, email := range []string{"deleted-user@invalid", " Deleted-User@INVALID", "ann@forum.invalid"} {
+		if err := repo.CreateUser(ctx, db, email, "someone", "pw"); !errors.Is(err, repo.ErrEmailTaken) {
+			t.Errorf("CreateUser with %q = %v, want ErrEmailTaken", email, err)
+		}
+		if err := repo.CreateEmailChange(ctx, db, ann, email, "hash", time.Now().Add(time.Hour)); !errors.Is(err, repo.ErrEmailTaken) {
+			t.Errorf("CreateEmailChange to %q = %v, want ErrEmailTaken", email, err)
+		}
+	}
+	for _, username := range []string{models.DeletedUsername, "[Deleted User]"} {
+		if err := repo.CreateUser(ctx, db, "someone@example.com", username, "pw"); !errors.Is(err, repo.ErrUsernameTaken) {
+			t.Errorf("CreateUser as %q = %v, want ErrUsernameTaken", username, err)
+		}
+		if err := repo.UpdateUsername(ctx, db, ann, username); !errors.Is(err, repo.ErrUsernameTaken) {
+			t.Errorf("UpdateUsername to %q = %v, want ErrUsernameTaken", username, err)
+		}
+	}
+}
+
+func TestDeleteAccountReturnsBlobKeys(t *testing.T) {
+	tests := []struct {
+		mode string
+		want []string
+	}{
+		{repo.AccountAnonymize, []string{"avatar.png"}},
+		{repo.AccountRemove, []string{"avatar.png", "only-ann.png", "shared.png"}},
+	}
+	for _, tt := range tests {
+		db := openTestDB(t)
+		ctx := context.Background()
+		ann := createUser(t, db, "ann")
+		bob := createUser(t, db, "bob")
+		if err := repo.SetAvatar(ctx, db, ann, "avatar.png", "image/png"); err != nil {
+			t.Fatal(err)
+		}
+		createAttachedPost(t, db, ann, "only-ann.png", "shared.png")
+		createAttachedPost(t, db, bob, "shared.png")
+
+		keys, err := repo.DeleteAccount(ctx, db, ann, tt.mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		slices.Sort(keys)
+		if !slices.Equal(keys, tt.want) {
+			t.Errorf("%s: keys = %v, want %v", tt.mode, keys, tt.want)
+		}
+	}
+}
+
+func TestDeleteAccountUnknownMode(t *testing.T) {
+	db := openTestDB(t)
+	ann := createUser(t, db, "ann")
+	if _, err := repo.DeleteAccount(context.Background(), db, ann, "shred"); !errors.Is(err, repo.ErrUnknownDeleteMode) {
+		t.Errorf("err = %v, want ErrUnknownDeleteMode", err)
+	}
+	if _, err := repo.GetUserByID(context.Background(), db, ann); err != nil {
+		t.Errorf("user is gone: %v", err)
+	}
+}
+
+func TestCreateUser(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	if err := repo.CreateUser(ctx, db, "ann@example.com", "ann", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	ann, err := repo.GetUserByEmail(ctx, db, "ann@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ann.HasPassword() || ann.Password == "secret" {
+		t.Errorf("password not stored as a hash: %q", ann.Password)
+	}
+
+	if err := repo.CreateUser(ctx, db, "bob@example.com", "bob", ""); err != nil {
+		t.Fatal(err)
+	}
+	bob, err := repo.GetUserByUsername(ctx, db, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bob.HasPassword() {
+		t.Errorf("passwordless account has password %q", bob.Password)
+	}
+
+	if err := repo.CreateUser(ctx, db, "ann@example.com", "ann2", "secret"); !errors.Is(err, repo.ErrEmailTaken) {
+		t.Errorf("duplicate email: err = %v, want ErrEmailTaken", err)
+	}
+}
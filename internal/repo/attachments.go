@@ -0,0 +1,123 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+
+	"forum/internal/models"
+)
+
+func insertAttachments(ctx context.Context, tx *Tx, postID int64, userID int, attachments []models.Attachment) error {
+	for _, a := range attachments {
+		_, err := tx.ExecContext(ctx, `
+            INSERT INTO attachments (post_id, user_id, storage_key, content_type, size, width, height, original_name, created_at)
+            VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+        `, postID, userID, a.StorageKey, a.ContentType, a.Size, a.Width, a.Height, a.OriginalName, now())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func GetAttachmentsByPostIDs(ctx context.Context, db *DB, postIDs []int) (map[int][]models.Attachment, error) {
+	result := make(map[int][]models.Attachment, len(postIDs))
+	if len(postIDs) == 0 {
+		return result, nil
+	}
+
+	for _, batch := range idBatches(postIDs) {
+		if err := addAttachments(ctx, db, result, batch); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func addAttachments(ctx context.Context, db *DB, result map[int][]models.Attachment, postIDs []int) error {
+	args := make([]any, 0, len(postIDs))
+	for _, id := range postIDs {
+		args = append(args, id)
+	}
+	rows, err := db.QueryContext(ctx, `
+    SELECT id, post_id, user_id, storage_key, content_type, size, width, height, original_name, created_at
+    FROM attachments
+    WHERE post_id IN (`+placeholders(len(postIDs))+`)
+    ORDER BY id
+    `, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a models.Attachment
+		if err := rows.Scan(&a.ID, &a.PostID, &a.UserID, &a.StorageKey, &a.ContentType, &a.Size,
+			&a.Width, &a.Height, &a.OriginalName, &a.CreatedAt); err != nil {
+			return err
+		}
+		result[a.PostID] = append(result[a.PostID], a)
+	}
+	return rows.Err()
+}
+
+func GetAttachmentByKey(ctx context.Context, db *DB, storageKey string) (*models.Attachment, error) {
+	row := db.QueryRowContext(ctx, `
+    SELECT id, post_id, user_id, storage_key, content_type, size, width, height, original_name, created_at
+    FROM attachments
+    WHERE storage_key = ?
+    LIMIT 1
+    `, storageKey)
+
+	var a models.Attachment
+	err := row.Scan(&a.ID, &a.PostID, &a.UserID, &a.StorageKey, &a.ContentType, &a.Size,
+		&a.Width, &a.Height, &a.OriginalName, &a.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// ReleaseBlobs calls remove for each of keys that no attachment or avatar
+// references any more. Blobs are named by their content, so a post stored
+// meanwhile may have taken a key up again; each key is checked and removed
+// in a transaction that keeps new references out until remove returns.
+// Writers store a blob before the row that references it, so once their row
+// is committed they check that the blob is still there.
+func ReleaseBlobs(ctx context.Context, db *DB, keys []string, remove func(ctx context.Context, key string) error) error {
+	for _, key := range keys {
+		if err := releaseBlob(ctx, db, key, remove); err != nil {
+			return fmt.Errorf("release %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+func releaseBlob(ctx context.Context, db *DB, key string, remove func(ctx context.Context, key string) error) error {
+	// SQLite transactions take the write lock when they begin; PostgreSQL
+	// needs the tables locked against writers explicitly.
+	tx, err := db.BeginTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if db.Dialect == Postgres {
+		if _, err := tx.ExecContext(ctx, `LOCK TABLE attachments, users IN SHARE MODE`); err != nil {
+			return err
+		}
+	}
+
+	var used bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM attachments WHERE storage_key = ?)
+			OR EXISTS (SELECT 1 FROM users WHERE avatar_key = ?)`,
+		key, key,
+	).Scan(&used)
+	if err != nil || used {
+		return err
+	}
+	if err := remove(ctx, key); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
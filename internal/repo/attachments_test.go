@@ -0,0 +1,132 @@
+package repo_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"forum/internal/models"
+	"forum/internal/repo"
+)
+
+// createAttachedPost creates a post by userID with an image under each of
+// keys and returns its id.
+func createAttachedPost(t testing.TB, db *repo.DB, userID int, keys ...string) int {
+	t.Helper()
+	attachments := make([]models.Attachment, len(keys))
+	for i, key := range keys {
+		attachments[i] = models.Attachment{StorageKey: key, ContentType: "image/png", Size: 10, Width: 2, Height: 2, OriginalName: key}
+	}
+	id, err := repo.CreatePost(context.Background(), db, userID, "with pictures", "content", []int{1}, attachments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestGetAttachments(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	ann := createUser(t, db, "ann")
+	first := createAttachedPost(t, db, ann, "a.png", "b.png")
+	second := createAttachedPost(t, db, ann, "c.png")
+	bare := createPost(t, db, ann, "no pictures")
+
+	byPost, err := repo.GetAttachmentsByPostIDs(ctx, db, []int{first, second, bare})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := func(list []models.Attachment) []string {
+		var out []string
+		for _, a := range list {
+			out = append(out, a.StorageKey)
+		}
+		return out
+	}
+	if got := keys(byPost[first]); !slices.Equal(got, []string{"a.png", "b.png"}) {
+		t.Errorf("attachments of the first post = %v, want [a.png b.png]", got)
+	}
+	if got := keys(byPost[second]); !slices.Equal(got, []string{"c.png"}) {
+		t.Errorf("attachments of the second post = %v, want [c.png]", got)
+	}
+	if len(byPost[bare]) != 0 {
+		t.Errorf("a post without pictures has attachments %v", byPost[bare])
+	}
+
+	att, err := repo.GetAttachmentByKey(ctx, db, "c.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if att.PostID != second || att.UserID != ann || att.ContentType != "image/png" || att.OriginalName != "c.png" {
+		t.Errorf("GetAttachmentByKey(c.png) = %+v", att)
+	}
+	if _, err := repo.GetAttachmentByKey(ctx, db, "missing.png"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetAttachmentByKey(missing) = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestReleaseBlobs(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	ann := createUser(t, db, "ann")
+	createAttachedPost(t, db, ann, "posted.png")
+	if err := repo.SetAvatar(ctx, db, ann, "avatar.png", "image/png"); err != nil {
+		t.Fatal(err)
+	}
+
+	var removed []string
+	remove := func(ctx context.Context, key string) error {
+		removed = append(removed, key)
+		return nil
+	}
+	if err := repo.ReleaseBlobs(ctx, db, []string{"posted.png", "avatar.png", "gone.png"}, remove); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(removed, []string{"gone.png"}) {
+		t.Errorf("removed %v, want only gone.png", removed)
+	}
+
+	// A failing remove is reported.
+	boom := errors.New("disk gone")
+	err := repo.ReleaseBlobs(ctx, db, []string{"gone.png"}, func(context.Context, string) error { return boom })
+	if !errors.Is(err, boom) {
+		t.Errorf("ReleaseBlobs with a failing remove = %v, want %v", err, boom)
+	}
+}
+
+// TestReleaseBlobsHoldsWritersOff checks that a post saved while a blob is
+// being removed waits for the removal, so that its writer can find the blob
+// gone and store it again, rather than be committed before the removal.
+func TestReleaseBlobsHoldsWritersOff(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	ann := createUser(t, db, "ann")
+
+	removing := make(chan struct{})
+	saved := make(chan error, 1)
+	remove := func(context.Context, string) error {
+		close(removing)
+		select {
+		case err := <-saved:
+			t.Errorf("the post was saved during the removal: %v", err)
+		case <-time.After(200 * time.Millisecond):
+		}
+		return nil
+	}
+	go func() {
+		<-removing
+		_, err := repo.CreatePost(ctx, db, ann, "racing", "content", []int{1},
+			[]models.Attachment{{StorageKey: "shared.png", ContentType: "image/png", Size: 10, Width: 2, Height: 2}})
+		saved <- err
+	}()
+
+	if err := repo.ReleaseBlobs(ctx, db, []string{"shared.png"}, remove); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-saved; err != nil {
+		t.Fatalf("the post waiting for the removal failed: %v", err)
+	}
+}
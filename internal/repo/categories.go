@@ -1,24 +1,26 @@
 package repo
 
 import (
+	"context"
 	"database/sql"
 
 	"forum/internal/models"
 )
 
-func SeedCategories(db *sql.DB) error {
-	_, err := db.Exec(`
-		INSERT OR IGNORE INTO categories (name) VALUES
+func SeedCategories(ctx context.Context, db *DB) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO categories (name) VALUES
 		('Математика'),
 		('Рофл'),
 		('Новости'),
-		('Игры');
+		('Игры')
+		ON CONFLICT DO NOTHING;
 	`)
 	return err
 }
 
-func GetAllCategories(db *sql.DB) ([]models.Category, error) {
-	rows, err := db.Query(`SELECT id, name FROM categories ORDER BY name`)
+func GetAllCategories(ctx context.Context, db *DB) ([]models.Category, error) {
+	rows, err := db.QueryContext(ctx, `SELECT id, name FROM categories ORDER BY name`)
 	if err != nil {
 		return nil, err
 	}
@@ -38,8 +40,8 @@ func GetAllCategories(db *sql.DB) ([]models.Category, error) {
 	return categories, nil
 }
 
-func CategoryExists(db *sql.DB, categoryID int) (bool, error) {
-	row := db.QueryRow(`SELECT 1 FROM categories WHERE id = ? LIMIT 1`, categoryID)
+func CategoryExists(ctx context.Context, db *DB, categoryID int) (bool, error) {
+	row := db.QueryRowContext(ctx, `SELECT 1 FROM categories WHERE id = ? LIMIT 1`, categoryID)
 	var one int
 	err := row.Scan(&one)
 	if err == sql.ErrNoRows {
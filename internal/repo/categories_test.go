@@ -0,0 +1,49 @@
+package repo_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"forum/internal/repo"
+)
+
+func TestCategories(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	// openTestDB seeds once; seeding again adds nothing.
+	if err := repo.SeedCategories(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	categories, err := repo.GetAllCategories(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, c := range categories {
+		names = append(names, c.Name)
+	}
+	if want := []string{"Игры", "Математика", "Новости", "Рофл"}; !slices.Equal(names, want) {
+		t.Errorf("categories = %v, want %v", names, want)
+	}
+
+	tests := []struct {
+		id   int
+		want bool
+	}{
+		{categories[0].ID, true},
+		{categories[3].ID, true},
+		{0, false},
+		{999, false},
+	}
+	for _, tt := range tests {
+		got, err := repo.CategoryExists(ctx, db, tt.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("CategoryExists(%d) = %v, want %v", tt.id, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,10 @@
+package repo
+
+import "time"
+
+// now is the time written to the database. Timestamps are stored in UTC so
+// that SQLite, which compares them as text, orders them correctly no matter
+// which zone the server runs in.
+func now() time.Time {
+	return time.Now().UTC()
+}
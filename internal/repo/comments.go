@@ -1,30 +1,60 @@
 package repo
 
 import (
+	"context"
 	"database/sql"
-	"time"
 
 	"forum/internal/models"
 )
 
-func CreateComment(db *sql.DB, postID int, userID int, content string) error {
+func CreateComment(ctx context.Context, db *DB, postID int, userID int, content string) (int, error) {
 	query := `
-        INSERT INTO comments (post_id, user_id, content, created_at)
-        VALUES (?, ?, ?, ?)
+        INSERT INTO comments (post_id, user_id, content, created_at, updated_at)
+        VALUES (?, ?, ?, ?, ?)
+        RETURNING id
     `
-	_, err := db.Exec(query, postID, userID, content, time.Now())
-	return err
+	createdAt := now()
+	var commentID int
+	err := db.QueryRowContext(ctx, query, postID, userID, content, createdAt, createdAt).Scan(&commentID)
+	if err != nil {
+		return 0, err
+	}
+	return commentID, nil
+}
+
+func GetCommentByID(ctx context.Context, db *DB, commentID int, viewerID int) (*models.CommentView, error) {
+	query := `
+    SELECT c.id, c.post_id, c.user_id, u.username, c.content, c.created_at, c.updated_at
+    FROM comments c
+    JOIN users u ON u.id = c.user_id
+    WHERE c.id = ?
+    LIMIT 1
+`
+	var c models.CommentView
+	err := db.QueryRowContext(ctx, query, commentID).Scan(&c.ID, &c.PostID, &c.UserID, &c.AuthorName, &c.Content, &c.CreatedAt, &c.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	reactions, err := GetReactionSummaries(ctx, db, ReactionTargetComment, []int{c.ID}, viewerID)
+	if err != nil {
+		return nil, err
+	}
+	c.Reactions = reactions[c.ID]
+	c.Likes, c.Dislikes = scoreCounts(c.Reactions)
+
+	return &c, nil
 }
 
-func GetCommentsByPostID(db *sql.DB, postID int) ([]models.CommentView, error) {
+func GetCommentsByPostID(ctx context.Context, db *DB, postID int) ([]models.CommentView, error) {
 	query := `
-    SELECT c.id, c.user_id, u.username, c.content, c.created_at
+    SELECT c.id, c.post_id, c.user_id, u.username, c.content, c.created_at, c.updated_at
     FROM comments c
     JOIN users u ON u.id = c.user_id
     WHERE c.post_id = ?
     ORDER BY c.created_at DESC
 `
-	rows, err := db.Query(query, postID)
+	rows, err := db.QueryContext(ctx, query, postID)
 	if err != nil {
 		return nil, err
 	}
@@ -33,7 +63,7 @@ func GetCommentsByPostID(db *sql.DB, postID int) ([]models.CommentView, error) {
 	var comments []models.CommentView
 	for rows.Next() {
 		var c models.CommentView
-		if err := rows.Scan(&c.ID, &c.UserID, &c.AuthorName, &c.Content, &c.CreatedAt); err != nil {
+		if err := rows.Scan(&c.ID, &c.PostID, &c.UserID, &c.AuthorName, &c.Content, &c.CreatedAt, &c.UpdatedAt); err != nil {
 			return nil, err
 		}
 		comments = append(comments, c)
@@ -45,8 +75,8 @@ func GetCommentsByPostID(db *sql.DB, postID int) ([]models.CommentView, error) {
 	return comments, nil
 }
 
-func CommentExists(db *sql.DB, commentID int) (bool, error) {
-	row := db.QueryRow(`SELECT 1 FROM comments WHERE id = ? LIMIT 1`, commentID)
+func CommentExists(ctx context.Context, db *DB, commentID int) (bool, error) {
+	row := db.QueryRowContext(ctx, `SELECT 1 FROM comments WHERE id = ? LIMIT 1`, commentID)
 	var one int
 	err := row.Scan(&one)
 	if err == sql.ErrNoRows {
@@ -0,0 +1,53 @@
+package repo_test
+
+import (
+	"context"
+	"testing"
+
+	"forum/internal/repo"
+)
+
+func TestComments(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	ann := createUser(t, db, "ann")
+	bob := createUser(t, db, "bob")
+	post := createPost(t, db, ann, "post")
+
+	first, err := repo.CreateComment(ctx, db, post, bob, "first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.CreateComment(ctx, db, post, ann, "second"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.ToggleReaction(ctx, db, ann, repo.ReactionTargetComment, first, "like"); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := repo.GetCommentByID(ctx, db, first, ann)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.PostID != post || c.UserID != bob || c.AuthorName != "bob" || c.Content != "first" {
+		t.Errorf("comment = %+v", c)
+	}
+	if c.Likes != 1 || c.Dislikes != 0 {
+		t.Errorf("likes, dislikes = %d, %d, want 1, 0", c.Likes, c.Dislikes)
+	}
+
+	comments, err := repo.GetCommentsByPostID(ctx, db, post)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(comments) != 2 || comments[0].Content != "second" || comments[1].Content != "first" {
+		t.Errorf("comments = %+v, want second then first", comments)
+	}
+
+	if ok, err := repo.CommentExists(ctx, db, first); err != nil || !ok {
+		t.Errorf("CommentExists(%d) = %v, %v, want true", first, ok, err)
+	}
+	if ok, err := repo.CommentExists(ctx, db, first+100); err != nil || ok {
+		t.Errorf("CommentExists(%d) = %v, %v, want false", first+100, ok, err)
+	}
+}
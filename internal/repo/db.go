@@ -0,0 +1,97 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+)
+
+// DB is the connection pool the repo functions run on, paired with the
+// dialect of the database behind it. Every query is rebound for the dialect
+// before it reaches the driver, and every call takes the caller's context so
+// a cancelled request stops its queries.
+type DB struct {
+	pool    *sql.DB
+	Dialect Dialect
+}
+
+func NewDB(pool *sql.DB, dialect Dialect) *DB {
+	return &DB{pool: pool, Dialect: dialect}
+}
+
+// Pool returns the underlying pool for code that manages the database as a
+// whole, such as schema checks and shutdown.
+func (db *DB) Pool() *sql.DB {
+	return db.pool
+}
+
+func (db *DB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return db.pool.ExecContext(ctx, db.Dialect.Rebind(query), args...)
+}
+
+func (db *DB) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return db.pool.QueryContext(ctx, db.Dialect.Rebind(query), args...)
+}
+
+func (db *DB) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return db.pool.QueryRowContext(ctx, db.Dialect.Rebind(query), args...)
+}
+
+func (db *DB) BeginTx(ctx context.Context) (*Tx, error) {
+	tx, err := db.pool.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{tx: tx, dialect: db.Dialect}, nil
+}
+
+// Tx is a transaction on a DB, with the same rebinding.
+type Tx struct {
+	tx      *sql.Tx
+	dialect Dialect
+}
+
+func (tx *Tx) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return tx.tx.ExecContext(ctx, tx.dialect.Rebind(query), args...)
+}
+
+func (tx *Tx) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return tx.tx.QueryContext(ctx, tx.dialect.Rebind(query), args...)
+}
+
+func (tx *Tx) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return tx.tx.QueryRowContext(ctx, tx.dialect.Rebind(query), args...)
+}
+
+func (tx *Tx) Commit() error {
+	return tx.tx.Commit()
+}
+
+func (tx *Tx) Rollback() error {
+	return tx.tx.Rollback()
+}
+
+// queryer is satisfied by both DB and Tx, for helpers that run either
+// inside or outside a transaction.
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// queryStrings returns the first column of the rows query selects.
+func queryStrings(ctx context.Context, q queryer, query string, args ...any) ([]string, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var values []string
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, rows.Err()
+}
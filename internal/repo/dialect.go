@@ -0,0 +1,91 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/mattn/go-sqlite3"
+)
+
+// Dialect is what the store needs to know about the database behind it.
+// Queries are written once, with ? placeholders and SQL that SQLite and
+// PostgreSQL both accept (ON CONFLICT, RETURNING, string_agg); the dialect
+// covers what cannot be written portably.
+type Dialect interface {
+	// Name is the database/sql driver the database is opened with.
+	Name() string
+	// Rebind rewrites the ? placeholders in query for the database.
+	Rebind(query string) string
+	// UniqueViolation reports whether err broke the unique constraint on
+	// table.column.
+	UniqueViolation(err error, table string, column string) bool
+}
+
+var (
+	SQLite   Dialect = sqliteDialect{}
+	Postgres Dialect = postgresDialect{}
+)
+
+// DialectFor returns the dialect for a database/sql driver name.
+func DialectFor(driver string) (Dialect, error) {
+	for _, d := range []Dialect{SQLite, Postgres} {
+		if d.Name() == driver {
+			return d, nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported database driver %q", driver)
+}
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) Name() string { return "sqlite3" }
+
+func (sqliteDialect) Rebind(query string) string { return query }
+
+func (sqliteDialect) UniqueViolation(err error, table string, column string) bool {
+	var e sqlite3.Error
+	if !errors.As(err, &e) {
+		return false
+	}
+	if e.ExtendedCode != sqlite3.ErrConstraintUnique && e.ExtendedCode != sqlite3.ErrConstraintPrimaryKey {
+		return false
+	}
+	return strings.Contains(e.Error(), table+"."+column)
+}
+
+type postgresDialect struct{}
+
+func (postgresDialect) Name() string { return "pgx" }
+
+// Rebind numbers the placeholders $1, $2, ... and leaves question marks in
+// string literals alone.
+func (postgresDialect) Rebind(query string) string {
+	var b strings.Builder
+	b.Grow(len(query) + 16)
+	n := 0
+	quoted := false
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		if c == '\'' {
+			quoted = !quoted
+		}
+		if c == '?' && !quoted {
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// UniqueViolation relies on the schema naming unique constraints
+// <table>_<column>_key, which is also PostgreSQL's default.
+func (postgresDialect) UniqueViolation(err error, table string, column string) bool {
+	var e *pgconn.PgError
+	return errors.As(err, &e) && e.Code == "23505" && e.ConstraintName == table+"_"+column+"_key"
+}
@@ -0,0 +1,320 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"forum/internal/models"
+)
+
+func CreateExport(ctx context.Context, db *DB, userID int) (int, error) {
+	var id int
+	err := db.QueryRowContext(ctx,
+		`INSERT INTO data_exports (user_id, status, created_at) VALUES (?, ?, ?) RETURNING id`,
+		userID, models.ExportPending, now(),
+	).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+const exportColumns = `id, user_id, status, storage_key, size, error, created_at, completed_at, expires_at`
+
+func scanExport(row interface{ Scan(...any) error }) (*models.DataExport, error) {
+	var (
+		e           models.DataExport
+		completedAt sql.NullTime
+		expiresAt   sql.NullTime
+	)
+	err := row.Scan(&e.ID, &e.UserID, &e.Status, &e.StorageKey, &e.Size, &e.Error, &e.CreatedAt, &completedAt, &expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	e.CompletedAt = completedAt.Time
+	e.ExpiresAt = expiresAt.Time
+	return &e, nil
+}
+
+func GetLatestExport(ctx context.Context, db *DB, userID int) (*models.DataExport, error) {
+	return scanExport(db.QueryRowContext(ctx,
+		`SELECT `+exportColumns+` FROM data_exports WHERE user_id = ? ORDER BY created_at DESC, id DESC LIMIT 1`,
+		userID,
+	))
+}
+
+func GetExportByID(ctx context.Context, db *DB, userID int, exportID int) (*models.DataExport, error) {
+	return scanExport(db.QueryRowContext(ctx,
+		`SELECT `+exportColumns+` FROM data_exports WHERE id = ? AND user_id = ?`,
+		exportID, userID,
+	))
+}
+
+func MarkExportReady(ctx context.Context, db *DB, exportID int, storageKey string, size int64, expiresAt time.Time) error {
+	_, err := db.ExecContext(ctx,
+		`UPDATE data_exports SET status = ?, storage_key = ?, size = ?, completed_at = ?, expires_at = ? WHERE id = ?`,
+		models.ExportReady, storageKey, size, now(), expiresAt.UTC(), exportID,
+	)
+	return err
+}
+
+func MarkExportFailed(ctx context.Context, db *DB, exportID int, message string) error {
+	_, err := db.ExecContext(ctx,
+		`UPDATE data_exports SET status = ?, error = ?, completed_at = ? WHERE id = ?`,
+		models.ExportFailed, message, now(), exportID,
+	)
+	return err
+}
+
+// FailPendingExports marks exports left pending by a previous process as
+// failed so that their owners can request a new one.
+func FailPendingExports(ctx context.Context, db *DB) error {
+	_, err := db.ExecContext(ctx,
+		`UPDATE data_exports SET status = ?, error = 'interrupted', completed_at = ? WHERE status = ?`,
+		models.ExportFailed, now(), models.ExportPending,
+	)
+	return err
+}
+
+// DeleteOldExports removes a user's exports other than keepID and returns
+// the storage keys of their archives.
+func DeleteOldExports(ctx context.Context, db *DB, userID int, keepID int) ([]string, error) {
+	rows, err := db.QueryContext(ctx,
+		`SELECT storage_key FROM data_exports WHERE user_id = ? AND id <> ? AND storage_key <> ''`,
+		userID, keepID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	_, err = db.ExecContext(ctx, `DELETE FROM data_exports WHERE user_id = ? AND id <> ?`, userID, keepID)
+	return keys, err
+}
+
+// GetUserExportData collects everything stored about a user inside one read
+// transaction, so the archive is a consistent snapshot.
+func GetUserExportData(ctx context.Context, db *DB, userID int) (*models.UserExport, error) {
+	tx, err := db.BeginTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	data := &models.UserExport{
+		Posts:         make([]models.ExportPost, 0),
+		Comments:      make([]models.ExportComment, 0),
+		Reactions:     make([]models.ExportReaction, 0),
+		Sessions:      make([]models.ExportSession, 0),
+		Notifications: make([]models.ExportNotification, 0),
+		Preferences:   make([]models.ExportPreference, 0),
+	}
+
+	var createdAt sql.NullTime
+	p := &data.Profile
+	err = tx.QueryRowContext(ctx, `
+    SELECT id, email, username, bio, avatar_key, profile_visibility, show_liked, created_at
+    FROM users WHERE id = ?
+    `, userID).Scan(&p.ID, &p.Email, &p.Username, &p.Bio, &p.AvatarKey, &p.Visibility, &p.ShowLiked, &createdAt)
+	if err != nil {
+		return nil, err
+	}
+	p.CreatedAt = createdAt.Time
+
+	if err := exportPosts(ctx, tx, userID, data); err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.QueryContext(ctx, `SELECT id, post_id, content, created_at FROM comments WHERE user_id = ? ORDER BY id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var c models.ExportComment
+		if err := rows.Scan(&c.ID, &c.PostID, &c.Content, &c.CreatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		data.Comments = append(data.Comments, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = tx.QueryContext(ctx, `SELECT target_type, target_id, kind, created_at FROM reactions WHERE user_id = ? ORDER BY created_at`, userID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var r models.ExportReaction
+		if err := rows.Scan(&r.TargetType, &r.TargetID, &r.Kind, &r.CreatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		data.Reactions = append(data.Reactions, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = tx.QueryContext(ctx, `SELECT expires_at FROM sessions WHERE user_id = ? ORDER BY expires_at`, userID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var s models.ExportSession
+		if err := rows.Scan(&s.ExpiresAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		data.Sessions = append(data.Sessions, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = tx.QueryContext(ctx, `
+    SELECT n.type, COALESCE(u.username, ''), n.post_id, COALESCE(n.comment_id, 0), n.detail, n.created_at, n.read_at
+    FROM notifications n
+    LEFT JOIN users u ON u.id = n.actor_id
+    WHERE n.user_id = ?
+    ORDER BY n.id
+    `, userID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var (
+			n      models.ExportNotification
+			readAt sql.NullTime
+		)
+		if err := rows.Scan(&n.Type, &n.Actor, &n.PostID, &n.CommentID, &n.Detail, &n.CreatedAt, &readAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if readAt.Valid {
+			n.ReadAt = &readAt.Time
+		}
+		data.Notifications = append(data.Notifications, n)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	data.Identities = make([]models.ExternalIdentity, 0)
+	rows, err = tx.QueryContext(ctx, `SELECT provider, subject, email, created_at FROM external_identities WHERE user_id = ? ORDER BY created_at`, userID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var i models.ExternalIdentity
+		if err := rows.Scan(&i.Provider, &i.Subject, &i.Email, &i.CreatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		data.Identities = append(data.Identities, i)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = tx.QueryContext(ctx, `SELECT type, enabled FROM notification_preferences WHERE user_id = ? ORDER BY type`, userID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var pref models.ExportPreference
+		if err := rows.Scan(&pref.Type, &pref.Enabled); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		data.Preferences = append(data.Preferences, pref)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func exportPosts(ctx context.Context, tx *Tx, userID int, data *models.UserExport) error {
+	rows, err := tx.QueryContext(ctx, `
+    SELECT p.id, p.title, p.content, p.created_at,
+           COALESCE((SELECT string_agg(c.name, '|') FROM post_categories pc JOIN categories c ON c.id = pc.category_id WHERE pc.post_id = p.id), '')
+    FROM posts p
+    WHERE p.user_id = ?
+    ORDER BY p.id
+    `, userID)
+	if err != nil {
+		return err
+	}
+	index := make(map[int]int)
+	for rows.Next() {
+		var (
+			post       models.ExportPost
+			categories string
+		)
+		if err := rows.Scan(&post.ID, &post.Title, &post.Content, &post.CreatedAt, &categories); err != nil {
+			rows.Close()
+			return err
+		}
+		post.Categories = make([]string, 0)
+		if categories != "" {
+			post.Categories = strings.Split(categories, "|")
+		}
+		post.Attachments = make([]models.ExportAttachment, 0)
+		index[post.ID] = len(data.Posts)
+		data.Posts = append(data.Posts, post)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = tx.QueryContext(ctx, `
+    SELECT a.post_id, a.storage_key, a.content_type, a.size, a.width, a.height, a.original_name
+    FROM attachments a
+    JOIN posts p ON p.id = a.post_id
+    WHERE p.user_id = ?
+    ORDER BY a.id
+    `, userID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			postID int
+			a      models.ExportAttachment
+		)
+		if err := rows.Scan(&postID, &a.StorageKey, &a.ContentType, &a.Size, &a.Width, &a.Height, &a.OriginalName); err != nil {
+			return err
+		}
+		i, ok := index[postID]
+		if !ok {
+			continue
+		}
+		data.Posts[i].Attachments = append(data.Posts[i].Attachments, a)
+	}
+	return rows.Err()
+}
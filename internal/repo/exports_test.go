@@ -0,0 +1,172 @@
+package repo_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"forum/internal/models"
+	"forum/internal/repo"
+)
+
+func TestExportLifecycle(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	ann := createUser(t, db, "ann")
+	bob := createUser(t, db, "bob")
+	expires := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+
+	newExport := func() int {
+		t.Helper()
+		id, err := repo.CreateExport(ctx, db, ann)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	ready, failed, interrupted := newExport(), newExport(), newExport()
+
+	steps := []struct {
+		name   string
+		run    func() error
+		id     int
+		status string
+	}{
+		{"ready", func() error { return repo.MarkExportReady(ctx, db, ready, "exports/ready.zip", 1234, expires) }, ready, models.ExportReady},
+		{"failed", func() error { return repo.MarkExportFailed(ctx, db, failed, "disk full") }, failed, models.ExportFailed},
+		{"interrupted", func() error { return repo.FailPendingExports(ctx, db) }, interrupted, models.ExportFailed},
+	}
+	for _, s := range steps {
+		if err := s.run(); err != nil {
+			t.Fatalf("%s: %v", s.name, err)
+		}
+		e, err := repo.GetExportByID(ctx, db, ann, s.id)
+		if err != nil {
+			t.Fatalf("%s: %v", s.name, err)
+		}
+		if e.Status != s.status || e.CompletedAt.IsZero() {
+			t.Errorf("%s: status %q, completed %v; want %q and a completion time", s.name, e.Status, e.CompletedAt, s.status)
+		}
+	}
+
+	e, err := repo.GetExportByID(ctx, db, ann, ready)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.StorageKey != "exports/ready.zip" || e.Size != 1234 || !e.ExpiresAt.Equal(expires) {
+		t.Errorf("ready export = %+v", e)
+	}
+	if e, _ := repo.GetExportByID(ctx, db, ann, interrupted); e.Error != "interrupted" {
+		t.Errorf("interrupted export error = %q", e.Error)
+	}
+	if _, err := repo.GetExportByID(ctx, db, bob, ready); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("bob got ann's export: %v", err)
+	}
+	if _, err := repo.GetLatestExport(ctx, db, bob); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("latest export of a user without any = %v, want sql.ErrNoRows", err)
+	}
+
+	latest := newExport()
+	if e, err := repo.GetLatestExport(ctx, db, ann); err != nil || e.ID != latest || e.Status != models.ExportPending {
+		t.Errorf("latest export = %+v, %v, want pending %d", e, err, latest)
+	}
+
+	// Only exports with an archive have a key to hand back.
+	keys, err := repo.DeleteOldExports(ctx, db, ann, latest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(keys, []string{"exports/ready.zip"}) {
+		t.Errorf("DeleteOldExports returned %v, want the ready archive", keys)
+	}
+	if n := count(t, db, `SELECT COUNT(*) FROM data_exports WHERE user_id = ?`, ann); n != 1 {
+		t.Errorf("%d exports left, want the one kept", n)
+	}
+}
+
+func TestGetUserExportData(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	ann := createUser(t, db, "ann")
+	bob := createUser(t, db, "bob")
+
+	annPost := createAttachedPost(t, db, ann, "a.png")
+	if _, err := db.ExecContext(ctx, `INSERT INTO post_categories (post_id, category_id) VALUES (?, 2)`, annPost); err != nil {
+		t.Fatal(err)
+	}
+	bobPost := createPost(t, db, bob, "Bob's")
+	annComment, err := repo.CreateComment(ctx, db, bobPost, ann, "Nice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.CreateComment(ctx, db, annPost, bob, "Thanks"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.ToggleReaction(ctx, db, ann, repo.ReactionTargetPost, bobPost, "like"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.ToggleReaction(ctx, db, bob, repo.ReactionTargetPost, annPost, "like"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.CreateSessions(ctx, db, ann); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.CreateNotification(ctx, db, models.Notification{UserID: ann, ActorID: bob, Type: models.NotificationReaction, PostID: annPost, Detail: "like"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.SetNotificationPreferences(ctx, db, ann, map[string]bool{models.NotificationComment: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.LinkIdentity(ctx, db, ann, "mock", "ann-1", "ann@example.com", true); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := repo.GetUserExportData(ctx, db, ann)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.Profile.ID != ann || data.Profile.Email != "ann@example.com" || data.Profile.Username != "ann" {
+		t.Errorf("profile = %+v", data.Profile)
+	}
+	if len(data.Posts) != 1 || data.Posts[0].ID != annPost {
+		t.Fatalf("posts = %+v, want ann's post only", data.Posts)
+	}
+	post := data.Posts[0]
+	if len(post.Categories) != 2 {
+		t.Errorf("post categories = %v, want two", post.Categories)
+	}
+	if len(post.Attachments) != 1 || post.Attachments[0].StorageKey != "a.png" {
+		t.Errorf("post attachments = %+v, want a.png", post.Attachments)
+	}
+	if len(data.Comments) != 1 || data.Comments[0].ID != annComment {
+		t.Errorf("comments = %+v, want ann's comment only", data.Comments)
+	}
+	if len(data.Reactions) != 1 || data.Reactions[0].TargetID != bobPost || data.Reactions[0].Kind != "like" {
+		t.Errorf("reactions = %+v, want ann's like of bob's post", data.Reactions)
+	}
+	if len(data.Sessions) != 1 {
+		t.Errorf("sessions = %+v, want one", data.Sessions)
+	}
+	if len(data.Notifications) != 1 || data.Notifications[0].Actor != "bob" || data.Notifications[0].ReadAt != nil {
+		t.Errorf("notifications = %+v, want bob's unread reaction", data.Notifications)
+	}
+	if len(data.Preferences) != len(models.NotificationTypes) {
+		t.Errorf("preferences = %+v, want one per type", data.Preferences)
+	}
+	if len(data.Identities) != 1 || data.Identities[0].Subject != "ann-1" {
+		t.Errorf("identities = %+v, want ann-1", data.Identities)
+	}
+
+	// A user with nothing gets empty lists, not nulls, in the archive.
+	carol := createUser(t, db, "carol")
+	empty, err := repo.GetUserExportData(ctx, db, carol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if empty.Posts == nil || empty.Comments == nil || empty.Reactions == nil || empty.Notifications == nil || empty.Identities == nil {
+		t.Errorf("export of an empty account has nil lists: %+v", empty)
+	}
+}
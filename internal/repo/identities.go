@@ -0,0 +1,106 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"forum/internal/models"
+)
+
+func SaveAuthState(ctx context.Context, db *DB, st models.AuthState) error {
+	_, err := db.ExecContext(ctx, `
+        INSERT INTO auth_states (state, provider, nonce, verifier, redirect_uri, link_user_id, confirm, created_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+    `, st.State, st.Provider, st.Nonce, st.Verifier, st.RedirectURI, st.LinkUserID, st.Confirm, now())
+	return err
+}
+
+// TakeAuthState returns and deletes a started sign-in, so each state can be
+// used once. States older than maxAge are purged and never returned.
+func TakeAuthState(ctx context.Context, db *DB, state string, maxAge time.Duration) (*models.AuthState, error) {
+	tx, err := db.BeginTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM auth_states WHERE created_at < ?`, now().Add(-maxAge)); err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	var st models.AuthState
+	err = tx.QueryRowContext(ctx, `
+        SELECT state, provider, nonce, verifier, redirect_uri, link_user_id, confirm, created_at
+        FROM auth_states WHERE state = ?
+    `, state).Scan(&st.State, &st.Provider, &st.Nonce, &st.Verifier, &st.RedirectURI, &st.LinkUserID, &st.Confirm, &st.CreatedAt)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM auth_states WHERE state = ?`, state); err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	return &st, tx.Commit()
+}
+
+func GetUserIDByIdentity(ctx context.Context, db *DB, provider string, subject string) (int, error) {
+	var userID int
+	err := db.QueryRowContext(ctx,
+		`SELECT user_id FROM external_identities WHERE provider = ? AND subject = ?`,
+		provider, subject,
+	).Scan(&userID)
+	return userID, err
+}
+
+// LinkIdentity links a provider identity to a user. When the provider
+// verified the identity's email and it is the account's address, the address
+// counts as verified from then on.
+func LinkIdentity(ctx context.Context, db *DB, userID int, provider string, subject string, email string, emailVerified bool) error {
+	tx, err := db.BeginTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	_, err = tx.ExecContext(ctx, `
+        INSERT INTO external_identities (provider, subject, user_id, email, created_at)
+        VALUES (?, ?, ?, ?, ?)
+    `, provider, subject, userID, email, now())
+	if err != nil {
+		return err
+	}
+	if emailVerified && email != "" {
+		_, err = tx.ExecContext(ctx, `UPDATE users SET email_verified = TRUE WHERE id = ? AND email = ?`, userID, email)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func GetIdentitiesByUserID(ctx context.Context, db *DB, userID int) ([]models.ExternalIdentity, error) {
+	rows, err := db.QueryContext(ctx, `
+        SELECT provider, subject, user_id, email, created_at
+        FROM external_identities WHERE user_id = ?
+        ORDER BY created_at
+    `, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := make([]models.ExternalIdentity, 0)
+	for rows.Next() {
+		var i models.ExternalIdentity
+		if err := rows.Scan(&i.Provider, &i.Subject, &i.UserID, &i.Email, &i.CreatedAt); err != nil {
+			return nil, err
+		}
+		identities = append(identities, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return identities, nil
+}
@@ -0,0 +1,137 @@
+package repo_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"forum/internal/models"
+	"forum/internal/repo"
+)
+
+func TestAuthStates(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	ann := createUser(t, db, "ann")
+
+	st := models.AuthState{State: "s1", Provider: "mock", Nonce: "n", Verifier: "v", RedirectURI: "http://forum.test/auth/callback", LinkUserID: ann, Confirm: true}
+	if err := repo.SaveAuthState(ctx, db, st); err != nil {
+		t.Fatal(err)
+	}
+	got, err := repo.TakeAuthState(ctx, db, "s1", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got.CreatedAt = time.Time{}
+	if *got != st {
+		t.Errorf("TakeAuthState = %+v, want %+v", *got, st)
+	}
+
+	tests := []struct {
+		name   string
+		state  string
+		maxAge time.Duration
+	}{
+		{"taken before", "s1", time.Minute},
+		{"unknown", "nope", time.Minute},
+		{"expired", "s2", -time.Second},
+	}
+	if err := repo.SaveAuthState(ctx, db, models.AuthState{State: "s2", Provider: "mock"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		if _, err := repo.TakeAuthState(ctx, db, tt.state, tt.maxAge); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("%s: TakeAuthState = %v, want sql.ErrNoRows", tt.name, err)
+		}
+	}
+}
+
+func TestIdentities(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	ann := createUser(t, db, "ann")
+	bob := createUser(t, db, "bob")
+
+	for _, subject := range []string{"ann-home", "ann-work"} {
+		if err := repo.LinkIdentity(ctx, db, ann, "mock", subject, "ann@example.com", false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if id, err := repo.GetUserIDByIdentity(ctx, db, "mock", "ann-work"); err != nil || id != ann {
+		t.Errorf("GetUserIDByIdentity(ann-work) = %d, %v, want %d", id, err, ann)
+	}
+	if _, err := repo.GetUserIDByIdentity(ctx, db, "other", "ann-work"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("the same subject at another provider = %v, want sql.ErrNoRows", err)
+	}
+	// One identity belongs to one account.
+	if err := repo.LinkIdentity(ctx, db, bob, "mock", "ann-home", "bob@example.com", false); err == nil {
+		t.Error("an identity was linked to a second account")
+	}
+
+	list, err := repo.GetIdentitiesByUserID(ctx, db, ann)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].Subject != "ann-home" || list[1].Subject != "ann-work" {
+		t.Errorf("ann's identities = %+v", list)
+	}
+	if list, err := repo.GetIdentitiesByUserID(ctx, db, bob); err != nil || len(list) != 0 {
+		t.Errorf("bob's identities = %+v, %v, want none", list, err)
+	}
+}
+
+// TestEmailVerification checks what verifies an account's address: linking
+// an identity whose provider verified that same address, or confirming a
+// change to it. Registering does not.
+func TestEmailVerification(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	verified := func(userID int) bool {
+		t.Helper()
+		user, err := repo.GetUserByID(ctx, db, userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return user.EmailVerified
+	}
+
+	tests := []struct {
+		name          string
+		email         string
+		emailVerified bool
+		want          bool
+	}{
+		{"unverified by the provider", "u0@example.com", false, false},
+		{"another address", "elsewhere@example.com", true, false},
+		{"same address, verified", "u2@example.com", true, true},
+	}
+	for i, tt := range tests {
+		userID := createUser(t, db, "u"+string(rune('0'+i)))
+		if verified(userID) {
+			t.Fatalf("%s: a registered address is verified", tt.name)
+		}
+		if err := repo.LinkIdentity(ctx, db, userID, "mock", tt.name, tt.email, tt.emailVerified); err != nil {
+			t.Fatal(err)
+		}
+		if got := verified(userID); got != tt.want {
+			t.Errorf("%s: verified = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	ann := createUser(t, db, "ann")
+	if err := repo.CreateEmailChange(ctx, db, ann, "ann@example.org", "token", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if verified(ann) {
+		t.Error("requesting an email change verified the address")
+	}
+	if _, err := repo.ConfirmEmailChange(ctx, db, "token"); err != nil {
+		t.Fatal(err)
+	}
+	if !verified(ann) {
+		t.Error("confirming an email change left the new address unverified")
+	}
+}
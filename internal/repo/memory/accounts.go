@@ -0,0 +1,445 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	"forum/internal/models"
+)
+
+type enrolment struct {
+	secret   string
+	enabled  bool
+	lastStep int64
+	// codes maps recovery code hashes to whether they were used.
+	codes map[string]bool
+}
+
+type challenge struct {
+	userID    int
+	attempts  int
+	createdAt time.Time
+}
+
+// TwoFactor keeps TOTP enrolments, recovery codes and login challenges in
+// maps. Enabling and disabling also sets TOTPEnabled on the user in Users,
+// which the handlers read from the signed-in user as the SQL store's users
+// column would give it.
+type TwoFactor struct {
+	Users *Sessions
+
+	mu         sync.Mutex
+	enrolments map[int]*enrolment
+	challenges map[string]challenge
+}
+
+// enrolment returns the user's enrolment, creating an empty one. The caller
+// holds t.mu.
+func (t *TwoFactor) enrolment(userID int) *enrolment {
+	if t.enrolments == nil {
+		t.enrolments = map[int]*enrolment{}
+	}
+	e, ok := t.enrolments[userID]
+	if !ok {
+		e = &enrolment{}
+		t.enrolments[userID] = e
+	}
+	return e
+}
+
+func (t *TwoFactor) GetTOTP(ctx context.Context, userID int) (string, bool, int64, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e := t.enrolment(userID)
+	return e.secret, e.enabled, e.lastStep, nil
+}
+
+func (t *TwoFactor) SetPendingTOTPSecret(ctx context.Context, userID int, secret string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if e := t.enrolment(userID); !e.enabled {
+		e.secret = secret
+	}
+	return nil
+}
+
+func (t *TwoFactor) EnableTOTP(ctx context.Context, userID int, step int64, codeHashes []string) error {
+	t.mu.Lock()
+	e := t.enrolment(userID)
+	e.enabled = true
+	e.lastStep = step
+	e.codes = newCodes(codeHashes)
+	t.mu.Unlock()
+	t.Users.update(userID, func(u *models.User) { u.TOTPEnabled = true })
+	return nil
+}
+
+func (t *TwoFactor) DisableTOTP(ctx context.Context, userID int) error {
+	t.mu.Lock()
+	delete(t.enrolments, userID)
+	t.mu.Unlock()
+	t.Users.update(userID, func(u *models.User) { u.TOTPEnabled = false })
+	return nil
+}
+
+func (t *TwoFactor) UseTOTPStep(ctx context.Context, userID int, step int64) (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e := t.enrolment(userID)
+	if step <= e.lastStep {
+		return false, nil
+	}
+	e.lastStep = step
+	return true, nil
+}
+
+func (t *TwoFactor) ReplaceRecoveryCodes(ctx context.Context, userID int, codeHashes []string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.enrolment(userID).codes = newCodes(codeHashes)
+	return nil
+}
+
+func newCodes(hashes []string) map[string]bool {
+	codes := make(map[string]bool, len(hashes))
+	for _, h := range hashes {
+		codes[h] = false
+	}
+	return codes
+}
+
+func (t *TwoFactor) UseRecoveryCode(ctx context.Context, userID int, codeHash string) (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e := t.enrolment(userID)
+	used, ok := e.codes[codeHash]
+	if !ok || used {
+		return false, nil
+	}
+	e.codes[codeHash] = true
+	return true, nil
+}
+
+func (t *TwoFactor) CountRecoveryCodes(ctx context.Context, userID int) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := 0
+	for _, used := range t.enrolment(userID).codes {
+		if !used {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (t *TwoFactor) CreateLoginChallenge(ctx context.Context, userID int, tokenHash string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.challenges == nil {
+		t.challenges = map[string]challenge{}
+	}
+	t.challenges[tokenHash] = challenge{userID: userID, createdAt: time.Now()}
+	return nil
+}
+
+func (t *TwoFactor) GetLoginChallenge(ctx context.Context, tokenHash string, maxAge time.Duration) (int, int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for hash, c := range t.challenges {
+		if time.Since(c.createdAt) > maxAge {
+			delete(t.challenges, hash)
+		}
+	}
+	c, ok := t.challenges[tokenHash]
+	if !ok {
+		return 0, 0, sql.ErrNoRows
+	}
+	return c.userID, c.attempts, nil
+}
+
+func (t *TwoFactor) FailLoginChallenge(ctx context.Context, tokenHash string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if c, ok := t.challenges[tokenHash]; ok {
+		c.attempts++
+		t.challenges[tokenHash] = c
+	}
+	return nil
+}
+
+func (t *TwoFactor) DeleteLoginChallenge(ctx context.Context, tokenHash string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.challenges, tokenHash)
+	return nil
+}
+
+// Site keeps site settings in a map; unset keys read as "".
+type Site struct {
+	mu     sync.Mutex
+	values map[string]string
+}
+
+func (s *Site) GetSiteSetting(ctx context.Context, key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.values[key], nil
+}
+
+func (s *Site) SetSiteSetting(ctx context.Context, key string, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.values == nil {
+		s.values = map[string]string{}
+	}
+	s.values[key] = value
+	return nil
+}
+
+// errIdentityLinked stands in for the unique constraint the SQL store fails
+// on when an identity is linked twice.
+var errIdentityLinked = errors.New("identity is already linked")
+
+// Identities keeps started external sign-ins and linked identities. Linking
+// with a verified email marks the address verified on the user in Users when
+// it is the account's address, as the SQL store does.
+type Identities struct {
+	Users *Sessions
+
+	mu         sync.Mutex
+	states     map[string]models.AuthState
+	identities []models.ExternalIdentity
+}
+
+func (ids *Identities) SaveAuthState(ctx context.Context, st models.AuthState) error {
+	ids.mu.Lock()
+	defer ids.mu.Unlock()
+	if ids.states == nil {
+		ids.states = map[string]models.AuthState{}
+	}
+	st.CreatedAt = time.Now().UTC()
+	ids.states[st.State] = st
+	return nil
+}
+
+func (ids *Identities) TakeAuthState(ctx context.Context, state string, maxAge time.Duration) (*models.AuthState, error) {
+	ids.mu.Lock()
+	defer ids.mu.Unlock()
+	st, ok := ids.states[state]
+	if !ok || time.Since(st.CreatedAt) > maxAge {
+		return nil, sql.ErrNoRows
+	}
+	delete(ids.states, state)
+	return &st, nil
+}
+
+func (ids *Identities) GetUserIDByIdentity(ctx context.Context, provider string, subject string) (int, error) {
+	ids.mu.Lock()
+	defer ids.mu.Unlock()
+	for _, id := range ids.identities {
+		if id.Provider == provider && id.Subject == subject {
+			return id.UserID, nil
+		}
+	}
+	return 0, sql.ErrNoRows
+}
+
+func (ids *Identities) LinkIdentity(ctx context.Context, userID int, provider string, subject string, email string, emailVerified bool) error {
+	ids.mu.Lock()
+	for _, id := range ids.identities {
+		if id.Provider == provider && id.Subject == subject {
+			ids.mu.Unlock()
+			return errIdentityLinked
+		}
+	}
+	ids.identities = append(ids.identities, models.ExternalIdentity{
+		Provider:  provider,
+		Subject:   subject,
+		UserID:    userID,
+		Email:     email,
+		CreatedAt: time.Now().UTC(),
+	})
+	ids.mu.Unlock()
+	if emailVerified && email != "" {
+		ids.Users.update(userID, func(u *models.User) {
+			if u.Email == email {
+				u.EmailVerified = true
+			}
+		})
+	}
+	return nil
+}
+
+func (ids *Identities) GetIdentitiesByUserID(ctx context.Context, userID int) ([]models.ExternalIdentity, error) {
+	ids.mu.Lock()
+	defer ids.mu.Unlock()
+	list := make([]models.ExternalIdentity, 0)
+	for _, id := range ids.identities {
+		if id.UserID == userID {
+			list = append(list, id)
+		}
+	}
+	return list, nil
+}
+
+// Exports keeps data export requests in the order they were made. Nothing
+// builds the archives; tests settle a request with MarkExportReady or
+// MarkExportFailed.
+type Exports struct {
+	mu      sync.Mutex
+	exports []models.DataExport
+}
+
+func (es *Exports) CreateExport(ctx context.Context, userID int) (int, error) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	id := len(es.exports) + 1
+	es.exports = append(es.exports, models.DataExport{ID: id, UserID: userID, Status: models.ExportPending, CreatedAt: time.Now().UTC()})
+	return id, nil
+}
+
+func (es *Exports) GetLatestExport(ctx context.Context, userID int) (*models.DataExport, error) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	for i := len(es.exports) - 1; i >= 0; i-- {
+		if e := es.exports[i]; e.UserID == userID {
+			return &e, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (es *Exports) GetExportByID(ctx context.Context, userID int, exportID int) (*models.DataExport, error) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	for _, e := range es.exports {
+		if e.ID == exportID && e.UserID == userID {
+			return &e, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (es *Exports) DeleteOldExports(ctx context.Context, userID int, keepID int) ([]string, error) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	var keys []string
+	kept := es.exports[:0]
+	for _, e := range es.exports {
+		if e.UserID != userID || e.ID == keepID {
+			kept = append(kept, e)
+		} else if e.StorageKey != "" {
+			keys = append(keys, e.StorageKey)
+		}
+	}
+	es.exports = kept
+	return keys, nil
+}
+
+// settle applies change to the export with the given ID.
+func (es *Exports) settle(exportID int, change func(*models.DataExport)) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	for i := range es.exports {
+		if es.exports[i].ID == exportID {
+			change(&es.exports[i])
+			es.exports[i].CompletedAt = time.Now().UTC()
+		}
+	}
+}
+
+func (es *Exports) MarkExportReady(ctx context.Context, exportID int, storageKey string, size int64, expiresAt time.Time) error {
+	es.settle(exportID, func(e *models.DataExport) {
+		e.Status = models.ExportReady
+		e.StorageKey = storageKey
+		e.Size = size
+		e.ExpiresAt = expiresAt
+	})
+	return nil
+}
+
+func (es *Exports) MarkExportFailed(ctx context.Context, exportID int, message string) error {
+	es.settle(exportID, func(e *models.DataExport) {
+		e.Status = models.ExportFailed
+		e.Error = message
+	})
+	return nil
+}
+
+// Profiles keeps the profile fields of the users in Users. Profiles start
+// public, as new accounts do. Counts and reputation are left at zero and
+// users have no comments.
+type Profiles struct {
+	Users *Sessions
+
+	mu       sync.Mutex
+	profiles map[int]models.Profile
+}
+
+func (ps *Profiles) GetProfileByUsername(ctx context.Context, username string) (*models.Profile, error) {
+	user, err := ps.Users.GetUserByUsername(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	p, ok := ps.profiles[user.ID]
+	if !ok {
+		p = models.Profile{Visibility: models.ProfilePublic}
+	}
+	p.UserID = user.ID
+	p.Username = user.Username
+	return &p, nil
+}
+
+func (ps *Profiles) GetCommentsByUserID(ctx context.Context, userID int, limit int, offset int) ([]models.ProfileComment, error) {
+	return make([]models.ProfileComment, 0), nil
+}
+
+// update applies change to the user's profile. The caller holds ps.mu.
+func (ps *Profiles) update(userID int, change func(*models.Profile)) {
+	if ps.profiles == nil {
+		ps.profiles = map[int]models.Profile{}
+	}
+	p, ok := ps.profiles[userID]
+	if !ok {
+		p = models.Profile{Visibility: models.ProfilePublic}
+	}
+	change(&p)
+	ps.profiles[userID] = p
+}
+
+func (ps *Profiles) UpdateProfile(ctx context.Context, userID int, bio string, visibility string, showLiked bool) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.update(userID, func(p *models.Profile) {
+		p.Bio = bio
+		p.Visibility = visibility
+		p.ShowLiked = showLiked
+	})
+	return nil
+}
+
+func (ps *Profiles) SetAvatar(ctx context.Context, userID int, key string, contentType string) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.update(userID, func(p *models.Profile) {
+		p.AvatarKey = key
+		p.AvatarType = contentType
+	})
+	return nil
+}
+
+func (ps *Profiles) GetAvatarContentType(ctx context.Context, key string) (string, error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	for _, p := range ps.profiles {
+		if key != "" && p.AvatarKey == key {
+			return p.AvatarType, nil
+		}
+	}
+	return "", sql.ErrNoRows
+}
@@ -0,0 +1,234 @@
+// Package memory holds in-memory stand-ins for the repositories behind
+// users and sessions, categories, reactions, posts, profiles, exports,
+// external identities and two-factor sign-in, so handlers can be exercised
+// with httptest and no database file.
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"sync"
+	"time"
+
+	"forum/internal/models"
+	"forum/internal/repo"
+)
+
+// Sessions keeps users and their sessions in maps. It serves the
+// authentication middleware and the handlers' SessionRepo and UserRepo.
+type Sessions struct {
+	mu           sync.Mutex
+	users        map[int]models.User
+	sessions     map[string]int
+	confirmed    map[string]time.Time
+	emailChanges map[int]emailChange
+	next         int
+}
+
+func NewSessions() *Sessions {
+	return &Sessions{users: map[int]models.User{}, sessions: map[string]int{}, confirmed: map[string]time.Time{}}
+}
+
+// AddUser makes user known so sessions can be created for it.
+func (s *Sessions) AddUser(user models.User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[user.ID] = user
+}
+
+// CreateSession replaces the user's sessions with a new one, as the SQL
+// store does.
+func (s *Sessions) CreateSession(ctx context.Context, userID int) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, owner := range s.sessions {
+		if owner == userID {
+			delete(s.sessions, id)
+			delete(s.confirmed, id)
+		}
+	}
+	s.next++
+	id := "session-" + strconv.Itoa(s.next)
+	s.sessions[id] = userID
+	return id, nil
+}
+
+func (s *Sessions) DeleteSession(ctx context.Context, sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, sessionID)
+	delete(s.confirmed, sessionID)
+	return nil
+}
+
+func (s *Sessions) DeleteOtherSessions(ctx context.Context, userID int, keepSessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, owner := range s.sessions {
+		if owner == userID && id != keepSessionID {
+			delete(s.sessions, id)
+			delete(s.confirmed, id)
+		}
+	}
+	return nil
+}
+
+// GetUserBySessionID returns a copy of the user, so the middleware can fill
+// in per-request fields without touching the stored one.
+func (s *Sessions) GetUserBySessionID(ctx context.Context, sessionID string) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	userID, ok := s.sessions[sessionID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	user, ok := s.users[userID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	user.ConfirmedAt = s.confirmed[sessionID]
+	return &user, nil
+}
+
+func (s *Sessions) ConfirmSession(ctx context.Context, sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.sessions[sessionID]; ok {
+		s.confirmed[sessionID] = time.Now().UTC()
+	}
+	return nil
+}
+
+func (s *Sessions) CountUnreadNotifications(ctx context.Context, userID int) (int, error) {
+	return 0, nil
+}
+
+// Categories serves a fixed list of categories.
+type Categories struct {
+	List []models.Category
+}
+
+func (c *Categories) GetAllCategories(ctx context.Context) ([]models.Category, error) {
+	return append([]models.Category(nil), c.List...), nil
+}
+
+func (c *Categories) CategoryExists(ctx context.Context, categoryID int) (bool, error) {
+	for _, cat := range c.List {
+		if cat.ID == categoryID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+type reaction struct {
+	userID     int
+	targetType string
+	targetID   int
+	kind       string
+}
+
+// Reactions toggles reactions with the same rules as the SQL store: a
+// scored kind replaces the opposite one. Usernames in the summaries come
+// from Names when set.
+type Reactions struct {
+	Names map[int]string
+
+	mu        sync.Mutex
+	reactions []reaction
+}
+
+func (rs *Reactions) Toggle(ctx context.Context, userID int, targetType string, targetID int, kind string) (*models.ReactionState, error) {
+	if targetType != repo.ReactionTargetPost && targetType != repo.ReactionTargetComment {
+		return nil, repo.ErrUnknownReactionTarget
+	}
+	reactionKind, ok := models.ReactionKindByCode(kind)
+	if !ok {
+		return nil, repo.ErrUnknownReactionKind
+	}
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	state := models.ReactionState{Kind: kind}
+	removed := false
+	kept := rs.reactions[:0]
+	for _, r := range rs.reactions {
+		if r.userID == userID && r.targetType == targetType && r.targetID == targetID && r.kind == kind {
+			removed = true
+			continue
+		}
+		kept = append(kept, r)
+	}
+	rs.reactions = kept
+
+	if !removed {
+		if reactionKind.Score != 0 {
+			kept = rs.reactions[:0]
+			for _, r := range rs.reactions {
+				k, _ := models.ReactionKindByCode(r.kind)
+				if r.userID == userID && r.targetType == targetType && r.targetID == targetID && k.Score != 0 {
+					continue
+				}
+				kept = append(kept, r)
+			}
+			rs.reactions = kept
+		}
+		rs.reactions = append(rs.reactions, reaction{userID, targetType, targetID, kind})
+		state.Active = true
+		state.Added = true
+	}
+
+	state.Reactions, state.Likes, state.Dislikes = rs.summarize(targetType, targetID, userID)
+	return &state, nil
+}
+
+// Summaries returns the reactions on a target and its like and dislike
+// counts, with Mine set on the kinds viewerID chose.
+func (rs *Reactions) Summaries(targetType string, targetID int, viewerID int) ([]models.ReactionSummary, int, int) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return rs.summarize(targetType, targetID, viewerID)
+}
+
+// Liked reports whether userID gave the target a kind with a positive score.
+func (rs *Reactions) Liked(userID int, targetType string, targetID int) bool {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	for _, r := range rs.reactions {
+		k, _ := models.ReactionKindByCode(r.kind)
+		if r.userID == userID && r.targetType == targetType && r.targetID == targetID && k.Score > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (rs *Reactions) summarize(targetType string, targetID int, viewerID int) ([]models.ReactionSummary, int, int) {
+	var likes, dislikes int
+	summaries := models.NewReactionSummaries()
+	for _, r := range rs.reactions {
+		if r.targetType != targetType || r.targetID != targetID {
+			continue
+		}
+		for i := range summaries {
+			if summaries[i].Kind != r.kind {
+				continue
+			}
+			summaries[i].Count++
+			summaries[i].Users = append(summaries[i].Users, rs.Names[r.userID])
+			if r.userID == viewerID {
+				summaries[i].Mine = true
+			}
+			k, _ := models.ReactionKindByCode(r.kind)
+			switch {
+			case k.Score > 0:
+				likes++
+			case k.Score < 0:
+				dislikes++
+			}
+		}
+	}
+	return summaries, likes, dislikes
+}
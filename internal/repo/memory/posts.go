@@ -0,0 +1,206 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"forum/internal/models"
+	"forum/internal/repo"
+)
+
+type post struct {
+	card        models.PostCard
+	userID      int
+	categoryIDs []int
+}
+
+// Posts keeps posts in the order they were created and serves both the
+// handlers' PostRepo and their AttachmentRepo. Author names come from Names
+// and category names from Categories. Reactions, when set, supplies the
+// reactions on the cards and the liked filter. Err, when set, makes
+// CreatePost fail, so the cleanup after a failed write can be tested.
+// Posts have no comments.
+type Posts struct {
+	Names      map[int]string
+	Categories *Categories
+	Reactions  *Reactions
+	Err        error
+
+	mu    sync.Mutex
+	posts []post
+	next  int
+}
+
+func (p *Posts) CreatePost(ctx context.Context, userID int, title string, content string, categoryIDs []int, attachments []models.Attachment) (int, error) {
+	if p.Err != nil {
+		return 0, p.Err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	id := len(p.posts) + 1
+	stored := make([]models.Attachment, len(attachments))
+	for i, a := range attachments {
+		p.next++
+		a.ID, a.PostID, a.UserID = p.next, id, userID
+		stored[i] = a
+	}
+	createdAt := time.Now().UTC()
+	p.posts = append(p.posts, post{
+		card: models.PostCard{
+			ID:           id,
+			Title:        title,
+			Content:      content,
+			CategoryName: p.categoryNames(categoryIDs),
+			AuthorName:   p.Names[userID],
+			CreatedAt:    createdAt,
+			UpdatedAt:    createdAt,
+			Attachments:  stored,
+		},
+		userID:      userID,
+		categoryIDs: slices.Clone(categoryIDs),
+	})
+	return id, nil
+}
+
+func (p *Posts) categoryNames(ids []int) string {
+	if p.Categories == nil {
+		return ""
+	}
+	var names []string
+	for _, c := range p.Categories.List {
+		if slices.Contains(ids, c.ID) {
+			names = append(names, c.Name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// GetPostCards lists the posts newest first. Comment limits are ignored, as
+// there are no comments.
+func (p *Posts) GetPostCards(ctx context.Context, filter repo.PostCardsFilter) ([]models.PostCard, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	cards := make([]models.PostCard, 0)
+	for i := len(p.posts) - 1; i >= 0; i-- {
+		post := p.posts[i]
+		if filter.MineOnly && post.userID != filter.UserID {
+			continue
+		}
+		if filter.CategoryID != 0 && !slices.Contains(post.categoryIDs, filter.CategoryID) {
+			continue
+		}
+		if filter.LikedOnly && (p.Reactions == nil || !p.Reactions.Liked(filter.UserID, repo.ReactionTargetPost, post.card.ID)) {
+			continue
+		}
+		cards = append(cards, p.card(post, filter.ViewerID))
+	}
+
+	cards = cards[min(filter.Offset, len(cards)):]
+	if filter.Limit > 0 && len(cards) > filter.Limit {
+		cards = cards[:filter.Limit]
+	}
+	return cards, nil
+}
+
+func (p *Posts) card(post post, viewerID int) models.PostCard {
+	card := post.card
+	card.Attachments = slices.Clone(card.Attachments)
+	if p.Reactions != nil {
+		card.Reactions, card.Likes, card.Dislikes = p.Reactions.Summaries(repo.ReactionTargetPost, card.ID, viewerID)
+	} else {
+		card.Reactions = models.NewReactionSummaries()
+	}
+	return card
+}
+
+func (p *Posts) GetPostCardWithComments(ctx context.Context, postID int, viewerID int) (*models.PostCardWithComments, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	post, ok := p.find(postID)
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	card := p.card(post, viewerID)
+	return &models.PostCardWithComments{
+		ID:           card.ID,
+		Title:        card.Title,
+		Content:      card.Content,
+		CategoryName: card.CategoryName,
+		AuthorName:   card.AuthorName,
+		CreatedAt:    card.CreatedAt,
+		UpdatedAt:    card.UpdatedAt,
+		Likes:        card.Likes,
+		Dislikes:     card.Dislikes,
+		Reactions:    card.Reactions,
+		Attachments:  card.Attachments,
+	}, nil
+}
+
+func (p *Posts) PostExists(ctx context.Context, postID int) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.find(postID)
+	return ok, nil
+}
+
+func (p *Posts) GetPostAuthorID(ctx context.Context, postID int) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	post, ok := p.find(postID)
+	if !ok {
+		return 0, sql.ErrNoRows
+	}
+	return post.userID, nil
+}
+
+func (p *Posts) GetAttachmentByKey(ctx context.Context, storageKey string) (*models.Attachment, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, post := range p.posts {
+		for _, a := range post.card.Attachments {
+			if a.StorageKey == storageKey {
+				return &a, nil
+			}
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// ReleaseBlobs removes the keys no post's attachment uses.
+func (p *Posts) ReleaseBlobs(ctx context.Context, keys []string, remove func(ctx context.Context, key string) error) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, key := range keys {
+		if p.uses(key) {
+			continue
+		}
+		if err := remove(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Posts) uses(key string) bool {
+	for _, post := range p.posts {
+		for _, a := range post.card.Attachments {
+			if a.StorageKey == key {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (p *Posts) find(postID int) (post, bool) {
+	if postID < 1 || postID > len(p.posts) {
+		return post{}, false
+	}
+	return p.posts[postID-1], true
+}
@@ -0,0 +1,207 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"strings"
+	"time"
+
+	"forum/internal/models"
+	"forum/internal/repo"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type emailChange struct {
+	email     string
+	tokenHash string
+	expiresAt time.Time
+}
+
+// The methods below make Sessions the handlers' UserRepo as well, over the
+// same users. Usernames are compared folded and emails without case, as in
+// the SQL store; the placeholder account and its reserved names are not
+// modelled.
+
+func (s *Sessions) CreateUser(ctx context.Context, email string, username string, password string) error {
+	var hash []byte
+	if password != "" {
+		var err error
+		if hash, err = bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost); err != nil {
+			return err
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkUnique(0, email, username); err != nil {
+		return err
+	}
+	id := 1
+	for existing := range s.users {
+		id = max(id, existing+1)
+	}
+	s.users[id] = models.User{ID: id, Email: email, Username: username, Password: string(hash), Role: models.RoleUser}
+	return nil
+}
+
+// checkUnique reports whether email or username belong to a user other than
+// userID. The caller holds s.mu.
+func (s *Sessions) checkUnique(userID int, email string, username string) error {
+	for _, u := range s.users {
+		if u.ID == userID {
+			continue
+		}
+		if email != "" && strings.EqualFold(u.Email, email) {
+			return repo.ErrEmailTaken
+		}
+		if username != "" && repo.FoldUsername(u.Username) == repo.FoldUsername(username) {
+			return repo.ErrUsernameTaken
+		}
+	}
+	return nil
+}
+
+// find returns the first user match accepts. The caller holds s.mu.
+func (s *Sessions) find(match func(models.User) bool) (*models.User, error) {
+	for _, u := range s.users {
+		if match(u) {
+			return &u, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *Sessions) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.find(func(u models.User) bool { return strings.EqualFold(u.Email, email) })
+}
+
+func (s *Sessions) GetUserByID(ctx context.Context, id int) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.find(func(u models.User) bool { return u.ID == id })
+}
+
+func (s *Sessions) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.find(func(u models.User) bool { return repo.FoldUsername(u.Username) == repo.FoldUsername(username) })
+}
+
+func (s *Sessions) SearchUsernames(ctx context.Context, prefix string, limit int) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var names []string
+	for _, u := range s.users {
+		if strings.HasPrefix(repo.FoldUsername(u.Username), repo.FoldUsername(prefix)) {
+			names = append(names, u.Username)
+		}
+	}
+	sort.Strings(names)
+	if limit > 0 && len(names) > limit {
+		names = names[:limit]
+	}
+	return names, nil
+}
+
+// update applies change to the stored user, if there is one.
+func (s *Sessions) update(userID int, change func(*models.User)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if u, ok := s.users[userID]; ok {
+		change(&u)
+		s.users[userID] = u
+	}
+}
+
+func (s *Sessions) UpdateUsername(ctx context.Context, userID int, username string) error {
+	s.mu.Lock()
+	err := s.checkUnique(userID, "", username)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	s.update(userID, func(u *models.User) { u.Username = username })
+	return nil
+}
+
+func (s *Sessions) UpdatePassword(ctx context.Context, userID int, password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		return err
+	}
+	s.update(userID, func(u *models.User) { u.Password = string(hash) })
+	return nil
+}
+
+func (s *Sessions) UpdateLocale(ctx context.Context, userID int, locale string) error {
+	s.update(userID, func(u *models.User) { u.Locale = locale })
+	return nil
+}
+
+func (s *Sessions) UpdateTimezone(ctx context.Context, userID int, timezone string) error {
+	s.update(userID, func(u *models.User) { u.Timezone = timezone })
+	return nil
+}
+
+func (s *Sessions) CreateEmailChange(ctx context.Context, userID int, email string, tokenHash string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.emailChanges == nil {
+		s.emailChanges = map[int]emailChange{}
+	}
+	s.emailChanges[userID] = emailChange{email, tokenHash, expiresAt}
+	return nil
+}
+
+func (s *Sessions) GetPendingEmail(ctx context.Context, userID int) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, ok := s.emailChanges[userID]; ok && time.Now().Before(c.expiresAt) {
+		return c.email, nil
+	}
+	return "", nil
+}
+
+func (s *Sessions) ConfirmEmailChange(ctx context.Context, tokenHash string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for userID, c := range s.emailChanges {
+		if c.tokenHash != tokenHash {
+			continue
+		}
+		if time.Now().After(c.expiresAt) {
+			break
+		}
+		if err := s.checkUnique(userID, c.email, ""); err != nil {
+			return 0, err
+		}
+		u := s.users[userID]
+		u.Email = c.email
+		u.EmailVerified = true
+		s.users[userID] = u
+		delete(s.emailChanges, userID)
+		return userID, nil
+	}
+	return 0, repo.ErrEmailChangeExpired
+}
+
+// DeleteAccount forgets the user and their sessions. Posts are kept by
+// Posts, which this does not reach, so it returns no blob keys.
+func (s *Sessions) DeleteAccount(ctx context.Context, userID int, mode string) ([]string, error) {
+	if mode != repo.AccountAnonymize && mode != repo.AccountRemove {
+		return nil, repo.ErrUnknownDeleteMode
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.users, userID)
+	delete(s.emailChanges, userID)
+	for id, owner := range s.sessions {
+		if owner == userID {
+			delete(s.sessions, id)
+			delete(s.confirmed, id)
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,66 @@
+package repo
+
+import (
+	"context"
+
+	"forum/internal/models"
+)
+
+const (
+	MentionSourcePost    = "post"
+	MentionSourceComment = "comment"
+)
+
+func SaveMentions(ctx context.Context, db *DB, sourceType string, sourceID int, usernames []string) ([]models.User, error) {
+	if len(usernames) == 0 {
+		return nil, nil
+	}
+
+	args := make([]any, 0, len(usernames))
+	for _, name := range usernames {
+		args = append(args, FoldUsername(name))
+	}
+	rows, err := db.QueryContext(ctx,
+		`SELECT id, username FROM users WHERE username_folded IN (`+placeholders(len(usernames))+`)`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	var users []models.User
+	for rows.Next() {
+		var u models.User
+		if err := rows.Scan(&u.ID, &u.Username); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, nil
+	}
+
+	tx, err := db.BeginTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range users {
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO mentions (source_type, source_id, user_id) VALUES (?, ?, ?) ON CONFLICT DO NOTHING`,
+			sourceType, sourceID, u.ID,
+		)
+		if err != nil {
+			_ = tx.Rollback()
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
@@ -0,0 +1,187 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+
+	"forum/internal/models"
+)
+
+func CreateNotification(ctx context.Context, db *DB, n models.Notification) error {
+	if n.UserID == n.ActorID {
+		return nil
+	}
+
+	enabled, err := NotificationEnabled(ctx, db, n.UserID, n.Type)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return nil
+	}
+
+	var commentID sql.NullInt64
+	if n.CommentID != 0 {
+		commentID = sql.NullInt64{Int64: int64(n.CommentID), Valid: true}
+	}
+
+	// The same actor doing the same thing to the same target notifies once:
+	// taking a reaction back and adding it again must not ping the author
+	// each time.
+	var seen int
+	err = db.QueryRowContext(ctx, `
+        SELECT COUNT(*) FROM notifications
+        WHERE user_id = ? AND actor_id = ? AND type = ? AND post_id = ?
+          AND COALESCE(comment_id, 0) = ? AND detail = ?
+    `, n.UserID, n.ActorID, n.Type, n.PostID, n.CommentID, n.Detail).Scan(&seen)
+	if err != nil || seen > 0 {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx, `
+        INSERT INTO notifications (user_id, actor_id, type, post_id, comment_id, detail, created_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?)
+    `, n.UserID, n.ActorID, n.Type, n.PostID, commentID, n.Detail, now())
+	return err
+}
+
+func GetNotifications(ctx context.Context, db *DB, userID int, limit int) ([]models.Notification, error) {
+	if limit <= 0 {
+		limit = 50
+	}
+	query := `
+    SELECT n.id, n.user_id, n.actor_id, COALESCE(u.username, ''), n.type,
+           n.post_id, COALESCE(p.title, ''), COALESCE(n.comment_id, 0), n.detail,
+           n.created_at, n.read_at IS NOT NULL
+    FROM notifications n
+    LEFT JOIN users u ON u.id = n.actor_id
+    LEFT JOIN posts p ON p.id = n.post_id
+    WHERE n.user_id = ?
+    ORDER BY n.created_at DESC, n.id DESC
+    LIMIT ?
+    `
+	rows, err := db.QueryContext(ctx, query, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notifications []models.Notification
+	for rows.Next() {
+		var n models.Notification
+		if err := rows.Scan(&n.ID, &n.UserID, &n.ActorID, &n.ActorName, &n.Type,
+			&n.PostID, &n.PostTitle, &n.CommentID, &n.Detail,
+			&n.CreatedAt, &n.Read); err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return notifications, nil
+}
+
+func GetNotificationByID(ctx context.Context, db *DB, userID int, notificationID int) (*models.Notification, error) {
+	row := db.QueryRowContext(ctx, `
+    SELECT id, user_id, actor_id, type, post_id, COALESCE(comment_id, 0), detail, created_at, read_at IS NOT NULL
+    FROM notifications
+    WHERE id = ? AND user_id = ?
+    LIMIT 1
+    `, notificationID, userID)
+
+	var n models.Notification
+	err := row.Scan(&n.ID, &n.UserID, &n.ActorID, &n.Type, &n.PostID, &n.CommentID, &n.Detail, &n.CreatedAt, &n.Read)
+	if err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+func CountUnreadNotifications(ctx context.Context, db *DB, userID int) (int, error) {
+	var count int
+	err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM notifications WHERE user_id = ? AND read_at IS NULL`, userID).Scan(&count)
+	return count, err
+}
+
+func MarkNotificationRead(ctx context.Context, db *DB, userID int, notificationID int) error {
+	_, err := db.ExecContext(ctx,
+		`UPDATE notifications SET read_at = ? WHERE id = ? AND user_id = ? AND read_at IS NULL`,
+		now(), notificationID, userID,
+	)
+	return err
+}
+
+func MarkAllNotificationsRead(ctx context.Context, db *DB, userID int) error {
+	_, err := db.ExecContext(ctx,
+		`UPDATE notifications SET read_at = ? WHERE user_id = ? AND read_at IS NULL`,
+		now(), userID,
+	)
+	return err
+}
+
+func NotificationEnabled(ctx context.Context, db *DB, userID int, notificationType string) (bool, error) {
+	var enabled bool
+	err := db.QueryRowContext(ctx,
+		`SELECT enabled FROM notification_preferences WHERE user_id = ? AND type = ?`,
+		userID, notificationType,
+	).Scan(&enabled)
+	if err == sql.ErrNoRows {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return enabled, nil
+}
+
+func GetNotificationPreferences(ctx context.Context, db *DB, userID int) ([]models.NotificationPreference, error) {
+	rows, err := db.QueryContext(ctx, `SELECT type, enabled FROM notification_preferences WHERE user_id = ?`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stored := make(map[string]bool)
+	for rows.Next() {
+		var (
+			notificationType string
+			enabled          bool
+		)
+		if err := rows.Scan(&notificationType, &enabled); err != nil {
+			return nil, err
+		}
+		stored[notificationType] = enabled
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	prefs := make([]models.NotificationPreference, 0, len(models.NotificationTypes))
+	for _, t := range models.NotificationTypes {
+		enabled, ok := stored[t.Code]
+		if !ok {
+			enabled = true
+		}
+		prefs = append(prefs, models.NotificationPreference{Code: t.Code, LabelKey: t.LabelKey, Enabled: enabled})
+	}
+	return prefs, nil
+}
+
+func SetNotificationPreferences(ctx context.Context, db *DB, userID int, enabled map[string]bool) error {
+	tx, err := db.BeginTx(ctx)
+	if err != nil {
+		return err
+	}
+	for _, t := range models.NotificationTypes {
+		_, err = tx.ExecContext(ctx, `
+            INSERT INTO notification_preferences (user_id, type, enabled) VALUES (?, ?, ?)
+            ON CONFLICT (user_id, type) DO UPDATE SET enabled = excluded.enabled
+        `, userID, t.Code, enabled[t.Code])
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,226 @@
+package repo_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"testing"
+
+	"forum/internal/models"
+	"forum/internal/repo"
+)
+
+func TestCreateNotification(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	ann := createUser(t, db, "ann")
+	bob := createUser(t, db, "bob")
+	post := createPost(t, db, ann, "Hello")
+	bobComment, err := repo.CreateComment(ctx, db, post, bob, "Hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	annComment, err := repo.CreateComment(ctx, db, post, ann, "Hi back")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.SetNotificationPreferences(ctx, db, bob, map[string]bool{
+		models.NotificationComment:  true,
+		models.NotificationReaction: false,
+		models.NotificationMention:  true,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		n    models.Notification
+		// stored is how many notifications the recipient has afterwards.
+		stored int
+	}{
+		{"comment", models.Notification{UserID: ann, ActorID: bob, Type: models.NotificationComment, PostID: post, CommentID: bobComment}, 1},
+		{"same comment again", models.Notification{UserID: ann, ActorID: bob, Type: models.NotificationComment, PostID: post, CommentID: bobComment}, 1},
+		{"reaction", models.Notification{UserID: ann, ActorID: bob, Type: models.NotificationReaction, PostID: post, Detail: "like"}, 2},
+		{"same reaction again", models.Notification{UserID: ann, ActorID: bob, Type: models.NotificationReaction, PostID: post, Detail: "like"}, 2},
+		{"another reaction", models.Notification{UserID: ann, ActorID: bob, Type: models.NotificationReaction, PostID: post, Detail: "dislike"}, 3},
+		{"own action", models.Notification{UserID: ann, ActorID: ann, Type: models.NotificationComment, PostID: post, CommentID: annComment}, 3},
+		{"type turned off", models.Notification{UserID: bob, ActorID: ann, Type: models.NotificationReaction, PostID: post, Detail: "like"}, 0},
+		{"type left on", models.Notification{UserID: bob, ActorID: ann, Type: models.NotificationMention, PostID: post}, 1},
+	}
+	for _, tt := range tests {
+		if err := repo.CreateNotification(ctx, db, tt.n); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if n := count(t, db, `SELECT COUNT(*) FROM notifications WHERE user_id = ?`, tt.n.UserID); n != tt.stored {
+			t.Errorf("%s: %d notifications stored, want %d", tt.name, n, tt.stored)
+		}
+	}
+}
+
+func TestReadNotifications(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	ann := createUser(t, db, "ann")
+	bob := createUser(t, db, "bob")
+	post := createPost(t, db, ann, "Hello")
+	for _, detail := range []string{"like", "dislike", "heart"} {
+		n := models.Notification{UserID: ann, ActorID: bob, Type: models.NotificationReaction, PostID: post, Detail: detail}
+		if err := repo.CreateNotification(ctx, db, n); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	list, err := repo.GetNotifications(ctx, db, ann, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 3 {
+		t.Fatalf("%d notifications, want 3", len(list))
+	}
+	// Newest first, with the actor and post filled in.
+	if list[0].Detail != "heart" || list[0].ActorName != "bob" || list[0].PostTitle != "Hello" {
+		t.Errorf("newest notification = %+v", list[0])
+	}
+	if limited, err := repo.GetNotifications(ctx, db, ann, 2); err != nil || len(limited) != 2 {
+		t.Errorf("GetNotifications with limit 2 = %d, %v", len(limited), err)
+	}
+
+	unread := func() int {
+		t.Helper()
+		n, err := repo.CountUnreadNotifications(ctx, db, ann)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	if n := unread(); n != 3 {
+		t.Fatalf("%d unread, want 3", n)
+	}
+
+	// Another user cannot read or mark ann's notifications.
+	if _, err := repo.GetNotificationByID(ctx, db, bob, list[0].ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("bob read ann's notification: %v", err)
+	}
+	if err := repo.MarkNotificationRead(ctx, db, bob, list[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if n := unread(); n != 3 {
+		t.Errorf("bob marked ann's notification read: %d unread", n)
+	}
+
+	if err := repo.MarkNotificationRead(ctx, db, ann, list[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	n, err := repo.GetNotificationByID(ctx, db, ann, list[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !n.Read || unread() != 2 {
+		t.Errorf("after marking one read: read %v, %d unread", n.Read, unread())
+	}
+
+	if err := repo.MarkAllNotificationsRead(ctx, db, ann); err != nil {
+		t.Fatal(err)
+	}
+	if n := unread(); n != 0 {
+		t.Errorf("%d unread after marking all read", n)
+	}
+}
+
+func TestNotificationPreferences(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	ann := createUser(t, db, "ann")
+
+	enabled := func() map[string]bool {
+		t.Helper()
+		prefs, err := repo.GetNotificationPreferences(ctx, db, ann)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := make(map[string]bool)
+		for _, p := range prefs {
+			out[p.Code] = p.Enabled
+			on, err := repo.NotificationEnabled(ctx, db, ann, p.Code)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if on != p.Enabled {
+				t.Errorf("NotificationEnabled(%s) = %v, preferences say %v", p.Code, on, p.Enabled)
+			}
+		}
+		return out
+	}
+
+	// Everything is on until the user says otherwise.
+	for code, on := range enabled() {
+		if !on {
+			t.Errorf("%s is off by default", code)
+		}
+	}
+
+	tests := []map[string]bool{
+		{models.NotificationComment: true},
+		{models.NotificationReaction: true, models.NotificationMention: true},
+		{},
+	}
+	for _, set := range tests {
+		if err := repo.SetNotificationPreferences(ctx, db, ann, set); err != nil {
+			t.Fatal(err)
+		}
+		got := enabled()
+		for _, nt := range models.NotificationTypes {
+			if got[nt.Code] != set[nt.Code] {
+				t.Errorf("after setting %v: %s enabled %v", set, nt.Code, got[nt.Code])
+			}
+		}
+	}
+}
+
+func TestSaveMentions(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	ann := createUser(t, db, "ann")
+	bob := createUser(t, db, "bob")
+	carol := createUser(t, db, "Carol")
+	post := createPost(t, db, ann, "Hello")
+
+	tests := []struct {
+		name      string
+		usernames []string
+		want      []int
+		// stored is how many mentions the post has afterwards.
+		stored int
+	}{
+		{"nobody", nil, nil, 0},
+		{"unknown names", []string{"dave", "erin"}, nil, 0},
+		{"one user", []string{"bob"}, []int{bob}, 1},
+		{"again, with another in a different case", []string{"bob", "carol"}, []int{bob, carol}, 2},
+	}
+	for _, tt := range tests {
+		users, err := repo.SaveMentions(ctx, db, repo.MentionSourcePost, post, tt.usernames)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		var ids []int
+		for _, u := range users {
+			ids = append(ids, u.ID)
+		}
+		slices.Sort(ids)
+		if !slices.Equal(ids, tt.want) {
+			t.Errorf("%s: mentioned %v, want %v", tt.name, ids, tt.want)
+		}
+		if n := count(t, db, `SELECT COUNT(*) FROM mentions WHERE source_type = ? AND source_id = ?`, repo.MentionSourcePost, post); n != tt.stored {
+			t.Errorf("%s: %d mentions stored, want %d", tt.name, n, tt.stored)
+		}
+	}
+
+	// The same user mentioned from a comment is a separate mention.
+	if _, err := repo.SaveMentions(ctx, db, repo.MentionSourceComment, post, []string{"bob"}); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(t, db, `SELECT COUNT(*) FROM mentions WHERE user_id = ?`, bob); n != 2 {
+		t.Errorf("bob has %d mentions, want 2", n)
+	}
+}
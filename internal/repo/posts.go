@@ -1,6 +1,7 @@
 package repo
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"strings"
@@ -9,72 +10,97 @@ import (
 	"forum/internal/models"
 )
 
+// PostCardsFilter selects the cards shown in a feed. UserID is the user whose
+// own (MineOnly) or liked (LikedOnly) posts are listed and need not be the
+// viewer, so the same filters back both the home page and profile pages.
 type PostCardsFilter struct {
 	UserID       int
+	ViewerID     int
 	CategoryID   int
 	MineOnly     bool
 	LikedOnly    bool
 	CommentLimit int
+	Limit        int
+	Offset       int
 }
 
-func GetPostCards(db *sql.DB, filter PostCardsFilter) ([]models.PostCard, error) {
+func GetPostCards(ctx context.Context, db *DB, filter PostCardsFilter) ([]models.PostCard, error) {
 	commentLimit := filter.CommentLimit
 	if commentLimit <= 0 {
 		commentLimit = 3
 	}
 
+	// The joins come before the conditions in the query, so their arguments
+	// are kept apart and bound first.
+	var joins []string
+	var joinArgs []any
+	var conditions []string
+	var conditionArgs []any
+
+	if filter.LikedOnly {
+		joins = append(joins, "JOIN reactions r ON r.target_type = 'post' AND r.target_id = p.id AND r.user_id = ? AND r.kind IN "+scoredKindsSQL(1))
+		joinArgs = append(joinArgs, filter.UserID)
+	}
+	if filter.MineOnly {
+		conditions = append(conditions, "p.user_id = ?")
+		conditionArgs = append(conditionArgs, filter.UserID)
+	}
+	if filter.CategoryID != 0 {
+		joins = append(joins, "JOIN post_categories pcfilter ON pcfilter.post_id = p.id AND pcfilter.category_id = ?")
+		joinArgs = append(joinArgs, filter.CategoryID)
+	}
+
+	selected := "SELECT p.id FROM posts p"
+	if len(joins) > 0 {
+		selected += "\n" + strings.Join(joins, "\n")
+	}
+	if len(conditions) > 0 {
+		selected += "\nWHERE " + strings.Join(conditions, " AND ")
+	}
+	args := append(joinArgs, conditionArgs...)
+	selected += "\nORDER BY p.created_at DESC, p.id DESC"
+	if filter.Limit > 0 {
+		selected += " LIMIT ? OFFSET ?"
+		args = append(args, filter.Limit, filter.Offset)
+	}
+	args = append(args, commentLimit)
+
+	// The categories and the comment window are computed for the selected
+	// posts only, so the indexes on post_categories and comments apply.
 	query := `
+    WITH selected AS (` + selected + `)
     SELECT
         p.id, p.title, p.content,
         cat.names,
         u.username,
-        COALESCE((SELECT SUM(CASE WHEN value = 1 THEN 1 ELSE 0 END) FROM post_reactions pr WHERE pr.post_id = p.id), 0),
-        COALESCE((SELECT SUM(CASE WHEN value = -1 THEN 1 ELSE 0 END) FROM post_reactions pr WHERE pr.post_id = p.id), 0),
+        p.created_at, p.updated_at,
+        (SELECT COUNT(*) FROM reactions pr WHERE pr.target_type = 'post' AND pr.target_id = p.id AND pr.kind IN `+scoredKindsSQL(1)+`),
+        (SELECT COUNT(*) FROM reactions pr WHERE pr.target_type = 'post' AND pr.target_id = p.id AND pr.kind IN `+scoredKindsSQL(-1)+`),
         cm.id,
         cu.username,
-        cm.content
+        cm.content,
+        cm.created_at,
+        cm.updated_at
     FROM posts p
+    JOIN selected s ON s.id = p.id
     JOIN (
-        SELECT pc.post_id, GROUP_CONCAT(c.name, ', ') AS names
+        SELECT pc.post_id, string_agg(c.name, ', ') AS names
         FROM post_categories pc
         JOIN categories c ON c.id = pc.category_id
+        WHERE pc.post_id IN (SELECT id FROM selected)
         GROUP BY pc.post_id
     ) cat ON cat.post_id = p.id
     JOIN users u ON u.id = p.user_id
     LEFT JOIN (
         SELECT c.*, ROW_NUMBER() OVER (PARTITION BY c.post_id ORDER BY c.created_at DESC) AS rn
         FROM comments c
+        WHERE c.post_id IN (SELECT id FROM selected)
     ) cm ON cm.post_id = p.id AND cm.rn <= ?
     LEFT JOIN users cu ON cu.id = cm.user_id
+    ORDER BY p.created_at DESC, p.id DESC, cm.created_at DESC
     `
 
-	var joins []string
-	var conditions []string
-	args := []any{commentLimit}
-
-	if filter.LikedOnly {
-		joins = append(joins, "JOIN post_reactions r ON r.post_id = p.id AND r.user_id = ? AND r.value = 1")
-		args = append(args, filter.UserID)
-	}
-	if filter.MineOnly {
-		conditions = append(conditions, "p.user_id = ?")
-		args = append(args, filter.UserID)
-	}
-	if filter.CategoryID != 0 {
-		joins = append(joins, "JOIN post_categories pcfilter ON pcfilter.post_id = p.id AND pcfilter.category_id = ?")
-		args = append(args, filter.CategoryID)
-	}
-
-	if len(joins) > 0 {
-		query += "\n" + strings.Join(joins, "\n")
-	}
-	if len(conditions) > 0 {
-		query += "\nWHERE " + strings.Join(conditions, " AND ")
-	}
-
-	query += "\nORDER BY p.created_at DESC, cm.created_at DESC"
-
-	rows, err := db.Query(query, args...)
+	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -90,11 +116,15 @@ func GetPostCards(db *sql.DB, filter PostCardsFilter) ([]models.PostCard, error)
 			content         string
 			categoryName    string
 			authorName      string
+			createdAt       time.Time
+			updatedAt       time.Time
 			likes           int
 			dislikes        int
 			commentID       sql.NullInt64
 			commentAuthor   sql.NullString
 			commentContent  sql.NullString
+			commentCreated  sql.NullTime
+			commentUpdated  sql.NullTime
 		)
 
 		if err := rows.Scan(
@@ -103,11 +133,15 @@ func GetPostCards(db *sql.DB, filter PostCardsFilter) ([]models.PostCard, error)
 			&content,
 			&categoryName,
 			&authorName,
+			&createdAt,
+			&updatedAt,
 			&likes,
 			&dislikes,
 			&commentID,
 			&commentAuthor,
 			&commentContent,
+			&commentCreated,
+			&commentUpdated,
 		); err != nil {
 			return nil, err
 		}
@@ -120,6 +154,8 @@ func GetPostCards(db *sql.DB, filter PostCardsFilter) ([]models.PostCard, error)
 				Content:      content,
 				CategoryName: categoryName,
 				AuthorName:   authorName,
+				CreatedAt:    createdAt,
+				UpdatedAt:    updatedAt,
 				Likes:        likes,
 				Dislikes:     dislikes,
 			}
@@ -130,8 +166,11 @@ func GetPostCards(db *sql.DB, filter PostCardsFilter) ([]models.PostCard, error)
 
 		if commentID.Valid {
 			card.Comments = append(card.Comments, models.CommentCard{
+				ID:         int(commentID.Int64),
 				AuthorName: commentAuthor.String,
 				Content:    commentContent.String,
+				CreatedAt:  commentCreated.Time,
+				UpdatedAt:  commentUpdated.Time,
 			})
 		}
 	}
@@ -140,40 +179,58 @@ func GetPostCards(db *sql.DB, filter PostCardsFilter) ([]models.PostCard, error)
 		return nil, err
 	}
 
+	postIDs := make([]int, 0, len(cards))
+	for _, card := range cards {
+		postIDs = append(postIDs, card.ID)
+	}
+	reactions, err := GetReactionSummaries(ctx, db, ReactionTargetPost, postIDs, filter.ViewerID)
+	if err != nil {
+		return nil, err
+	}
+	attachments, err := GetAttachmentsByPostIDs(ctx, db, postIDs)
+	if err != nil {
+		return nil, err
+	}
+	for i := range cards {
+		cards[i].Reactions = reactions[cards[i].ID]
+		cards[i].Attachments = attachments[cards[i].ID]
+	}
+
 	return cards, nil
 }
 
-func CreatePost(db *sql.DB, userID int, title string, content string, categoryIDs []int) (int, error) {
+func CreatePost(ctx context.Context, db *DB, userID int, title string, content string, categoryIDs []int, attachments []models.Attachment) (int, error) {
 	if len(categoryIDs) == 0 {
 		return 0, errors.New("category list is empty")
 	}
-	query := `INSERT INTO posts (user_id, title, content, category_id, created_at) VALUES (?, ?, ?, ?, ?)`
+	query := `INSERT INTO posts (user_id, title, content, category_id, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?) RETURNING id`
 
-	tx, err := db.Begin()
+	tx, err := db.BeginTx(ctx)
 	if err != nil {
 		return 0, err
 	}
 
-	res, err := tx.Exec(query, userID, title, content, categoryIDs[0], time.Now())
-	if err != nil {
-		_ = tx.Rollback()
-		return 0, err
-	}
-
-	postID, err := res.LastInsertId()
+	createdAt := now()
+	var postID int64
+	err = tx.QueryRowContext(ctx, query, userID, title, content, categoryIDs[0], createdAt, createdAt).Scan(&postID)
 	if err != nil {
 		_ = tx.Rollback()
 		return 0, err
 	}
 
 	for _, categoryID := range categoryIDs {
-		_, err = tx.Exec(`INSERT OR IGNORE INTO post_categories (post_id, category_id) VALUES (?, ?)`, postID, categoryID)
+		_, err = tx.ExecContext(ctx, `INSERT INTO post_categories (post_id, category_id) VALUES (?, ?) ON CONFLICT DO NOTHING`, postID, categoryID)
 		if err != nil {
 			_ = tx.Rollback()
 			return 0, err
 		}
 	}
 
+	if err := insertAttachments(ctx, tx, postID, userID, attachments); err != nil {
+		_ = tx.Rollback()
+		return 0, err
+	}
+
 	if err := tx.Commit(); err != nil {
 		return 0, err
 	}
@@ -181,17 +238,17 @@ func CreatePost(db *sql.DB, userID int, title string, content string, categoryID
 	return int(postID), nil
 }
 
-func GetAllPosts(db *sql.DB) ([]models.PostView, error) {
+func GetAllPosts(ctx context.Context, db *DB) ([]models.PostView, error) {
 	query := `
     SELECT 
         p.id, p.user_id, p.title, p.content,
         p.category_id, c.name,
-        p.created_at, p.count_likes
+        p.created_at, p.updated_at, p.count_likes
     FROM posts p
     JOIN categories c ON c.id = p.category_id
     ORDER BY p.created_at DESC
     `
-	rows, err := db.Query(query)
+	rows, err := db.QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -200,7 +257,7 @@ func GetAllPosts(db *sql.DB) ([]models.PostView, error) {
 	var posts []models.PostView
 	for rows.Next() {
 		var p models.PostView
-		if err := rows.Scan(&p.ID, &p.UserID, &p.Title, &p.Content, &p.CategoryID, &p.CategoryName, &p.CreatedAt, &p.CountLikes); err != nil {
+		if err := rows.Scan(&p.ID, &p.UserID, &p.Title, &p.Content, &p.CategoryID, &p.CategoryName, &p.CreatedAt, &p.UpdatedAt, &p.CountLikes); err != nil {
 			return nil, err
 		}
 		posts = append(posts, p)
@@ -211,18 +268,18 @@ func GetAllPosts(db *sql.DB) ([]models.PostView, error) {
 	return posts, nil
 }
 
-func GetPostsByCategory(db *sql.DB, categoryID int) ([]models.PostView, error) {
+func GetPostsByCategory(ctx context.Context, db *DB, categoryID int) ([]models.PostView, error) {
 	query := `
     SELECT 
         p.id, p.user_id, p.title, p.content,
         p.category_id, c.name,
-        p.created_at, p.count_likes
+        p.created_at, p.updated_at, p.count_likes
     FROM posts p
     JOIN categories c ON c.id = p.category_id
     WHERE p.category_id = ?
     ORDER BY p.created_at DESC
     `
-	rows, err := db.Query(query, categoryID)
+	rows, err := db.QueryContext(ctx, query, categoryID)
 	if err != nil {
 		return nil, err
 	}
@@ -231,7 +288,7 @@ func GetPostsByCategory(db *sql.DB, categoryID int) ([]models.PostView, error) {
 	var posts []models.PostView
 	for rows.Next() {
 		var p models.PostView
-		if err := rows.Scan(&p.ID, &p.UserID, &p.Title, &p.Content, &p.CategoryID, &p.CategoryName, &p.CreatedAt, &p.CountLikes); err != nil {
+		if err := rows.Scan(&p.ID, &p.UserID, &p.Title, &p.Content, &p.CategoryID, &p.CategoryName, &p.CreatedAt, &p.UpdatedAt, &p.CountLikes); err != nil {
 			return nil, err
 		}
 		posts = append(posts, p)
@@ -242,45 +299,47 @@ func GetPostsByCategory(db *sql.DB, categoryID int) ([]models.PostView, error) {
 	return posts, nil
 }
 
-func GetPostByID(db *sql.DB, postID int) (*models.PostView, error) {
+func GetPostByID(ctx context.Context, db *DB, postID int) (*models.PostView, error) {
 	query := `
     SELECT 
         p.id, p.user_id, p.title, p.content,
         p.category_id, c.name,
-        p.created_at, p.count_likes
+        p.created_at, p.updated_at, p.count_likes
     FROM posts p
     JOIN categories c ON c.id = p.category_id
     WHERE p.id = ?
     LIMIT 1
     `
-	row := db.QueryRow(query, postID)
+	row := db.QueryRowContext(ctx, query, postID)
 
 	var p models.PostView
-	err := row.Scan(&p.ID, &p.UserID, &p.Title, &p.Content, &p.CategoryID, &p.CategoryName, &p.CreatedAt, &p.CountLikes)
+	err := row.Scan(&p.ID, &p.UserID, &p.Title, &p.Content, &p.CategoryID, &p.CategoryName, &p.CreatedAt, &p.UpdatedAt, &p.CountLikes)
 	if err != nil {
 		return nil, err
 	}
 	return &p, nil
 }
 
-func GetPostCardWithComments(db *sql.DB, postID int) (*models.PostCardWithComments, error) {
+func GetPostCardWithComments(ctx context.Context, db *DB, postID int, viewerID int) (*models.PostCardWithComments, error) {
 	query := `
     SELECT
         p.id, p.title, p.content,
         cat.names,
         u.username,
-        COALESCE((SELECT SUM(CASE WHEN value = 1 THEN 1 ELSE 0 END) FROM post_reactions pr WHERE pr.post_id = p.id), 0),
-        COALESCE((SELECT SUM(CASE WHEN value = -1 THEN 1 ELSE 0 END) FROM post_reactions pr WHERE pr.post_id = p.id), 0),
+        p.created_at, p.updated_at,
+        (SELECT COUNT(*) FROM reactions pr WHERE pr.target_type = 'post' AND pr.target_id = p.id AND pr.kind IN `+scoredKindsSQL(1)+`),
+        (SELECT COUNT(*) FROM reactions pr WHERE pr.target_type = 'post' AND pr.target_id = p.id AND pr.kind IN `+scoredKindsSQL(-1)+`),
         cm.id,
         cm.user_id,
         cu.username,
         cm.content,
         cm.created_at,
-        COALESCE((SELECT SUM(CASE WHEN value = 1 THEN 1 ELSE 0 END) FROM comment_reactions cr WHERE cr.comment_id = cm.id), 0),
-        COALESCE((SELECT SUM(CASE WHEN value = -1 THEN 1 ELSE 0 END) FROM comment_reactions cr WHERE cr.comment_id = cm.id), 0)
+        cm.updated_at,
+        (SELECT COUNT(*) FROM reactions cr WHERE cr.target_type = 'comment' AND cr.target_id = cm.id AND cr.kind IN `+scoredKindsSQL(1)+`),
+        (SELECT COUNT(*) FROM reactions cr WHERE cr.target_type = 'comment' AND cr.target_id = cm.id AND cr.kind IN `+scoredKindsSQL(-1)+`)
     FROM posts p
     JOIN (
-        SELECT pc.post_id, GROUP_CONCAT(c.name, ', ') AS names
+        SELECT pc.post_id, string_agg(c.name, ', ') AS names
         FROM post_categories pc
         JOIN categories c ON c.id = pc.category_id
         GROUP BY pc.post_id
@@ -292,7 +351,7 @@ func GetPostCardWithComments(db *sql.DB, postID int) (*models.PostCardWithCommen
     ORDER BY cm.created_at DESC
     `
 
-	rows, err := db.Query(query, postID)
+	rows, err := db.QueryContext(ctx, query, postID)
 	if err != nil {
 		return nil, err
 	}
@@ -307,6 +366,8 @@ func GetPostCardWithComments(db *sql.DB, postID int) (*models.PostCardWithCommen
 			content         string
 			categoryName    string
 			authorName      string
+			createdAt       time.Time
+			updatedAt       time.Time
 			likes           int
 			dislikes        int
 			commentID       sql.NullInt64
@@ -314,6 +375,7 @@ func GetPostCardWithComments(db *sql.DB, postID int) (*models.PostCardWithCommen
 			commentAuthor   sql.NullString
 			commentContent  sql.NullString
 			commentCreated  sql.NullTime
+			commentUpdated  sql.NullTime
 			commentLikes    sql.NullInt64
 			commentDislikes sql.NullInt64
 		)
@@ -324,6 +386,8 @@ func GetPostCardWithComments(db *sql.DB, postID int) (*models.PostCardWithCommen
 			&content,
 			&categoryName,
 			&authorName,
+			&createdAt,
+			&updatedAt,
 			&likes,
 			&dislikes,
 			&commentID,
@@ -331,6 +395,7 @@ func GetPostCardWithComments(db *sql.DB, postID int) (*models.PostCardWithCommen
 			&commentAuthor,
 			&commentContent,
 			&commentCreated,
+			&commentUpdated,
 			&commentLikes,
 			&commentDislikes,
 		); err != nil {
@@ -344,6 +409,8 @@ func GetPostCardWithComments(db *sql.DB, postID int) (*models.PostCardWithCommen
 				Content:      content,
 				CategoryName: categoryName,
 				AuthorName:   authorName,
+				CreatedAt:    createdAt,
+				UpdatedAt:    updatedAt,
 				Likes:        likes,
 				Dislikes:     dislikes,
 			}
@@ -352,10 +419,12 @@ func GetPostCardWithComments(db *sql.DB, postID int) (*models.PostCardWithCommen
 		if commentID.Valid {
 			comment := models.CommentView{
 				ID:         int(commentID.Int64),
+				PostID:     id,
 				UserID:     int(commentUserID.Int64),
 				AuthorName: commentAuthor.String,
 				Content:    commentContent.String,
 				CreatedAt:  commentCreated.Time,
+				UpdatedAt:  commentUpdated.Time,
 				Likes:      int(commentLikes.Int64),
 				Dislikes:   int(commentDislikes.Int64),
 			}
@@ -370,21 +439,45 @@ func GetPostCardWithComments(db *sql.DB, postID int) (*models.PostCardWithCommen
 		return nil, sql.ErrNoRows
 	}
 
+	postReactions, err := GetReactionSummaries(ctx, db, ReactionTargetPost, []int{post.ID}, viewerID)
+	if err != nil {
+		return nil, err
+	}
+	post.Reactions = postReactions[post.ID]
+
+	attachments, err := GetAttachmentsByPostIDs(ctx, db, []int{post.ID})
+	if err != nil {
+		return nil, err
+	}
+	post.Attachments = attachments[post.ID]
+
+	commentIDs := make([]int, 0, len(post.Comments))
+	for _, c := range post.Comments {
+		commentIDs = append(commentIDs, c.ID)
+	}
+	commentReactions, err := GetReactionSummaries(ctx, db, ReactionTargetComment, commentIDs, viewerID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range post.Comments {
+		post.Comments[i].Reactions = commentReactions[post.Comments[i].ID]
+	}
+
 	return post, nil
 }
 
-func GetMyPosts(db *sql.DB, userID int) ([]models.PostView, error) {
+func GetMyPosts(ctx context.Context, db *DB, userID int) ([]models.PostView, error) {
 	query := `
     SELECT 
         p.id, p.user_id, p.title, p.content,
         p.category_id, c.name,
-        p.created_at, p.count_likes
+        p.created_at, p.updated_at, p.count_likes
     FROM posts p
     JOIN categories c ON c.id = p.category_id
     WHERE p.user_id = ?
     ORDER BY p.created_at DESC
     `
-	rows, err := db.Query(query, userID)
+	rows, err := db.QueryContext(ctx, query, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -393,7 +486,7 @@ func GetMyPosts(db *sql.DB, userID int) ([]models.PostView, error) {
 	var posts []models.PostView
 	for rows.Next() {
 		var p models.PostView
-		if err := rows.Scan(&p.ID, &p.UserID, &p.Title, &p.Content, &p.CategoryID, &p.CategoryName, &p.CreatedAt, &p.CountLikes); err != nil {
+		if err := rows.Scan(&p.ID, &p.UserID, &p.Title, &p.Content, &p.CategoryID, &p.CategoryName, &p.CreatedAt, &p.UpdatedAt, &p.CountLikes); err != nil {
 			return nil, err
 		}
 		posts = append(posts, p)
@@ -404,19 +497,19 @@ func GetMyPosts(db *sql.DB, userID int) ([]models.PostView, error) {
 	return posts, nil
 }
 
-func GetLikedPosts(db *sql.DB, userID int) ([]models.PostView, error) {
+func GetLikedPosts(ctx context.Context, db *DB, userID int) ([]models.PostView, error) {
 	query := `
     SELECT 
         p.id, p.user_id, p.title, p.content,
         p.category_id, c.name,
-        p.created_at, p.count_likes
+        p.created_at, p.updated_at, p.count_likes
     FROM posts p
     JOIN categories c ON c.id = p.category_id
-    JOIN post_reactions r ON r.post_id = p.id
-    WHERE r.user_id = ? AND r.value = 1
+    JOIN reactions r ON r.target_type = 'post' AND r.target_id = p.id
+    WHERE r.user_id = ? AND r.kind IN `+scoredKindsSQL(1)+`
     ORDER BY p.created_at DESC
     `
-	rows, err := db.Query(query, userID)
+	rows, err := db.QueryContext(ctx, query, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -425,7 +518,7 @@ func GetLikedPosts(db *sql.DB, userID int) ([]models.PostView, error) {
 	var posts []models.PostView
 	for rows.Next() {
 		var p models.PostView
-		if err := rows.Scan(&p.ID, &p.UserID, &p.Title, &p.Content, &p.CategoryID, &p.CategoryName, &p.CreatedAt, &p.CountLikes); err != nil {
+		if err := rows.Scan(&p.ID, &p.UserID, &p.Title, &p.Content, &p.CategoryID, &p.CategoryName, &p.CreatedAt, &p.UpdatedAt, &p.CountLikes); err != nil {
 			return nil, err
 		}
 		posts = append(posts, p)
@@ -436,8 +529,8 @@ func GetLikedPosts(db *sql.DB, userID int) ([]models.PostView, error) {
 	return posts, nil
 }
 
-func PostExists(db *sql.DB, postID int) (bool, error) {
-	row := db.QueryRow(`SELECT 1 FROM posts WHERE id = ? LIMIT 1`, postID)
+func PostExists(ctx context.Context, db *DB, postID int) (bool, error) {
+	row := db.QueryRowContext(ctx, `SELECT 1 FROM posts WHERE id = ? LIMIT 1`, postID)
 	var one int
 	err := row.Scan(&one)
 	if err == sql.ErrNoRows {
@@ -448,3 +541,9 @@ func PostExists(db *sql.DB, postID int) (bool, error) {
 	}
 	return true, nil
 }
+
+func GetPostAuthorID(ctx context.Context, db *DB, postID int) (int, error) {
+	var userID int
+	err := db.QueryRowContext(ctx, `SELECT user_id FROM posts WHERE id = ? LIMIT 1`, postID).Scan(&userID)
+	return userID, err
+}
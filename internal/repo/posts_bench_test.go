@@ -0,0 +1,122 @@
+package repo_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"forum/internal/repo"
+)
+
+const (
+	benchUsers    = 50
+	benchPosts    = 100_000
+	benchComments = 5
+)
+
+// seedFeed fills db with benchPosts posts spread over benchUsers authors and
+// every category. Every tenth post gets comments and reactions. The rows are
+// written with plain INSERTs because CreateUser hashes passwords and
+// CreatePost runs one transaction per post.
+func seedFeed(b *testing.B, db *repo.DB) {
+	b.Helper()
+	ctx := context.Background()
+	var categories int
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM categories").Scan(&categories); err != nil {
+		b.Fatal(err)
+	}
+	tx, err := db.BeginTx(ctx)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	insert := func(table, columns string, rows [][]any) {
+		if len(rows) == 0 {
+			return
+		}
+		holder := "(" + strings.TrimSuffix(strings.Repeat("?, ", len(rows[0])), ", ") + ")"
+		values := make([]string, len(rows))
+		var args []any
+		for i, row := range rows {
+			values[i] = holder
+			args = append(args, row...)
+		}
+		query := "INSERT INTO " + table + " (" + columns + ") VALUES " + strings.Join(values, ", ")
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	start := time.Now().Add(-benchPosts * time.Minute)
+	var users [][]any
+	for u := 1; u <= benchUsers; u++ {
+		users = append(users, []any{u, fmt.Sprintf("bench%d@example.com", u), fmt.Sprintf("bench%d", u), "", start})
+	}
+	insert("users", "id, email, username, password, created_at", users)
+
+	const batch = 500
+	var posts, links, comments, reactions [][]any
+	flush := func() {
+		insert("posts", "id, user_id, title, content, category_id, created_at, updated_at", posts)
+		insert("post_categories", "post_id, category_id", links)
+		insert("comments", "post_id, user_id, content, created_at, updated_at", comments)
+		insert("reactions", "user_id, target_type, target_id, kind, created_at", reactions)
+		posts, links, comments, reactions = posts[:0], links[:0], comments[:0], reactions[:0]
+	}
+	for id := 1; id <= benchPosts; id++ {
+		author := id%benchUsers + 1
+		category := id%categories + 1
+		at := start.Add(time.Duration(id) * time.Minute)
+		posts = append(posts, []any{id, author, fmt.Sprintf("Post %d", id), "Some content for the feed.", category, at, at})
+		links = append(links, []any{id, category})
+		if id%10 == 0 {
+			for c := 0; c < benchComments; c++ {
+				commenter := (id+c)%benchUsers + 1
+				comments = append(comments, []any{id, commenter, "A comment.", at.Add(time.Duration(c) * time.Second), at})
+				reactions = append(reactions, []any{commenter, "post", id, "like", at})
+			}
+		}
+		if len(posts) == batch {
+			flush()
+		}
+	}
+	flush()
+	if err := tx.Commit(); err != nil {
+		b.Fatal(err)
+	}
+}
+
+// BenchmarkHomeFeed runs the queries behind each tab of the home page against
+// a feed of benchPosts posts.
+func BenchmarkHomeFeed(b *testing.B) {
+	db := openTestDB(b)
+	seedFeed(b, db)
+	ctx := context.Background()
+
+	const viewer = 1
+	tabs := []struct {
+		name   string
+		filter repo.PostCardsFilter
+	}{
+		{"all", repo.PostCardsFilter{}},
+		{"category", repo.PostCardsFilter{CategoryID: 1}},
+		{"mine", repo.PostCardsFilter{MineOnly: true, UserID: viewer}},
+		{"liked", repo.PostCardsFilter{LikedOnly: true, UserID: viewer}},
+		{"all_page", repo.PostCardsFilter{Limit: 20}},
+		{"category_page", repo.PostCardsFilter{CategoryID: 1, Limit: 20, Offset: 1000}},
+	}
+	for _, tab := range tabs {
+		tab.filter.ViewerID = viewer
+		tab.filter.CommentLimit = 3
+		b.Run(tab.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := repo.GetPostCards(ctx, db, tab.filter); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
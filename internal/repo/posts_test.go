@@ -0,0 +1,134 @@
+package repo_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"forum/internal/repo"
+)
+
+// feed is a small forum for the filter tests: ann wrote the posts a1 and a2,
+// bob wrote b1 and b2, and the posts were created in that order.
+type feed struct {
+	db       *repo.DB
+	ann, bob int
+	posts    map[string]int
+}
+
+func newFeed(t *testing.T) *feed {
+	t.Helper()
+	db := openTestDB(t)
+	f := &feed{db: db, posts: map[string]int{}}
+	f.ann = createUser(t, db, "ann")
+	f.bob = createUser(t, db, "bob")
+
+	f.posts["a1"] = createPost(t, db, f.ann, "a1", 1)
+	f.posts["a2"] = createPost(t, db, f.ann, "a2", 2)
+	f.posts["b1"] = createPost(t, db, f.bob, "b1", 1, 2)
+	f.posts["b2"] = createPost(t, db, f.bob, "b2", 2)
+
+	ctx := context.Background()
+	for _, r := range []struct {
+		user int
+		post string
+		kind string
+	}{
+		{f.ann, "a1", "like"},
+		{f.ann, "b1", "like"},
+		{f.ann, "b2", "heart"},
+		{f.ann, "a2", "dislike"},
+		{f.bob, "a2", "like"},
+	} {
+		if _, err := repo.ToggleReaction(ctx, db, r.user, repo.ReactionTargetPost, f.posts[r.post], r.kind); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return f
+}
+
+// titles returns the titles of the cards filter selects, in feed order.
+func (f *feed) titles(t *testing.T, filter repo.PostCardsFilter) []string {
+	t.Helper()
+	cards, err := repo.GetPostCards(context.Background(), f.db, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	titles := make([]string, 0, len(cards))
+	for _, c := range cards {
+		titles = append(titles, c.Title)
+	}
+	return titles
+}
+
+func TestGetPostCardsFilters(t *testing.T) {
+	f := newFeed(t)
+
+	tests := []struct {
+		name   string
+		filter repo.PostCardsFilter
+		want   []string
+	}{
+		{"all", repo.PostCardsFilter{}, []string{"b2", "b1", "a2", "a1"}},
+		{"category", repo.PostCardsFilter{CategoryID: 1}, []string{"b1", "a1"}},
+		{"mine", repo.PostCardsFilter{MineOnly: true, UserID: f.ann}, []string{"a2", "a1"}},
+		{"liked", repo.PostCardsFilter{LikedOnly: true, UserID: f.ann}, []string{"b1", "a1"}},
+		{"liked by another user", repo.PostCardsFilter{LikedOnly: true, UserID: f.bob}, []string{"a2"}},
+		{"mine in category", repo.PostCardsFilter{MineOnly: true, UserID: f.ann, CategoryID: 2}, []string{"a2"}},
+		{"mine in other category", repo.PostCardsFilter{MineOnly: true, UserID: f.bob, CategoryID: 1}, []string{"b1"}},
+		{"liked in category", repo.PostCardsFilter{LikedOnly: true, UserID: f.ann, CategoryID: 2}, []string{"b1"}},
+		{"liked and mine", repo.PostCardsFilter{LikedOnly: true, MineOnly: true, UserID: f.ann}, []string{"a1"}},
+		{"liked and mine in category", repo.PostCardsFilter{LikedOnly: true, MineOnly: true, UserID: f.ann, CategoryID: 1}, []string{"a1"}},
+		{"liked and mine in empty category", repo.PostCardsFilter{LikedOnly: true, MineOnly: true, UserID: f.ann, CategoryID: 2}, []string{}},
+		{"page", repo.PostCardsFilter{Limit: 2, Offset: 1}, []string{"b1", "a2"}},
+		{"page in category", repo.PostCardsFilter{CategoryID: 2, Limit: 1, Offset: 1}, []string{"b1"}},
+		{"page of mine in category", repo.PostCardsFilter{MineOnly: true, UserID: f.bob, CategoryID: 2, Limit: 1, Offset: 1}, []string{"b1"}},
+		{"past the end", repo.PostCardsFilter{Limit: 2, Offset: 4}, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.filter.ViewerID = f.ann
+			if got := f.titles(t, tt.filter); !slices.Equal(got, tt.want) {
+				t.Errorf("titles = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetPostCardsCountsAndComments(t *testing.T) {
+	f := newFeed(t)
+	ctx := context.Background()
+	for _, content := range []string{"first", "second", "third"} {
+		if _, err := repo.CreateComment(ctx, f.db, f.posts["a2"], f.bob, content); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cards, err := repo.GetPostCards(ctx, f.db, repo.PostCardsFilter{ViewerID: f.ann, MineOnly: true, UserID: f.ann, CommentLimit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cards) != 2 {
+		t.Fatalf("got %d cards, want 2", len(cards))
+	}
+	card := cards[0]
+	if card.Title != "a2" || card.AuthorName != "ann" {
+		t.Fatalf("first card = %q by %q, want a2 by ann", card.Title, card.AuthorName)
+	}
+	if card.Likes != 1 || card.Dislikes != 1 {
+		t.Errorf("likes, dislikes = %d, %d, want 1, 1", card.Likes, card.Dislikes)
+	}
+	if card.CategoryName == "" {
+		t.Error("category name is empty")
+	}
+	var comments []string
+	for _, c := range card.Comments {
+		comments = append(comments, c.Content)
+	}
+	if want := []string{"third", "second"}; !slices.Equal(comments, want) {
+		t.Errorf("comments = %v, want %v", comments, want)
+	}
+	if len(cards[1].Comments) != 0 {
+		t.Errorf("a1 has %d comments, want 0", len(cards[1].Comments))
+	}
+}
@@ -0,0 +1,98 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+
+	"forum/internal/models"
+)
+
+func GetProfileByUsername(ctx context.Context, db *DB, username string) (*models.Profile, error) {
+	score := reactionScoreSQL("r.kind")
+	query := `
+    SELECT
+        u.id, u.username, u.bio, u.avatar_key, u.avatar_type, u.profile_visibility, u.show_liked, u.created_at,
+        (SELECT COUNT(*) FROM posts p WHERE p.user_id = u.id),
+        (SELECT COUNT(*) FROM comments c WHERE c.user_id = u.id),
+        (SELECT COALESCE(SUM(` + score + `), 0)
+            FROM reactions r JOIN posts p ON p.id = r.target_id
+            WHERE r.target_type = 'post' AND p.user_id = u.id AND r.user_id <> u.id)
+        + (SELECT COALESCE(SUM(` + score + `), 0)
+            FROM reactions r JOIN comments c ON c.id = r.target_id
+            WHERE r.target_type = 'comment' AND c.user_id = u.id AND r.user_id <> u.id)
+    FROM users u
+    WHERE u.username_folded = ?
+    LIMIT 1
+`
+	var p models.Profile
+	var joinedAt sql.NullTime
+	err := db.QueryRowContext(ctx, query, FoldUsername(username)).Scan(
+		&p.UserID,
+		&p.Username,
+		&p.Bio,
+		&p.AvatarKey,
+		&p.AvatarType,
+		&p.Visibility,
+		&p.ShowLiked,
+		&joinedAt,
+		&p.PostCount,
+		&p.CommentCount,
+		&p.Reputation,
+	)
+	if err != nil {
+		return nil, err
+	}
+	p.JoinedAt = joinedAt.Time
+
+	return &p, nil
+}
+
+func GetCommentsByUserID(ctx context.Context, db *DB, userID int, limit int, offset int) ([]models.ProfileComment, error) {
+	query := `
+    SELECT c.id, c.post_id, p.title, c.content, c.created_at, c.updated_at
+    FROM comments c
+    JOIN posts p ON p.id = c.post_id
+    WHERE c.user_id = ?
+    ORDER BY c.created_at DESC, c.id DESC
+    LIMIT ? OFFSET ?
+`
+	rows, err := db.QueryContext(ctx, query, userID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	comments := make([]models.ProfileComment, 0)
+	for rows.Next() {
+		var c models.ProfileComment
+		if err := rows.Scan(&c.ID, &c.PostID, &c.PostTitle, &c.Content, &c.CreatedAt, &c.UpdatedAt); err != nil {
+			return nil, err
+		}
+		comments = append(comments, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return comments, nil
+}
+
+func UpdateProfile(ctx context.Context, db *DB, userID int, bio string, visibility string, showLiked bool) error {
+	_, err := db.ExecContext(ctx,
+		`UPDATE users SET bio = ?, profile_visibility = ?, show_liked = ? WHERE id = ?`,
+		bio, visibility, showLiked, userID,
+	)
+	return err
+}
+
+func SetAvatar(ctx context.Context, db *DB, userID int, key string, contentType string) error {
+	_, err := db.ExecContext(ctx, `UPDATE users SET avatar_key = ?, avatar_type = ? WHERE id = ?`, key, contentType, userID)
+	return err
+}
+
+// GetAvatarContentType returns the stored content type of an avatar that is
+// still in use by some user, or sql.ErrNoRows.
+func GetAvatarContentType(ctx context.Context, db *DB, key string) (string, error) {
+	var contentType string
+	err := db.QueryRowContext(ctx, `SELECT avatar_type FROM users WHERE avatar_key = ? AND avatar_key <> '' LIMIT 1`, key).Scan(&contentType)
+	return contentType, err
+}
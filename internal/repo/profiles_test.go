@@ -0,0 +1,144 @@
+package repo_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"testing"
+
+	"forum/internal/repo"
+)
+
+func TestGetProfileByUsername(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	ann := createUser(t, db, "ann")
+	bob := createUser(t, db, "bob")
+	carol := createUser(t, db, "carol")
+
+	liked := createPost(t, db, ann, "Liked")
+	disliked := createPost(t, db, ann, "Disliked")
+	comment, err := repo.CreateComment(ctx, db, liked, ann, "Mine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reactions := []struct {
+		userID     int
+		targetType string
+		targetID   int
+		kind       string
+	}{
+		{bob, repo.ReactionTargetPost, liked, "like"},
+		{carol, repo.ReactionTargetPost, disliked, "dislike"},
+		{carol, repo.ReactionTargetComment, comment, "like"},
+		{bob, repo.ReactionTargetPost, disliked, "heart"}, // scores nothing
+		{ann, repo.ReactionTargetPost, liked, "like"},     // her own
+	}
+	for _, r := range reactions {
+		if _, err := repo.ToggleReaction(ctx, db, r.userID, r.targetType, r.targetID, r.kind); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p, err := repo.GetProfileByUsername(ctx, db, "ANN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.UserID != ann || p.Username != "ann" || p.PostCount != 2 || p.CommentCount != 1 || p.Reputation != 1 {
+		t.Errorf("profile = %+v, want 2 posts, 1 comment and reputation 1", p)
+	}
+	if p.Visibility != "public" || p.ShowLiked || p.JoinedAt.IsZero() {
+		t.Errorf("defaults: visibility %q, show liked %v, joined %v", p.Visibility, p.ShowLiked, p.JoinedAt)
+	}
+	if _, err := repo.GetProfileByUsername(ctx, db, "nobody"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("unknown user: %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestUpdateProfileAndAvatar(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	ann := createUser(t, db, "ann")
+
+	updates := []struct {
+		bio        string
+		visibility string
+		showLiked  bool
+	}{
+		{"Hello", "members", true},
+		{"", "private", false},
+	}
+	for _, u := range updates {
+		if err := repo.UpdateProfile(ctx, db, ann, u.bio, u.visibility, u.showLiked); err != nil {
+			t.Fatal(err)
+		}
+		p, err := repo.GetProfileByUsername(ctx, db, "ann")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Bio != u.bio || p.Visibility != u.visibility || p.ShowLiked != u.showLiked {
+			t.Errorf("after %+v: profile = %+v", u, p)
+		}
+	}
+
+	if err := repo.SetAvatar(ctx, db, ann, "ab/cd/avatar.png", "image/png"); err != nil {
+		t.Fatal(err)
+	}
+	if ct, err := repo.GetAvatarContentType(ctx, db, "ab/cd/avatar.png"); err != nil || ct != "image/png" {
+		t.Errorf("avatar content type = %q, %v", ct, err)
+	}
+	if err := repo.SetAvatar(ctx, db, ann, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"ab/cd/avatar.png", ""} {
+		if _, err := repo.GetAvatarContentType(ctx, db, key); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("GetAvatarContentType(%q) after removing the avatar = %v, want sql.ErrNoRows", key, err)
+		}
+	}
+}
+
+func TestGetCommentsByUserID(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	ann := createUser(t, db, "ann")
+	bob := createUser(t, db, "bob")
+	post := createPost(t, db, bob, "Bob's")
+	var ids []int
+	for _, content := range []string{"one", "two", "three"} {
+		id, err := repo.CreateComment(ctx, db, post, ann, content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	if _, err := repo.CreateComment(ctx, db, post, bob, "not ann's"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		limit, offset int
+		want          []int
+	}{
+		{10, 0, []int{ids[2], ids[1], ids[0]}},
+		{2, 0, []int{ids[2], ids[1]}},
+		{2, 2, []int{ids[0]}},
+		{2, 3, nil},
+	}
+	for _, tt := range tests {
+		comments, err := repo.GetCommentsByUserID(ctx, db, ann, tt.limit, tt.offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []int
+		for _, c := range comments {
+			got = append(got, c.ID)
+			if c.PostTitle != "Bob's" {
+				t.Errorf("comment %d has post title %q", c.ID, c.PostTitle)
+			}
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("limit %d offset %d: comments %v, want %v", tt.limit, tt.offset, got, tt.want)
+		}
+	}
+}
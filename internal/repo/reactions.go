@@ -0,0 +1,252 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"forum/internal/models"
+)
+
+const (
+	ReactionTargetPost    = "post"
+	ReactionTargetComment = "comment"
+)
+
+var (
+	ErrUnknownReactionTarget = errors.New("unknown reaction target")
+	ErrUnknownReactionKind   = errors.New("unknown reaction kind")
+)
+
+func ToggleReaction(ctx context.Context, db *DB, userID int, targetType string, targetID int, kind string) (*models.ReactionState, error) {
+	if targetType != ReactionTargetPost && targetType != ReactionTargetComment {
+		return nil, ErrUnknownReactionTarget
+	}
+	reactionKind, ok := models.ReactionKindByCode(kind)
+	if !ok {
+		return nil, ErrUnknownReactionKind
+	}
+
+	tx, err := db.BeginTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := tx.ExecContext(ctx,
+		`DELETE FROM reactions WHERE user_id = ? AND target_type = ? AND target_id = ? AND kind = ?`,
+		userID, targetType, targetID, kind,
+	)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	removed, err := res.RowsAffected()
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	state := models.ReactionState{Kind: kind}
+	if removed == 0 {
+		if reactionKind.Score != 0 {
+			var opposite []any
+			for _, k := range models.ReactionKinds {
+				if k.Score != 0 && k.Code != kind {
+					opposite = append(opposite, k.Code)
+				}
+			}
+			if len(opposite) > 0 {
+				args := append([]any{userID, targetType, targetID}, opposite...)
+				_, err = tx.ExecContext(ctx,
+					`DELETE FROM reactions WHERE user_id = ? AND target_type = ? AND target_id = ? AND kind IN (`+placeholders(len(opposite))+`)`,
+					args...,
+				)
+				if err != nil {
+					_ = tx.Rollback()
+					return nil, err
+				}
+			}
+		}
+
+		// On PostgreSQL a concurrent toggle can insert the same row after our
+		// DELETE saw nothing, so a conflict means the reaction is already set
+		// rather than an error.
+		res, err = tx.ExecContext(ctx,
+			`INSERT INTO reactions (user_id, target_type, target_id, kind, created_at) VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT DO NOTHING`,
+			userID, targetType, targetID, kind, now(),
+		)
+		if err != nil {
+			_ = tx.Rollback()
+			return nil, err
+		}
+		inserted, err := res.RowsAffected()
+		if err != nil {
+			_ = tx.Rollback()
+			return nil, err
+		}
+		state.Active = true
+		state.Added = inserted > 0
+	}
+
+	summaries, err := reactionSummaries(ctx, tx, targetType, []int{targetID}, userID)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	state.Reactions = summaries[targetID]
+	state.Likes, state.Dislikes = scoreCounts(state.Reactions)
+	return &state, nil
+}
+
+func GetReactionSummaries(ctx context.Context, db *DB, targetType string, targetIDs []int, viewerID int) (map[int][]models.ReactionSummary, error) {
+	return reactionSummaries(ctx, db, targetType, targetIDs, viewerID)
+}
+
+func reactionSummaries(ctx context.Context, q queryer, targetType string, targetIDs []int, viewerID int) (map[int][]models.ReactionSummary, error) {
+	result := make(map[int][]models.ReactionSummary, len(targetIDs))
+	if len(targetIDs) == 0 {
+		return result, nil
+	}
+
+	for _, id := range targetIDs {
+		result[id] = models.NewReactionSummaries()
+	}
+	for _, batch := range idBatches(targetIDs) {
+		if err := addReactionSummaries(ctx, q, result, targetType, batch, viewerID); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func addReactionSummaries(ctx context.Context, q queryer, result map[int][]models.ReactionSummary, targetType string, targetIDs []int, viewerID int) error {
+	args := []any{targetType}
+	for _, id := range targetIDs {
+		args = append(args, id)
+	}
+	rows, err := q.QueryContext(ctx, `
+    SELECT r.target_id, r.kind, r.user_id, u.username
+    FROM reactions r
+    JOIN users u ON u.id = r.user_id
+    WHERE r.target_type = ? AND r.target_id IN (`+placeholders(len(targetIDs))+`)
+    ORDER BY r.created_at
+    `, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			targetID int
+			kind     string
+			userID   int
+			username string
+		)
+		if err := rows.Scan(&targetID, &kind, &userID, &username); err != nil {
+			return err
+		}
+		summaries := result[targetID]
+		for i := range summaries {
+			if summaries[i].Kind != kind {
+				continue
+			}
+			summaries[i].Count++
+			summaries[i].Users = append(summaries[i].Users, username)
+			if userID == viewerID {
+				summaries[i].Mine = true
+			}
+		}
+	}
+	return rows.Err()
+}
+
+func scoreCounts(summaries []models.ReactionSummary) (int, int) {
+	var likes, dislikes int
+	for _, s := range summaries {
+		k, _ := models.ReactionKindByCode(s.Kind)
+		switch {
+		case k.Score > 0:
+			likes += s.Count
+		case k.Score < 0:
+			dislikes += s.Count
+		}
+	}
+	return likes, dislikes
+}
+
+// reactionScoreSQL returns a CASE expression mapping the kind column to its
+// score, so reputation follows models.ReactionKinds.
+func reactionScoreSQL(column string) string {
+	var b strings.Builder
+	b.WriteString("CASE " + column)
+	for _, k := range models.ReactionKinds {
+		if k.Score != 0 {
+			fmt.Fprintf(&b, " WHEN '%s' THEN %d", k.Code, k.Score)
+		}
+	}
+	b.WriteString(" ELSE 0 END")
+	return b.String()
+}
+
+// scoredKindsSQL returns a parenthesized list of the kinds scored like sign,
+// for use after IN. The codes are checked by models.ValidateReactionKinds, so
+// they are safe to inline.
+func scoredKindsSQL(sign int) string {
+	codes := models.ReactionCodesByScore(sign)
+	if len(codes) == 0 {
+		return "(NULL)"
+	}
+	return "('" + strings.Join(codes, "', '") + "')"
+}
+
+// UnconfiguredReactionKinds returns the stored reaction kinds that are not in
+// models.ReactionKinds. Reactions of such kinds would no longer be shown or
+// scored, so the server refuses to start with them.
+func UnconfiguredReactionKinds(ctx context.Context, db *DB) ([]string, error) {
+	rows, err := db.QueryContext(ctx, `SELECT DISTINCT kind FROM reactions ORDER BY kind`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var unknown []string
+	for rows.Next() {
+		var kind string
+		if err := rows.Scan(&kind); err != nil {
+			return nil, err
+		}
+		if _, ok := models.ReactionKindByCode(kind); !ok {
+			unknown = append(unknown, kind)
+		}
+	}
+	return unknown, rows.Err()
+}
+
+// maxIDBatch bounds the ids bound in one IN list. SQLite refuses more than
+// 32766 variables in a statement and PostgreSQL more than 65535.
+const maxIDBatch = 1000
+
+// idBatches splits ids into slices of at most maxIDBatch.
+func idBatches(ids []int) [][]int {
+	var batches [][]int
+	for len(ids) > maxIDBatch {
+		batches = append(batches, ids[:maxIDBatch])
+		ids = ids[maxIDBatch:]
+	}
+	if len(ids) > 0 {
+		batches = append(batches, ids)
+	}
+	return batches
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
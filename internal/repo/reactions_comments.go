@@ -1,14 +1,14 @@
 package repo
 
-import "database/sql"
+import "context"
 
-func GetCommentReactionCounts(db *sql.DB, commentID int) (int, int, error) {
-	row := db.QueryRow(`
+func GetCommentReactionCounts(ctx context.Context, db *DB, commentID int) (int, int, error) {
+	row := db.QueryRowContext(ctx, `
 SELECT
-  COALESCE(SUM(CASE WHEN value = 1 THEN 1 ELSE 0 END), 0),
-  COALESCE(SUM(CASE WHEN value = -1 THEN 1 ELSE 0 END), 0)
-FROM comment_reactions
-WHERE comment_id = ?;
+  COALESCE(SUM(CASE WHEN kind IN `+scoredKindsSQL(1)+` THEN 1 ELSE 0 END), 0),
+  COALESCE(SUM(CASE WHEN kind IN `+scoredKindsSQL(-1)+` THEN 1 ELSE 0 END), 0)
+FROM reactions
+WHERE target_type = 'comment' AND target_id = ?;
 `, commentID)
 
 	var likes, dislikes int
@@ -1,13 +1,13 @@
 package repo
 
-import "database/sql"
+import "context"
 
-func GetPostReactionCounts(db *sql.DB, postID int) (int, int, error) {
-	row := db.QueryRow(`SELECT
-  COALESCE(SUM(CASE WHEN value = 1 THEN 1 ELSE 0 END), 0),
-  COALESCE(SUM(CASE WHEN value = -1 THEN 1 ELSE 0 END), 0)
-FROM post_reactions
-WHERE post_id = ?;`, postID)
+func GetPostReactionCounts(ctx context.Context, db *DB, postID int) (int, int, error) {
+	row := db.QueryRowContext(ctx, `SELECT
+  COALESCE(SUM(CASE WHEN kind IN `+scoredKindsSQL(1)+` THEN 1 ELSE 0 END), 0),
+  COALESCE(SUM(CASE WHEN kind IN `+scoredKindsSQL(-1)+` THEN 1 ELSE 0 END), 0)
+FROM reactions
+WHERE target_type = 'post' AND target_id = ?;`, postID)
 
 	var likes int
 	var dislikes int
@@ -0,0 +1,204 @@
+package repo_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"forum/internal/models"
+	"forum/internal/repo"
+)
+
+type toggle struct {
+	user string
+	kind string
+}
+
+func TestToggleReactionTransitions(t *testing.T) {
+	tests := []struct {
+		name    string
+		toggles []toggle
+		// active is whether the last toggle left its reaction set.
+		active bool
+		counts map[string]int
+		mine   []string
+	}{
+		{
+			name:    "like",
+			toggles: []toggle{{"ann", "like"}},
+			active:  true,
+			counts:  map[string]int{"like": 1},
+			mine:    []string{"like"},
+		},
+		{
+			name:    "like twice removes it",
+			toggles: []toggle{{"ann", "like"}, {"ann", "like"}},
+			counts:  map[string]int{},
+		},
+		{
+			name:    "dislike replaces like",
+			toggles: []toggle{{"ann", "like"}, {"ann", "dislike"}},
+			active:  true,
+			counts:  map[string]int{"dislike": 1},
+			mine:    []string{"dislike"},
+		},
+		{
+			name:    "like replaces dislike",
+			toggles: []toggle{{"ann", "dislike"}, {"ann", "like"}},
+			active:  true,
+			counts:  map[string]int{"like": 1},
+			mine:    []string{"like"},
+		},
+		{
+			name:    "unscored kinds stack",
+			toggles: []toggle{{"ann", "heart"}, {"ann", "party"}, {"ann", "like"}},
+			active:  true,
+			counts:  map[string]int{"heart": 1, "party": 1, "like": 1},
+			mine:    []string{"like", "heart", "party"},
+		},
+		{
+			name:    "dislike keeps unscored kinds",
+			toggles: []toggle{{"ann", "heart"}, {"ann", "like"}, {"ann", "dislike"}},
+			active:  true,
+			counts:  map[string]int{"heart": 1, "dislike": 1},
+			mine:    []string{"dislike", "heart"},
+		},
+		{
+			name:    "removing an unscored kind",
+			toggles: []toggle{{"ann", "heart"}, {"ann", "like"}, {"ann", "heart"}},
+			counts:  map[string]int{"like": 1},
+			mine:    []string{"like"},
+		},
+		{
+			name:    "users do not affect each other",
+			toggles: []toggle{{"ann", "like"}, {"bob", "like"}, {"bob", "dislike"}, {"ann", "wow"}},
+			active:  true,
+			counts:  map[string]int{"like": 1, "dislike": 1, "wow": 1},
+			mine:    []string{"like", "wow"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := openTestDB(t)
+			ctx := context.Background()
+			users := map[string]int{"ann": createUser(t, db, "ann"), "bob": createUser(t, db, "bob")}
+			postID := createPost(t, db, users["ann"], "post")
+
+			var state *models.ReactionState
+			for _, tg := range tt.toggles {
+				var err error
+				state, err = repo.ToggleReaction(ctx, db, users[tg.user], repo.ReactionTargetPost, postID, tg.kind)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if state.Added != state.Active {
+					t.Errorf("toggle %v: Added = %v, Active = %v", tg, state.Added, state.Active)
+				}
+			}
+			if state.Active != tt.active {
+				t.Errorf("Active = %v, want %v", state.Active, tt.active)
+			}
+
+			likes, dislikes := 0, 0
+			for _, s := range state.Reactions {
+				if s.Count != tt.counts[s.Kind] || s.Count != len(s.Users) {
+					t.Errorf("%s: count %d, users %v; want %d", s.Kind, s.Count, s.Users, tt.counts[s.Kind])
+				}
+				k, _ := models.ReactionKindByCode(s.Kind)
+				if k.Score > 0 {
+					likes += s.Count
+				} else if k.Score < 0 {
+					dislikes += s.Count
+				}
+			}
+			if state.Likes != likes || state.Dislikes != dislikes {
+				t.Errorf("Likes, Dislikes = %d, %d; want %d, %d", state.Likes, state.Dislikes, likes, dislikes)
+			}
+
+			// The summaries read back for ann match what the last toggle said.
+			summaries, err := repo.GetReactionSummaries(ctx, db, repo.ReactionTargetPost, []int{postID}, users["ann"])
+			if err != nil {
+				t.Fatal(err)
+			}
+			var mine []string
+			for _, s := range summaries[postID] {
+				if s.Mine {
+					mine = append(mine, s.Kind)
+				}
+			}
+			if !sameSet(mine, tt.mine) {
+				t.Errorf("ann's reactions = %v, want %v", mine, tt.mine)
+			}
+		})
+	}
+}
+
+func TestToggleReactionRejectsUnknown(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	userID := createUser(t, db, "ann")
+	postID := createPost(t, db, userID, "post")
+
+	if _, err := repo.ToggleReaction(ctx, db, userID, "user", postID, "like"); !errors.Is(err, repo.ErrUnknownReactionTarget) {
+		t.Errorf("unknown target: err = %v", err)
+	}
+	if _, err := repo.ToggleReaction(ctx, db, userID, repo.ReactionTargetPost, postID, "meh"); !errors.Is(err, repo.ErrUnknownReactionKind) {
+		t.Errorf("unknown kind: err = %v", err)
+	}
+}
+
+func TestToggleReactionConcurrently(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	userID := createUser(t, db, "ann")
+	postID := createPost(t, db, userID, "post")
+
+	// Concurrent toggles of one reaction must each succeed and never store it
+	// twice. Which of them wins depends on the database's isolation, so the
+	// end state may be either.
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := repo.ToggleReaction(ctx, db, userID, repo.ReactionTargetPost, postID, "heart"); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	summaries, err := repo.GetReactionSummaries(ctx, db, repo.ReactionTargetPost, []int{postID}, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range summaries[postID] {
+		if s.Count > 1 {
+			t.Errorf("%s: count %d, want at most 1", s.Kind, s.Count)
+		}
+	}
+}
+
+func sameSet(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	seen := map[string]int{}
+	for _, s := range a {
+		seen[s]++
+	}
+	for _, s := range b {
+		if seen[s] == 0 {
+			return false
+		}
+		seen[s]--
+	}
+	return true
+}
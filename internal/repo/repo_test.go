@@ -0,0 +1,90 @@
+package repo_test
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	internaldb "forum/internal/db"
+	"forum/internal/repo"
+)
+
+// openTestDB opens a migrated, seeded database for one test: a SQLite file in
+// a temporary directory, or a schema of its own on the PostgreSQL server at
+// FORUM_TEST_POSTGRES_URL when that is set.
+func openTestDB(t testing.TB) *repo.DB {
+	t.Helper()
+	url := filepath.Join(t.TempDir(), "forum.db")
+	if server := os.Getenv("FORUM_TEST_POSTGRES_URL"); server != "" {
+		url = postgresSchema(t, server)
+	}
+	db, err := internaldb.Open(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Pool().Close() })
+	if err := repo.SeedCategories(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// postgresSchema creates an empty schema on the server at url, dropped when
+// the test ends, and returns url with the schema as its search path.
+func postgresSchema(t testing.TB, url string) string {
+	t.Helper()
+	admin, err := sql.Open(repo.Postgres.Name(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	suffix := make([]byte, 8)
+	rand.Read(suffix)
+	schema := "forum_test_" + hex.EncodeToString(suffix)
+	if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+		admin.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if _, err := admin.Exec("DROP SCHEMA " + schema + " CASCADE"); err != nil {
+			t.Error(err)
+		}
+		admin.Close()
+	})
+
+	sep := "?"
+	if strings.Contains(url, "?") {
+		sep = "&"
+	}
+	return url + sep + "search_path=" + schema
+}
+
+// createUser registers username with the password "pw" and returns its id.
+func createUser(t testing.TB, db *repo.DB, username string) int {
+	t.Helper()
+	ctx := context.Background()
+	if err := repo.CreateUser(ctx, db, username+"@example.com", username, "pw"); err != nil {
+		t.Fatal(err)
+	}
+	user, err := repo.GetUserByUsername(ctx, db, username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user.ID
+}
+
+func createPost(t testing.TB, db *repo.DB, userID int, title string, categoryIDs ...int) int {
+	t.Helper()
+	if len(categoryIDs) == 0 {
+		categoryIDs = []int{1}
+	}
+	id, err := repo.CreatePost(context.Background(), db, userID, title, "content of "+title, categoryIDs, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
@@ -1,25 +1,28 @@
 package repo
 
 import (
+	"context"
 	"database/sql"
-	"fmt"
+	"errors"
 	"time"
 
 	"forum/internal/models"
 	"github.com/google/uuid"
 )
 
-func CreateSessions(db *sql.DB, userID int) (string, error) {
+var ErrSessionExpired = errors.New("session expired")
+
+func CreateSessions(ctx context.Context, db *DB, userID int) (string, error) {
 	sessionID := uuid.New().String()
-	expiresAt := time.Now().Add(20 * time.Minute)
+	expiresAt := now().Add(20 * time.Minute)
 
-	_, err := db.Exec(`DELETE FROM sessions WHERE user_id = ?`, userID)
+	_, err := db.ExecContext(ctx, `DELETE FROM sessions WHERE user_id = ?`, userID)
 	if err != nil {
 		return "", err
 	}
 
 	query := `INSERT INTO sessions(id, user_id, expires_at) VALUES (?, ?, ?)`
-	_, err = db.Exec(query, sessionID, userID, expiresAt)
+	_, err = db.ExecContext(ctx, query, sessionID, userID, expiresAt)
 	if err != nil {
 		return "", err
 	}
@@ -27,35 +30,52 @@ func CreateSessions(db *sql.DB, userID int) (string, error) {
 	return sessionID, nil
 }
 
-func DeleteSession(db *sql.DB, sessionID string) error {
-	_, err := db.Exec(`DELETE FROM sessions WHERE id = ?`, sessionID)
+func DeleteSession(ctx context.Context, db *DB, sessionID string) error {
+	_, err := db.ExecContext(ctx, `DELETE FROM sessions WHERE id = ?`, sessionID)
 	return err
 }
 
-func GetUserBySessionID(db *sql.DB, sessionID string) (*models.User, error) {
-	query := `SELECT user_id, expires_at FROM sessions WHERE id = ? LIMIT 1`
-	row := db.QueryRow(query, sessionID)
+func DeleteOtherSessions(ctx context.Context, db *DB, userID int, keepSessionID string) error {
+	_, err := db.ExecContext(ctx, `DELETE FROM sessions WHERE user_id = ? AND id <> ?`, userID, keepSessionID)
+	return err
+}
+
+// CountActiveSessions counts sessions that have not expired yet.
+func CountActiveSessions(ctx context.Context, db *DB) (int, error) {
+	var n int
+	err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM sessions WHERE expires_at > ?`, now()).Scan(&n)
+	return n, err
+}
+
+func GetUserBySessionID(ctx context.Context, db *DB, sessionID string) (*models.User, error) {
+	query := `SELECT user_id, expires_at, confirmed_at FROM sessions WHERE id = ? LIMIT 1`
+	row := db.QueryRowContext(ctx, query, sessionID)
 
 	var userID int
 	var expiresAt time.Time
+	var confirmedAt sql.NullTime
 
-	err := row.Scan(&userID, &expiresAt)
+	err := row.Scan(&userID, &expiresAt, &confirmedAt)
 	if err != nil {
 		return nil, err
 	}
 
-	if time.Now().After(expiresAt) {
-		return nil, fmt.Errorf("session expired")
+	if now().After(expiresAt) {
+		return nil, ErrSessionExpired
 	}
 
-	userQuery := `SELECT id, email, username, password FROM users WHERE id = ? LIMIT 1`
-	userRow := db.QueryRow(userQuery, userID)
-
-	var user models.User
-	err = userRow.Scan(&user.ID, &user.Email, &user.Username, &user.Password)
+	userQuery := `SELECT ` + userColumns + ` FROM users WHERE id = ? LIMIT 1`
+	user, err := scanUser(db.QueryRowContext(ctx, userQuery, userID))
 	if err != nil {
 		return nil, err
 	}
+	user.ConfirmedAt = confirmedAt.Time
+	return user, nil
+}
 
-	return &user, nil
+// ConfirmSession records that the session's user has just proven it is them
+// through a sign-in provider.
+func ConfirmSession(ctx context.Context, db *DB, sessionID string) error {
+	_, err := db.ExecContext(ctx, `UPDATE sessions SET confirmed_at = ? WHERE id = ?`, now(), sessionID)
+	return err
 }
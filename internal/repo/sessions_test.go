@@ -0,0 +1,109 @@
+package repo_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"forum/internal/repo"
+)
+
+func TestSessions(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	ann := createUser(t, db, "ann")
+
+	old, err := repo.CreateSessions(ctx, db, ann)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := repo.CreateSessions(ctx, db, ann)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.GetUserBySessionID(ctx, db, old); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("replaced session: err = %v, want sql.ErrNoRows", err)
+	}
+
+	user, err := repo.GetUserBySessionID(ctx, db, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != ann || user.Username != "ann" {
+		t.Errorf("user = %d %q, want %d ann", user.ID, user.Username, ann)
+	}
+	if !user.ConfirmedAt.IsZero() {
+		t.Errorf("new session is confirmed at %v", user.ConfirmedAt)
+	}
+
+	if err := repo.ConfirmSession(ctx, db, id); err != nil {
+		t.Fatal(err)
+	}
+	user, err = repo.GetUserBySessionID(ctx, db, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if since := time.Since(user.ConfirmedAt); since < 0 || since > time.Minute {
+		t.Errorf("confirmed at %v, want about now", user.ConfirmedAt)
+	}
+
+	if n, err := repo.CountActiveSessions(ctx, db); err != nil || n != 1 {
+		t.Errorf("CountActiveSessions = %d, %v, want 1", n, err)
+	}
+
+	if err := repo.DeleteSession(ctx, db, id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.GetUserBySessionID(ctx, db, id); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("deleted session: err = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	ann := createUser(t, db, "ann")
+
+	id, err := repo.CreateSessions(ctx, db, ann)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ExecContext(ctx, `UPDATE sessions SET expires_at = ? WHERE id = ?`, time.Now().UTC().Add(-time.Second), id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.GetUserBySessionID(ctx, db, id); !errors.Is(err, repo.ErrSessionExpired) {
+		t.Errorf("err = %v, want ErrSessionExpired", err)
+	}
+	if n, err := repo.CountActiveSessions(ctx, db); err != nil || n != 0 {
+		t.Errorf("CountActiveSessions = %d, %v, want 0", n, err)
+	}
+}
+
+func TestDeleteOtherSessions(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	ann := createUser(t, db, "ann")
+
+	keep, err := repo.CreateSessions(ctx, db, ann)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// CreateSessions signs the user out everywhere else, so the second
+	// session is added directly.
+	other := "other-session"
+	if _, err := db.ExecContext(ctx, `INSERT INTO sessions (id, user_id, expires_at) VALUES (?, ?, ?)`, other, ann, time.Now().UTC().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.DeleteOtherSessions(ctx, db, ann, keep); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.GetUserBySessionID(ctx, db, keep); err != nil {
+		t.Errorf("kept session: %v", err)
+	}
+	if _, err := repo.GetUserBySessionID(ctx, db, other); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("other session: err = %v, want sql.ErrNoRows", err)
+	}
+}
@@ -1,55 +1,392 @@
 package repo
 
 import (
-	"database/sql"
+	"context"
+	"time"
 
 	"forum/internal/models"
 )
 
 type Store struct {
-	DB *sql.DB
+	DB *DB
+
+	// Observe, when set, is told how long each method took.
+	Observe func(method string, d time.Duration)
 }
 
-func NewStore(db *sql.DB) *Store {
+func NewStore(db *DB) *Store {
 	return &Store{DB: db}
 }
 
-func (s *Store) CreateUser(email string, username string, password string) error {
-	return CreateUser(s.DB, email, username, password)
+func (s *Store) track(method string) func() {
+	if s.Observe == nil {
+		return func() {}
+	}
+	start := time.Now()
+	return func() { s.Observe(method, time.Since(start)) }
+}
+
+func (s *Store) CreateUser(ctx context.Context, email string, username string, password string) error {
+	defer s.track("CreateUser")()
+	return CreateUser(ctx, s.DB, email, username, password)
+}
+
+func (s *Store) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	defer s.track("GetUserByEmail")()
+	return GetUserByEmail(ctx, s.DB, email)
+}
+
+func (s *Store) GetUserByID(ctx context.Context, id int) (*models.User, error) {
+	defer s.track("GetUserByID")()
+	return GetUserByID(ctx, s.DB, id)
+}
+
+func (s *Store) UpdateUsername(ctx context.Context, userID int, username string) error {
+	defer s.track("UpdateUsername")()
+	return UpdateUsername(ctx, s.DB, userID, username)
+}
+
+func (s *Store) UpdatePassword(ctx context.Context, userID int, password string) error {
+	defer s.track("UpdatePassword")()
+	return UpdatePassword(ctx, s.DB, userID, password)
+}
+
+func (s *Store) UpdateLocale(ctx context.Context, userID int, locale string) error {
+	defer s.track("UpdateLocale")()
+	return UpdateLocale(ctx, s.DB, userID, locale)
+}
+
+func (s *Store) UpdateTimezone(ctx context.Context, userID int, timezone string) error {
+	defer s.track("UpdateTimezone")()
+	return UpdateTimezone(ctx, s.DB, userID, timezone)
+}
+
+func (s *Store) CreateEmailChange(ctx context.Context, userID int, email string, tokenHash string, expiresAt time.Time) error {
+	defer s.track("CreateEmailChange")()
+	return CreateEmailChange(ctx, s.DB, userID, email, tokenHash, expiresAt)
+}
+
+func (s *Store) GetPendingEmail(ctx context.Context, userID int) (string, error) {
+	defer s.track("GetPendingEmail")()
+	return GetPendingEmail(ctx, s.DB, userID)
+}
+
+func (s *Store) ConfirmEmailChange(ctx context.Context, tokenHash string) (int, error) {
+	defer s.track("ConfirmEmailChange")()
+	return ConfirmEmailChange(ctx, s.DB, tokenHash)
+}
+
+func (s *Store) DeleteAccount(ctx context.Context, userID int, mode string) ([]string, error) {
+	defer s.track("DeleteAccount")()
+	return DeleteAccount(ctx, s.DB, userID, mode)
+}
+
+func (s *Store) CreatePost(ctx context.Context, userID int, title string, content string, categoryIDs []int, attachments []models.Attachment) (int, error) {
+	defer s.track("CreatePost")()
+	return CreatePost(ctx, s.DB, userID, title, content, categoryIDs, attachments)
+}
+
+func (s *Store) GetPostCards(ctx context.Context, filter PostCardsFilter) ([]models.PostCard, error) {
+	defer s.track("GetPostCards")()
+	return GetPostCards(ctx, s.DB, filter)
+}
+
+func (s *Store) GetPostCardWithComments(ctx context.Context, postID int, viewerID int) (*models.PostCardWithComments, error) {
+	defer s.track("GetPostCardWithComments")()
+	return GetPostCardWithComments(ctx, s.DB, postID, viewerID)
+}
+
+func (s *Store) PostExists(ctx context.Context, postID int) (bool, error) {
+	defer s.track("PostExists")()
+	return PostExists(ctx, s.DB, postID)
+}
+
+func (s *Store) CreateComment(ctx context.Context, postID int, userID int, content string) (int, error) {
+	defer s.track("CreateComment")()
+	return CreateComment(ctx, s.DB, postID, userID, content)
+}
+
+func (s *Store) GetCommentByID(ctx context.Context, commentID int, viewerID int) (*models.CommentView, error) {
+	defer s.track("GetCommentByID")()
+	return GetCommentByID(ctx, s.DB, commentID, viewerID)
+}
+
+func (s *Store) GetCommentsByPostID(ctx context.Context, postID int) ([]models.CommentView, error) {
+	defer s.track("GetCommentsByPostID")()
+	return GetCommentsByPostID(ctx, s.DB, postID)
+}
+
+func (s *Store) CommentExists(ctx context.Context, commentID int) (bool, error) {
+	defer s.track("CommentExists")()
+	return CommentExists(ctx, s.DB, commentID)
+}
+
+func (s *Store) Toggle(ctx context.Context, userID int, targetType string, targetID int, kind string) (*models.ReactionState, error) {
+	defer s.track("Toggle")()
+	return ToggleReaction(ctx, s.DB, userID, targetType, targetID, kind)
+}
+
+func (s *Store) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
+	defer s.track("GetUserByUsername")()
+	return GetUserByUsername(ctx, s.DB, username)
+}
+
+func (s *Store) GetPostAuthorID(ctx context.Context, postID int) (int, error) {
+	defer s.track("GetPostAuthorID")()
+	return GetPostAuthorID(ctx, s.DB, postID)
+}
+
+func (s *Store) CreateNotification(ctx context.Context, n models.Notification) error {
+	defer s.track("CreateNotification")()
+	return CreateNotification(ctx, s.DB, n)
+}
+
+func (s *Store) GetNotifications(ctx context.Context, userID int, limit int) ([]models.Notification, error) {
+	defer s.track("GetNotifications")()
+	return GetNotifications(ctx, s.DB, userID, limit)
+}
+
+func (s *Store) GetNotificationByID(ctx context.Context, userID int, notificationID int) (*models.Notification, error) {
+	defer s.track("GetNotificationByID")()
+	return GetNotificationByID(ctx, s.DB, userID, notificationID)
+}
+
+func (s *Store) CountUnreadNotifications(ctx context.Context, userID int) (int, error) {
+	defer s.track("CountUnreadNotifications")()
+	return CountUnreadNotifications(ctx, s.DB, userID)
+}
+
+func (s *Store) MarkNotificationRead(ctx context.Context, userID int, notificationID int) error {
+	defer s.track("MarkNotificationRead")()
+	return MarkNotificationRead(ctx, s.DB, userID, notificationID)
+}
+
+func (s *Store) MarkAllNotificationsRead(ctx context.Context, userID int) error {
+	defer s.track("MarkAllNotificationsRead")()
+	return MarkAllNotificationsRead(ctx, s.DB, userID)
+}
+
+func (s *Store) GetNotificationPreferences(ctx context.Context, userID int) ([]models.NotificationPreference, error) {
+	defer s.track("GetNotificationPreferences")()
+	return GetNotificationPreferences(ctx, s.DB, userID)
+}
+
+func (s *Store) SetNotificationPreferences(ctx context.Context, userID int, enabled map[string]bool) error {
+	defer s.track("SetNotificationPreferences")()
+	return SetNotificationPreferences(ctx, s.DB, userID, enabled)
+}
+
+func (s *Store) SearchUsernames(ctx context.Context, prefix string, limit int) ([]string, error) {
+	defer s.track("SearchUsernames")()
+	return SearchUsernames(ctx, s.DB, prefix, limit)
+}
+
+func (s *Store) SaveMentions(ctx context.Context, sourceType string, sourceID int, usernames []string) ([]models.User, error) {
+	defer s.track("SaveMentions")()
+	return SaveMentions(ctx, s.DB, sourceType, sourceID, usernames)
+}
+
+func (s *Store) GetAttachmentByKey(ctx context.Context, storageKey string) (*models.Attachment, error) {
+	defer s.track("GetAttachmentByKey")()
+	return GetAttachmentByKey(ctx, s.DB, storageKey)
+}
+
+func (s *Store) ReleaseBlobs(ctx context.Context, keys []string, remove func(ctx context.Context, key string) error) error {
+	defer s.track("ReleaseBlobs")()
+	return ReleaseBlobs(ctx, s.DB, keys, remove)
+}
+
+func (s *Store) GetProfileByUsername(ctx context.Context, username string) (*models.Profile, error) {
+	defer s.track("GetProfileByUsername")()
+	return GetProfileByUsername(ctx, s.DB, username)
+}
+
+func (s *Store) GetCommentsByUserID(ctx context.Context, userID int, limit int, offset int) ([]models.ProfileComment, error) {
+	defer s.track("GetCommentsByUserID")()
+	return GetCommentsByUserID(ctx, s.DB, userID, limit, offset)
+}
+
+func (s *Store) UpdateProfile(ctx context.Context, userID int, bio string, visibility string, showLiked bool) error {
+	defer s.track("UpdateProfile")()
+	return UpdateProfile(ctx, s.DB, userID, bio, visibility, showLiked)
+}
+
+func (s *Store) SetAvatar(ctx context.Context, userID int, key string, contentType string) error {
+	defer s.track("SetAvatar")()
+	return SetAvatar(ctx, s.DB, userID, key, contentType)
+}
+
+func (s *Store) GetAvatarContentType(ctx context.Context, key string) (string, error) {
+	defer s.track("GetAvatarContentType")()
+	return GetAvatarContentType(ctx, s.DB, key)
+}
+
+func (s *Store) CreateExport(ctx context.Context, userID int) (int, error) {
+	defer s.track("CreateExport")()
+	return CreateExport(ctx, s.DB, userID)
+}
+
+func (s *Store) GetLatestExport(ctx context.Context, userID int) (*models.DataExport, error) {
+	defer s.track("GetLatestExport")()
+	return GetLatestExport(ctx, s.DB, userID)
+}
+
+func (s *Store) GetExportByID(ctx context.Context, userID int, exportID int) (*models.DataExport, error) {
+	defer s.track("GetExportByID")()
+	return GetExportByID(ctx, s.DB, userID, exportID)
+}
+
+func (s *Store) MarkExportReady(ctx context.Context, exportID int, storageKey string, size int64, expiresAt time.Time) error {
+	defer s.track("MarkExportReady")()
+	return MarkExportReady(ctx, s.DB, exportID, storageKey, size, expiresAt)
+}
+
+func (s *Store) MarkExportFailed(ctx context.Context, exportID int, message string) error {
+	defer s.track("MarkExportFailed")()
+	return MarkExportFailed(ctx, s.DB, exportID, message)
+}
+
+func (s *Store) DeleteOldExports(ctx context.Context, userID int, keepID int) ([]string, error) {
+	defer s.track("DeleteOldExports")()
+	return DeleteOldExports(ctx, s.DB, userID, keepID)
+}
+
+func (s *Store) GetUserExportData(ctx context.Context, userID int) (*models.UserExport, error) {
+	defer s.track("GetUserExportData")()
+	return GetUserExportData(ctx, s.DB, userID)
+}
+
+func (s *Store) SaveAuthState(ctx context.Context, st models.AuthState) error {
+	defer s.track("SaveAuthState")()
+	return SaveAuthState(ctx, s.DB, st)
+}
+
+func (s *Store) TakeAuthState(ctx context.Context, state string, maxAge time.Duration) (*models.AuthState, error) {
+	defer s.track("TakeAuthState")()
+	return TakeAuthState(ctx, s.DB, state, maxAge)
+}
+
+func (s *Store) GetUserIDByIdentity(ctx context.Context, provider string, subject string) (int, error) {
+	defer s.track("GetUserIDByIdentity")()
+	return GetUserIDByIdentity(ctx, s.DB, provider, subject)
+}
+
+func (s *Store) LinkIdentity(ctx context.Context, userID int, provider string, subject string, email string, emailVerified bool) error {
+	defer s.track("LinkIdentity")()
+	return LinkIdentity(ctx, s.DB, userID, provider, subject, email, emailVerified)
+}
+
+func (s *Store) GetIdentitiesByUserID(ctx context.Context, userID int) ([]models.ExternalIdentity, error) {
+	defer s.track("GetIdentitiesByUserID")()
+	return GetIdentitiesByUserID(ctx, s.DB, userID)
+}
+
+func (s *Store) GetTOTP(ctx context.Context, userID int) (string, bool, int64, error) {
+	defer s.track("GetTOTP")()
+	return GetTOTP(ctx, s.DB, userID)
+}
+
+func (s *Store) SetPendingTOTPSecret(ctx context.Context, userID int, secret string) error {
+	defer s.track("SetPendingTOTPSecret")()
+	return SetPendingTOTPSecret(ctx, s.DB, userID, secret)
+}
+
+func (s *Store) EnableTOTP(ctx context.Context, userID int, step int64, codeHashes []string) error {
+	defer s.track("EnableTOTP")()
+	return EnableTOTP(ctx, s.DB, userID, step, codeHashes)
+}
+
+func (s *Store) DisableTOTP(ctx context.Context, userID int) error {
+	defer s.track("DisableTOTP")()
+	return DisableTOTP(ctx, s.DB, userID)
+}
+
+func (s *Store) UseTOTPStep(ctx context.Context, userID int, step int64) (bool, error) {
+	defer s.track("UseTOTPStep")()
+	return UseTOTPStep(ctx, s.DB, userID, step)
+}
+
+func (s *Store) ReplaceRecoveryCodes(ctx context.Context, userID int, codeHashes []string) error {
+	defer s.track("ReplaceRecoveryCodes")()
+	return ReplaceRecoveryCodes(ctx, s.DB, userID, codeHashes)
+}
+
+func (s *Store) UseRecoveryCode(ctx context.Context, userID int, codeHash string) (bool, error) {
+	defer s.track("UseRecoveryCode")()
+	return UseRecoveryCode(ctx, s.DB, userID, codeHash)
+}
+
+func (s *Store) CountRecoveryCodes(ctx context.Context, userID int) (int, error) {
+	defer s.track("CountRecoveryCodes")()
+	return CountRecoveryCodes(ctx, s.DB, userID)
+}
+
+func (s *Store) CreateLoginChallenge(ctx context.Context, userID int, tokenHash string) error {
+	defer s.track("CreateLoginChallenge")()
+	return CreateLoginChallenge(ctx, s.DB, userID, tokenHash)
+}
+
+func (s *Store) GetLoginChallenge(ctx context.Context, tokenHash string, maxAge time.Duration) (int, int, error) {
+	defer s.track("GetLoginChallenge")()
+	return GetLoginChallenge(ctx, s.DB, tokenHash, maxAge)
+}
+
+func (s *Store) FailLoginChallenge(ctx context.Context, tokenHash string) error {
+	defer s.track("FailLoginChallenge")()
+	return FailLoginChallenge(ctx, s.DB, tokenHash)
+}
+
+func (s *Store) DeleteLoginChallenge(ctx context.Context, tokenHash string) error {
+	defer s.track("DeleteLoginChallenge")()
+	return DeleteLoginChallenge(ctx, s.DB, tokenHash)
+}
+
+func (s *Store) GetSiteSetting(ctx context.Context, key string) (string, error) {
+	defer s.track("GetSiteSetting")()
+	return GetSiteSetting(ctx, s.DB, key)
 }
 
-func (s *Store) GetUserByEmail(email string) (*models.User, error) {
-	return GetUserByEmail(s.DB, email)
+func (s *Store) SetSiteSetting(ctx context.Context, key string, value string) error {
+	defer s.track("SetSiteSetting")()
+	return SetSiteSetting(ctx, s.DB, key, value)
 }
 
-func (s *Store) GetUserByID(id int) (*models.User, error) {
-	return GetUserByID(s.DB, id)
+func (s *Store) CountActiveSessions(ctx context.Context) (int, error) {
+	defer s.track("CountActiveSessions")()
+	return CountActiveSessions(ctx, s.DB)
 }
 
-func (s *Store) CreatePost(userID int, title string, content string, categoryIDs []int) (int, error) {
-	return CreatePost(s.DB, userID, title, content, categoryIDs)
+func (s *Store) CreateSession(ctx context.Context, userID int) (string, error) {
+	defer s.track("CreateSession")()
+	return CreateSessions(ctx, s.DB, userID)
 }
 
-func (s *Store) GetPostCards(filter PostCardsFilter) ([]models.PostCard, error) {
-	return GetPostCards(s.DB, filter)
+func (s *Store) DeleteSession(ctx context.Context, sessionID string) error {
+	defer s.track("DeleteSession")()
+	return DeleteSession(ctx, s.DB, sessionID)
 }
 
-func (s *Store) GetPostCardWithComments(postID int) (*models.PostCardWithComments, error) {
-	return GetPostCardWithComments(s.DB, postID)
+func (s *Store) DeleteOtherSessions(ctx context.Context, userID int, keepSessionID string) error {
+	defer s.track("DeleteOtherSessions")()
+	return DeleteOtherSessions(ctx, s.DB, userID, keepSessionID)
 }
 
-func (s *Store) PostExists(postID int) (bool, error) {
-	return PostExists(s.DB, postID)
+func (s *Store) ConfirmSession(ctx context.Context, sessionID string) error {
+	defer s.track("ConfirmSession")()
+	return ConfirmSession(ctx, s.DB, sessionID)
 }
 
-func (s *Store) CreateComment(postID int, userID int, content string) error {
-	return CreateComment(s.DB, postID, userID, content)
+func (s *Store) GetUserBySessionID(ctx context.Context, sessionID string) (*models.User, error) {
+	defer s.track("GetUserBySessionID")()
+	return GetUserBySessionID(ctx, s.DB, sessionID)
 }
 
-func (s *Store) GetCommentsByPostID(postID int) ([]models.CommentView, error) {
-	return GetCommentsByPostID(s.DB, postID)
+func (s *Store) GetAllCategories(ctx context.Context) ([]models.Category, error) {
+	defer s.track("GetAllCategories")()
+	return GetAllCategories(ctx, s.DB)
 }
 
-func (s *Store) CommentExists(commentID int) (bool, error) {
-	return CommentExists(s.DB, commentID)
+func (s *Store) CategoryExists(ctx context.Context, categoryID int) (bool, error) {
+	defer s.track("CategoryExists")()
+	return CategoryExists(ctx, s.DB, categoryID)
 }
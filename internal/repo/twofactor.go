@@ -0,0 +1,178 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// SettingRequireStaff2FA makes two-factor authentication mandatory for
+// moderators and admins when set to "1".
+const SettingRequireStaff2FA = "require_staff_2fa"
+
+func GetTOTP(ctx context.Context, db *DB, userID int) (secret string, enabled bool, lastStep int64, err error) {
+	err = db.QueryRowContext(ctx,
+		`SELECT totp_secret, totp_enabled, totp_last_step FROM users WHERE id = ?`,
+		userID,
+	).Scan(&secret, &enabled, &lastStep)
+	return secret, enabled, lastStep, err
+}
+
+// SetPendingTOTPSecret stores a secret that is not active until EnableTOTP.
+// It never replaces the secret of an enabled enrolment.
+func SetPendingTOTPSecret(ctx context.Context, db *DB, userID int, secret string) error {
+	_, err := db.ExecContext(ctx, `UPDATE users SET totp_secret = ? WHERE id = ? AND totp_enabled = FALSE`, secret, userID)
+	return err
+}
+
+func EnableTOTP(ctx context.Context, db *DB, userID int, step int64, codeHashes []string) error {
+	tx, err := db.BeginTx(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE users SET totp_enabled = TRUE, totp_last_step = ? WHERE id = ?`, step, userID); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if err := replaceRecoveryCodes(ctx, tx, userID, codeHashes); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// DisableTOTP removes the enrolment and its recovery codes. It is used both
+// when users turn 2FA off and when an admin resets it.
+func DisableTOTP(ctx context.Context, db *DB, userID int) error {
+	tx, err := db.BeginTx(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE users SET totp_secret = '', totp_enabled = FALSE, totp_last_step = 0 WHERE id = ?`, userID); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = ?`, userID); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseTOTPStep records that the code for step was used. It reports false when
+// the same or a later step was already used, which stops replays.
+func UseTOTPStep(ctx context.Context, db *DB, userID int, step int64) (bool, error) {
+	res, err := db.ExecContext(ctx,
+		`UPDATE users SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?`,
+		step, userID, step,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func ReplaceRecoveryCodes(ctx context.Context, db *DB, userID int, codeHashes []string) error {
+	tx, err := db.BeginTx(ctx)
+	if err != nil {
+		return err
+	}
+	if err := replaceRecoveryCodes(ctx, tx, userID, codeHashes); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx *Tx, userID int, codeHashes []string) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	for _, h := range codeHashes {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)`, userID, h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func UseRecoveryCode(ctx context.Context, db *DB, userID int, codeHash string) (bool, error) {
+	res, err := db.ExecContext(ctx,
+		`UPDATE recovery_codes SET used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at IS NULL`,
+		now(), userID, codeHash,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func CountRecoveryCodes(ctx context.Context, db *DB, userID int) (int, error) {
+	var n int
+	err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM recovery_codes WHERE user_id = ? AND used_at IS NULL`, userID).Scan(&n)
+	return n, err
+}
+
+func CreateLoginChallenge(ctx context.Context, db *DB, userID int, tokenHash string) error {
+	_, err := db.ExecContext(ctx,
+		`INSERT INTO login_challenges (token_hash, user_id, created_at) VALUES (?, ?, ?)`,
+		tokenHash, userID, now(),
+	)
+	return err
+}
+
+// GetLoginChallenge returns the user and failed attempts of a pending second
+// login step. Challenges older than maxAge are purged first.
+func GetLoginChallenge(ctx context.Context, db *DB, tokenHash string, maxAge time.Duration) (int, int, error) {
+	if _, err := db.ExecContext(ctx, `DELETE FROM login_challenges WHERE created_at < ?`, now().Add(-maxAge)); err != nil {
+		return 0, 0, err
+	}
+	var userID, attempts int
+	err := db.QueryRowContext(ctx,
+		`SELECT user_id, attempts FROM login_challenges WHERE token_hash = ?`,
+		tokenHash,
+	).Scan(&userID, &attempts)
+	return userID, attempts, err
+}
+
+func FailLoginChallenge(ctx context.Context, db *DB, tokenHash string) error {
+	_, err := db.ExecContext(ctx, `UPDATE login_challenges SET attempts = attempts + 1 WHERE token_hash = ?`, tokenHash)
+	return err
+}
+
+func DeleteLoginChallenge(ctx context.Context, db *DB, tokenHash string) error {
+	_, err := db.ExecContext(ctx, `DELETE FROM login_challenges WHERE token_hash = ?`, tokenHash)
+	return err
+}
+
+func GetSiteSetting(ctx context.Context, db *DB, key string) (string, error) {
+	var value string
+	err := db.QueryRowContext(ctx, `SELECT value FROM site_settings WHERE key = ?`, key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return value, err
+}
+
+func SetSiteSetting(ctx context.Context, db *DB, key string, value string) error {
+	_, err := db.ExecContext(ctx, `INSERT INTO site_settings (key, value) VALUES (?, ?) ON CONFLICT (key) DO UPDATE SET value = excluded.value`, key, value)
+	return err
+}
+
+// PromoteAdmins gives the admin role to the accounts with the given emails.
+// It lets a deployment bootstrap its first admins from configuration.
+func PromoteAdmins(ctx context.Context, db *DB, emails []string) error {
+	for _, email := range emails {
+		email = strings.TrimSpace(email)
+		if email == "" {
+			continue
+		}
+		if _, err := db.ExecContext(ctx, `UPDATE users SET role = 'admin' WHERE email = ? AND role <> 'deleted'`, email); err != nil {
+			return err
+		}
+	}
+	return nil
+}
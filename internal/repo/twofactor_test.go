@@ -0,0 +1,193 @@
+package repo_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"forum/internal/repo"
+)
+
+func TestTOTPEnrolment(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	ann := createUser(t, db, "ann")
+
+	state := func() (string, bool, int64) {
+		t.Helper()
+		secret, enabled, lastStep, err := repo.GetTOTP(ctx, db, ann)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return secret, enabled, lastStep
+	}
+
+	if err := repo.SetPendingTOTPSecret(ctx, db, ann, "first"); err != nil {
+		t.Fatal(err)
+	}
+	if secret, enabled, _ := state(); secret != "first" || enabled {
+		t.Fatalf("pending enrolment: secret %q, enabled %v", secret, enabled)
+	}
+	if err := repo.EnableTOTP(ctx, db, ann, 100, []string{"h1", "h2", "h3"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, enabled, lastStep := state(); !enabled || lastStep != 100 {
+		t.Fatalf("enabled enrolment: enabled %v, last step %d", enabled, lastStep)
+	}
+
+	// A new pending secret must not replace the active one.
+	if err := repo.SetPendingTOTPSecret(ctx, db, ann, "second"); err != nil {
+		t.Fatal(err)
+	}
+	if secret, _, _ := state(); secret != "first" {
+		t.Errorf("the active secret was replaced by %q", secret)
+	}
+
+	steps := []struct {
+		step int64
+		ok   bool
+	}{
+		{100, false}, // the step used to enable
+		{99, false},
+		{101, true},
+		{101, false}, // replayed
+		{105, true},
+		{103, false},
+	}
+	for _, s := range steps {
+		ok, err := repo.UseTOTPStep(ctx, db, ann, s.step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != s.ok {
+			t.Errorf("UseTOTPStep(%d) = %v, want %v", s.step, ok, s.ok)
+		}
+	}
+
+	if err := repo.DisableTOTP(ctx, db, ann); err != nil {
+		t.Fatal(err)
+	}
+	if secret, enabled, lastStep := state(); secret != "" || enabled || lastStep != 0 {
+		t.Errorf("after disabling: secret %q, enabled %v, last step %d", secret, enabled, lastStep)
+	}
+	if n, err := repo.CountRecoveryCodes(ctx, db, ann); err != nil || n != 0 {
+		t.Errorf("recovery codes after disabling = %d, %v", n, err)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	ann := createUser(t, db, "ann")
+	bob := createUser(t, db, "bob")
+	if err := repo.EnableTOTP(ctx, db, ann, 1, []string{"h1", "h2", "h3"}); err != nil {
+		t.Fatal(err)
+	}
+
+	uses := []struct {
+		name   string
+		userID int
+		hash   string
+		ok     bool
+		left   int
+	}{
+		{"valid", ann, "h1", true, 2},
+		{"used twice", ann, "h1", false, 2},
+		{"unknown", ann, "nope", false, 2},
+		{"another user's", bob, "h2", false, 2},
+		{"another valid", ann, "h2", true, 1},
+	}
+	for _, u := range uses {
+		ok, err := repo.UseRecoveryCode(ctx, db, u.userID, u.hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != u.ok {
+			t.Errorf("%s: UseRecoveryCode = %v, want %v", u.name, ok, u.ok)
+		}
+		if n, _ := repo.CountRecoveryCodes(ctx, db, ann); n != u.left {
+			t.Errorf("%s: %d codes left, want %d", u.name, n, u.left)
+		}
+	}
+
+	if err := repo.ReplaceRecoveryCodes(ctx, db, ann, []string{"n1", "n2", "n3", "n4"}); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := repo.CountRecoveryCodes(ctx, db, ann); n != 4 {
+		t.Errorf("%d codes after replacing, want 4", n)
+	}
+	if ok, _ := repo.UseRecoveryCode(ctx, db, ann, "h3"); ok {
+		t.Error("a replaced code still works")
+	}
+}
+
+func TestLoginChallenges(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	ann := createUser(t, db, "ann")
+
+	if err := repo.CreateLoginChallenge(ctx, db, ann, "token"); err != nil {
+		t.Fatal(err)
+	}
+	for want := 0; want < 3; want++ {
+		userID, attempts, err := repo.GetLoginChallenge(ctx, db, "token", time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if userID != ann || attempts != want {
+			t.Errorf("challenge = user %d, %d attempts; want user %d, %d", userID, attempts, ann, want)
+		}
+		if err := repo.FailLoginChallenge(ctx, db, "token"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := repo.DeleteLoginChallenge(ctx, db, "token"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := repo.GetLoginChallenge(ctx, db, "token", time.Minute); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("deleted challenge: %v, want sql.ErrNoRows", err)
+	}
+
+	// A challenge older than the limit is gone.
+	if err := repo.CreateLoginChallenge(ctx, db, ann, "old"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := repo.GetLoginChallenge(ctx, db, "old", -time.Second); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expired challenge: %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestSiteSettingsAndAdmins(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	if v, err := repo.GetSiteSetting(ctx, db, repo.SettingRequireStaff2FA); err != nil || v != "" {
+		t.Errorf("unset setting = %q, %v, want empty", v, err)
+	}
+	for _, v := range []string{"1", "0"} {
+		if err := repo.SetSiteSetting(ctx, db, repo.SettingRequireStaff2FA, v); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := repo.GetSiteSetting(ctx, db, repo.SettingRequireStaff2FA); err != nil || got != v {
+			t.Errorf("setting = %q, %v, want %q", got, err, v)
+		}
+	}
+
+	ann := createUser(t, db, "ann")
+	bob := createUser(t, db, "bob")
+	if err := repo.PromoteAdmins(ctx, db, []string{" ann@example.com ", "", "nobody@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	for id, role := range map[int]string{ann: "admin", bob: "user"} {
+		user, err := repo.GetUserByID(ctx, db, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.Role != role {
+			t.Errorf("%s has role %q, want %q", user.Username, user.Role, role)
+		}
+	}
+}
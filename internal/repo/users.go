@@ -1,48 +1,127 @@
 package repo
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"strings"
 
 	"forum/internal/models"
 	"golang.org/x/crypto/bcrypt"
 )
 
-func CreateUser(db *sql.DB, email string, username string, password string) error {
-	query := `INSERT INTO users (email, username, password) VALUES (?, ?, ?)`
+var (
+	ErrEmailTaken    = errors.New("email is already registered")
+	ErrUsernameTaken = errors.New("username is already taken")
+)
 
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		return err
+// uniqueUserError turns a unique constraint failure on users into
+// ErrEmailTaken or ErrUsernameTaken and passes other errors through.
+func uniqueUserError(dialect Dialect, err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case dialect.UniqueViolation(err, "users", "email"):
+		return ErrEmailTaken
+	case dialect.UniqueViolation(err, "users", "username_folded"):
+		return ErrUsernameTaken
 	}
-
-	_, err = db.Exec(query, email, username, string(hash))
 	return err
 }
 
-func GetUserByEmail(db *sql.DB, email string) (*models.User, error) {
-	query := `SELECT id, email, username, password FROM users WHERE email = ? LIMIT 1`
+// FoldUsername is the form usernames are compared in: two names that fold
+// the same are the same name. It is stored in users.username_folded, which
+// the unique index is on; SQL's lower() cannot be used, as SQLite's only
+// folds ASCII.
+func FoldUsername(username string) string {
+	return strings.ToLower(username)
+}
 
-	row := db.QueryRow(query, email)
+const userColumns = `id, email, username, password, role, totp_enabled, email_verified, locale, timezone`
 
+func scanUser(row *sql.Row) (*models.User, error) {
 	var user models.User
-	err := row.Scan(&user.ID, &user.Email, &user.Username, &user.Password)
+	err := row.Scan(&user.ID, &user.Email, &user.Username, &user.Password, &user.Role, &user.TOTPEnabled, &user.EmailVerified, &user.Locale, &user.Timezone)
 	if err != nil {
 		return nil, err
 	}
-
 	return &user, nil
 }
 
-func GetUserByID(db *sql.DB, id int) (*models.User, error) {
-	query := `SELECT id, email, username, password FROM users WHERE id = ? LIMIT 1`
+// CreateUser registers an account. An empty password creates one without a
+// local password, for accounts that sign in through a provider; it matches
+// no password at the login form.
+func CreateUser(ctx context.Context, db *DB, email string, username string, password string) error {
+	if reservedEmail(email) {
+		return ErrEmailTaken
+	}
+	if reservedUsername(username) {
+		return ErrUsernameTaken
+	}
+	query := `INSERT INTO users (email, username, username_folded, password, created_at) VALUES (?, ?, ?, ?, ?)`
 
-	row := db.QueryRow(query, id)
+	var hash []byte
+	if password != "" {
+		var err error
+		hash, err = bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+	}
 
-	var user models.User
-	err := row.Scan(&user.ID, &user.Email, &user.Username, &user.Password)
+	_, err := db.ExecContext(ctx, query, email, username, FoldUsername(username), string(hash), now())
+	return uniqueUserError(db.Dialect, err)
+}
+
+func GetUserByEmail(ctx context.Context, db *DB, email string) (*models.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE email = ? LIMIT 1`
+
+	row := db.QueryRowContext(ctx, query, email)
+
+	return scanUser(row)
+}
+
+func GetUserByID(ctx context.Context, db *DB, id int) (*models.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = ? LIMIT 1`
+
+	row := db.QueryRowContext(ctx, query, id)
+
+	return scanUser(row)
+}
+
+func GetUserByUsername(ctx context.Context, db *DB, username string) (*models.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE username_folded = ? LIMIT 1`
+
+	row := db.QueryRowContext(ctx, query, FoldUsername(username))
+
+	return scanUser(row)
+}
+
+func SearchUsernames(ctx context.Context, db *DB, prefix string, limit int) ([]string, error) {
+	if limit <= 0 {
+		limit = 10
+	}
+	escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(prefix)
+
+	rows, err := db.QueryContext(ctx,
+		`SELECT username FROM users WHERE username_folded LIKE ? ESCAPE '\' ORDER BY username_folded LIMIT ?`,
+		FoldUsername(escaped)+"%", limit,
+	)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	return &user, nil
+	usernames := make([]string, 0)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		usernames = append(usernames, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return usernames, nil
 }
@@ -0,0 +1,187 @@
+package repo_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"forum/internal/models"
+	"forum/internal/repo"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// TestUsernamesFoldUnicode checks that usernames compare without regard to
+// case beyond ASCII, which SQLite's lower() and NOCASE do not fold.
+func TestUsernamesFoldUnicode(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	ivan := createUser(t, db, "Иван")
+	createUser(t, db, "Ångström")
+
+	for _, name := range []string{"Иван", "иван", "ИВАН", "иВаН"} {
+		user, err := repo.GetUserByUsername(ctx, db, name)
+		if err != nil || user.ID != ivan {
+			t.Errorf("GetUserByUsername(%q) = %v, %v, want user %d", name, user, err, ivan)
+		}
+		profile, err := repo.GetProfileByUsername(ctx, db, name)
+		if err != nil || profile.UserID != ivan {
+			t.Errorf("GetProfileByUsername(%q) = %v, %v, want user %d", name, profile, err, ivan)
+		}
+	}
+
+	for _, name := range []string{"ИВАН", "иван", "åNGSTRÖM"} {
+		if err := repo.CreateUser(ctx, db, name+"@example.org", name, "pw"); !errors.Is(err, repo.ErrUsernameTaken) {
+			t.Errorf("CreateUser(%q) = %v, want ErrUsernameTaken", name, err)
+		}
+	}
+
+	bob := createUser(t, db, "bob")
+	if err := repo.UpdateUsername(ctx, db, bob, "иВАН"); !errors.Is(err, repo.ErrUsernameTaken) {
+		t.Errorf("UpdateUsername to a taken name = %v, want ErrUsernameTaken", err)
+	}
+	if err := repo.UpdateUsername(ctx, db, bob, "Борис"); err != nil {
+		t.Fatal(err)
+	}
+	if user, err := repo.GetUserByUsername(ctx, db, "борис"); err != nil || user.ID != bob {
+		t.Errorf("renamed user not found by the new name: %v, %v", user, err)
+	}
+
+	names, err := repo.SearchUsernames(ctx, db, "ив", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(names, []string{"Иван"}) {
+		t.Errorf("SearchUsernames(ив) = %v, want [Иван]", names)
+	}
+
+	post := createPost(t, db, bob, "Hello", 1)
+	mentioned, err := repo.SaveMentions(ctx, db, repo.MentionSourcePost, post, []string{"иван", "nobody"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mentioned) != 1 || mentioned[0].ID != ivan {
+		t.Errorf("SaveMentions(иван) = %+v, want user %d", mentioned, ivan)
+	}
+}
+
+func TestUpdateUser(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	ann := createUser(t, db, "ann")
+	createUser(t, db, "bob")
+
+	get := func() *models.User {
+		t.Helper()
+		user, err := repo.GetUserByID(ctx, db, ann)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return user
+	}
+
+	tests := []struct {
+		name    string
+		update  func() error
+		wantErr error
+		check   func(u *models.User) bool
+	}{
+		{"rename", func() error { return repo.UpdateUsername(ctx, db, ann, "Annie") }, nil,
+			func(u *models.User) bool { return u.Username == "Annie" }},
+		{"rename to a taken name", func() error { return repo.UpdateUsername(ctx, db, ann, "BOB") }, repo.ErrUsernameTaken,
+			func(u *models.User) bool { return u.Username == "Annie" }},
+		{"rename to the placeholder", func() error { return repo.UpdateUsername(ctx, db, ann, models.DeletedUsername) }, repo.ErrUsernameTaken,
+			func(u *models.User) bool { return u.Username == "Annie" }},
+		{"rename in another case", func() error { return repo.UpdateUsername(ctx, db, ann, "ANNIE") }, nil,
+			func(u *models.User) bool { return u.Username == "ANNIE" }},
+		{"password", func() error { return repo.UpdatePassword(ctx, db, ann, "new secret") }, nil,
+			func(u *models.User) bool {
+				return bcrypt.CompareHashAndPassword([]byte(u.Password), []byte("new secret")) == nil
+			}},
+		{"locale", func() error { return repo.UpdateLocale(ctx, db, ann, "ru") }, nil,
+			func(u *models.User) bool { return u.Locale == "ru" }},
+		{"locale back to the browser's", func() error { return repo.UpdateLocale(ctx, db, ann, "") }, nil,
+			func(u *models.User) bool { return u.Locale == "" }},
+		{"timezone", func() error { return repo.UpdateTimezone(ctx, db, ann, "Europe/Moscow") }, nil,
+			func(u *models.User) bool { return u.Timezone == "Europe/Moscow" }},
+	}
+	for _, tt := range tests {
+		if err := tt.update(); !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: error %v, want %v", tt.name, err, tt.wantErr)
+		}
+		if u := get(); !tt.check(u) {
+			t.Errorf("%s: user is now %+v", tt.name, u)
+		}
+	}
+}
+
+func TestEmailChange(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	ann := createUser(t, db, "ann")
+	createUser(t, db, "bob")
+	later := time.Now().Add(time.Hour)
+
+	pending := func() string {
+		t.Helper()
+		email, err := repo.GetPendingEmail(ctx, db, ann)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return email
+	}
+	if email := pending(); email != "" {
+		t.Fatalf("pending email before any request = %q", email)
+	}
+
+	tests := []struct {
+		name      string
+		email     string
+		expiresAt time.Time
+		// createErr is what requesting the change returns, confirmErr what
+		// confirming it does.
+		createErr, confirmErr error
+		want                  string
+	}{
+		{"the placeholder's address", "deleted-user@invalid", later, repo.ErrEmailTaken, nil, "ann@example.com"},
+		{"expired", "ann@example.org", time.Now().Add(-time.Minute), nil, repo.ErrEmailChangeExpired, "ann@example.com"},
+		{"taken meanwhile", "bob@example.com", later, nil, repo.ErrEmailTaken, "ann@example.com"},
+		{"free", "ann@example.net", later, nil, nil, "ann@example.net"},
+	}
+	for i, tt := range tests {
+		token := "token" + strconv.Itoa(i)
+		err := repo.CreateEmailChange(ctx, db, ann, tt.email, token, tt.expiresAt)
+		if !errors.Is(err, tt.createErr) {
+			t.Errorf("%s: CreateEmailChange = %v, want %v", tt.name, err, tt.createErr)
+		}
+		if err == nil {
+			if tt.expiresAt.After(time.Now()) && pending() != tt.email {
+				t.Errorf("%s: pending email = %q, want %q", tt.name, pending(), tt.email)
+			}
+			userID, err := repo.ConfirmEmailChange(ctx, db, token)
+			if !errors.Is(err, tt.confirmErr) {
+				t.Errorf("%s: ConfirmEmailChange = %v, want %v", tt.name, err, tt.confirmErr)
+			}
+			if err == nil && userID != ann {
+				t.Errorf("%s: confirmed for user %d, want %d", tt.name, userID, ann)
+			}
+		}
+		user, err := repo.GetUserByID(ctx, db, ann)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.Email != tt.want {
+			t.Errorf("%s: email = %q, want %q", tt.name, user.Email, tt.want)
+		}
+	}
+
+	// A confirmed change cannot be confirmed again.
+	if _, err := repo.ConfirmEmailChange(ctx, db, "token3"); !errors.Is(err, repo.ErrEmailChangeExpired) {
+		t.Errorf("second confirmation = %v, want ErrEmailChangeExpired", err)
+	}
+	if email := pending(); email != "" {
+		t.Errorf("pending email after confirming = %q", email)
+	}
+}
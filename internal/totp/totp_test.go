@@ -0,0 +1,106 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 key of RFC 6238 appendix B, "12345678901234567890",
+// in base32.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// TestCodeRFC6238 checks the SHA1 test vectors of RFC 6238. The RFC lists
+// eight digit codes; six digit ones are their last six digits.
+func TestCodeRFC6238(t *testing.T) {
+	tests := []struct {
+		unix int64
+		rfc  string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := tt.rfc[len(tt.rfc)-Digits:]; got != want {
+			t.Errorf("Code at %d = %s, want %s", tt.unix, got, want)
+		}
+	}
+}
+
+func TestCodeAcceptsLowercaseAndPadding(t *testing.T) {
+	at := time.Unix(1111111109, 0)
+	want, _ := Code(rfcSecret, at)
+	for _, secret := range []string{strings.ToLower(rfcSecret), rfcSecret + "===="} {
+		if got, err := Code(secret, at); err != nil || got != want {
+			t.Errorf("Code(%q) = %s, %v, want %s", secret, got, err, want)
+		}
+	}
+	if _, err := Code("not base32!", at); err == nil {
+		t.Error("Code accepted a bad secret")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current := now.Unix() / Period
+	codeAt := func(s int64) string {
+		c, err := Code(rfcSecret, time.Unix(s*Period, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name     string
+		input    string
+		lastStep int64
+		step     int64
+		ok       bool
+	}{
+		{"current step", codeAt(current), 0, current, true},
+		{"previous step within skew", codeAt(current - 1), 0, current - 1, true},
+		{"next step within skew", codeAt(current + 1), 0, current + 1, true},
+		{"two steps behind", codeAt(current - 2), 0, 0, false},
+		{"two steps ahead", codeAt(current + 2), 0, 0, false},
+		{"spaces are ignored", codeAt(current)[:3] + " " + codeAt(current)[3:], 0, current, true},
+		{"replay of the last step", codeAt(current), current, 0, false},
+		{"step before the last one", codeAt(current - 1), current - 1, 0, false},
+		{"step after the last one", codeAt(current + 1), current, current + 1, true},
+		{"wrong code", "000000", 0, 0, false},
+		{"too short", codeAt(current)[:5], 0, 0, false},
+		{"too long", codeAt(current) + "0", 0, 0, false},
+	}
+	for _, tt := range tests {
+		step, ok := Validate(rfcSecret, tt.input, now, tt.lastStep)
+		if ok != tt.ok || step != tt.step {
+			t.Errorf("%s: Validate = %d, %v, want %d, %v", tt.name, step, ok, tt.step, tt.ok)
+		}
+	}
+
+	if _, ok := Validate("not base32!", codeAt(current), now, 0); ok {
+		t.Error("Validate accepted a code for a bad secret")
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	a, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := GenerateSecret()
+	if a == b {
+		t.Error("two secrets are equal")
+	}
+	if key, err := decodeSecret(a); err != nil || len(key) != 20 {
+		t.Errorf("secret %q decodes to %d bytes, %v; want 20", a, len(key), err)
+	}
+}
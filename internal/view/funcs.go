@@ -0,0 +1,27 @@
+package view
+
+import (
+	"html/template"
+	"unicode/utf8"
+)
+
+// Funcs are the helpers every template can use. Date helpers depend on the
+// reader's language and zone and are added by the caller.
+func Funcs() template.FuncMap {
+	return template.FuncMap{
+		"truncate": Truncate,
+	}
+}
+
+// Truncate shortens s to at most n characters, ending with an ellipsis when
+// something was cut.
+func Truncate(n int, s string) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	if n <= 0 {
+		return ""
+	}
+	runes := []rune(s)
+	return string(runes[:n-1]) + "…"
+}
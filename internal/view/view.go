@@ -0,0 +1,242 @@
+// Package view parses the HTML templates once and hands out one template set
+// per page.
+package view
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	layoutFile   = "layout.html"
+	partialsFile = "partials.html"
+)
+
+// Set holds, for every language, the layout with the shared partials and a
+// clone of it per page with that page's blocks filled in. They are parsed
+// once per language, as the translation helpers are bound at parse time.
+//
+// The time helpers read the reader's zone while the template runs. A
+// template can only carry one zone at a time, so the parsed templates are
+// never executed themselves, which also keeps them cloneable: each execution
+// borrows a clone from a pool and sets its zone first. Pools grow with the
+// number of concurrent requests, not with the number of zones readers pick.
+type Set struct {
+	fsys  fs.FS
+	langs []string
+	funcs func(lang string, loc func() *time.Location) template.FuncMap
+
+	mu   sync.RWMutex
+	sets map[string]*pageSet
+}
+
+// pageSet holds one language's templates, each with a pool of clones to run.
+// The layout with the partials alone is kept under the empty name.
+type pageSet struct {
+	pages map[string]*template.Template
+	pools map[string]*sync.Pool
+}
+
+// instance is a clone of a parsed template with its own zone.
+type instance struct {
+	tmpl *template.Template
+	loc  *time.Location
+}
+
+// Load parses the templates at the root of fsys once for each language.
+// funcs returns the helpers for a language; loc reports the zone of the
+// execution in progress. langs[0] is used for languages that were not
+// loaded.
+func Load(fsys fs.FS, langs []string, funcs func(lang string, loc func() *time.Location) template.FuncMap) (*Set, error) {
+	s := &Set{fsys: fsys, langs: langs, funcs: funcs}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload parses every template again. On error the previous templates stay
+// in use.
+func (s *Set) Reload() error {
+	sets := make(map[string]*pageSet, len(s.langs))
+	for _, lang := range s.langs {
+		set, err := s.parse(lang)
+		if err != nil {
+			return err
+		}
+		sets[lang] = set
+	}
+
+	s.mu.Lock()
+	s.sets = sets
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *Set) parse(lang string) (*pageSet, error) {
+	utc := func() *time.Location { return time.UTC }
+	base, err := template.New(layoutFile).Funcs(s.funcs(lang, utc)).ParseFS(s.fsys, layoutFile, partialsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := fs.Glob(s.fsys, "*.html")
+	if err != nil {
+		return nil, err
+	}
+	set := &pageSet{
+		pages: map[string]*template.Template{"": base},
+		pools: map[string]*sync.Pool{},
+	}
+	for _, name := range files {
+		if name == layoutFile || name == partialsFile {
+			continue
+		}
+		page, err := base.Clone()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := page.ParseFS(s.fsys, name); err != nil {
+			return nil, err
+		}
+		set.pages[name] = page
+	}
+	for name, page := range set.pages {
+		// One clone up front warms the pool and shows at load time that
+		// cloning works.
+		inst, err := s.clone(lang, page)
+		if err != nil {
+			return nil, err
+		}
+		pool := &sync.Pool{New: func() any {
+			inst, err := s.clone(lang, page)
+			if err != nil {
+				return err
+			}
+			return inst
+		}}
+		pool.Put(inst)
+		set.pools[name] = pool
+	}
+	return set, nil
+}
+
+// clone copies a parsed template and binds its time helpers to the copy's
+// own zone.
+func (s *Set) clone(lang string, tmpl *template.Template) (*instance, error) {
+	c, err := tmpl.Clone()
+	if err != nil {
+		return nil, err
+	}
+	inst := &instance{tmpl: c, loc: time.UTC}
+	c.Funcs(s.funcs(lang, func() *time.Location { return inst.loc }))
+	return inst, nil
+}
+
+func (s *Set) get(lang string) *pageSet {
+	if !slices.Contains(s.langs, lang) {
+		lang = s.langs[0]
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.sets[lang]
+}
+
+// Require fails unless every named page exists, so a typo shows up at
+// startup instead of on the first request.
+func (s *Set) Require(names ...string) error {
+	set := s.get(s.langs[0])
+	var missing []string
+	for _, name := range names {
+		if _, ok := set.pages[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing templates: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// Page executes the layout with the page's blocks, showing text in lang and
+// times in loc. The result is returned whole so a failing template never
+// leaves a half-written response behind.
+func (s *Set) Page(lang string, loc *time.Location, page string, data any) ([]byte, error) {
+	set := s.get(lang)
+	if _, ok := set.pages[page]; !ok || page == "" {
+		return nil, fmt.Errorf("template %s not found", page)
+	}
+	return set.execute(page, loc, layoutFile, data)
+}
+
+// Partial executes one of the shared partials.
+func (s *Set) Partial(lang string, loc *time.Location, name string, data any) ([]byte, error) {
+	return s.get(lang).execute("", loc, name, data)
+}
+
+// execute runs the template called name in a pooled clone of page.
+func (set *pageSet) execute(page string, loc *time.Location, name string, data any) ([]byte, error) {
+	pool := set.pools[page]
+	v := pool.Get()
+	if err, ok := v.(error); ok {
+		return nil, err
+	}
+	inst := v.(*instance)
+	defer pool.Put(inst)
+	inst.loc = loc
+	return execute(inst.tmpl, name, data)
+}
+
+func execute(tmpl *template.Template, name string, data any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&buf, name, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Watch reloads the templates whenever a file changes, until ctx is done. It
+// polls, which is plenty for development, and only makes sense when fsys is
+// a directory on disk.
+func (s *Set) Watch(ctx context.Context, interval time.Duration, onReload func(error)) {
+	last := s.stamp()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		current := s.stamp()
+		if current == last {
+			continue
+		}
+		last = current
+		onReload(s.Reload())
+	}
+}
+
+// stamp summarises names, sizes and modification times of the template
+// files; any edit, addition or removal changes it.
+func (s *Set) stamp() string {
+	files, _ := fs.Glob(s.fsys, "*.html")
+	sort.Strings(files)
+	var b strings.Builder
+	for _, file := range files {
+		info, err := fs.Stat(s.fsys, file)
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(&b, "%s %d %d\n", file, info.Size(), info.ModTime().UnixNano())
+	}
+	return b.String()
+}
@@ -0,0 +1,134 @@
+package view
+
+import (
+	"fmt"
+	"html/template"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+var testFiles = fstest.MapFS{
+	"layout.html":   {Data: []byte(`{{define "layout.html"}}{{lang}}|{{block "content" .}}{{end}}{{end}}`)},
+	"partials.html": {Data: []byte(`{{define "clock"}}{{clock .}}{{end}}`)},
+	"page.html":     {Data: []byte(`{{define "content"}}{{template "clock" .}}{{end}}`)},
+}
+
+// testFuncs counts how often the helpers are built, which happens once per
+// parse and once per clone.
+func testFuncs(calls *int, mu *sync.Mutex) func(lang string, loc func() *time.Location) template.FuncMap {
+	return func(lang string, loc func() *time.Location) template.FuncMap {
+		mu.Lock()
+		*calls++
+		mu.Unlock()
+		return template.FuncMap{
+			"lang":  func() string { return lang },
+			"clock": func(t time.Time) string { return t.In(loc()).Format("15:04 MST") },
+		}
+	}
+}
+
+func TestPageShowsTimesInTheReadersZone(t *testing.T) {
+	var (
+		calls int
+		mu    sync.Mutex
+	)
+	s, err := Load(testFiles, []string{"ru", "en"}, testFuncs(&calls, &mu))
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC)
+
+	zones := []string{"UTC", "Europe/Moscow", "America/New_York", "Asia/Tokyo"}
+	want := map[string]string{}
+	for _, name := range zones {
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want[name] = "en|" + at.In(loc).Format("15:04 MST")
+	}
+
+	// Concurrent pages in different zones must not see each other's zone.
+	var wg sync.WaitGroup
+	errs := make(chan error, 400)
+	for i := 0; i < 400; i++ {
+		name := zones[i%len(zones)]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			loc, _ := time.LoadLocation(name)
+			got, err := s.Page("en", loc, "page.html", at)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if string(got) != want[name] {
+				errs <- fmt.Errorf("%s: got %q, want %q", name, got, want[name])
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	got, err := s.Partial("ru", time.UTC, "clock", at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "12:00 UTC" {
+		t.Errorf("Partial = %q, want %q", got, "12:00 UTC")
+	}
+}
+
+func TestZonesDoNotCauseParsing(t *testing.T) {
+	var (
+		calls int
+		mu    sync.Mutex
+	)
+	s, err := Load(testFiles, []string{"ru", "en"}, testFuncs(&calls, &mu))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	loaded := calls
+	mu.Unlock()
+
+	// One at a time, requests reuse the same pooled clone however many
+	// zones they ask for. The race detector makes sync.Pool drop some of
+	// what is put back, so the bound leaves room for that.
+	for i := 0; i < 200; i++ {
+		loc := time.FixedZone(fmt.Sprintf("Z%d", i), i*60)
+		if _, err := s.Page("en", loc, "page.html", time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if calls-loaded >= 100 {
+		t.Errorf("200 zones built the helpers %d more times", calls-loaded)
+	}
+}
+
+func TestPageNotFound(t *testing.T) {
+	var (
+		calls int
+		mu    sync.Mutex
+	)
+	s, err := Load(testFiles, []string{"ru"}, testFuncs(&calls, &mu))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Require("page.html", "missing.html"); err == nil {
+		t.Error("Require passed a missing page")
+	}
+	if _, err := s.Page("ru", time.UTC, "missing.html", nil); err == nil {
+		t.Error("Page rendered a missing page")
+	}
+	if _, err := s.Page("ru", time.UTC, "", nil); err == nil {
+		t.Error("Page rendered the bare layout")
+	}
+}
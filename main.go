@@ -1,41 +1,303 @@
 package main
 
 import (
+	"context"
+	"embed"
+	"fmt"
 	"html/template"
+	"io/fs"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+	_ "time/tzdata"
 
+	"forum/internal/assets"
+	"forum/internal/backup"
 	internaldb "forum/internal/db"
+	"forum/internal/events"
+	"forum/internal/export"
 	"forum/internal/handlers"
+	"forum/internal/i18n"
+	"forum/internal/identity"
+	"forum/internal/mail"
+	"forum/internal/metrics"
+	"forum/internal/middleware"
+	"forum/internal/models"
 	"forum/internal/repo"
+	"forum/internal/storage"
+	"forum/internal/view"
 )
 
 func main() {
-	db := internaldb.InitDB()
+	logger := newLogger()
+	slog.SetDefault(logger)
 
-	if err := repo.SeedCategories(db); err != nil {
-		panic(err)
+	if len(os.Args) > 1 {
+		runCommand(os.Args[1:])
+		return
+	}
+
+	db, err := internaldb.Open(os.Getenv("FORUM_DATABASE_URL"))
+	if err != nil {
+		fatal("open database", err)
+	}
+
+	ctx := context.Background()
+	if err := repo.SeedCategories(ctx, db); err != nil {
+		fatal("seed categories", err)
+	}
+
+	if err := repo.FailPendingExports(ctx, db); err != nil {
+		fatal("fail pending exports", err)
+	}
+
+	if err := repo.PromoteAdmins(ctx, db, strings.Split(os.Getenv("FORUM_ADMIN_EMAILS"), ",")); err != nil {
+		fatal("promote admins", err)
+	}
+
+	if err := loadReactionKinds(os.Getenv("FORUM_REACTIONS_CONFIG")); err != nil {
+		fatal("load reaction kinds", err)
+	}
+	unknown, err := repo.UnconfiguredReactionKinds(ctx, db)
+	if err != nil {
+		fatal("check reaction kinds", err)
+	}
+	if len(unknown) > 0 {
+		fatal("check reaction kinds", fmt.Errorf("stored reactions use kinds missing from FORUM_REACTIONS_CONFIG: %s", strings.Join(unknown, ", ")))
+	}
+
+	providers, err := identity.LoadConfig(os.Getenv("FORUM_IDENTITY_CONFIG"))
+	if err != nil {
+		fatal("load identity providers", err)
+	}
+
+	blobs, err := storage.NewFSStore(envOr("FORUM_UPLOAD_DIR", "uploads"))
+	if err != nil {
+		fatal("open upload store", err)
+	}
+
+	location, err := time.LoadLocation(envOr("FORUM_TIMEZONE", "Local"))
+	if err != nil {
+		fatal("load FORUM_TIMEZONE", err)
+	}
+
+	dev := os.Getenv("FORUM_DEV") == "1"
+	files := webFiles(dev)
+	static, err := assets.New(subFS(files, "static"), "/static/", dev)
+	if err != nil {
+		fatal("load static files", err)
+	}
+	bundle, err := i18n.Load()
+	if err != nil {
+		fatal("load translations", err)
+	}
+	if err := bundle.Check(); err != nil {
+		fatal("check translations", err)
+	}
+	views, err := view.Load(subFS(files, "templates"), bundle.Languages(), func(lang string, loc func() *time.Location) template.FuncMap {
+		return handlers.TemplateFuncs(static.URL, bundle.Translator(lang), loc, bundle.Languages())
+	})
+	if err != nil {
+		fatal("parse templates", err)
+	}
+	if err := views.Require(handlers.Pages...); err != nil {
+		fatal("check templates", err)
+	}
+	if dev {
+		go views.Watch(context.Background(), 500*time.Millisecond, func(err error) {
+			if err != nil {
+				slog.Error("reload templates", "err", err)
+				return
+			}
+			slog.Info("templates reloaded")
+		})
 	}
 
-	tpl := template.Must(template.ParseFiles("templates/layout.html"))
 	store := repo.NewStore(db)
+	store.Observe = func(method string, d time.Duration) {
+		metrics.DBQueryDuration.With(method).Observe(d.Seconds())
+	}
+	metrics.Default.NewGaugeFunc("forum_active_sessions", "Sessions that have not expired.", func() (float64, error) {
+		n, err := store.CountActiveSessions(context.Background())
+		return float64(n), err
+	})
 	app := &handlers.App{
-		DB:       db,
-		Tpl:      tpl,
-		Posts:    store,
-		Users:    store,
-		Comments: store,
+		Views:      views,
+		I18n:       bundle,
+		Posts:      store,
+		Users:      store,
+		Comments:   store,
+		Reactions:  store,
+		Sessions:   store,
+		Categories: store,
+		Events:     events.NewHub(16),
+
+		Notifications: store,
+		Mentions:      store,
+		Attachments:   store,
+		Blobs:         blobs,
+		Profiles:      store,
+		Mailer:        newMailer(),
+		Exports:       store,
+		Exporter:      export.NewRunner(store, blobs, 2),
+		Identities:    store,
+		Providers:     providers,
+		TwoFactor:     store,
+		Site:          store,
+		Ready:         func(ctx context.Context) error { return internaldb.Ready(ctx, db) },
+		Location:      location,
+		Dev:           dev,
+	}
+
+	if db.Dialect == repo.SQLite {
+		opts, err := backupOptions()
+		if err != nil {
+			fatal("read backup settings", err)
+		}
+		interval, err := envDuration("FORUM_BACKUP_INTERVAL")
+		if err != nil {
+			fatal("read backup settings", err)
+		}
+		job := backup.NewJob(db.Pool(), opts, interval)
+		go job.Run(ctx)
+		app.Backups = job
 	}
 
 	http.HandleFunc("/", app.HomeHandler)
 	http.HandleFunc("/post", app.PostPageHandler)
 	http.HandleFunc("/register", app.RegisterHandler)
 	http.HandleFunc("/login", app.LoginHandler)
+	http.HandleFunc("/login/2fa", app.LoginTwoFactorHandler)
+	http.HandleFunc("/language", app.LanguageHandler)
 	http.HandleFunc("/logout", app.LogoutHandler)
+	http.HandleFunc("/auth/login", app.ExternalLoginHandler)
+	http.HandleFunc("/auth/callback", app.ExternalCallbackHandler)
 	http.HandleFunc("/create-post", app.CreatePostHandler)
+	http.HandleFunc("/preview", app.PreviewHandler)
 	http.HandleFunc("/addcomment", app.CommentHandler)
+	http.HandleFunc("/attachments/", app.AttachmentHandler)
+	http.HandleFunc("/avatars/", app.AvatarHandler)
+	http.HandleFunc("/user/", app.ProfileHandler)
+	http.HandleFunc("/settings", app.SettingsHandler)
+	http.HandleFunc("/settings/profile", app.ProfileSettingsHandler)
+	http.HandleFunc("/settings/username", app.SettingsUsernameHandler)
+	http.HandleFunc("/settings/email", app.SettingsEmailHandler)
+	http.HandleFunc("/settings/email/confirm", app.EmailConfirmHandler)
+	http.HandleFunc("/settings/password", app.SettingsPasswordHandler)
+	http.HandleFunc("/settings/timezone", app.SettingsTimezoneHandler)
+	http.HandleFunc("/settings/delete", app.SettingsDeleteHandler)
+	http.HandleFunc("/settings/export", app.SettingsExportHandler)
+	http.HandleFunc("/settings/export/download", app.ExportDownloadHandler)
+	http.HandleFunc("/settings/2fa", app.TwoFactorSettingsHandler)
+	http.HandleFunc("/admin/security", app.AdminSecurityHandler)
+	http.HandleFunc("/admin/backups", app.AdminBackupsHandler)
 	http.HandleFunc("/react-post", app.ReactPosts)
 	http.HandleFunc("/react-comment", app.ReactComment)
-	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
+	http.HandleFunc("/users/autocomplete", app.UsernameAutocompleteHandler)
+	http.HandleFunc("/notifications", app.NotificationsHandler)
+	http.HandleFunc("/notifications/read", app.NotificationsReadHandler)
+	http.HandleFunc("/notifications/open", app.NotificationOpenHandler)
+	http.HandleFunc("/notifications/preferences", app.NotificationPreferencesHandler)
+	http.HandleFunc("/events", app.FeedEventsHandler)
+	http.HandleFunc("/events/post", app.PostEventsHandler)
+	http.HandleFunc("/events/category", app.CategoryEventsHandler)
+	http.HandleFunc("/healthz", app.HealthzHandler)
+	http.HandleFunc("/readyz", app.ReadyzHandler)
+	http.Handle("/metrics", metrics.Default.Handler())
+	http.Handle("/static/", static)
 
-	http.ListenAndServe(":8080", nil)
+	if err := http.ListenAndServe(":8080", middleware.RequestLog(logger, app.Recover(middleware.Authenticate(store, middleware.Metrics(http.DefaultServeMux))))); err != nil {
+		fatal("server stopped", err)
+	}
+}
+
+//go:embed templates static
+var embedded embed.FS
+
+// webFiles returns the templates and static files. They are built into the
+// binary; FORUM_ASSET_DIR points at a checkout to use instead, and dev mode
+// reads them from the working directory so edits show up without a rebuild.
+func webFiles(dev bool) fs.FS {
+	if dir := os.Getenv("FORUM_ASSET_DIR"); dir != "" {
+		return os.DirFS(dir)
+	}
+	if dev {
+		return os.DirFS(".")
+	}
+	return embedded
+}
+
+func subFS(fsys fs.FS, dir string) fs.FS {
+	sub, err := fs.Sub(fsys, dir)
+	if err != nil {
+		fatal("open "+dir, err)
+	}
+	return sub
+}
+
+// fatal logs a startup error and exits without the panic trace that would
+// bury the message.
+func fatal(message string, err error) {
+	slog.Error(message, "err", err)
+	os.Exit(1)
+}
+
+// loadReactionKinds replaces the default reaction kinds with the JSON list in
+// the file at path, if one is given.
+func loadReactionKinds(path string) error {
+	if path == "" {
+		return nil
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	kinds, err := models.ParseReactionKinds(raw)
+	if err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+	return models.SetReactionKinds(kinds)
+}
+
+func envOr(key string, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// newLogger writes text logs by default and JSON when FORUM_LOG_FORMAT=json.
+// FORUM_LOG_LEVEL accepts debug, info, warn or error.
+func newLogger() *slog.Logger {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(envOr("FORUM_LOG_LEVEL", "info"))); err != nil {
+		level = slog.LevelInfo
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	if os.Getenv("FORUM_LOG_FORMAT") == "json" {
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts))
+	}
+	return slog.New(slog.NewTextHandler(os.Stderr, opts))
+}
+
+// newMailer sends mail through FORUM_SMTP_ADDR when it is set and logs
+// messages otherwise.
+func newMailer() mail.Mailer {
+	addr := os.Getenv("FORUM_SMTP_ADDR")
+	if addr == "" {
+		return mail.LogMailer{}
+	}
+	m := &mail.SMTPMailer{
+		Addr: addr,
+		From: envOr("FORUM_SMTP_FROM", "forum@localhost"),
+	}
+	if username := os.Getenv("FORUM_SMTP_USER"); username != "" {
+		host, _, _ := net.SplitHostPort(addr)
+		m.Auth = smtp.PlainAuth("", username, os.Getenv("FORUM_SMTP_PASSWORD"), host)
+	}
+	return m
 }
@@ -0,0 +1,268 @@
+(function () {
+  "use strict";
+
+  function send(form) {
+    return fetch(form.action, {
+      method: "POST",
+      credentials: "same-origin",
+      headers: {
+        "HX-Request": "true",
+        "Accept": "text/html",
+        "Content-Type": "application/x-www-form-urlencoded"
+      },
+      body: new URLSearchParams(new FormData(form))
+    }).then(function (res) {
+      if (!res.ok) {
+        throw new Error(res.status);
+      }
+      return res.text();
+    });
+  }
+
+  function fragment(html) {
+    var tpl = document.createElement("template");
+    tpl.innerHTML = html.trim();
+    return tpl.content.firstElementChild;
+  }
+
+  function onReaction(form) {
+    var bar = form.closest("[data-reactions]");
+    return send(form).then(function (html) {
+      bar.replaceWith(fragment(html));
+    });
+  }
+
+  function onComment(form) {
+    var input = form.querySelector("[name=content]");
+    if (!input.value.trim()) {
+      return Promise.reject(new Error("empty"));
+    }
+    var list = document.getElementById(form.dataset.target);
+    return send(form).then(function (html) {
+      var node = fragment(html);
+      input.value = "";
+      if (document.getElementById(node.id)) {
+        return;
+      }
+      list.insertBefore(node, list.firstChild);
+      var counter = document.querySelector("[data-comment-count]");
+      if (counter) {
+        counter.textContent = String(Number(counter.textContent) + 1);
+      }
+    });
+  }
+
+  var handlers = { reaction: onReaction, comment: onComment };
+
+  function updateReactions(data) {
+    var selector = '[data-reactions][data-target-type="' + data.target_type + '"][data-target-id="' + data.target_id + '"]';
+    document.querySelectorAll(selector).forEach(function (bar) {
+      var users = bar.querySelector(".reaction-users");
+      if (users) {
+        users.textContent = "";
+      }
+      data.reactions.forEach(function (r) {
+        var button = bar.querySelector('[data-kind="' + r.kind + '"]');
+        if (button) {
+          button.textContent = r.emoji + " " + r.count;
+        }
+        if (users && r.count > 0) {
+          var line = document.createElement("div");
+          line.textContent = r.emoji + " " + r.users.join(", ");
+          users.appendChild(line);
+        }
+      });
+    });
+  }
+
+  function addComment(data) {
+    if (document.getElementById("comment-" + data.comment_id)) {
+      return;
+    }
+    var list = document.getElementById("comments-" + data.post_id);
+    if (!list) {
+      return;
+    }
+    var node;
+    if (list.querySelector("[data-reactions]") || document.querySelector("[data-comment-count]")) {
+      node = fragment(data.html);
+      var counter = document.querySelector("[data-comment-count]");
+      if (counter) {
+        counter.textContent = String(Number(counter.textContent) + 1);
+      }
+    } else {
+      node = document.createElement("div");
+      node.className = "comment";
+      node.id = "comment-" + data.comment_id;
+      var author = document.createElement("b");
+      var link = document.createElement("a");
+      link.href = "/user/" + encodeURIComponent(data.author);
+      link.textContent = data.author;
+      author.appendChild(link);
+      author.appendChild(document.createTextNode(":"));
+      node.appendChild(author);
+      node.appendChild(document.createTextNode(" " + data.content));
+    }
+    list.insertBefore(node, list.firstChild);
+  }
+
+  function subscribe() {
+    var source = document.querySelector("[data-events]");
+    if (!source || !window.EventSource) {
+      return;
+    }
+    var stream = new EventSource(source.dataset.events);
+    stream.addEventListener("reaction.toggled", function (e) {
+      updateReactions(JSON.parse(e.data));
+    });
+    stream.addEventListener("comment.created", function (e) {
+      addComment(JSON.parse(e.data));
+    });
+    stream.addEventListener("post.created", function () {
+      var notice = document.querySelector("[data-new-posts]");
+      if (notice) {
+        notice.hidden = false;
+      }
+    });
+  }
+
+  subscribe();
+
+  // Tell the server which zone to show times in for visitors who have not
+  // picked one in their settings.
+  function rememberTimezone() {
+    var zone;
+    try {
+      zone = Intl.DateTimeFormat().resolvedOptions().timeZone;
+    } catch (e) {
+      return;
+    }
+    if (zone && document.cookie.split("; ").indexOf("tz=" + zone) === -1) {
+      document.cookie = "tz=" + zone + "; path=/; max-age=31536000; samesite=lax";
+    }
+  }
+
+  rememberTimezone();
+
+  var mentionMenu = null;
+
+  function closeMentions() {
+    if (mentionMenu) {
+      mentionMenu.remove();
+      mentionMenu = null;
+    }
+  }
+
+  function mentionQuery(field) {
+    var before = field.value.slice(0, field.selectionStart);
+    var match = /(?:^|[^\w@])@([\w.-]*)$/u.exec(before);
+    return match ? match[1] : null;
+  }
+
+  function insertMention(field, name) {
+    var caret = field.selectionStart;
+    var before = field.value.slice(0, caret).replace(/@[\w.-]*$/u, "@" + name + " ");
+    field.value = before + field.value.slice(caret);
+    field.focus();
+    field.setSelectionRange(before.length, before.length);
+    closeMentions();
+  }
+
+  function showMentions(field, names) {
+    closeMentions();
+    if (!names.length) {
+      return;
+    }
+    mentionMenu = document.createElement("div");
+    mentionMenu.className = "mention-menu";
+    names.forEach(function (name) {
+      var item = document.createElement("button");
+      item.type = "button";
+      item.textContent = "@" + name;
+      item.addEventListener("mousedown", function (e) {
+        e.preventDefault();
+        insertMention(field, name);
+      });
+      mentionMenu.appendChild(item);
+    });
+    field.insertAdjacentElement("afterend", mentionMenu);
+  }
+
+  document.addEventListener("input", function (event) {
+    var field = event.target;
+    if (!field.matches || !field.matches("[data-mentions]")) {
+      return;
+    }
+    var query = mentionQuery(field);
+    if (!query) {
+      closeMentions();
+      return;
+    }
+    fetch("/users/autocomplete?q=" + encodeURIComponent(query), {
+      headers: { "Accept": "application/json" }
+    }).then(function (res) {
+      return res.ok ? res.json() : [];
+    }).then(function (names) {
+      if (mentionQuery(field) === query) {
+        showMentions(field, names);
+      }
+    }).catch(closeMentions);
+  });
+
+  var previewTimer = null;
+
+  function updatePreview(field) {
+    var target = document.getElementById(field.dataset.preview);
+    if (!target) {
+      return;
+    }
+    if (!field.value.trim()) {
+      target.hidden = true;
+      target.innerHTML = "";
+      return;
+    }
+    fetch("/preview", {
+      method: "POST",
+      credentials: "same-origin",
+      headers: {
+        "HX-Request": "true",
+        "Content-Type": "application/x-www-form-urlencoded"
+      },
+      body: new URLSearchParams({ content: field.value })
+    }).then(function (res) {
+      return res.ok ? res.text() : Promise.reject(new Error(res.status));
+    }).then(function (html) {
+      target.innerHTML = html;
+      target.hidden = false;
+    }).catch(function () {});
+  }
+
+  document.addEventListener("input", function (event) {
+    var field = event.target;
+    if (!field.matches || !field.matches("[data-preview]")) {
+      return;
+    }
+    clearTimeout(previewTimer);
+    previewTimer = setTimeout(function () {
+      updatePreview(field);
+    }, 300);
+  });
+
+  document.addEventListener("focusout", function (event) {
+    if (event.target.matches && event.target.matches("[data-mentions]")) {
+      closeMentions();
+    }
+  });
+
+  document.addEventListener("submit", function (event) {
+    var form = event.target;
+    var handler = handlers[form.dataset && form.dataset.enhance];
+    if (!handler || !window.fetch) {
+      return;
+    }
+    event.preventDefault();
+    handler(form).catch(function () {
+      form.submit();
+    });
+  });
+})();